@@ -18,6 +18,19 @@
 package btcadaptor
 
 import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+
 	"github.com/palletone/adaptor"
 )
 
@@ -31,16 +44,205 @@ type RPCParams struct {
 type AdaptorBTC struct {
 	NetID int
 	RPCParams
+	LightClient *LightClient     //if set, GetBalance and GetAddrTxHistory use compact block filters
+	Indexer     *Indexer         //if set, the addresses watched by it are served from the local index
+	Tracker     *TipTracker      //if set, IsStable of blocks and txs is checked against the tracked main chain
+	PoolConfig  ClientPoolConfig //config of the rpc client pool of each node, zero value means default
+	Nodes       []RPCParams      //nodes for failover and quorum reads, RPCParams is used if empty
+	//number of nodes must agree on tx confirmation, block hash at height and UTXO existence,
+	//0 or 1 means no quorum
+	Quorum int
+	Retry  RetryConfig //retry of transient errors, zero value means default
+	//when blocks and txs are stable and utxos can be spent, zero value means the default of NetID
+	Finality FinalityPolicy
+	//if set, SendTransaction check the tx by it against the prevouts resolved by the nodes before broadcasting,
+	//the violations are of the PolicyError which is the Cause of the error
+	Policy *TxPolicy
+
+	poolMtx sync.Mutex
+	nodes   *NodeSet
 }
 
 func NewAdaptorBTC(netID int, rPCParams RPCParams) *AdaptorBTC {
-	return &AdaptorBTC{netID, rPCParams}
+	return &AdaptorBTC{NetID: netID, RPCParams: rPCParams}
+}
+
+//NewAdaptorBTCParams create an adaptor of a network not of the NETIDs, params is registered by RegisterNet,
+//so the addresses, WIF and HD keys of the adaptor use its magics
+func NewAdaptorBTCParams(params *chaincfg.Params, rPCParams RPCParams) (*AdaptorBTC, error) {
+	netID, err := RegisterNet(params)
+	if err != nil {
+		return nil, err
+	}
+	return NewAdaptorBTC(netID, rPCParams), nil
+}
+
+//NewAdaptorChain create an adaptor of a chain not of the NETIDs, like another coin of the tx format of bitcoin,
+//chain is registered by RegisterChain
+func NewAdaptorChain(chain *Chain, rPCParams RPCParams) (*AdaptorBTC, error) {
+	netID, err := RegisterChain(chain)
+	if err != nil {
+		return nil, err
+	}
+	return NewAdaptorBTC(netID, rPCParams), nil
+}
+
+//NewAdaptorBTCFinality create an adaptor with a finality policy instead of the default of netID
+func NewAdaptorBTCFinality(netID int, rPCParams RPCParams, policy FinalityPolicy) *AdaptorBTC {
+	return &AdaptorBTC{NetID: netID, RPCParams: rPCParams, Finality: policy}
+}
+
+//NewAdaptorBTCLight create an adaptor which use a BIP157/158 light client for balance and history
+func NewAdaptorBTCLight(netID int, rPCParams RPCParams, lightCfg LightClientConfig) *AdaptorBTC {
+	lightCfg.Params = GetNet(netID)
+	return &AdaptorBTC{NetID: netID, RPCParams: rPCParams, LightClient: NewLightClient(lightCfg)}
+}
+
+//NewAdaptorBTCNodes create an adaptor which fail over between nodes,
+//and require quorum nodes to agree on security-sensitive reads
+func NewAdaptorBTCNodes(netID int, nodes []RPCParams, quorum int) *AdaptorBTC {
+	abtc := &AdaptorBTC{NetID: netID, Nodes: nodes, Quorum: quorum}
+	if len(nodes) > 0 {
+		abtc.RPCParams = nodes[0]
+	}
+	return abtc
+}
+
+//NewAdaptorBTCIndexer create an adaptor with a local index of watched addresses,
+//the caller should WatchAddress and Run the indexer
+func NewAdaptorBTCIndexer(netID int, rPCParams RPCParams, indexCfg IndexerConfig) (*AdaptorBTC, error) {
+	indexCfg.Params = GetNet(netID)
+	abtc := NewAdaptorBTC(netID, rPCParams)
+	indexer, err := NewIndexer(indexCfg, abtc.nodeSet())
+	if err != nil {
+		return nil, err
+	}
+	abtc.Indexer = indexer
+	return abtc, nil
+}
+
+//TrackTip create the Tracker of the adaptor's nodes, the caller should Run it
+func (abtc *AdaptorBTC) TrackTip(cfg TipTrackerConfig) *TipTracker {
+	abtc.Tracker = NewTipTracker(cfg, abtc.nodeSet())
+	return abtc.Tracker
+}
+
+//NewWatcher create a deposit Watcher of the websocket of RPCParams, the caller should WatchAddress and Start it
+func (abtc *AdaptorBTC) NewWatcher(cfg WatcherConfig) *Watcher {
+	cfg.Params = GetNet(abtc.NetID)
+	return NewWatcher(abtc.RPCParams, cfg)
+}
+
+//NewZMQFeed create a ZMQFeed which resync by the adaptor's nodes, the caller should drive a Watcher or the Tracker and Run it
+func (abtc *AdaptorBTC) NewZMQFeed(cfg ZMQConfig) *ZMQFeed {
+	return NewZMQFeed(cfg, abtc.nodeSet())
+}
+
+//NewBroadcaster create a Broadcaster which send txs to all the adaptor's nodes, the caller should Run it
+func (abtc *AdaptorBTC) NewBroadcaster(cfg BroadcastConfig) (*Broadcaster, error) {
+	cfg.Params = GetNet(abtc.NetID)
+	return NewBroadcaster(cfg, abtc.nodeSet())
+}
+
+//checkStable recompute IsStable of a block or a tx of amount by the Tracker if set
+func (abtc *AdaptorBTC) checkStable(ctx context.Context, blockID []byte, height uint, amount int64, stable *bool) error {
+	if abtc.Tracker == nil || len(blockID) == 0 {
+		return nil
+	}
+	policy := abtc.finality()
+	isStable, err := abtc.Tracker.checkStable(ctx, blockID, height, policy.Required(amount), *stable)
+	if err != nil {
+		return err
+	}
+	if policy.needBits() { //the work is only checked by the node
+		isStable = isStable && *stable
+	}
+	*stable = isStable
+	return nil
+}
+
+//finality return the policy of the adaptor with the defaults of NetID
+func (abtc *AdaptorBTC) finality() *FinalityPolicy {
+	policy := abtc.Finality.withDefault(GetNet(abtc.NetID))
+	return &policy
+}
+
+//indexed return whether addr is served by the indexer, addresses being backfilled or an index behind the nodes are served by rpc
+func (abtc *AdaptorBTC) indexed(addr string) bool {
+	return abtc.Indexer != nil && abtc.Indexer.Ready(addr)
+}
+
+//nodeSet return the rpc nodes, create them on first use
+func (abtc *AdaptorBTC) nodeSet() *NodeSet {
+	abtc.poolMtx.Lock()
+	defer abtc.poolMtx.Unlock()
+	if abtc.nodes == nil {
+		nodes := abtc.Nodes
+		if len(nodes) == 0 {
+			nodes = []RPCParams{abtc.RPCParams}
+		}
+		abtc.nodes = NewNodeSet(nodes, abtc.PoolConfig)
+	}
+	return abtc.nodes
+}
+
+//call call f with failover between nodes, and retry it on transient errors
+func (abtc *AdaptorBTC) call(ctx context.Context, f func(client *rpcclient.Client) (interface{}, error)) (interface{}, error) {
+	var result interface{}
+	err := retry(ctx, abtc.Retry, func(attempt int) (err error) {
+		result, err = abtc.nodeSet().CallCtx(ctx, f)
+		return err
+	})
+	return result, err
+}
+
+//callResolver is call which pass the tx resolver of the node to f
+func (abtc *AdaptorBTC) callResolver(ctx context.Context, f func(client *rpcclient.Client, resolver *txResolver) (interface{}, error)) (interface{}, error) {
+	var result interface{}
+	err := retry(ctx, abtc.Retry, func(attempt int) (err error) {
+		result, err = abtc.nodeSet().callNodeCtx(ctx, func(node *rpcNode, client *rpcclient.Client) (interface{}, error) {
+			resolver, err := node.getResolver()
+			if err != nil {
+				return nil, err
+			}
+			return f(client, resolver)
+		})
+		return err
+	})
+	return result, err
+}
+
+//Close shutdown the rpc clients and the light client
+func (abtc *AdaptorBTC) Close() {
+	abtc.poolMtx.Lock()
+	nodes := abtc.nodes
+	abtc.nodes = nil
+	abtc.poolMtx.Unlock()
+	if nodes != nil {
+		nodes.Close()
+	}
+	if abtc.LightClient != nil {
+		abtc.LightClient.Close()
+	}
+	if abtc.Indexer != nil {
+		abtc.Indexer.Close()
+	}
 }
 
 const MinConfirm = 6
 const (
 	NETID_MAIN = iota
-	NETID_TEST
+	NETID_TEST //testnet3
+	NETID_REGTEST
+	NETID_SIGNET //the default signet, RegisterNet the params of CustomSignetParams for others
+	NETID_TESTNET4
+	NETID_BCH_MAIN //Bitcoin Cash, addresses are CashAddr and txs are signed with SIGHASH_FORKID
+	NETID_BCH_TEST
+	NETID_BCH_REGTEST
+	NETID_LTC_MAIN //Litecoin, MWEB outputs are ignored
+	NETID_LTC_TEST //testnet4 of LTC
+	NETID_DOGE_MAIN
+	NETID_DOGE_TEST
 )
 
 /*IUtility*/
@@ -76,7 +278,18 @@ func (abtc *AdaptorBTC) GetAddress(key *adaptor.GetAddressInput) (*adaptor.GetAd
 
 //获得原链的地址和PalletOne的地址的映射 //btc， not implement
 func (abtc *AdaptorBTC) GetPalletOneMappingAddress(addr *adaptor.GetPalletOneMappingAddressInput) (*adaptor.GetPalletOneMappingAddressOutput, error) {
-	return GetPalletOneMappingAddress(addr, &abtc.RPCParams)
+	return abtc.GetPalletOneMappingAddressCtx(context.Background(), addr)
+}
+
+func (abtc *AdaptorBTC) GetPalletOneMappingAddressCtx(ctx context.Context, addr *adaptor.GetPalletOneMappingAddressInput) (*adaptor.GetPalletOneMappingAddressOutput, error) {
+	result, err := abtc.call(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return getPalletOneMappingAddressByClient(addr, client)
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := result.(*adaptor.GetPalletOneMappingAddressOutput)
+	return output, nil
 }
 
 func (abtc *AdaptorBTC) HashMessage(input *adaptor.HashMessageInput) (*adaptor.HashMessageOutput, error) {
@@ -95,12 +308,51 @@ func (abtc *AdaptorBTC) VerifySignature(input *adaptor.VerifySignatureInput) (*a
 
 //对一条交易进行签名，并返回签名结果
 func (abtc *AdaptorBTC) SignTransaction(input *adaptor.SignTransactionInput) (*adaptor.SignTransactionOutput, error) {
-	return SignTransaction(input, abtc.NetID)
+	return abtc.SignTransactionCtx(context.Background(), input)
+}
+
+//SignTransactionCtx get the amounts of the inputs from the node on BCH networks, which are signed
+func (abtc *AdaptorBTC) SignTransactionCtx(ctx context.Context, input *adaptor.SignTransactionInput) (*adaptor.SignTransactionOutput, error) {
+	amounts, err := abtc.inputAmounts(ctx, input.Transaction, input.Extra)
+	if err != nil {
+		return nil, err
+	}
+	return SignTransactionAmounts(input, amounts, abtc.NetID)
 }
 
 //将未签名的原始交易与签名进行绑定，返回一个签名后的交易
 func (abtc *AdaptorBTC) BindTxAndSignature(input *adaptor.BindTxAndSignatureInput) (*adaptor.BindTxAndSignatureOutput, error) {
-	return BindTxAndSignature(input, abtc.NetID)
+	return abtc.BindTxAndSignatureCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) BindTxAndSignatureCtx(ctx context.Context, input *adaptor.BindTxAndSignatureInput) (*adaptor.BindTxAndSignatureOutput, error) {
+	amounts, err := abtc.inputAmounts(ctx, input.Transaction, input.Extra)
+	if err != nil {
+		return nil, err
+	}
+	return BindTxAndSignatureAmounts(input, amounts, abtc.NetID)
+}
+
+//inputAmounts return the amounts of the inputs of the raw tx on BCH networks, nil on others
+//or if they are in the Extra
+func (abtc *AdaptorBTC) inputAmounts(ctx context.Context, rawTx []byte, extra []byte) ([]int64, error) {
+	if !IsBCH(GetNet(abtc.NetID)) {
+		return nil, nil
+	}
+	if decoded, err := DecodeExtra(extra); err == nil && len(decoded.Amounts) != 0 {
+		return nil, nil
+	}
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, kindError(ErrKindInvalidTx, "Deserialize failed", err)
+	}
+	result, err := abtc.callResolver(ctx, func(client *rpcclient.Client, resolver *txResolver) (interface{}, error) {
+		return inputAmountsByResolver(ctx, &tx, resolver)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]int64), nil
 }
 
 //根据交易内容，计算交易Hash
@@ -110,44 +362,188 @@ func (abtc *AdaptorBTC) CalcTxHash(input *adaptor.CalcTxHashInput) (*adaptor.Cal
 
 //将签名后的交易广播到网络中,如果发送交易需要手续费，指定最多支付的手续费
 func (abtc *AdaptorBTC) SendTransaction(input *adaptor.SendTransactionInput) (*adaptor.SendTransactionOutput, error) {
-	return SendTransaction(input, &abtc.RPCParams)
+	return abtc.SendTransactionCtx(context.Background(), input)
+}
+
+//SendTransactionCtx is SendTransaction, a retry after a transient error is accepted
+//if the node already has the tx, which is sent by the failed attempt
+func (abtc *AdaptorBTC) SendTransactionCtx(ctx context.Context, input *adaptor.SendTransactionInput) (*adaptor.SendTransactionOutput, error) {
+	if abtc.Policy != nil {
+		violations, err := abtc.CheckTransactionCtx(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		if len(violations) != 0 {
+			return nil, kindError(ErrKindInvalidTx, "CheckTransaction failed", &PolicyError{Violations: violations})
+		}
+	}
+	var output *adaptor.SendTransactionOutput
+	err := retry(ctx, abtc.Retry, func(attempt int) error {
+		result, err := abtc.nodeSet().CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+			return sendTransactionByClient(input, client)
+		})
+		if err != nil && attempt > 1 && isTxAlreadyKnown(err) {
+			hash, hashErr := CalcTxHash(&adaptor.CalcTxHashInput{Transaction: input.Transaction})
+			if hashErr != nil {
+				return err
+			}
+			output = &adaptor.SendTransactionOutput{TxID: hash.Hash}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		output = result.(*adaptor.SendTransactionOutput)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
 }
 
 //根据交易ID获得交易的基本信息
 func (abtc *AdaptorBTC) GetTxBasicInfo(input *adaptor.GetTxBasicInfoInput) (*adaptor.GetTxBasicInfoOutput, error) {
-	return GetTxBasicInfo(input, &abtc.RPCParams)
+	return abtc.GetTxBasicInfoCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) GetTxBasicInfoCtx(ctx context.Context, input *adaptor.GetTxBasicInfoInput) (*adaptor.GetTxBasicInfoOutput, error) {
+	result, err := abtc.call(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return getTxBasicInfoByClient(input, client, abtc.finality())
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := result.(*adaptor.GetTxBasicInfoOutput)
+	if abtc.Quorum > 1 {
+		if err := abtc.checkTxQuorum(ctx, &output.Tx); err != nil {
+			return nil, err
+		}
+	}
+	if err := abtc.checkStable(ctx, output.Tx.BlockID, output.Tx.BlockHeight, rawTxAmount(output.Tx.TxRawData),
+		&output.Tx.IsStable); err != nil {
+		return nil, err
+	}
+	return output, nil
 }
 
 //查询获得一个区块的信息
 func (abtc *AdaptorBTC) GetBlockInfo(input *adaptor.GetBlockInfoInput) (*adaptor.GetBlockInfoOutput, error) {
-	return GetBlockInfo(input, &abtc.RPCParams)
+	return abtc.GetBlockInfoCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) GetBlockInfoCtx(ctx context.Context, input *adaptor.GetBlockInfoInput) (*adaptor.GetBlockInfoOutput, error) {
+	result, err := abtc.call(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return getBlockInfoByClient(input, client, abtc.finality())
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := result.(*adaptor.GetBlockInfoOutput)
+	if abtc.Quorum > 1 && !input.Latest {
+		if err := abtc.checkBlockQuorum(ctx, &output.Block); err != nil {
+			return nil, err
+		}
+	}
+	if err := abtc.checkStable(ctx, output.Block.BlockID, output.Block.BlockHeight, 0, &output.Block.IsStable); err != nil {
+		return nil, err
+	}
+	return output, nil
 }
 
 /*ICryptoCurrency*/
 //获取某地址下持有某资产的数量,返回数量为该资产的最小单位
 func (abtc *AdaptorBTC) GetBalance(input *adaptor.GetBalanceInput) (*adaptor.GetBalanceOutput, error) {
-	return GetBalance(input, &abtc.RPCParams, abtc.NetID)
+	return abtc.GetBalanceCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) GetBalanceCtx(ctx context.Context, input *adaptor.GetBalanceInput) (*adaptor.GetBalanceOutput, error) {
+	if abtc.indexed(input.Address) {
+		return abtc.Indexer.GetBalance(input)
+	}
+	if abtc.LightClient != nil {
+		return abtc.LightClient.GetBalanceCtx(ctx, input)
+	}
+	result, err := abtc.call(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return getBalanceByClient(input, client, abtc.NetID, abtc.finality())
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := result.(*adaptor.GetBalanceOutput)
+	return output, nil
 }
 
 //获取某资产的小数点位数
 func (abtc *AdaptorBTC) GetAssetDecimal(asset *adaptor.GetAssetDecimalInput) (*adaptor.GetAssetDecimalOutput, error) {
-	result := adaptor.GetAssetDecimalOutput{Decimal: 8}
+	result := adaptor.GetAssetDecimalOutput{Decimal: uint(ChainOf(GetNet(abtc.NetID)).Decimals)}
 	return &result, nil
 }
 
-//创建一个转账交易，但是未签名 //input.Extra 必须是33的整数倍， txid:22+index:1 ，output.Extra 同理
+//创建一个转账交易，但是未签名 //the Extra are of EncodeExtra, see createTransferTokenTxByUnspend
 func (abtc *AdaptorBTC) CreateTransferTokenTx(input *adaptor.CreateTransferTokenTxInput) (*adaptor.CreateTransferTokenTxOutput, error) {
-	return CreateTransferTokenTx(input, &abtc.RPCParams, abtc.NetID)
+	return abtc.CreateTransferTokenTxCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) CreateTransferTokenTxCtx(ctx context.Context, input *adaptor.CreateTransferTokenTxInput) (*adaptor.CreateTransferTokenTxOutput, error) {
+	if abtc.indexed(input.FromAddress) {
+		return createTransferTokenTxByUnspend(input, abtc.NetID, abtc.Indexer.unspendMap)
+	}
+	result, err := abtc.call(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return createTransferTokenTxByClient(input, client, abtc.NetID, abtc.finality())
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := result.(*adaptor.CreateTransferTokenTxOutput)
+	return output, nil
 }
 
 //获取某个地址对某种Token的交易历史,支持分页和升序降序排列
 func (abtc *AdaptorBTC) GetAddrTxHistory(input *adaptor.GetAddrTxHistoryInput) (*adaptor.GetAddrTxHistoryOutput, error) {
-	return GetTransactions(input, &abtc.RPCParams, abtc.NetID)
+	return abtc.GetAddrTxHistoryCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) GetAddrTxHistoryCtx(ctx context.Context, input *adaptor.GetAddrTxHistoryInput) (*adaptor.GetAddrTxHistoryOutput, error) {
+	if abtc.indexed(input.FromAddress) {
+		return abtc.Indexer.GetTransactions(input)
+	}
+	if abtc.LightClient != nil {
+		return abtc.LightClient.GetTransactionsCtx(ctx, input)
+	}
+	result, err := abtc.callResolver(ctx, func(client *rpcclient.Client, resolver *txResolver) (interface{}, error) {
+		return getTransactionsByClient(ctx, input, client, resolver, abtc.NetID, abtc.finality())
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := result.(*adaptor.GetAddrTxHistoryOutput)
+	return output, nil
 }
 
 //根据交易ID获得对应的转账交易
 func (abtc *AdaptorBTC) GetTransferTx(input *adaptor.GetTransferTxInput) (*adaptor.GetTransferTxOutput, error) {
-	return GetTransferTx(input, &abtc.RPCParams)
+	return abtc.GetTransferTxCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) GetTransferTxCtx(ctx context.Context, input *adaptor.GetTransferTxInput) (*adaptor.GetTransferTxOutput, error) {
+	result, err := abtc.callResolver(ctx, func(client *rpcclient.Client, resolver *txResolver) (interface{}, error) {
+		return getTransferTxByClient(ctx, input, client, resolver, abtc.finality(), ChainOf(GetNet(abtc.NetID)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	output := result.(*adaptor.GetTransferTxOutput)
+	if abtc.Quorum > 1 {
+		if err := abtc.checkTxQuorum(ctx, &output.Tx.TxBasicInfo); err != nil {
+			return nil, err
+		}
+	}
+	if err := abtc.checkStable(ctx, output.Tx.BlockID, output.Tx.BlockHeight, rawTxAmount(output.Tx.TxRawData),
+		&output.Tx.IsStable); err != nil {
+		return nil, err
+	}
+	return output, nil
 }
 
 //创建一个多签地址，该地址必须要满足signCount个签名才能解锁
@@ -156,12 +552,123 @@ func (abtc *AdaptorBTC) CreateMultiSigAddress(input *adaptor.CreateMultiSigAddre
 }
 
 func (abtc *AdaptorBTC) CreateMultiSigPayoutTx(input *adaptor.CreateMultiSigPayoutTxInput) (*adaptor.CreateMultiSigPayoutTxOutput, error) {
+	return abtc.CreateMultiSigPayoutTxCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) CreateMultiSigPayoutTxCtx(ctx context.Context, input *adaptor.CreateMultiSigPayoutTxInput) (*adaptor.CreateMultiSigPayoutTxOutput, error) {
 	newInput := &adaptor.CreateTransferTokenTxInput{FromAddress: input.FromAddress, ToAddress: input.ToAddress,
 		Amount: input.Amount, Fee: input.Fee, Extra: input.Extra}
-	output, err := CreateTransferTokenTx(newInput, &abtc.RPCParams, abtc.NetID)
+	output, err := abtc.CreateTransferTokenTxCtx(ctx, newInput)
 	if err != nil {
 		return nil, err
 	}
 	newOutput := adaptor.CreateMultiSigPayoutTxOutput{Transaction: output.Transaction, Extra: output.Extra}
 	return &newOutput, nil
 }
+
+//GetBlockHash return the hash of the main chain block at height, agreed by Quorum nodes
+func (abtc *AdaptorBTC) GetBlockHash(height int64) (*chainhash.Hash, error) {
+	return abtc.GetBlockHashCtx(context.Background(), height)
+}
+
+func (abtc *AdaptorBTC) GetBlockHashCtx(ctx context.Context, height int64) (*chainhash.Hash, error) {
+	var hash *chainhash.Hash
+	err := retry(ctx, abtc.Retry, func(attempt int) (err error) {
+		hash, err = abtc.nodeSet().GetBlockHash(ctx, height, abtc.Quorum)
+		return err
+	})
+	return hash, err
+}
+
+//GetTxConfirm return the block and confirmations of the tx, agreed by Quorum nodes
+func (abtc *AdaptorBTC) GetTxConfirm(txID []byte) (*TxConfirm, error) {
+	return abtc.GetTxConfirmCtx(context.Background(), txID)
+}
+
+func (abtc *AdaptorBTC) GetTxConfirmCtx(ctx context.Context, txID []byte) (*TxConfirm, error) {
+	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(txID))
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "NewHashFromStr tx failed", err)
+	}
+	var confirm *TxConfirm
+	err = retry(ctx, abtc.Retry, func(attempt int) (err error) {
+		confirm, err = abtc.nodeSet().GetTxConfirm(ctx, hash, abtc.Quorum)
+		return err
+	})
+	return confirm, err
+}
+
+//GetTxOut return the unspent output, nil if it is spent or not exist, agreed by Quorum nodes
+func (abtc *AdaptorBTC) GetTxOut(txID []byte, index uint32) (*btcjson.GetTxOutResult, error) {
+	return abtc.GetTxOutCtx(context.Background(), txID, index)
+}
+
+func (abtc *AdaptorBTC) GetTxOutCtx(ctx context.Context, txID []byte, index uint32) (*btcjson.GetTxOutResult, error) {
+	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(txID))
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "NewHashFromStr tx failed", err)
+	}
+	var txOut *btcjson.GetTxOutResult
+	err = retry(ctx, abtc.Retry, func(attempt int) (err error) {
+		txOut, err = abtc.nodeSet().GetTxOut(ctx, hash, index, abtc.Quorum)
+		return err
+	})
+	return txOut, err
+}
+
+//GetTxMerkleProof return the merkle proof of a mined tx, Bytes of it is the same as gettxoutproof
+func (abtc *AdaptorBTC) GetTxMerkleProof(txID []byte) (*MerkleProof, error) {
+	return abtc.GetTxMerkleProofCtx(context.Background(), txID)
+}
+
+func (abtc *AdaptorBTC) GetTxMerkleProofCtx(ctx context.Context, txID []byte) (*MerkleProof, error) {
+	result, err := abtc.call(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return getTxMerkleProofByClient(txID, client)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*MerkleProof), nil
+}
+
+//checkTxQuorum check the block of tx with Quorum nodes, and set IsStable by the minimum confirmations
+func (abtc *AdaptorBTC) checkTxQuorum(ctx context.Context, tx *adaptor.TxBasicInfo) error {
+	confirm, err := abtc.GetTxConfirmCtx(ctx, tx.TxID)
+	if err != nil {
+		return err
+	}
+	if err := checkTxConfirm(confirm, tx.BlockID, tx.IsInBlock); err != nil {
+		return err
+	}
+	policy := abtc.finality()
+	isStable := confirm.Confirmations >= policy.Required(rawTxAmount(tx.TxRawData))
+	if policy.needBits() { //the work is only checked by the node
+		isStable = isStable && tx.IsStable
+	}
+	tx.IsStable = isStable
+	return nil
+}
+
+//checkBlockQuorum check the block is in the main chain of Quorum nodes
+func (abtc *AdaptorBTC) checkBlockQuorum(ctx context.Context, block *adaptor.BlockInfo) error {
+	hash, err := abtc.GetBlockHashCtx(ctx, int64(block.BlockHeight))
+	if err != nil {
+		return err
+	}
+	if hash.String() != hex.EncodeToString(block.BlockID) {
+		return newError(ErrKindNode, fmt.Sprintf("block %x at height %d not agreed by quorum, quorum block : %s",
+			block.BlockID, block.BlockHeight, hash.String()))
+	}
+	return nil
+}
+
+//isTxAlreadyKnown return true if the node reject the tx because it already has it
+func isTxAlreadyKnown(err error) bool {
+	rpcErr, ok := Cause(err).(*btcjson.RPCError)
+	if !ok {
+		return false
+	}
+	return rpcErr.Code == btcjson.ErrRPCTxAlreadyInChain ||
+		strings.Contains(rpcErr.Message, "already have transaction") || //btcd
+		strings.Contains(rpcErr.Message, "txn-already") //Bitcoin core
+}
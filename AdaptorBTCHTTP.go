@@ -18,6 +18,7 @@
 package btcadaptor
 
 import (
+	"context"
 	"errors"
 
 	"github.com/palletone/adaptor"
@@ -103,6 +104,10 @@ func (abtc *AdaptorBTCHTTP) GetTxBasicInfo(input *adaptor.GetTxBasicInfoInput) (
 	return GetTxBasicInfoHttp(input, abtc.NetID)
 }
 
+func (abtc *AdaptorBTCHTTP) GetTxBasicInfoCtx(ctx context.Context, input *adaptor.GetTxBasicInfoInput) (*adaptor.GetTxBasicInfoOutput, error) {
+	return GetTxBasicInfoHttpCtx(ctx, input, abtc.NetID)
+}
+
 //查询获得一个区块的信息
 func (abtc *AdaptorBTCHTTP) GetBlockInfo(input *adaptor.GetBlockInfoInput) (*adaptor.GetBlockInfoOutput, error) { //todo zxl
 	return nil, errors.New("todo")
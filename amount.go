@@ -0,0 +1,70 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/shopspring/decimal"
+
+	"github.com/palletone/adaptor"
+)
+
+//satoshiDecimals is the decimals of the amounts in coins returned by nodes of bitcoin
+const satoshiDecimals = 8
+
+//amountOf return the amount in the smallest unit of a value in coins returned by nodes.
+//The value is converted by its shortest decimal representation, which is the number sent by the node
+//if it has 15 significant digits at most, so no unit is lost by the binary representation of float64.
+//Amounts must be summed after the conversion, never as float64.
+func amountOf(value float64, decimals int32) btcutil.Amount {
+	return btcutil.Amount(decimal.NewFromFloat(value).Shift(decimals).Round(0).IntPart())
+}
+
+//satoshiOf is amountOf a value in BTC
+func satoshiOf(value float64) btcutil.Amount {
+	return amountOf(value, satoshiDecimals)
+}
+
+//amountOf is amountOf a value in coins of the chain
+func (c *Chain) amountOf(value float64) btcutil.Amount {
+	return amountOf(value, c.Decimals)
+}
+
+//amountInput return the amount of the input in the smallest unit, it must be a non-negative int64
+func amountInput(amount *adaptor.AmountAsset, name string) (btcutil.Amount, error) {
+	if amount == nil || amount.Amount == nil {
+		return 0, nil
+	}
+	if amount.Amount.Sign() < 0 || !amount.Amount.IsInt64() {
+		return 0, newError(ErrKindInvalidParams, fmt.Sprintf("the %s %s is out of range", name, amount.Amount))
+	}
+	return btcutil.Amount(amount.Amount.Int64()), nil
+}
+
+//bigAmount convert the amount to the big int of adaptor
+func bigAmount(amount btcutil.Amount) *big.Int {
+	return big.NewInt(int64(amount))
+}
+
+//formatCoins format the amount in the smallest unit as coins of the decimals, like the amounts of nodes
+func formatCoins(amount btcutil.Amount, decimals int32) string {
+	return decimal.New(int64(amount), -decimals).StringFixed(decimals)
+}
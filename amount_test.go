@@ -0,0 +1,173 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/quick"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+)
+
+//nodeValue return the value of the satoshis as decoded from the JSON of nodes
+func nodeValue(satoshi int64) float64 {
+	value, _ := strconv.ParseFloat(fmt.Sprintf("%d.%08d", satoshi/1e8, satoshi%1e8), 64)
+	return value
+}
+
+func TestAmountOf(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  btcutil.Amount
+	}{
+		{0, 0},
+		{0.00000001, 1},
+		{0.1, 10000000},
+		{0.29, 29000000}, //0.29*1e8 is 28999999.999999996
+		{1.0 - 0.7 - 0.2, 10000000},
+		{20999999.9769, 2099999997690000},
+	}
+	for _, test := range tests {
+		if amount := satoshiOf(test.value); amount != test.want {
+			t.Errorf("unexpected amount of %v - got: %v, "+"want: %v", test.value, int64(amount), int64(test.want))
+		}
+	}
+	if amount := DOGEMainNetChain.amountOf(1000000000.5); amount != 100000000050000000 {
+		t.Errorf("unexpected DOGE amount - got: %v", int64(amount))
+	}
+
+	//every value of 15 significant digits at most is exact
+	exact := func(satoshi int64) bool {
+		if satoshi < 0 {
+			satoshi = -satoshi
+		}
+		satoshi %= 1e15
+		return satoshiOf(nodeValue(satoshi)) == btcutil.Amount(satoshi)
+	}
+	if err := quick.Check(exact, nil); err != nil {
+		t.Error(err)
+	}
+
+	for _, test := range []*adaptor.AmountAsset{adaptor.NewAmountAsset(big.NewInt(-1), "BTC"),
+		adaptor.NewAmountAsset(new(big.Int).Lsh(big.NewInt(1), 64), "BTC")} {
+		if _, err := amountInput(test, "amount"); ErrorKindOf(err) != ErrKindInvalidParams {
+			t.Errorf("unexpected error of amount %v - got: %v", test.Amount, err)
+		}
+	}
+}
+
+func TestCreateTransferTxBalanced(t *testing.T) {
+	key, _ := hex.DecodeString("d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0")
+	pubKey, _ := GetPublicKey(key, NETID_TEST)
+	from, _ := PubKeyToAddress(pubKey, NETID_TEST)
+
+	//the inputs are the outputs and the fee, the fee is the requested one or with the dust change
+	balanced := func(values []uint32, amount uint32, fee uint16) bool {
+		utxos := map[wire.OutPoint]btcutil.Amount{}
+		for i, value := range values {
+			utxos[outPointOf(fmt.Sprintf("%064x", i+1), 0)] = btcutil.Amount(value)
+		}
+		input := &adaptor.CreateTransferTokenTxInput{FromAddress: from, ToAddress: from,
+			Amount: adaptor.NewAmountAsset(big.NewInt(int64(amount)+546), "BTC"),
+			Fee:    adaptor.NewAmountAsset(big.NewInt(int64(fee)+5000), "BTC")}
+		output, err := createTransferTokenTxByUnspend(input, NETID_TEST,
+			func(addr btcutil.Address) (map[wire.OutPoint]btcutil.Amount, error) { return utxos, nil })
+		if err != nil {
+			return ErrorKindOf(err) == ErrKindInvalidParams || err.Error() == "selUnspends failed : balance is not enough" ||
+				len(values) == 0
+		}
+		var tx wire.MsgTx
+		tx.Deserialize(bytes.NewReader(output.Transaction))
+		var inputs, outputs btcutil.Amount
+		for _, in := range tx.TxIn {
+			inputs += utxos[in.PreviousOutPoint]
+		}
+		for _, out := range tx.TxOut {
+			outputs += btcutil.Amount(out.Value)
+		}
+		requested := input.Fee.Amount.Int64()
+		actual := int64(inputs - outputs)
+		return tx.TxOut[0].Value == input.Amount.Amount.Int64() &&
+			(actual == requested || len(tx.TxOut) == 1 && actual >= requested && actual < requested+BTCChain.DustLimit)
+	}
+	if err := quick.Check(balanced, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetTransferTxBalanced(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+
+	hash := func(n int) string { return fmt.Sprintf("%064x", n) }
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addrB := "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"
+	vout := func(n uint32, satoshi int64, addr string) btcjson.Vout {
+		return btcjson.Vout{Value: nodeValue(satoshi), N: n, ScriptPubKey: btcjson.ScriptPubKeyResult{Addresses: []string{addr}}}
+	}
+	var mtx sync.Mutex
+	txs := map[string]btcjson.TxRawResult{}
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		mtx.Lock()
+		defer mtx.Unlock()
+		if tx, ok := txs[txid]; ok {
+			return tx, nil
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No information available about transaction")
+	})
+	node.handle("getblockheader", func(params []json.RawMessage) (interface{}, error) {
+		return btcjson.GetBlockHeaderVerboseResult{Hash: hash(100), Height: 99, Confirmations: 20}, nil
+	})
+	node.handle("gettxoutproof", func(params []json.RawMessage) (interface{}, error) {
+		var txids []string
+		json.Unmarshal(params[0], &txids)
+		return txOutProof(txids, txids[0])
+	})
+	node.handle("getbestblockhash", func(params []json.RawMessage) (interface{}, error) {
+		return hash(100), nil
+	})
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	defer abtc.Close()
+	next := 1000
+	//the amount, the change and the fee are the inputs exactly
+	balanced := func(values []uint32, amountPart, changePart uint16) bool {
+		mtx.Lock()
+		tx := btcjson.TxRawResult{Txid: hash(next), BlockHash: hash(100), Confirmations: 20}
+		total := int64(0)
+		for _, value := range append(values, 1) {
+			next++
+			txs[hash(next)] = btcjson.TxRawResult{Txid: hash(next), BlockHash: hash(100), Confirmations: 20,
+				Vout: []btcjson.Vout{vout(0, int64(value)*1000, addrA)}}
+			tx.Vin = append(tx.Vin, btcjson.Vin{Txid: hash(next), Vout: 0})
+			total += int64(value) * 1000
+		}
+		amount := total * int64(amountPart) / 65536
+		change := (total - amount) * int64(changePart) / 65536
+		tx.Vout = []btcjson.Vout{vout(0, amount, addrB), vout(1, change, addrA)}
+		txs[tx.Txid] = tx
+		next++
+		mtx.Unlock()
+
+		transfer, err := abtc.GetTransferTx(&adaptor.GetTransferTxInput{TxID: mustDecodeHex(tx.Txid)})
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		return transfer.Tx.Amount.Amount.Int64() == amount && transfer.Tx.Fee.Amount.Int64() == total-amount-change
+	}
+	if err := quick.Check(balanced, &quick.Config{MaxCount: 30}); err != nil {
+		t.Error(err)
+	}
+}
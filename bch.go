@@ -0,0 +1,127 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+)
+
+//The BCH networks have the legacy address magics of bitcoin, no segwit, and sign with SIGHASH_FORKID.
+//Their difficulty adjustment is not of bitcoin, so they are not supported by HeaderChain.
+var (
+	BCHMainNetParams  = bchParams(&chaincfg.MainNetParams, "bchmainnet", 0xe8f3e1e3)
+	BCHTestNet3Params = bchParams(&chaincfg.TestNet3Params, "bchtestnet3", 0xf4f3e5f4)
+	BCHRegTestParams  = bchParams(&chaincfg.RegressionNetParams, "bchregtest", 0xfabfb5da)
+)
+
+//bchParams return the params of the BCH network forked from btc
+func bchParams(btc *chaincfg.Params, name string, net wire.BitcoinNet) chaincfg.Params {
+	params := *btc
+	params.Name = name
+	params.Net = net
+	params.DNSSeeds = nil
+	params.Checkpoints = nil
+	params.Bech32HRPSegwit = "" //no segwit addresses
+	return params
+}
+
+//RegisterBCHNet is RegisterChain of a BCH network of the CashAddr prefix
+func RegisterBCHNet(params *chaincfg.Params, prefix string) (int, error) {
+	chain := bchChain(params, prefix)
+	return RegisterChain(&chain)
+}
+
+//CashAddrPrefix return the CashAddr prefix of a BCH network
+func CashAddrPrefix(params *chaincfg.Params) (string, bool) {
+	prefix := ChainOf(params).CashAddrPrefix
+	return prefix, prefix != ""
+}
+
+//IsBCH return whether params is of a BCH network
+func IsBCH(params *chaincfg.Params) bool {
+	_, ok := CashAddrPrefix(params)
+	return ok
+}
+
+//DecodeAddress decode an address of the network, CashAddr and legacy addresses on BCH networks
+func DecodeAddress(addr string, params *chaincfg.Params) (btcutil.Address, error) {
+	prefix, ok := CashAddrPrefix(params)
+	if !ok {
+		return btcutil.DecodeAddress(addr, params)
+	}
+	if legacy, err := btcutil.DecodeAddress(addr, params); err == nil && legacy.IsForNet(params) {
+		return legacy, nil
+	}
+	return DecodeCashAddr(addr, prefix, params)
+}
+
+//EncodeAddress encode an address of the network, a CashAddr on BCH networks
+func EncodeAddress(addr btcutil.Address, params *chaincfg.Params) string {
+	if prefix, ok := CashAddrPrefix(params); ok {
+		if cashAddr, err := EncodeCashAddr(prefix, addr); err == nil {
+			return cashAddr
+		}
+	}
+	return addr.EncodeAddress()
+}
+
+//addressKey return the legacy encoding of a CashAddr of any BCH network, to compare it with the addresses
+//returned by nodes. Other addresses are returned as is.
+func addressKey(addr string) string {
+	if addr == "" || strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr { //base58 is mixed case
+		return addr
+	}
+	netsMtx.RLock()
+	defer netsMtx.RUnlock()
+	for _, chain := range chains {
+		if chain.CashAddrPrefix == "" {
+			continue
+		}
+		if decoded, err := DecodeCashAddr(addr, chain.CashAddrPrefix, chain.Params); err == nil {
+			return decoded.EncodeAddress()
+		}
+	}
+	return addr
+}
+
+//inputAmountsByResolver return the amounts of the prevouts of the inputs of tx
+func inputAmountsByResolver(ctx context.Context, tx *wire.MsgTx, resolver *txResolver) ([]int64, error) {
+	txids := make([]string, 0, len(tx.TxIn))
+	for _, in := range tx.TxIn {
+		txids = append(txids, in.PreviousOutPoint.Hash.String())
+	}
+	prevTxs, err := resolver.getTxs(ctx, txids)
+	if err != nil {
+		return nil, err
+	}
+	amounts := make([]int64, 0, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		prevTx := prevTxs[in.PreviousOutPoint.Hash.String()]
+		if prevTx == nil || int(in.PreviousOutPoint.Index) >= len(prevTx.Vout) {
+			return nil, newError(ErrKindNode, fmt.Sprintf("prevout of input %d %v is not found", i, in.PreviousOutPoint))
+		}
+		amounts = append(amounts, int64(satoshiOf(prevTx.Vout[in.PreviousOutPoint.Index].Value)))
+	}
+	return amounts, nil
+}
@@ -0,0 +1,160 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+func TestBCHAddress(t *testing.T) {
+	key, _ := hex.DecodeString("d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0")
+	pubKey, _ := GetPublicKey(key, NETID_BCH_MAIN)
+	legacy, _ := PubKeyToAddress(pubKey, NETID_MAIN)
+	for netID, prefix := range map[int]string{NETID_BCH_MAIN: "bitcoincash:q", NETID_BCH_TEST: "bchtest:q",
+		NETID_BCH_REGTEST: "bchreg:q"} {
+		addr, err := PubKeyToAddress(pubKey, netID)
+		if err != nil || !strings.HasPrefix(addr, prefix) {
+			t.Errorf("unexpected address of %d - got: %v %v, "+"want: %v...", netID, addr, err, prefix)
+		}
+		decoded, err := DecodeAddress(addr, GetNet(netID))
+		if err != nil || EncodeAddress(decoded, GetNet(netID)) != addr {
+			t.Errorf("unexpected decoded address of %s - got: %v %v", addr, decoded, err)
+		}
+	}
+
+	cashAddr, _ := PubKeyToAddress(pubKey, NETID_BCH_MAIN)
+	if decoded, err := DecodeAddress(legacy, &BCHMainNetParams); err != nil || decoded.EncodeAddress() != legacy {
+		t.Errorf("unexpected decoded legacy address - got: %v %v, "+"want: %v", decoded, err, legacy)
+	}
+	if key := addressKey(cashAddr); key != legacy {
+		t.Errorf("unexpected address key - got: %v, "+"want: %v", key, legacy)
+	}
+	if key := addressKey(legacy); key != legacy {
+		t.Errorf("unexpected address key of legacy - got: %v, "+"want: %v", key, legacy)
+	}
+	if _, err := DecodeAddress(cashAddr, &BCHTestNet3Params); err == nil {
+		t.Errorf("mainnet CashAddr is decoded on testnet")
+	}
+	if _, err := NewHeaderChain(HeaderChainConfig{Params: &BCHMainNetParams}); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of BCH header chain - got: %v", err)
+	}
+}
+
+func TestBCHSignTransaction(t *testing.T) {
+	key, _ := hex.DecodeString("d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0")
+	pubKey, _ := GetPublicKey(key, NETID_BCH_TEST)
+	from, _ := PubKeyToAddress(pubKey, NETID_BCH_TEST)
+
+	//two utxos of 0.01 and 0.02 are spent to pay 0.025
+	utxos := map[wire.OutPoint]btcutil.Amount{
+		outPointOf("101d482b60cd3f74a61ce265d62e383456b9c21c84477931d207ea8f503d84cc", 0): 1000000,
+		outPointOf("4d8e651f7f261e3f8165000ebe1a2dd71606662b0ba741b3482ab0481b0d0844", 0): 2000000,
+	}
+	var input adaptor.CreateTransferTokenTxInput
+	input.FromAddress = from
+	input.ToAddress = "bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvqcw003ap"
+	input.Amount = adaptor.NewAmountAssetString("2500000", "BCH")
+	input.Fee = adaptor.NewAmountAssetString("10000", "BCH")
+	created, err := createTransferTokenTxByUnspend(&input, NETID_BCH_TEST, func(addr btcutil.Address) (map[wire.OutPoint]btcutil.Amount, error) {
+		return utxos, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tx wire.MsgTx
+	tx.Deserialize(bytes.NewReader(created.Transaction))
+	if len(tx.TxIn) != 2 || len(tx.TxOut) != 2 || tx.TxOut[0].Value != 2500000 ||
+		txscript.GetScriptClass(tx.TxOut[0].PkScript) != txscript.PubKeyHashTy {
+		t.Fatalf("unexpected tx - got: %d inputs %d outputs", len(tx.TxIn), len(tx.TxOut))
+	}
+	amounts := make([]int64, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		amounts[i] = int64(utxos[in.PreviousOutPoint])
+	}
+
+	signInput := &adaptor.SignTransactionInput{PrivateKey: key, Transaction: created.Transaction, Extra: []byte(from)}
+	if _, err := SignTransaction(signInput, NETID_BCH_TEST); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of signing without amounts - got: %v", err)
+	}
+	signed, err := SignTransactionAmounts(signInput, amounts, NETID_BCH_TEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var signedTx wire.MsgTx
+	signedTx.Deserialize(bytes.NewReader(signed.SignedTx))
+	addr, _ := DecodeAddress(from, &BCHTestNet3Params)
+	pkScript, _ := txscript.PayToAddrScript(addr)
+	for i, in := range signedTx.TxIn {
+		pushes, _ := txscript.PushedData(in.SignatureScript)
+		if sig := pushes[0]; txscript.SigHashType(sig[len(sig)-1]) != txscript.SigHashAll|txscript.SigHashForkID {
+			t.Errorf("unexpected hash type of input %d - got: %x", i, sig[len(sig)-1])
+		}
+		if err := verifyInput(&BCHTestNet3Params, pkScript, &signedTx, i, amounts[i]); err != nil {
+			t.Errorf("unexpected error of input %d - got: %v", i, err)
+		}
+		//the amount is signed, and the signature is not valid on bitcoin
+		if err := verifyInput(&BCHTestNet3Params, pkScript, &signedTx, i, amounts[i]+1); err == nil {
+			t.Errorf("input %d is valid of another amount", i)
+		}
+		if err := verifyInput(GetNet(NETID_TEST), pkScript, &signedTx, i, amounts[i]); err == nil {
+			t.Errorf("input %d is valid on bitcoin", i)
+		}
+	}
+}
+
+func TestBCHBindTxAndSignature(t *testing.T) {
+	var keys [][]byte
+	var pubKeys [][]byte
+	for _, keyHex := range []string{"d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0",
+		"ac18d6ffa4e006ee5c297e14962d213030910a045dccc9d393686eb1613a1477"} {
+		key, _ := hex.DecodeString(keyHex)
+		pubKey, _ := GetPublicKey(key, NETID_BCH_REGTEST)
+		keys = append(keys, key)
+		pubKeys = append(pubKeys, pubKey)
+	}
+	multisig, err := CreateMultiSigAddress(&adaptor.CreateMultiSigAddressInput{Keys: pubKeys, SignCount: 2},
+		NETID_BCH_REGTEST)
+	if err != nil || !strings.HasPrefix(multisig.Address, "bchreg:p") {
+		t.Fatalf("unexpected multisig address - got: %v %v", multisig, err)
+	}
+	redeem := []byte(hex.EncodeToString(multisig.Extra))
+
+	prevHash, _ := hex.DecodeString("101d482b60cd3f74a61ce265d62e383456b9c21c84477931d207ea8f503d84cc")
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: 1}})
+	copy(tx.TxIn[0].PreviousOutPoint.Hash[:], prevHash)
+	to, err := DecodeAddress("bchreg:qpm2qsznhks23z7629mms6s4cwef74vcwv6ycwvz78", &BCHRegTestParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkScript, _ := txscript.PayToAddrScript(to)
+	tx.AddTxOut(wire.NewTxOut(90000, pkScript))
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	amounts := []int64{100000}
+
+	var signedTxs [][]byte
+	for _, key := range keys {
+		signed, err := SignTransactionAmounts(&adaptor.SignTransactionInput{PrivateKey: key,
+			Transaction: buf.Bytes(), Extra: redeem}, amounts, NETID_BCH_REGTEST)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signedTxs = append(signedTxs, signed.SignedTx)
+	}
+	bindInput := &adaptor.BindTxAndSignatureInput{Transaction: buf.Bytes(), SignedTxs: signedTxs, Extra: redeem}
+	if _, err := BindTxAndSignatureAmounts(bindInput, amounts, NETID_BCH_REGTEST); err != nil {
+		t.Errorf("unexpected error of binding - got: %v", err)
+	}
+	if _, err := BindTxAndSignatureAmounts(bindInput, []int64{amounts[0] + 1}, NETID_BCH_REGTEST); err == nil {
+		t.Errorf("signatures are bound of another amount")
+	}
+}
@@ -0,0 +1,295 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+//xorKeyFile is the obfuscation key file of the blocks dir, Bitcoin Core 28+
+const xorKeyFile = "xor.dat"
+
+//xorFile read a blk file, xor the data by key at the file offset
+type xorFile struct {
+	f   *os.File
+	key []byte
+}
+
+func (xf *xorFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := xf.f.ReadAt(p, off)
+	xf.xor(p[:n], off)
+	return n, err
+}
+
+//xor deobfuscate p read at the file offset off
+func (xf *xorFile) xor(p []byte, off int64) {
+	if len(xf.key) > 0 {
+		for i := range p {
+			p[i] ^= xf.key[(off+int64(i))%int64(len(xf.key))]
+		}
+	}
+}
+
+//blockLocation is a block found in the blk files
+type blockLocation struct {
+	file   string
+	offset int64 //of the block data, after magic and size
+	size   uint32
+	header wire.BlockHeader
+}
+
+//BlockFiles read the blocks in the blk*.dat files of Bitcoin Core.
+//Each record of a file is network magic, block size and the block, the records are not in height order.
+type BlockFiles struct {
+	dir    string
+	params *chaincfg.Params
+	xorKey []byte
+
+	blocks map[chainhash.Hash]*blockLocation
+}
+
+//OpenBlockFiles open the blocks dir, if xorKey is nil it is read from xor.dat of dir if exist
+func OpenBlockFiles(dir string, params *chaincfg.Params, xorKey []byte) (*BlockFiles, error) {
+	if xorKey == nil {
+		key, err := ioutil.ReadFile(filepath.Join(dir, xorKeyFile))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read %s failed : %s", xorKeyFile, err.Error())
+		}
+		xorKey = key
+	}
+	if bytes.Equal(xorKey, make([]byte, len(xorKey))) { //all zero, not obfuscated
+		xorKey = nil
+	}
+	return &BlockFiles{dir: dir, params: params, xorKey: xorKey, blocks: map[chainhash.Hash]*blockLocation{}}, nil
+}
+
+func (bf *BlockFiles) open(file string) (*xorFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &xorFile{f: f, key: bf.xorKey}, nil
+}
+
+//Scan read the headers of all blocks in the blk files
+func (bf *BlockFiles) Scan() error {
+	files, err := filepath.Glob(filepath.Join(bf.dir, "blk*.dat"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no blk*.dat in %s", bf.dir)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if err := bf.scanFile(file); err != nil {
+			return fmt.Errorf("scan %s failed : %s", filepath.Base(file), err.Error())
+		}
+	}
+	return nil
+}
+
+func (bf *BlockFiles) scanFile(file string) error {
+	xf, err := bf.open(file)
+	if err != nil {
+		return err
+	}
+	defer xf.f.Close()
+
+	var buf [8 + wire.MaxBlockHeaderPayload]byte
+	for offset := int64(0); ; {
+		n, err := xf.f.ReadAt(buf[:8], offset)
+		if n < 8 { //end of file
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if binary.LittleEndian.Uint32(buf[:4]) == 0 { //the rest of file is preallocated, the zeros are not obfuscated
+			return nil
+		}
+		xf.xor(buf[:8], offset)
+		magic := binary.LittleEndian.Uint32(buf[:4])
+		if wire.BitcoinNet(magic) != bf.params.Net {
+			return fmt.Errorf("unexpected magic %08x at %d", magic, offset)
+		}
+		size := binary.LittleEndian.Uint32(buf[4:8])
+		if size < wire.MaxBlockHeaderPayload || size > wire.MaxBlockPayload {
+			return fmt.Errorf("invalid block size %d at %d", size, offset)
+		}
+		if _, err := xf.ReadAt(buf[8:], offset+8); err != nil {
+			if err == io.EOF { //truncated by an unclean shutdown
+				return nil
+			}
+			return err
+		}
+		loc := &blockLocation{file: file, offset: offset + 8, size: size}
+		if err := loc.header.Deserialize(bytes.NewReader(buf[8:])); err != nil {
+			return err
+		}
+		bf.blocks[loc.header.BlockHash()] = loc
+		offset += 8 + int64(size)
+	}
+}
+
+//Count return the number of scanned blocks
+func (bf *BlockFiles) Count() int {
+	return len(bf.blocks)
+}
+
+//calcWork return the work of a block with bits, 2^256 / (target+1)
+func calcWork(bits uint32) *big.Int {
+	target := compactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denominator := new(big.Int).Add(target, big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+//MainChain return the hashes of the most work chain from genesis, index is height.
+//The proof of work of every header is verified, blocks not linked to genesis are ignored.
+func (bf *BlockFiles) MainChain() ([]chainhash.Hash, error) {
+	genesis := *bf.params.GenesisHash
+	if _, exist := bf.blocks[genesis]; !exist {
+		return nil, fmt.Errorf("genesis block %s is not found", genesis)
+	}
+	children := map[chainhash.Hash][]chainhash.Hash{}
+	for hash, loc := range bf.blocks {
+		if hash != genesis {
+			children[loc.header.PrevBlock] = append(children[loc.header.PrevBlock], hash)
+		}
+	}
+
+	type node struct {
+		hash   chainhash.Hash
+		parent *node
+		height int32
+		work   *big.Int
+	}
+	best := &node{hash: genesis, work: calcWork(bf.blocks[genesis].header.Bits)}
+	queue := []*node{best}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		hashes := children[parent.hash]
+		sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+		for _, hash := range hashes {
+			header := &bf.blocks[hash].header
+			if err := checkProofOfWork(header, bf.params.PowLimit); err != nil {
+				return nil, fmt.Errorf("block %s : %s", hash, err.Error())
+			}
+			child := &node{hash: hash, parent: parent, height: parent.height + 1,
+				work: new(big.Int).Add(parent.work, calcWork(header.Bits))}
+			if child.work.Cmp(best.work) > 0 {
+				best = child
+			}
+			queue = append(queue, child)
+		}
+	}
+
+	chain := make([]chainhash.Hash, best.height+1)
+	for n := best; n != nil; n = n.parent {
+		chain[n.height] = n.hash
+	}
+	return chain, nil
+}
+
+//ReadBlock read and decode the block of hash, the merkle root is verified
+func (bf *BlockFiles) ReadBlock(hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	loc, exist := bf.blocks[*hash]
+	if !exist {
+		return nil, fmt.Errorf("block %s is not found", hash)
+	}
+	xf, err := bf.open(loc.file)
+	if err != nil {
+		return nil, err
+	}
+	defer xf.f.Close()
+	data := make([]byte, loc.size)
+	if _, err := xf.ReadAt(data, loc.offset); err != nil {
+		return nil, fmt.Errorf("read block %s failed : %s", hash, err.Error())
+	}
+	var block wire.MsgBlock
+	if err := block.Deserialize(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("Deserialize block %s failed : %s", hash, err.Error())
+	}
+	hashes := make([]chainhash.Hash, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		hashes[i] = tx.TxHash()
+	}
+	if len(hashes) == 0 || calcMerkleRoot(hashes) != block.Header.MerkleRoot {
+		return nil, fmt.Errorf("block %s merkle root mismatch", hash)
+	}
+	return &block, nil
+}
+
+//ImportBlockFiles index the main chain blocks of bf above the tip of idx, return the last imported height.
+//The index must be on the main chain of bf, the addresses watched after blocks are indexed are backfilled
+//from bf first. The db is synced to disk once at the end.
+func ImportBlockFiles(ctx context.Context, bf *BlockFiles, idx *Indexer) (int32, error) {
+	chain, err := bf.MainChain()
+	if err != nil {
+		return 0, err
+	}
+	tipHeight := idx.BestHeight()
+	if tipHeight >= idx.cfg.StartHeight {
+		tipHash, err := idx.blockHash(tipHeight)
+		if err != nil {
+			return 0, err
+		}
+		if int(tipHeight) >= len(chain) || chain[tipHeight] != *tipHash {
+			return 0, fmt.Errorf("the index tip %d %s is not on the chain of block files", tipHeight, tipHash)
+		}
+	}
+
+	idx.db.NoSync = true
+	defer func() {
+		idx.db.NoSync = false
+		idx.db.Sync()
+	}()
+	if err := idx.Backfill(ctx, bf.ReadBlock); err != nil {
+		return tipHeight, fmt.Errorf("backfill watched addresses failed : %s", err.Error())
+	}
+	for height := tipHeight + 1; int(height) < len(chain); height++ {
+		if err := ctx.Err(); err != nil {
+			return height - 1, err
+		}
+		block, err := bf.ReadBlock(&chain[height])
+		if err != nil {
+			return height - 1, err
+		}
+		if err := idx.ImportBlock(block, height); err != nil {
+			return height - 1, err
+		}
+	}
+	return int32(len(chain) - 1), nil
+}
@@ -0,0 +1,172 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//mineBlock create a regtest block of txs after prev
+func mineBlock(prev *wire.MsgBlock, tag byte, txs ...*wire.MsgTx) *wire.MsgBlock {
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript: []byte{tag, 0}})
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, []byte{txscript.OP_TRUE}))
+	block := wire.NewMsgBlock(&wire.BlockHeader{PrevBlock: prev.BlockHash(), Bits: 0x207fffff,
+		Timestamp: prev.Header.Timestamp.Add(10 * time.Minute)})
+	block.AddTransaction(coinbase)
+	for _, tx := range txs {
+		block.AddTransaction(tx)
+	}
+	var hashes []chainhash.Hash
+	for _, tx := range block.Transactions {
+		hashes = append(hashes, tx.TxHash())
+	}
+	block.Header.MerkleRoot = calcMerkleRoot(hashes)
+	for checkProofOfWork(&block.Header, chaincfg.RegressionNetParams.PowLimit) != nil {
+		block.Header.Nonce++
+	}
+	return block
+}
+
+//writeBlockFile write blocks as Bitcoin Core, xor obfuscated by key, with zero padding of preallocation which is not obfuscated
+func writeBlockFile(t *testing.T, file string, key []byte, blocks ...*wire.MsgBlock) {
+	var buf bytes.Buffer
+	for _, block := range blocks {
+		var data bytes.Buffer
+		block.Serialize(&data)
+		binary.Write(&buf, binary.LittleEndian, uint32(chaincfg.RegressionNetParams.Net))
+		binary.Write(&buf, binary.LittleEndian, uint32(data.Len()))
+		buf.Write(data.Bytes())
+	}
+	out := buf.Bytes()
+	for i := range out {
+		out[i] ^= key[i%len(key)]
+	}
+	out = append(out, make([]byte, 64)...)
+	if err := ioutil.WriteFile(file, out, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportBlockFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "btcblocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	params := &chaincfg.RegressionNetParams
+
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addrB := "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"
+	pkScript := func(addrStr string) []byte {
+		addr, _ := btcutil.DecodeAddress(addrStr, params)
+		script, _ := txscript.PayToAddrScript(addr)
+		return script
+	}
+	fund := wire.NewMsgTx(1)
+	fund.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	fund.AddTxOut(wire.NewTxOut(5000000000, pkScript(addrA)))
+	spend := wire.NewMsgTx(1)
+	spend.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: fund.TxHash()}})
+	spend.AddTxOut(wire.NewTxOut(1000000000, pkScript(addrB)))
+	spend.AddTxOut(wire.NewTxOut(3900000000, pkScript(addrA)))
+
+	genesis := params.GenesisBlock
+	b1 := mineBlock(genesis, 1, fund)
+	b2 := mineBlock(b1, 2, spend)
+	b3 := mineBlock(b2, 3)
+	fork := mineBlock(b1, 4) //less work than b2, b3
+
+	key := []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
+	if err := ioutil.WriteFile(filepath.Join(dir, xorKeyFile), key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeBlockFile(t, filepath.Join(dir, "blk00000.dat"), key, b3, genesis, fork)
+	writeBlockFile(t, filepath.Join(dir, "blk00001.dat"), key, b2, b1)
+
+	bf, err := OpenBlockFiles(dir, params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bf.Scan(); err != nil {
+		t.Fatal(err)
+	}
+	if bf.Count() != 5 {
+		t.Fatalf("unexpected block count - got: %v, "+"want: %v", bf.Count(), 5)
+	}
+	chain, err := bf.MainChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []chainhash.Hash{*params.GenesisHash, b1.BlockHash(), b2.BlockHash(), b3.BlockHash()}
+	if len(chain) != len(want) {
+		t.Fatalf("unexpected chain length - got: %v, "+"want: %v", len(chain), len(want))
+	}
+	for i := range want {
+		if chain[i] != want[i] {
+			t.Errorf("unexpected block at %d - got: %v, "+"want: %v", i, chain[i], want[i])
+		}
+	}
+
+	indexer, err := NewIndexer(IndexerConfig{DBPath: filepath.Join(dir, "index.db"), Params: params, StartHeight: 1}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer indexer.Close()
+	if err := indexer.WatchAddress(addrA); err != nil {
+		t.Fatal(err)
+	}
+	height, err := ImportBlockFiles(context.Background(), bf, indexer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if height != 3 || indexer.BestHeight() != 3 {
+		t.Errorf("unexpected imported height - got: %v %v, "+"want: %v", height, indexer.BestHeight(), 3)
+	}
+	utxos, err := indexer.ListUnspent(addrA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].Value != 3900000000 || utxos[0].Confirmations != 2 {
+		t.Errorf("unexpected utxos - got: %v", utxos)
+	}
+
+	//blocks must link to the tip
+	if err := indexer.ImportBlock(mineBlock(b2, 5), 4); err == nil {
+		t.Errorf("expected error of a block not linked to the tip")
+	}
+	if err := indexer.Sync(); err != ErrNoNode {
+		t.Errorf("unexpected error of offline sync - got: %v, "+"want: %v", err, ErrNoNode)
+	}
+
+	//an address watched after the import is backfilled from the block files by the next import
+	if err := indexer.WatchAddress(addrB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := indexer.ListUnspent(addrB, 0); ErrorKindOf(err) != ErrKindTransient {
+		t.Errorf("unexpected error before backfill - got: %v", err)
+	}
+	if height, err = ImportBlockFiles(context.Background(), bf, indexer); err != nil || height != 3 {
+		t.Fatalf("unexpected import - got: %v %v, "+"want: %v", height, err, 3)
+	}
+	utxos, err = indexer.ListUnspent(addrB, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].Value != 1000000000 || utxos[0].Confirmations != 2 {
+		t.Errorf("unexpected backfilled utxos - got: %v", utxos)
+	}
+}
@@ -0,0 +1,516 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultBroadcastInterval = 5 * time.Minute
+	defaultBroadcastKeep     = 7 * 24 * time.Hour
+	defaultBroadcastBuffer   = 100
+)
+
+//bucketBroadcastTxs is the bucket of the broadcast db, txid -> BroadcastRecord json
+var bucketBroadcastTxs = []byte("txs")
+
+//BroadcastStatus is the status of a tx of Broadcaster
+type BroadcastStatus int
+
+const (
+	BroadcastPending    BroadcastStatus = iota //not mined, rebroadcast every Interval
+	BroadcastMined                             //in a block of less than Confirmations
+	BroadcastConfirmed                         //confirmed Confirmations blocks, no longer checked
+	BroadcastConflicted                        //an input is spent by another tx, no longer checked
+)
+
+func (s BroadcastStatus) String() string {
+	switch s {
+	case BroadcastMined:
+		return "mined"
+	case BroadcastConfirmed:
+		return "confirmed"
+	case BroadcastConflicted:
+		return "conflicted"
+	}
+	return "pending"
+}
+
+//BroadcastConfig is the config of Broadcaster
+type BroadcastConfig struct {
+	DBPath   string           //file of the bbolt db of the txs
+	Params   *chaincfg.Params //network of the nodes
+	Interval time.Duration    //interval of Run to check and rebroadcast the txs
+	//confirmations to stop checking a tx, default Confirmations of the finality policy of Params
+	Confirmations int64
+	Keep          time.Duration //how long the confirmed and conflicted txs are kept for Status
+	EventBuffer   int           //buffer of each subscription channel
+}
+
+//BroadcastRecord is a tx of Broadcaster
+type BroadcastRecord struct {
+	TxID          string          `json:"txid"`
+	RawTx         []byte          `json:"rawTx"`
+	Status        BroadcastStatus `json:"status"`
+	Created       int64           `json:"created"`            //unix seconds
+	Updated       int64           `json:"updated"`            //unix seconds of the last change of the status
+	LastBroadcast int64           `json:"lastBroadcast"`      //unix seconds
+	Broadcasts    int             `json:"broadcasts"`         //times sent to the nodes
+	Accepted      []string        `json:"accepted,omitempty"` //hosts of the nodes accepted the last broadcast
+	BlockHash     string          `json:"blockHash,omitempty"`
+	Confirmations int64           `json:"confirmations"`
+	Conflict      string          `json:"conflict,omitempty"` //the input spent by another tx, txid:vout
+	LastError     string          `json:"lastError,omitempty"`
+}
+
+//BroadcastEvent is a change of a tx of Broadcaster: it is kept, accepted by a node after rejected by all,
+//mined, confirmed one more block, conflicted, or its block is reorged out
+type BroadcastEvent struct {
+	Record    BroadcastRecord
+	PrevBlock string //the block of the tx before the change, it is reorged out if not Record.BlockHash
+}
+
+type broadcastSubscription struct {
+	events chan BroadcastEvent
+	done   chan struct{}
+}
+
+//mempoolAcceptResult is a result of testmempoolaccept of Bitcoin Core
+type mempoolAcceptResult struct {
+	TxID         string `json:"txid"`
+	Allowed      bool   `json:"allowed"`
+	RejectReason string `json:"reject-reason"`
+}
+
+//Broadcaster sends txs to all nodes after testmempoolaccept, and keeps them in a bbolt db.
+//Run rebroadcasts the txs not mined every Interval until they are confirmed,
+//and stops if an input of a tx is spent by a conflicting tx.
+type Broadcaster struct {
+	cfg   BroadcastConfig
+	db    *bolt.DB
+	nodes *NodeSet
+
+	mtx     sync.Mutex
+	subs    map[*broadcastSubscription]bool
+	lastErr error
+}
+
+//NewBroadcaster open or create the broadcast db, txs are sent to all nodes
+func NewBroadcaster(cfg BroadcastConfig, nodes *NodeSet) (*Broadcaster, error) {
+	if cfg.Params == nil {
+		cfg.Params = &chaincfg.MainNetParams
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultBroadcastInterval
+	}
+	if cfg.Confirmations <= 0 {
+		cfg.Confirmations = DefaultFinalityPolicy(cfg.Params).Confirmations
+	}
+	if cfg.Keep <= 0 {
+		cfg.Keep = defaultBroadcastKeep
+	}
+	if cfg.EventBuffer <= 0 {
+		cfg.EventBuffer = defaultBroadcastBuffer
+	}
+	db, err := bolt.Open(cfg.DBPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open broadcast db failed : %s", err.Error())
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketBroadcastTxs)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init broadcast db failed : %s", err.Error())
+	}
+	return &Broadcaster{cfg: cfg, db: db, nodes: nodes, subs: map[*broadcastSubscription]bool{}}, nil
+}
+
+//Close close the db
+func (b *Broadcaster) Close() error {
+	return b.db.Close()
+}
+
+//Broadcast check the tx by testmempoolaccept, keep it and send it to all nodes.
+//A tx rejected by testmempoolaccept is not kept. If no node accepts the tx, the record and the error are returned,
+//the tx is rebroadcast by Run. A tx already kept is not sent again.
+func (b *Broadcaster) Broadcast(ctx context.Context, rawTx []byte) (*BroadcastRecord, error) {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, kindError(ErrKindInvalidTx, "Deserialize failed", err)
+	}
+	txid := tx.TxHash().String()
+	if record, err := b.Status(txid); err == nil {
+		return record, nil
+	} else if ErrorKindOf(err) != ErrKindNotFound {
+		return nil, err
+	}
+
+	if err := b.testMempoolAccept(ctx, rawTx); err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	record := &BroadcastRecord{TxID: txid, RawTx: rawTx, Status: BroadcastPending, Created: now, Updated: now}
+	sendErr := b.send(ctx, record)
+	if err := b.put(record); err != nil {
+		return nil, err
+	}
+	b.emit(ctx, BroadcastEvent{Record: *record})
+	return record, sendErr
+}
+
+//Subscribe return a channel of BroadcastEvents and a func to cancel it.
+//Broadcast and Check block while the channel is full, so events are never lost.
+func (b *Broadcaster) Subscribe() (<-chan BroadcastEvent, func()) {
+	sub := &broadcastSubscription{events: make(chan BroadcastEvent, b.cfg.EventBuffer), done: make(chan struct{})}
+	b.mtx.Lock()
+	b.subs[sub] = true
+	b.mtx.Unlock()
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			b.mtx.Lock()
+			delete(b.subs, sub)
+			b.mtx.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+//emit send event to all subscribers
+func (b *Broadcaster) emit(ctx context.Context, event BroadcastEvent) {
+	b.mtx.Lock()
+	subs := make([]*broadcastSubscription, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mtx.Unlock()
+	for _, sub := range subs {
+		select {
+		case sub.events <- event:
+		case <-sub.done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+//testMempoolAccept check the tx by the preferred node, the nodes not support testmempoolaccept accept any tx
+func (b *Broadcaster) testMempoolAccept(ctx context.Context, rawTx []byte) error {
+	param, _ := json.Marshal([]string{hex.EncodeToString(rawTx)})
+	result, err := b.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return client.RawRequest("testmempoolaccept", []json.RawMessage{param})
+	})
+	if err != nil {
+		if rpcErr, ok := Cause(err).(*btcjson.RPCError); ok && rpcErr.Code == btcjson.ErrRPCMethodNotFound.Code {
+			return nil
+		}
+		return wrapError("testmempoolaccept failed", err)
+	}
+	var results []mempoolAcceptResult
+	if err := json.Unmarshal(result.(json.RawMessage), &results); err != nil || len(results) != 1 {
+		return newError(ErrKindNode, fmt.Sprintf("unexpected result of testmempoolaccept : %s", result))
+	}
+	if !results[0].Allowed && !strings.Contains(results[0].RejectReason, "txn-already") {
+		return newError(ErrKindInvalidTx, "testmempoolaccept rejected : "+results[0].RejectReason)
+	}
+	return nil
+}
+
+//send send the tx of record to all nodes, the tx already known by a node is accepted by it.
+//The error of the first node is returned if no node accepts the tx.
+func (b *Broadcaster) send(ctx context.Context, record *BroadcastRecord) error {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(record.RawTx)); err != nil {
+		return kindError(ErrKindInvalidTx, "Deserialize failed", err)
+	}
+	results := b.nodes.callAll(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return client.SendRawTransaction(&tx, false)
+	})
+	record.Broadcasts++
+	record.LastBroadcast = time.Now().Unix()
+	record.Accepted, record.LastError = nil, ""
+	var firstErr error
+	for _, result := range results {
+		if result.err == nil || isTxAlreadyKnown(result.err) {
+			record.Accepted = append(record.Accepted, result.host)
+		} else if firstErr == nil {
+			firstErr = result.err
+		}
+	}
+	if len(record.Accepted) == 0 {
+		if firstErr == nil {
+			firstErr = ErrNoNode
+		}
+		record.LastError = firstErr.Error()
+		return wrapError("SendRawTransaction failed", firstErr)
+	}
+	return nil
+}
+
+//Status return the record of the tx, ErrKindNotFound if it is not kept
+func (b *Broadcaster) Status(txid string) (*BroadcastRecord, error) {
+	var record *BroadcastRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(bucketBroadcastTxs).Get([]byte(txid))
+		if data == nil {
+			return newError(ErrKindNotFound, "broadcast tx "+txid+" is not found")
+		}
+		record = &BroadcastRecord{}
+		return json.Unmarshal(data, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+//Records return the records of status
+func (b *Broadcaster) Records(status BroadcastStatus) ([]BroadcastRecord, error) {
+	var records []BroadcastRecord
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketBroadcastTxs).ForEach(func(k, v []byte) error {
+			var record BroadcastRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			if record.Status == status {
+				records = append(records, record)
+			}
+			return nil
+		})
+	})
+	return records, err
+}
+
+func (b *Broadcaster) put(record *BroadcastRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketBroadcastTxs).Put([]byte(record.TxID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("put broadcast tx failed : %s", err.Error())
+	}
+	return nil
+}
+
+//LastError return the error of the last Check in Run, nil if it succeeded
+func (b *Broadcaster) LastError() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.lastErr
+}
+
+//Run Check every Interval until ctx is done, errors are kept by LastError and retried on next interval
+func (b *Broadcaster) Run(ctx context.Context) error {
+	for {
+		err := b.Check(ctx)
+		b.mtx.Lock()
+		b.lastErr = err
+		b.mtx.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(b.cfg.Interval):
+		}
+	}
+}
+
+//Check update the status of the pending and mined txs, rebroadcast the txs not mined,
+//and delete the confirmed and conflicted txs older than Keep
+func (b *Broadcaster) Check(ctx context.Context) error {
+	var records []BroadcastRecord
+	var expired [][]byte
+	expire := time.Now().Add(-b.cfg.Keep).Unix()
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketBroadcastTxs).ForEach(func(k, v []byte) error {
+			var record BroadcastRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			switch {
+			case record.Status == BroadcastPending || record.Status == BroadcastMined:
+				records = append(records, record)
+			case record.Updated < expire:
+				expired = append(expired, copyBytes(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("read broadcast txs failed : %s", err.Error())
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		for _, k := range expired {
+			if err := tx.Bucket(bucketBroadcastTxs).Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete broadcast txs failed : %s", err.Error())
+	}
+
+	var firstErr error
+	for i := range records {
+		if err := b.check(ctx, &records[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return firstErr
+}
+
+//findMined return the block of a mined tx by an unspent output of it, or by the block it was reported in before,
+//blockHash is empty if neither is on the main chain
+func findMined(client *rpcclient.Client, tx *wire.MsgTx, prevBlock string) (blockHash string, confirmations int64, err error) {
+	txHash := tx.TxHash()
+	for i := range tx.TxOut {
+		out, err := client.GetTxOut(&txHash, uint32(i), false)
+		if err != nil {
+			return "", 0, err
+		}
+		if out == nil || out.Confirmations <= 0 {
+			continue
+		}
+		best, err := chainhash.NewHashFromStr(out.BestBlock)
+		if err != nil {
+			return "", 0, kindError(ErrKindNode, "NewHashFromStr bestblock failed", err)
+		}
+		header, err := client.GetBlockHeaderVerbose(best)
+		if err != nil {
+			return "", 0, err
+		}
+		hash, err := client.GetBlockHash(int64(header.Height) - out.Confirmations + 1)
+		if err != nil {
+			return "", 0, err
+		}
+		return hash.String(), out.Confirmations, nil
+	}
+	if prevBlock == "" {
+		return "", 0, nil
+	}
+	hash, err := chainhash.NewHashFromStr(prevBlock)
+	if err != nil {
+		return "", 0, kindError(ErrKindInvalidParams, "NewHashFromStr block failed", err)
+	}
+	header, err := client.GetBlockHeaderVerbose(hash)
+	if err != nil {
+		if ErrorKindOf(err) == ErrKindNotFound {
+			return "", 0, nil
+		}
+		return "", 0, err
+	}
+	if header.Confirmations < 0 { //orphaned
+		return "", 0, nil
+	}
+	return prevBlock, header.Confirmations, nil
+}
+
+//check update the status of a tx by the preferred node, and rebroadcast it if it is not mined
+func (b *Broadcaster) check(ctx context.Context, record *BroadcastRecord) error {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(record.RawTx)); err != nil {
+		return kindError(ErrKindInvalidTx, "Deserialize failed", err)
+	}
+	status, blockHash, confirmations, conflict := BroadcastPending, "", int64(0), ""
+	_, err := b.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		txHash := tx.TxHash()
+		result, err := client.GetRawTransactionVerbose(&txHash)
+		if err == nil {
+			if result.BlockHash != "" {
+				status, blockHash, confirmations = BroadcastMined, result.BlockHash, int64(result.Confirmations)
+			}
+			return nil, nil
+		}
+		if rpcErr, ok := err.(*btcjson.RPCError); !ok || rpcErr.Code != btcjson.ErrRPCNoTxInfo {
+			return nil, err
+		}
+		//neither in the mempool nor in a block, an input spent means a conflicting tx
+		for _, in := range tx.TxIn {
+			out, err := client.GetTxOut(&in.PreviousOutPoint.Hash, in.PreviousOutPoint.Index, true)
+			if err != nil {
+				return nil, err
+			}
+			if out == nil {
+				conflict = in.PreviousOutPoint.String()
+				break
+			}
+		}
+		if conflict == "" {
+			return nil, nil
+		}
+		//or the tx is mined, a node without -txindex does not find it
+		minedBlock, minedConfirmations, err := findMined(client, &tx, record.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		if minedBlock != "" {
+			status, blockHash, confirmations, conflict = BroadcastMined, minedBlock, minedConfirmations, ""
+		} else {
+			status = BroadcastConflicted
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return wrapError("check broadcast tx "+record.TxID+" failed", err)
+	}
+	if status == BroadcastMined && confirmations >= b.cfg.Confirmations {
+		status = BroadcastConfirmed
+	}
+
+	if status != record.Status || blockHash != record.BlockHash || conflict != record.Conflict {
+		record.Updated = time.Now().Unix()
+	}
+	prev := *record
+	record.Status, record.BlockHash, record.Confirmations, record.Conflict = status, blockHash, confirmations, conflict
+	var sendErr error
+	if status == BroadcastPending {
+		sendErr = b.send(ctx, record)
+	}
+	if err := b.put(record); err != nil {
+		return err
+	}
+	if status != prev.Status || blockHash != prev.BlockHash || confirmations != prev.Confirmations ||
+		conflict != prev.Conflict || len(prev.Accepted) == 0 && len(record.Accepted) > 0 {
+		b.emit(ctx, BroadcastEvent{Record: *record, PrevBlock: prev.BlockHash})
+	}
+	return sendErr
+}
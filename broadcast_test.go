@@ -0,0 +1,250 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+)
+
+func TestBroadcaster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "broadcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newTx := func(n int) (*wire.MsgTx, []byte) {
+		tx := wire.NewMsgTx(1)
+		prevOut := outPointOf(fmt.Sprintf("%064x", n), 0)
+		tx.AddTxIn(wire.NewTxIn(&prevOut, nil, nil))
+		tx.AddTxOut(wire.NewTxOut(1000, mustDecodeHex("76a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac")))
+		var buf bytes.Buffer
+		tx.Serialize(&buf)
+		return tx, buf.Bytes()
+	}
+	tx, rawTx := newTx(1)
+	conflicted, rawConflicted := newTx(2)
+	_, rawRejected := newTx(3)
+	mined, rawMined := newTx(4)
+
+	//the txs known by the nodes, txid -> the result of getrawtransaction, the spent outpoints,
+	//the outputs of mined txs and the block headers
+	var mtx sync.Mutex
+	txs := map[string]*btcjson.TxRawResult{}
+	spent := map[string]bool{conflicted.TxIn[0].PreviousOutPoint.String(): true}
+	minedOuts := map[string]*btcjson.GetTxOutResult{}
+	headers := map[string]*btcjson.GetBlockHeaderVerboseResult{}
+	sent := map[string]int{}
+	var rpcNodes []RPCParams
+	var first *testNode
+	for i := 0; i < 2; i++ {
+		node, rpcParams := newTestNode(t)
+		defer node.Close()
+		host, relayed := rpcParams.Host, i == 1 //the tx is relayed to the second node by the first one
+		node.handle("testmempoolaccept", func(params []json.RawMessage) (interface{}, error) {
+			var rawTxs []string
+			json.Unmarshal(params[0], &rawTxs)
+			if rawTxs[0] == fmt.Sprintf("%x", rawRejected) {
+				return []mempoolAcceptResult{{Allowed: false, RejectReason: "bad-txns-inputs-missingorspent"}}, nil
+			}
+			return []mempoolAcceptResult{{Allowed: true}}, nil
+		})
+		node.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			sent[host]++
+			if relayed {
+				return nil, btcjson.NewRPCError(btcjson.ErrRPCVerify, "txn-already-in-mempool")
+			}
+			return tx.TxHash().String(), nil
+		})
+		node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+			var txid string
+			json.Unmarshal(params[0], &txid)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if result := txs[txid]; result != nil {
+				return result, nil
+			}
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No such mempool or blockchain transaction")
+		})
+		node.handle("gettxout", func(params []json.RawMessage) (interface{}, error) {
+			var txid string
+			var vout uint32
+			json.Unmarshal(params[0], &txid)
+			json.Unmarshal(params[1], &vout)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if spent[outPointOf(txid, vout).String()] {
+				return nil, nil
+			}
+			if out := minedOuts[outPointOf(txid, vout).String()]; out != nil {
+				return out, nil
+			}
+			return btcjson.GetTxOutResult{Value: 0.1}, nil
+		})
+		node.handle("getblockheader", func(params []json.RawMessage) (interface{}, error) {
+			var hash string
+			json.Unmarshal(params[0], &hash)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if header := headers[hash]; header != nil {
+				return header, nil
+			}
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCBlockNotFound, "Block not found")
+		})
+		node.handle("getblockhash", func(params []json.RawMessage) (interface{}, error) {
+			var height int64
+			json.Unmarshal(params[0], &height)
+			return fmt.Sprintf("%064x", height), nil
+		})
+		rpcNodes = append(rpcNodes, rpcParams)
+		if first == nil {
+			first = node
+		}
+	}
+
+	abtc := NewAdaptorBTCNodes(NETID_TEST, rpcNodes, 0)
+	abtc.PoolConfig.Size = 1
+	defer abtc.Close()
+	dbPath := filepath.Join(dir, "broadcast.db")
+	b, err := abtc.NewBroadcaster(BroadcastConfig{DBPath: dbPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	//sent to all nodes, the node already has the tx accepts it
+	record, err := b.Broadcast(ctx, rawTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.TxID != tx.TxHash().String() || record.Status != BroadcastPending || len(record.Accepted) != 2 ||
+		sent[rpcNodes[0].Host] != 1 || sent[rpcNodes[1].Host] != 1 {
+		t.Errorf("unexpected record - got: %+v, "+"sent: %v", record, sent)
+	}
+	if _, err := b.Broadcast(ctx, rawTx); err != nil || sent[rpcNodes[0].Host] != 1 {
+		t.Errorf("unexpected broadcast of a kept tx - got: %v %v", err, sent)
+	}
+
+	//rejected by testmempoolaccept, not kept
+	_, err = b.Broadcast(ctx, rawRejected)
+	if ErrorKindOf(err) != ErrKindInvalidTx || !strings.Contains(err.Error(), "bad-txns-inputs-missingorspent") {
+		t.Errorf("unexpected error of rejected tx - got: %v", err)
+	}
+	if _, err := b.Broadcast(ctx, rawConflicted); err != nil {
+		t.Fatal(err)
+	}
+
+	//the tx not mined is rebroadcast, the input of the other is spent by a conflicting tx
+	if err := b.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	record, _ = b.Status(tx.TxHash().String())
+	if record.Status != BroadcastPending || record.Broadcasts != 2 || sent[rpcNodes[0].Host] != 3 {
+		t.Errorf("unexpected rebroadcast - got: %+v, "+"sent: %v", record, sent)
+	}
+	record, _ = b.Status(conflicted.TxHash().String())
+	if record.Status != BroadcastConflicted || record.Conflict != conflicted.TxIn[0].PreviousOutPoint.String() ||
+		record.Broadcasts != 1 {
+		t.Errorf("unexpected conflicted record - got: %+v", record)
+	}
+
+	//mined, then confirmed, kept after reopened
+	for _, confirmations := range []uint64{1, 6} {
+		mtx.Lock()
+		txs[tx.TxHash().String()] = &btcjson.TxRawResult{Txid: tx.TxHash().String(),
+			BlockHash: fmt.Sprintf("%064x", 100), Confirmations: confirmations}
+		mtx.Unlock()
+		if err := b.Check(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Close()
+	if b, err = abtc.NewBroadcaster(BroadcastConfig{DBPath: dbPath}); err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	record, err = b.Status(tx.TxHash().String())
+	if err != nil || record.Status != BroadcastConfirmed || record.Confirmations != 6 || record.Broadcasts != 2 ||
+		record.BlockHash != fmt.Sprintf("%064x", 100) {
+		t.Errorf("unexpected confirmed record - got: %+v %v", record, err)
+	}
+	if records, err := b.Records(BroadcastConflicted); err != nil || len(records) != 1 {
+		t.Errorf("unexpected conflicted records - got: %v %v", len(records), err)
+	}
+	if _, err := b.Status(fmt.Sprintf("%064x", 1)); ErrorKindOf(err) != ErrKindNotFound {
+		t.Errorf("unexpected error of unknown tx - got: %v", err)
+	}
+
+	//the nodes without testmempoolaccept
+	first.handle("testmempoolaccept", func(params []json.RawMessage) (interface{}, error) {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCMethodNotFound.Code, "Method not found")
+	})
+	if _, err := b.Broadcast(ctx, rawRejected); err != nil {
+		t.Errorf("unexpected error without testmempoolaccept - got: %v", err)
+	}
+
+	//mined but not found by getrawtransaction without -txindex, the spent input is not a conflict
+	events, cancel := b.Subscribe()
+	defer cancel()
+	checkEvent := func(status BroadcastStatus, confirmations int64) {
+		select {
+		case e := <-events:
+			if e.Record.TxID != mined.TxHash().String() || e.Record.Status != status ||
+				e.Record.Confirmations != confirmations || e.PrevBlock != "" && e.PrevBlock != e.Record.BlockHash {
+				t.Errorf("unexpected event - got: %+v, "+"want: %v %v", e, status, confirmations)
+			}
+		default:
+			t.Errorf("no event - want: %v %v", status, confirmations)
+		}
+	}
+	if _, err := b.Broadcast(ctx, rawMined); err != nil {
+		t.Fatal(err)
+	}
+	checkEvent(BroadcastPending, 0)
+	minedOut := outPointOf(mined.TxHash().String(), 0).String()
+	mtx.Lock()
+	spent[mined.TxIn[0].PreviousOutPoint.String()] = true
+	minedOuts[minedOut] = &btcjson.GetTxOutResult{BestBlock: fmt.Sprintf("%064x", 102), Confirmations: 2, Value: 0.00001}
+	headers[fmt.Sprintf("%064x", 102)] = &btcjson.GetBlockHeaderVerboseResult{Height: 102, Confirmations: 1}
+	mtx.Unlock()
+	if err := b.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	record, _ = b.Status(mined.TxHash().String())
+	if record.Status != BroadcastMined || record.BlockHash != fmt.Sprintf("%064x", 101) || record.Confirmations != 2 ||
+		record.Conflict != "" {
+		t.Errorf("unexpected mined record - got: %+v", record)
+	}
+	checkEvent(BroadcastMined, 2)
+	//its output spent too, the block it was mined in is still on the main chain
+	mtx.Lock()
+	delete(minedOuts, minedOut)
+	spent[minedOut] = true
+	headers[fmt.Sprintf("%064x", 101)] = &btcjson.GetBlockHeaderVerboseResult{Height: 101, Confirmations: 7}
+	mtx.Unlock()
+	if err := b.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	record, _ = b.Status(mined.TxHash().String())
+	if record.Status != BroadcastConfirmed || record.BlockHash != fmt.Sprintf("%064x", 101) || record.Confirmations != 7 {
+		t.Errorf("unexpected confirmed record - got: %+v", record)
+	}
+	checkEvent(BroadcastConfirmed, 7)
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event - got: %+v", e)
+	default:
+	}
+}
@@ -0,0 +1,156 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+const (
+	cashAddrCharset  = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	cashAddrChecksum = 8 //5-bit groups of the checksum
+
+	cashAddrP2PKH = 0 //type bits of the version byte, the size bits are 0 of 160-bit hashes
+	cashAddrP2SH  = 1
+)
+
+//cashAddrPolyMod is the BCH code checksum of CashAddr
+func cashAddrPolyMod(values []byte) uint64 {
+	generators := [5]uint64{0x98f2bc8e61, 0x79b76d99e2, 0xf33e5fb3c4, 0xae2eabe2a8, 0x1e4f43e470}
+	c := uint64(1)
+	for _, d := range values {
+		c0 := byte(c >> 35)
+		c = ((c & 0x07ffffffff) << 5) ^ uint64(d)
+		for i, generator := range generators {
+			if c0&(1<<uint(i)) != 0 {
+				c ^= generator
+			}
+		}
+	}
+	return c ^ 1
+}
+
+//cashAddrChecksumInput return the lower 5 bits of the prefix, a zero separator and the payload
+func cashAddrChecksumInput(prefix string, payload []byte) []byte {
+	values := make([]byte, 0, len(prefix)+1+len(payload)+cashAddrChecksum)
+	for i := 0; i < len(prefix); i++ {
+		values = append(values, prefix[i]&0x1f)
+	}
+	values = append(values, 0)
+	return append(values, payload...)
+}
+
+//convertBits regroup bits of from bits to to bits, the last group is padded with zeros if pad
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var acc, bits uint
+	maxv := uint(1)<<to - 1
+	var out []byte
+	for _, value := range data {
+		if uint(value)>>from != 0 {
+			return nil, fmt.Errorf("invalid data range %d", value)
+		}
+		acc = acc<<from | uint(value)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return out, nil
+}
+
+//EncodeCashAddr encode a P2PKH or P2SH address as CashAddr of prefix, like bitcoincash:qp...
+func EncodeCashAddr(prefix string, addr btcutil.Address) (string, error) {
+	var version byte
+	switch a := addr.(type) {
+	case *btcutil.AddressPubKeyHash:
+		version = cashAddrP2PKH << 3
+	case *btcutil.AddressScriptHash:
+		version = cashAddrP2SH << 3
+	case *btcutil.AddressPubKey:
+		return EncodeCashAddr(prefix, a.AddressPubKeyHash())
+	default:
+		return "", newError(ErrKindBadAddress, fmt.Sprintf("address %s can not be encoded as CashAddr", addr))
+	}
+	payload, _ := convertBits(append([]byte{version}, addr.ScriptAddress()...), 8, 5, true)
+	checksum := cashAddrPolyMod(cashAddrChecksumInput(prefix, append(payload, make([]byte, cashAddrChecksum)...)))
+	for i := 0; i < cashAddrChecksum; i++ {
+		payload = append(payload, byte(checksum>>(5*uint(cashAddrChecksum-1-i))&0x1f))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	sb.WriteByte(':')
+	for _, value := range payload {
+		sb.WriteByte(cashAddrCharset[value])
+	}
+	return sb.String(), nil
+}
+
+//DecodeCashAddr decode a CashAddr of prefix, which may be omitted in addr, into an address of params
+func DecodeCashAddr(addr, prefix string, params *chaincfg.Params) (btcutil.Address, error) {
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return nil, newError(ErrKindBadAddress, "mixed case CashAddr "+addr)
+	}
+	addr = strings.ToLower(addr)
+	if i := strings.LastIndexByte(addr, ':'); i >= 0 {
+		if addr[:i] != prefix {
+			return nil, newError(ErrKindBadAddress, fmt.Sprintf("CashAddr %s is not of prefix %s", addr, prefix))
+		}
+		addr = addr[i+1:]
+	}
+	if len(addr) <= cashAddrChecksum {
+		return nil, newError(ErrKindBadAddress, "CashAddr "+addr+" is too short")
+	}
+	payload := make([]byte, len(addr))
+	for i := 0; i < len(addr); i++ {
+		value := strings.IndexByte(cashAddrCharset, addr[i])
+		if value < 0 {
+			return nil, newError(ErrKindBadAddress, fmt.Sprintf("invalid character %q of CashAddr", addr[i]))
+		}
+		payload[i] = byte(value)
+	}
+	if cashAddrPolyMod(cashAddrChecksumInput(prefix, payload)) != 0 {
+		return nil, newError(ErrKindBadAddress, "invalid checksum of CashAddr "+addr)
+	}
+	data, err := convertBits(payload[:len(payload)-cashAddrChecksum], 5, 8, false)
+	if err != nil {
+		return nil, kindError(ErrKindBadAddress, "CashAddr "+addr, err)
+	}
+	if len(data) != 21 || data[0]&0x07 != 0 {
+		return nil, newError(ErrKindBadAddress, fmt.Sprintf("CashAddr %s is not of a 160-bit hash", addr))
+	}
+	switch data[0] >> 3 {
+	case cashAddrP2PKH:
+		return btcutil.NewAddressPubKeyHash(data[1:], params)
+	case cashAddrP2SH:
+		return btcutil.NewAddressScriptHashFromHash(data[1:], params)
+	}
+	return nil, newError(ErrKindBadAddress, fmt.Sprintf("unknown type %d of CashAddr %s", data[0]>>3, addr))
+}
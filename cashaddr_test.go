@@ -0,0 +1,45 @@
+package btcadaptor
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+func TestCashAddr(t *testing.T) {
+	hash, _ := hex.DecodeString("76a04053bda0a88bda5177b86a15c3b29f559873")
+	pkh, _ := btcutil.NewAddressPubKeyHash(hash, &chaincfg.MainNetParams)
+	want := "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"
+	cashAddr, err := EncodeCashAddr("bitcoincash", pkh)
+	if err != nil || cashAddr != want {
+		t.Errorf("unexpected CashAddr - got: %v %v, "+"want: %v", cashAddr, err, want)
+	}
+
+	for _, addr := range []string{want, strings.ToUpper(want), want[len("bitcoincash:"):]} {
+		decoded, err := DecodeCashAddr(addr, "bitcoincash", &BCHMainNetParams)
+		if err != nil || decoded.String() != pkh.String() {
+			t.Errorf("unexpected address of %s - got: %v %v, "+"want: %v", addr, decoded, err, pkh)
+		}
+	}
+
+	sh, _ := btcutil.NewAddressScriptHashFromHash(hash, &chaincfg.TestNet3Params)
+	cashAddr, _ = EncodeCashAddr("bchtest", sh)
+	if decoded, err := DecodeCashAddr(cashAddr, "bchtest", &BCHTestNet3Params); err != nil ||
+		decoded.String() != sh.String() || !strings.HasPrefix(cashAddr, "bchtest:p") {
+		t.Errorf("unexpected P2SH CashAddr %s - got: %v %v", cashAddr, decoded, err)
+	}
+
+	for _, bad := range []string{
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6b", //checksum
+		"bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",     //prefix
+		"bitcoincash:Qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", //mixed case
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6o", //character
+	} {
+		if _, err := DecodeCashAddr(bad, "bitcoincash", &BCHMainNetParams); ErrorKindOf(err) != ErrKindBadAddress {
+			t.Errorf("unexpected error of %s - got: %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//BIP158 basic filter parameters
+const (
+	cfilterP = 19
+	cfilterM = 784931
+)
+
+//gcsFilter is a golomb-coded set as defined by BIP158
+type gcsFilter struct {
+	n    uint64
+	data []byte //golomb-rice coded deltas, without the N prefix
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
+
+//sipHash24 is SipHash-2-4 keyed with k0,k1
+func sipHash24(k0, k1 uint64, p []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	last := uint64(len(p)) << 56
+	for ; len(p) >= 8; p = p[8:] {
+		m := binary.LittleEndian.Uint64(p)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+	for i := range p {
+		last |= uint64(p[i]) << (8 * uint(i))
+	}
+	v3 ^= last
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= last
+
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+//the filter key is the first 16 bytes of the block hash
+func cfilterKey(blockHash *chainhash.Hash) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(blockHash[0:8]), binary.LittleEndian.Uint64(blockHash[8:16])
+}
+
+//hash item to range [0, n*M)
+func cfilterHash(k0, k1 uint64, f uint64, item []byte) uint64 {
+	hi, _ := bits.Mul64(sipHash24(k0, k1, item), f)
+	return hi
+}
+
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.nbits%8 == 0 {
+		w.buf = append(w.buf, 0)
+	}
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << (7 - w.nbits%8)
+	}
+	w.nbits++
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for i := n; i > 0; i-- {
+		w.writeBit(v&(1<<(i-1)) != 0)
+	}
+}
+
+type bitReader struct {
+	buf []byte
+	pos uint
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos/8 >= uint(len(r.buf)) {
+		return false, errors.New("gcs filter truncated")
+	}
+	bit := r.buf[r.pos/8]&(1<<(7-r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+func (r *bitReader) readBits(n uint) (uint64, error) {
+	v := uint64(0)
+	for i := uint(0); i < n; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+	return v, nil
+}
+
+//buildGCSFilter builds a BIP158 filter for items, keyed by blockHash
+func buildGCSFilter(blockHash *chainhash.Hash, items [][]byte) *gcsFilter {
+	//remove duplicate items
+	uniq := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		uniq[string(item)] = struct{}{}
+	}
+	n := uint64(len(uniq))
+	k0, k1 := cfilterKey(blockHash)
+	values := make([]uint64, 0, n)
+	for item := range uniq {
+		values = append(values, cfilterHash(k0, k1, n*cfilterM, []byte(item)))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	var w bitWriter
+	last := uint64(0)
+	for _, v := range values {
+		delta := v - last
+		last = v
+		for q := delta >> cfilterP; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, cfilterP)
+	}
+	return &gcsFilter{n: n, data: w.buf}
+}
+
+//parseGCSFilter parses a serialized filter, N as CompactSize then the bit stream
+func parseGCSFilter(serialized []byte) (*gcsFilter, error) {
+	r := bytes.NewReader(serialized)
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, fmt.Errorf("ReadVarInt filter N failed : %s", err.Error())
+	}
+	data := serialized[len(serialized)-r.Len():]
+	return &gcsFilter{n: n, data: data}, nil
+}
+
+//Bytes return the serialized filter
+func (f *gcsFilter) Bytes() []byte {
+	var buf bytes.Buffer
+	wire.WriteVarInt(&buf, 0, f.n)
+	buf.Write(f.data)
+	return buf.Bytes()
+}
+
+//Hash is the filter hash, used in filter header chain
+func (f *gcsFilter) Hash() chainhash.Hash {
+	return chainhash.DoubleHashH(f.Bytes())
+}
+
+//MatchAny return true if one of items may be in the filter
+func (f *gcsFilter) MatchAny(blockHash *chainhash.Hash, items [][]byte) (bool, error) {
+	if f.n == 0 || len(items) == 0 {
+		return false, nil
+	}
+	k0, k1 := cfilterKey(blockHash)
+	targets := make([]uint64, 0, len(items))
+	for _, item := range items {
+		targets = append(targets, cfilterHash(k0, k1, f.n*cfilterM, item))
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	r := bitReader{buf: f.data}
+	value := uint64(0)
+	t := 0
+	for i := uint64(0); i < f.n; i++ {
+		q := uint64(0)
+		for {
+			bit, err := r.readBit()
+			if err != nil {
+				return false, err
+			}
+			if !bit {
+				break
+			}
+			q++
+		}
+		rem, err := r.readBits(cfilterP)
+		if err != nil {
+			return false, err
+		}
+		value += q<<cfilterP + rem
+		for t < len(targets) && targets[t] < value {
+			t++
+		}
+		if t == len(targets) {
+			return false, nil
+		}
+		if targets[t] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//basicFilterItems return the scripts committed by a BIP158 basic filter,
+//prevScripts are the scripts spent by the block inputs
+func basicFilterItems(block *wire.MsgBlock, prevScripts [][]byte) [][]byte {
+	var items [][]byte
+	for _, tx := range block.Transactions {
+		for _, out := range tx.TxOut {
+			if len(out.PkScript) == 0 || out.PkScript[0] == txscript.OP_RETURN {
+				continue
+			}
+			items = append(items, out.PkScript)
+		}
+	}
+	for _, script := range prevScripts {
+		if len(script) == 0 {
+			continue
+		}
+		items = append(items, script)
+	}
+	return items
+}
+
+//BuildBasicFilter build the BIP158 basic filter of a block, return the serialized filter
+func BuildBasicFilter(block *wire.MsgBlock, prevScripts [][]byte) []byte {
+	blockHash := block.BlockHash()
+	return buildGCSFilter(&blockHash, basicFilterItems(block, prevScripts)).Bytes()
+}
+
+//CalcFilterHeader compute the filter header from filter hash and previous filter header
+func CalcFilterHeader(filterHash, prevHeader *chainhash.Hash) chainhash.Hash {
+	var buf [2 * chainhash.HashSize]byte
+	copy(buf[:chainhash.HashSize], filterHash[:])
+	copy(buf[chainhash.HashSize:], prevHeader[:])
+	return chainhash.DoubleHashH(buf[:])
+}
@@ -0,0 +1,64 @@
+package btcadaptor
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+func TestSipHash24(t *testing.T) {
+	//reference vector, key 00..0f and empty message
+	testResult := uint64(0x726fdb47dd0e0e31)
+	result := sipHash24(0x0706050403020100, 0x0f0e0d0c0b0a0908, nil)
+	if result != testResult {
+		t.Errorf("unexpected siphash - got: %x, "+"want: %x", result, testResult)
+	}
+}
+
+func TestBuildBasicFilter(t *testing.T) {
+	//BIP158 test vector, testnet genesis block
+	testFilter := "019dfca8"
+	testHeader := "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750"
+
+	block := chaincfg.TestNet3Params.GenesisBlock
+	filterBytes := BuildBasicFilter(block, nil)
+	if hex.EncodeToString(filterBytes) != testFilter {
+		t.Errorf("unexpected filter - got: %x, "+"want: %s", filterBytes, testFilter)
+	}
+
+	filterHash := chainhash.DoubleHashH(filterBytes)
+	header := CalcFilterHeader(&filterHash, &chainhash.Hash{})
+	if header.String() != testHeader {
+		t.Errorf("unexpected filter header - got: %s, "+"want: %s", header.String(), testHeader)
+	}
+}
+
+func TestGCSFilterMatch(t *testing.T) {
+	blockHash := chaincfg.MainNetParams.GenesisHash
+	var items [][]byte
+	for i := 0; i < 100; i++ {
+		items = append(items, []byte{byte(i), 0xab, byte(i * 7)})
+	}
+	filter, err := parseGCSFilter(buildGCSFilter(blockHash, items).Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range items {
+		isMatch, err := filter.MatchAny(blockHash, [][]byte{item})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isMatch {
+			t.Errorf("item %x not match", item)
+		}
+	}
+	isMatch, err := filter.MatchAny(blockHash, [][]byte{[]byte("not in filter")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isMatch {
+		t.Errorf("unexpected match for the item not in filter")
+	}
+}
@@ -0,0 +1,264 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//Chain define a UTXO chain of the transaction format of bitcoin, which is served by AdaptorBTC of its params.
+//Amounts are in the smallest unit, like satoshi.
+type Chain struct {
+	Params   *chaincfg.Params
+	Symbol   string //the asset of balances and amounts
+	Decimals int32  //of the coin in the smallest unit
+
+	DustLimit      int64 //outputs below it are not relayed, the change below it is added to the fee
+	MinRelayFee    int64 //per 1000 bytes, txs of lower fees are not relayed
+	DefaultFeeRate int64 //per 1000 bytes, the fee of CreateTransferTokenTx if it is zero
+	Confirmations  int64 //the default of FinalityPolicy, 0 means MinConfirm
+
+	//VerifyHeaders is whether HeaderChain can verify the proof of work and the difficulty of the headers,
+	//false for the scrypt chains, the difficulty adjustment of BCH and the block signatures of signets
+	VerifyHeaders bool
+	//CashAddrPrefix is the CashAddr prefix of a BCH network, whose txs are signed with SIGHASH_FORKID
+	CashAddrPrefix string
+	//IgnoreOutput return whether an output is not of the UTXO set of the chain, like MWEB outputs of LTC,
+	//which are not counted as fee, balance or deposits
+	IgnoreOutput func(pkScript []byte) bool
+}
+
+//LTC and DOGE are of scrypt proof of work, their regtests are not defined as of the magic of bitcoin regtest
+var (
+	LTCMainNetParams = func() chaincfg.Params {
+		params := chaincfg.MainNetParams
+		params.Name = "ltcmainnet"
+		params.Net = 0xdbb6c0fb
+		params.DefaultPort = "9333"
+		params.DNSSeeds = nil
+		params.GenesisBlock = scryptGenesisBlock(ltcGenesisMessage, 50, 1317972665, 2084524493)
+		params.GenesisHash = newBlockHash(params.GenesisBlock)
+		params.PowLimit = scryptPowLimit
+		params.PowLimitBits = 0x1e0fffff
+		params.TargetTimespan = 84 * time.Hour //3.5 days
+		params.TargetTimePerBlock = 150 * time.Second
+		params.Checkpoints = nil
+		params.Bech32HRPSegwit = "ltc"
+		params.PubKeyHashAddrID = 0x30
+		params.ScriptHashAddrID = 0x32
+		params.PrivateKeyID = 0xb0
+		params.HDCoinType = 2
+		return params
+	}()
+	LTCTestNet4Params = func() chaincfg.Params {
+		params := chaincfg.TestNet3Params
+		params.Name = "ltctestnet4"
+		params.Net = 0xf1c8d2fd
+		params.DefaultPort = "19335"
+		params.DNSSeeds = nil
+		params.GenesisBlock = scryptGenesisBlock(ltcGenesisMessage, 50, 1486949366, 293345)
+		params.GenesisHash = newBlockHash(params.GenesisBlock)
+		params.PowLimit = scryptPowLimit
+		params.PowLimitBits = 0x1e0fffff
+		params.TargetTimespan = 84 * time.Hour
+		params.TargetTimePerBlock = 150 * time.Second
+		params.Checkpoints = nil
+		params.Bech32HRPSegwit = "tltc"
+		params.ScriptHashAddrID = 0x3a
+		return params
+	}()
+	DOGEMainNetParams = func() chaincfg.Params {
+		params := chaincfg.MainNetParams
+		params.Name = "dogemainnet"
+		params.Net = 0xc0c0c0c0
+		params.DefaultPort = "22556"
+		params.DNSSeeds = nil
+		params.GenesisBlock = scryptGenesisBlock(dogeGenesisMessage, 88, 1386325540, 99943)
+		params.GenesisHash = newBlockHash(params.GenesisBlock)
+		params.PowLimit = scryptPowLimit
+		params.PowLimitBits = 0x1e0fffff
+		params.TargetTimespan = time.Minute
+		params.TargetTimePerBlock = time.Minute
+		params.Checkpoints = nil
+		params.Bech32HRPSegwit = "" //no segwit
+		params.PubKeyHashAddrID = 0x1e
+		params.ScriptHashAddrID = 0x16
+		params.PrivateKeyID = 0x9e
+		params.HDPrivateKeyID = [4]byte{0x02, 0xfa, 0xc3, 0x98} //dgpv
+		params.HDPublicKeyID = [4]byte{0x02, 0xfa, 0xca, 0xfd}  //dgub
+		params.HDCoinType = 3
+		return params
+	}()
+	DOGETestNetParams = func() chaincfg.Params {
+		params := chaincfg.TestNet3Params
+		params.Name = "dogetestnet"
+		params.Net = 0xdcb7c1fc
+		params.DefaultPort = "44556"
+		params.DNSSeeds = nil
+		params.GenesisBlock = scryptGenesisBlock(dogeGenesisMessage, 88, 1391503289, 997879)
+		params.GenesisHash = newBlockHash(params.GenesisBlock)
+		params.PowLimit = scryptPowLimit
+		params.PowLimitBits = 0x1e0fffff
+		params.TargetTimespan = time.Minute
+		params.TargetTimePerBlock = time.Minute
+		params.Checkpoints = nil
+		params.Bech32HRPSegwit = ""
+		params.PubKeyHashAddrID = 0x71
+		params.PrivateKeyID = 0xf1
+		return params
+	}()
+)
+
+const (
+	ltcGenesisMessage  = "NY Times 05/Oct/2011 Steve Jobs, Apple’s Visionary, Dies at 56"
+	dogeGenesisMessage = "Nintondo"
+)
+
+//scryptPowLimit is the highest target of LTC and DOGE, 0x1e0fffff in compact
+var scryptPowLimit = compactToBig(0x1e0fffff)
+
+//scryptGenesisPubKey is the public key of the genesis coinbase of LTC and DOGE
+var scryptGenesisPubKey, _ = hex.DecodeString("040184710fa689ad5023690c80f3a49c8f13f8d45b8c857fbcbc8bc4a8e4d3eb4b" +
+	"10f4d4604fa08dce601aaf0f470216fe1b51850b4acf21b179c45070ac7b03a9")
+
+//scryptGenesisBlock return the genesis block of LTC or DOGE, the coinbase pays coins to scryptGenesisPubKey
+func scryptGenesisBlock(message string, coins int64, timestamp int64, nonce uint32) *wire.MsgBlock {
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript: append([]byte{txscript.OP_DATA_4, 0xff, 0xff, 0x00, 0x1d, txscript.OP_DATA_1, 0x04,
+			byte(len(message))}, message...),
+		Sequence: wire.MaxTxInSequenceNum,
+	})
+	pkScript := append([]byte{txscript.OP_DATA_65}, scryptGenesisPubKey...)
+	coinbase.AddTxOut(wire.NewTxOut(coins*1e8, append(pkScript, txscript.OP_CHECKSIG)))
+	return &wire.MsgBlock{
+		Header: wire.BlockHeader{Version: 1, MerkleRoot: coinbase.TxHash(), Timestamp: time.Unix(timestamp, 0),
+			Bits: 0x1e0ffff0, Nonce: nonce},
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+}
+
+//isMWEBScript return whether pkScript is of a MWEB peg-in (witness v9) or the HogEx (witness v8) of LTC
+func isMWEBScript(pkScript []byte) bool {
+	return len(pkScript) == 34 && (pkScript[0] == txscript.OP_8 || pkScript[0] == txscript.OP_9) &&
+		pkScript[1] == txscript.OP_DATA_32
+}
+
+var (
+	//BTCChain is the chain of the networks of bitcoin, and of the networks not defined as a chain
+	BTCChain = Chain{Symbol: "BTC", Decimals: 8, DustLimit: 546, MinRelayFee: 1000, DefaultFeeRate: 10000,
+		VerifyHeaders: true}
+
+	BCHMainNetChain  = bchChain(&BCHMainNetParams, "bitcoincash")
+	BCHTestNet3Chain = bchChain(&BCHTestNet3Params, "bchtest")
+	BCHRegTestChain  = bchChain(&BCHRegTestParams, "bchreg")
+
+	LTCMainNetChain  = ltcChain(&LTCMainNetParams)
+	LTCTestNet4Chain = ltcChain(&LTCTestNet4Params)
+
+	DOGEMainNetChain = dogeChain(&DOGEMainNetParams)
+	DOGETestNetChain = dogeChain(&DOGETestNetParams)
+)
+
+func bchChain(params *chaincfg.Params, prefix string) Chain {
+	return Chain{Params: params, Symbol: "BCH", Decimals: 8, DustLimit: 546, MinRelayFee: 1000, DefaultFeeRate: 1000,
+		CashAddrPrefix: prefix}
+}
+
+//ltcChain has the dust of 30000 per 1000 bytes, and 24 confirmations of an hour
+func ltcChain(params *chaincfg.Params) Chain {
+	return Chain{Params: params, Symbol: "LTC", Decimals: 8, DustLimit: 5460, MinRelayFee: 10000, DefaultFeeRate: 20000,
+		Confirmations: 24, IgnoreOutput: isMWEBScript}
+}
+
+//dogeChain has the dust and the fee rate of 0.01 DOGE recommended by Dogecoin Core, and 60 confirmations of an hour
+func dogeChain(params *chaincfg.Params) Chain {
+	return Chain{Params: params, Symbol: "DOGE", Decimals: 8, DustLimit: 1000000, MinRelayFee: 100000,
+		DefaultFeeRate: 1000000, Confirmations: 60}
+}
+
+//chains is the chains of the networks, guarded by netsMtx
+var chains = map[wire.BitcoinNet]*Chain{
+	BCHMainNetParams.Net:  &BCHMainNetChain,
+	BCHTestNet3Params.Net: &BCHTestNet3Chain,
+	BCHRegTestParams.Net:  &BCHRegTestChain,
+	LTCMainNetParams.Net:  &LTCMainNetChain,
+	LTCTestNet4Params.Net: &LTCTestNet4Chain,
+	DOGEMainNetParams.Net: &DOGEMainNetChain,
+	DOGETestNetParams.Net: &DOGETestNetChain,
+}
+
+//RegisterChain register the params of chain as RegisterNet, the functions and AdaptorBTC of the netID follow chain
+func RegisterChain(chain *Chain) (int, error) {
+	if chain == nil || chain.Params == nil {
+		return 0, newError(ErrKindInvalidParams, "params of chain is nil")
+	}
+	netID, err := RegisterNet(chain.Params)
+	if err != nil {
+		return 0, err
+	}
+	netsMtx.Lock()
+	chains[chain.Params.Net] = chain
+	netsMtx.Unlock()
+	return netID, nil
+}
+
+//ChainOf return the chain of the network, a copy of BTCChain of params if it is not defined as a chain
+func ChainOf(params *chaincfg.Params) *Chain {
+	if params == nil {
+		params = &chaincfg.MainNetParams
+	}
+	netsMtx.RLock()
+	chain, ok := chains[params.Net]
+	netsMtx.RUnlock()
+	if ok {
+		return chain
+	}
+	btc := BTCChain
+	btc.Params = params
+	if *params.GenesisHash == *SigNetParams.GenesisHash { //all signets have the same genesis block
+		btc.VerifyHeaders = false
+	}
+	return &btc
+}
+
+//Fee return the fee of a tx of size at rate per 1000 bytes
+func Fee(rate int64, size int) int64 {
+	return rate * int64(size) / 1000
+}
+
+//estimateTxSize return the size of a signed tx of P2PKH inputs and outputs
+func estimateTxSize(inputs, outputs int) int {
+	return 10 + inputs*148 + outputs*34
+}
+
+//ignored return whether the output of the hex script is not of the UTXO set of the chain
+func (c *Chain) ignored(pkScriptHex string) bool {
+	if c.IgnoreOutput == nil {
+		return false
+	}
+	pkScript, err := hex.DecodeString(pkScriptHex)
+	return err == nil && c.IgnoreOutput(pkScript)
+}
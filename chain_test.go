@@ -0,0 +1,208 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+)
+
+func TestChainParams(t *testing.T) {
+	tests := []struct {
+		netID   int
+		symbol  string
+		genesis string
+		prefix  string //of P2PKH addresses
+	}{
+		{NETID_LTC_MAIN, "LTC", "12a765e31ffd4059bada1e25190f6e98c99d9714d334efa41a195a7e7e04bfe2", "L"},
+		{NETID_LTC_TEST, "LTC", "4966625a4b2851d9fdee139e56211a0d88575f59ed816ff5e6a63deb4e3e29a0", "m"},
+		{NETID_DOGE_MAIN, "DOGE", "1a91e3dace36e2be3bf030a65679fe821aa1d6ef92e7c9902eb318182c355691", "D"},
+		{NETID_DOGE_TEST, "DOGE", "bb0a78264637406b6360aad926284d544d7049f45189db5664f3c4d07350559e", "n"},
+	}
+	key, _ := hex.DecodeString("d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0")
+	pubKey, _ := GetPublicKey(key, NETID_MAIN)
+	for _, test := range tests {
+		params := GetNet(test.netID)
+		chain := ChainOf(params)
+		if chain.Symbol != test.symbol || chain.Params != params || chain.VerifyHeaders {
+			t.Errorf("unexpected chain of %s - got: %v", params.Name, chain.Symbol)
+		}
+		if hash := params.GenesisBlock.BlockHash(); hash.String() != test.genesis || *params.GenesisHash != hash {
+			t.Errorf("unexpected genesis of %s - got: %v, "+"want: %v", params.Name, hash, test.genesis)
+		}
+		if netID, ok := NetIDByName(params.Name); !ok || netID != test.netID {
+			t.Errorf("unexpected netID of %s - got: %v", params.Name, netID)
+		}
+		addr, err := PubKeyToAddress(pubKey, test.netID)
+		if err != nil || !strings.HasPrefix(addr, test.prefix) {
+			t.Errorf("unexpected address of %s - got: %v %v, "+"want: %v...", params.Name, addr, err, test.prefix)
+		}
+		if decoded, err := DecodeAddress(addr, params); err != nil || !decoded.IsForNet(params) {
+			t.Errorf("unexpected decoded address of %s - got: %v %v", params.Name, decoded, err)
+		}
+		if _, err := NewHeaderChain(HeaderChainConfig{Params: params}); ErrorKindOf(err) != ErrKindInvalidParams {
+			t.Errorf("unexpected error of header chain of %s - got: %v", params.Name, err)
+		}
+	}
+	if root := LTCMainNetParams.GenesisBlock.Header.MerkleRoot.String(); root != "97ddfbbae6be97fd6cdf3e7ca13232a3afff2353e29badfab7f73011edd4ced9" {
+		t.Errorf("unexpected LTC merkle root - got: %v", root)
+	}
+
+	//segwit of LTC
+	witness, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey), &LTCMainNetParams)
+	if err != nil || !strings.HasPrefix(witness.EncodeAddress(), "ltc1q") {
+		t.Fatalf("unexpected LTC segwit address - got: %v %v", witness, err)
+	}
+	if decoded, err := DecodeAddress(witness.EncodeAddress(), &LTCMainNetParams); err != nil || !decoded.IsForNet(&LTCMainNetParams) {
+		t.Errorf("unexpected decoded LTC segwit address - got: %v %v", decoded, err)
+	}
+
+	if ChainOf(GetNet(NETID_TEST)).Symbol != "BTC" || !ChainOf(GetNet(NETID_TEST)).VerifyHeaders {
+		t.Errorf("unexpected chain of bitcoin")
+	}
+	custom := CustomSignetParams([]byte{0x51})
+	if ChainOf(GetNet(NETID_SIGNET)).VerifyHeaders || ChainOf(&custom).VerifyHeaders {
+		t.Errorf("unexpected headers verified of signet")
+	}
+	if policy := DefaultFinalityPolicy(&DOGEMainNetParams); policy.Confirmations != 60 {
+		t.Errorf("unexpected DOGE confirmations - got: %v", policy.Confirmations)
+	}
+	abtc := NewAdaptorBTC(NETID_DOGE_MAIN, RPCParams{})
+	if decimal, _ := abtc.GetAssetDecimal(&adaptor.GetAssetDecimalInput{}); decimal.Decimal != 8 {
+		t.Errorf("unexpected decimal - got: %v", decimal.Decimal)
+	}
+}
+
+func TestRegisterChain(t *testing.T) {
+	params := testCustomParams
+	params.Name = "customchain"
+	params.Net = 0xfeedbeef
+	chain := &Chain{Params: &params, Symbol: "XYZ", Decimals: 6, DustLimit: 1000, MinRelayFee: 1000, DefaultFeeRate: 2000}
+	abtc, err := NewAdaptorChain(chain, RPCParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ChainOf(GetNet(abtc.NetID)) != chain {
+		t.Errorf("unexpected chain of the adaptor")
+	}
+	if decimal, _ := abtc.GetAssetDecimal(&adaptor.GetAssetDecimalInput{}); decimal.Decimal != 6 {
+		t.Errorf("unexpected decimal - got: %v", decimal.Decimal)
+	}
+	if _, err := RegisterChain(&Chain{}); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of chain without params - got: %v", err)
+	}
+}
+
+func TestChainTransferTx(t *testing.T) {
+	key, _ := hex.DecodeString("d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0")
+	pubKey, _ := GetPublicKey(key, NETID_DOGE_MAIN)
+	from, _ := PubKeyToAddress(pubKey, NETID_DOGE_MAIN)
+	utxos := func(addr btcutil.Address) (map[wire.OutPoint]btcutil.Amount, error) {
+		return map[wire.OutPoint]btcutil.Amount{
+			outPointOf("101d482b60cd3f74a61ce265d62e383456b9c21c84477931d207ea8f503d84cc", 0): 1000000000,
+			outPointOf("4d8e651f7f261e3f8165000ebe1a2dd71606662b0ba741b3482ab0481b0d0844", 0): 2000000000,
+		}, nil
+	}
+	create := func(amount, fee string) (*wire.MsgTx, error) {
+		input := &adaptor.CreateTransferTokenTxInput{FromAddress: from, ToAddress: from,
+			Amount: adaptor.NewAmountAssetString(amount, "DOGE"), Fee: adaptor.NewAmountAssetString(fee, "DOGE")}
+		output, err := createTransferTokenTxByUnspend(input, NETID_DOGE_MAIN, utxos)
+		if err != nil {
+			return nil, err
+		}
+		var tx wire.MsgTx
+		tx.Deserialize(bytes.NewReader(output.Transaction))
+		return &tx, nil
+	}
+
+	//the default fee of the inputs selected, 2 inputs of 25 DOGE
+	tx, err := create("2500000000", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fee := int64(3000000000) - tx.TxOut[0].Value - tx.TxOut[1].Value
+	if want := Fee(DOGEMainNetChain.DefaultFeeRate, estimateTxSize(2, 2)); len(tx.TxIn) != 2 || fee != want {
+		t.Errorf("unexpected default fee - got: %v %v, "+"want: %v", len(tx.TxIn), fee, want)
+	}
+
+	//the change below the dust limit is the fee
+	tx, err = create("999900000", "99000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.TxOut) != 1 || tx.TxOut[0].Value != 999900000 {
+		t.Errorf("unexpected outputs of dust change - got: %v", len(tx.TxOut))
+	}
+
+	//the amount below the dust limit and the fee below the min relay fee are rejected
+	for _, test := range []struct{ amount, fee string }{{"100", "1000000"}, {"100000000", "100"}} {
+		if _, err := create(test.amount, test.fee); ErrorKindOf(err) != ErrKindInvalidParams {
+			t.Errorf("unexpected error of %s %s - got: %v", test.amount, test.fee, err)
+		}
+	}
+}
+
+func TestLTCTransferTxMWEB(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+
+	hash := func(n int) string { return fmt.Sprintf("%064x", n) }
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addrB := "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"
+	pegin := "59" + "20" + strings.Repeat("ab", 32) //MWEB peg-in, witness v9
+	prevTx := btcjson.TxRawResult{Txid: hash(1), BlockHash: hash(100), Confirmations: 20,
+		Vout: []btcjson.Vout{{Value: 1, ScriptPubKey: btcjson.ScriptPubKeyResult{Addresses: []string{addrA}}}}}
+	tx := btcjson.TxRawResult{Txid: hash(2), BlockHash: hash(100), Confirmations: 20,
+		Vin: []btcjson.Vin{{Txid: hash(1), Vout: 0}},
+		Vout: []btcjson.Vout{
+			{N: 0, Value: 0.25, ScriptPubKey: btcjson.ScriptPubKeyResult{Addresses: []string{addrB}}},
+			{N: 1, Value: 0.5, ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: pegin, Type: "witness_mweb_pegin"}},
+			{N: 2, Value: 0.125, ScriptPubKey: btcjson.ScriptPubKeyResult{Addresses: []string{addrA}}},
+		}}
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		switch txid {
+		case prevTx.Txid:
+			return prevTx, nil
+		case tx.Txid:
+			return tx, nil
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No information available about transaction")
+	})
+	node.handle("getblockheader", func(params []json.RawMessage) (interface{}, error) {
+		return btcjson.GetBlockHeaderVerboseResult{Hash: hash(100), Height: 99, Confirmations: 20}, nil
+	})
+	node.handle("gettxoutproof", func(params []json.RawMessage) (interface{}, error) {
+		return txOutProof([]string{hash(10), tx.Txid}, tx.Txid)
+	})
+	node.handle("getbestblockhash", func(params []json.RawMessage) (interface{}, error) {
+		return hash(100), nil
+	})
+
+	abtc := NewAdaptorBTC(NETID_LTC_TEST, rpcParams)
+	defer abtc.Close()
+	transfer, err := abtc.GetTransferTx(&adaptor.GetTransferTxInput{TxID: mustDecodeHex(tx.Txid)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the peg-in is not fee
+	if transfer.Tx.Amount.Amount.Int64() != 25000000 || transfer.Tx.Fee.Amount.Int64() != 12500000 ||
+		transfer.Tx.Fee.Asset != "LTC" {
+		t.Errorf("unexpected amount and fee - got: %v %v %v", transfer.Tx.Amount.Amount, transfer.Tx.Fee.Amount,
+			transfer.Tx.Fee.Asset)
+	}
+
+	pkScript, _ := hex.DecodeString(pegin)
+	if !isMWEBScript(pkScript) || isMWEBScript(pkScript[:33]) {
+		t.Errorf("unexpected MWEB script")
+	}
+}
@@ -0,0 +1,332 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+const (
+	defaultPoolSize       = 4
+	defaultCallTimeout    = 30 * time.Second
+	defaultHealthInterval = 30 * time.Second
+)
+
+var ErrPoolClosed = errors.New("rpc client pool is closed")
+
+//ClientPoolConfig is the config of ClientPool, zero value means default
+type ClientPoolConfig struct {
+	Size           int           //number of long-lived clients
+	CallTimeout    time.Duration //timeout of one call
+	HealthInterval time.Duration //interval of health check, negative to disable
+	//HTTP POST mode by default, which Bitcoin core only supports, the connection is not kept.
+	//Websocket keeps one websocket connection per client, for btcd
+	Websocket bool
+	//number of cached txs and block heights of the node, zero means default,
+	//the cache is used for resolving prevouts and heights of history and transfer txs
+	CacheSize int
+}
+
+type pooledClient struct {
+	healthy int32 //atomic, 1 if client is connected and not broken
+
+	mtx    sync.Mutex //guard client and the connecting
+	client *rpcclient.Client
+}
+
+func (pc *pooledClient) isHealthy() bool {
+	return atomic.LoadInt32(&pc.healthy) == 1
+}
+
+//ClientPool is a concurrency-safe pool of long-lived rpc clients,
+//broken clients are reconnected by health check or on next use
+type ClientPool struct {
+	rpcParams RPCParams
+	cfg       ClientPoolConfig
+
+	mtx     sync.Mutex
+	connCfg *rpcclient.ConnConfig //built once, the cert file is read only once
+	clients []*pooledClient
+	next    int
+	closed  bool
+
+	quit     chan struct{}
+	wg       sync.WaitGroup //health check loop
+	inflight sync.WaitGroup //calls not returned
+}
+
+func NewClientPool(rpcParams RPCParams, cfg ClientPoolConfig) *ClientPool {
+	if cfg.Size <= 0 {
+		cfg.Size = defaultPoolSize
+	}
+	if cfg.CallTimeout <= 0 {
+		cfg.CallTimeout = defaultCallTimeout
+	}
+	if cfg.HealthInterval == 0 {
+		cfg.HealthInterval = defaultHealthInterval
+	}
+	pool := &ClientPool{rpcParams: rpcParams, cfg: cfg, quit: make(chan struct{})}
+	pool.clients = make([]*pooledClient, cfg.Size)
+	for i := range pool.clients {
+		pool.clients[i] = &pooledClient{}
+	}
+	if cfg.HealthInterval > 0 {
+		pool.wg.Add(1)
+		go pool.healthLoop()
+	}
+	return pool
+}
+
+//pick return the next client in round-robin order, prefer the healthy ones
+func (p *ClientPool) pick() (*pooledClient, *rpcclient.ConnConfig, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.closed {
+		return nil, nil, ErrPoolClosed
+	}
+	if p.connCfg == nil {
+		connCfg, err := newConnConfig(&p.rpcParams)
+		if err != nil {
+			return nil, nil, err
+		}
+		connCfg.HTTPPostMode = !p.cfg.Websocket
+		p.connCfg = connCfg
+	}
+
+	pc := p.clients[p.next]
+	for i := 0; i < len(p.clients); i++ {
+		candidate := p.clients[(p.next+i)%len(p.clients)]
+		if candidate.isHealthy() {
+			pc = candidate
+			p.next = (p.next + i) % len(p.clients)
+			break
+		}
+	}
+	p.next = (p.next + 1) % len(p.clients)
+	p.inflight.Add(1)
+	return pc, p.connCfg, nil
+}
+
+//get return the client of pc, connect it if need
+func (pc *pooledClient) get(connCfg *rpcclient.ConnConfig) (*rpcclient.Client, error) {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	if pc.client != nil && pc.isHealthy() {
+		return pc.client, nil
+	}
+	if pc.client != nil {
+		pc.client.Shutdown()
+		pc.client = nil
+	}
+	cfg := *connCfg
+	client, err := rpcclient.New(&cfg, nil)
+	if err != nil {
+		return nil, wrapError("rpcclient.New failed", err)
+	}
+	pc.client = client
+	atomic.StoreInt32(&pc.healthy, 1)
+	return client, nil
+}
+
+//markBroken mark pc broken if it still holds client, it will be reconnected on next use
+func (pc *pooledClient) markBroken(client *rpcclient.Client) {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	if pc.client == client {
+		atomic.StoreInt32(&pc.healthy, 0)
+	}
+}
+
+func (pc *pooledClient) shutdown() {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	if pc.client != nil {
+		pc.client.Shutdown()
+		pc.client = nil
+	}
+	atomic.StoreInt32(&pc.healthy, 0)
+}
+
+//Do call f with a pooled client, return error if f not return in CallTimeout.
+//A timed out client is marked broken and replaced on next use.
+func (p *ClientPool) Do(f func(client *rpcclient.Client) error) error {
+	return p.DoCtx(context.Background(), f)
+}
+
+//DoCtx is Do which return ctx.Err() when ctx is done before f return
+func (p *ClientPool) DoCtx(ctx context.Context, f func(client *rpcclient.Client) error) error {
+	_, err := p.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return nil, f(client)
+	})
+	return err
+}
+
+type callResult struct {
+	value interface{}
+	err   error
+}
+
+//CallCtx is DoCtx which return the value of f.
+//The rpc client has no cancellation, a timed out or canceled f is left running and its value is dropped,
+//so f should return its result instead of setting captured variables.
+func (p *ClientPool) CallCtx(ctx context.Context, f func(client *rpcclient.Client) (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pc, connCfg, err := p.pick()
+	if err != nil {
+		return nil, err
+	}
+	client, err := pc.get(connCfg)
+	if err != nil {
+		p.inflight.Done()
+		return nil, err
+	}
+
+	done := make(chan callResult, 1)
+	go func() {
+		defer p.inflight.Done()
+		value, err := f(client)
+		done <- callResult{value, err}
+	}()
+
+	timer := time.NewTimer(p.cfg.CallTimeout)
+	defer timer.Stop()
+	select {
+	case result := <-done:
+		if mayBeConnError(result.err) {
+			//the error may be caused by a lost connection, ping it
+			go p.check(pc)
+		}
+		return result.value, result.err
+	case <-timer.C:
+		pc.markBroken(client)
+		return nil, newError(ErrKindTransient, fmt.Sprintf("rpc call timeout after %s", p.cfg.CallTimeout))
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//isRPCError return true if err is returned by the node, so the connection is ok
+func isRPCError(err error) bool {
+	_, ok := Cause(err).(*btcjson.RPCError)
+	return ok
+}
+
+//mayBeConnError return true if err may be caused by the connection, not the node or the input
+func mayBeConnError(err error) bool {
+	switch ErrorKindOf(err) {
+	case ErrKindTransient:
+		return true
+	case ErrKindUnknown:
+		return isTransportError(Cause(err))
+	}
+	return false
+}
+
+//isTransportError return true if err is returned by the transport of rpcclient, not classified by the adaptor
+func isTransportError(err error) bool {
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF, rpcclient.ErrClientNotConnected, rpcclient.ErrClientDisconnect,
+		rpcclient.ErrClientShutdown:
+		return true
+	}
+	return false
+}
+
+//check ping the client, mark it broken if failed
+func (p *ClientPool) check(pc *pooledClient) {
+	pc.mtx.Lock()
+	client := pc.client
+	pc.mtx.Unlock()
+	if client == nil {
+		return
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetBlockCount()
+		done <- err
+	}()
+	timer := time.NewTimer(p.cfg.CallTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		if err != nil && !isRPCError(err) {
+			pc.markBroken(client)
+		}
+	case <-timer.C:
+		pc.markBroken(client)
+	}
+}
+
+func (p *ClientPool) healthLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.cfg.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+		}
+		for _, pc := range p.clients {
+			p.check(pc)
+		}
+	}
+}
+
+//Close stop the health check, wait the inflight calls at most CallTimeout and shutdown all clients
+func (p *ClientPool) Close() {
+	p.mtx.Lock()
+	if p.closed {
+		p.mtx.Unlock()
+		return
+	}
+	p.closed = true
+	p.mtx.Unlock()
+	close(p.quit)
+	p.wg.Wait()
+
+	done := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(p.cfg.CallTimeout):
+	}
+
+	for _, pc := range p.clients {
+		pc.shutdown()
+	}
+}
@@ -0,0 +1,347 @@
+package btcadaptor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/websocket"
+
+	"github.com/palletone/adaptor"
+)
+
+type testHandler func(params []json.RawMessage) (interface{}, error)
+
+//testNode is a fake node serving json-rpc over websocket and HTTP POST
+type testNode struct {
+	server   *httptest.Server
+	certPath string
+
+	mtx      sync.Mutex
+	handlers map[string]testHandler
+
+	wsConns int32 //websocket connections accepted
+	calls   int32 //requests handled
+	batches int32 //batch requests handled
+
+	wsMtx sync.Mutex //guard conns and the writing
+	conns map[*websocket.Conn]bool
+}
+
+func newTestNode(t *testing.T) (*testNode, RPCParams) {
+	node := &testNode{handlers: map[string]testHandler{}, conns: map[*websocket.Conn]bool{}}
+	node.server = httptest.NewTLSServer(http.HandlerFunc(node.serveHTTP))
+
+	certFile, err := ioutil.TempFile("", "rpc.cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pem.Encode(certFile, &pem.Block{Type: "CERTIFICATE", Bytes: node.server.Certificate().Raw})
+	certFile.Close()
+	node.certPath = certFile.Name()
+
+	rpcParams := RPCParams{
+		Host:      node.server.Listener.Addr().String(),
+		RPCUser:   "test",
+		RPCPasswd: "123456",
+		CertPath:  node.certPath,
+	}
+	return node, rpcParams
+}
+
+func (n *testNode) Close() {
+	n.server.Close()
+	os.Remove(n.certPath)
+}
+
+func (n *testNode) handle(method string, handler testHandler) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.handlers[method] = handler
+}
+
+//call dispatch one request, return the marshaled response
+func (n *testNode) call(req *btcjson.Request) []byte {
+	atomic.AddInt32(&n.calls, 1)
+	n.mtx.Lock()
+	handler, exist := n.handlers[req.Method]
+	n.mtx.Unlock()
+
+	var result interface{}
+	var err error
+	if !exist {
+		err = btcjson.NewRPCError(btcjson.ErrRPCMethodNotFound.Code, "Method not found")
+	} else {
+		result, err = handler(req.Params)
+	}
+	var rpcErr *btcjson.RPCError
+	if err != nil {
+		var ok bool
+		if rpcErr, ok = err.(*btcjson.RPCError); !ok {
+			rpcErr = btcjson.NewRPCError(btcjson.ErrRPCMisc, err.Error())
+		}
+		result = nil
+	}
+	resp, _ := json.Marshal(map[string]interface{}{"result": result, "error": rpcErr, "id": req.ID})
+	return resp
+}
+
+func (n *testNode) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/ws" {
+		n.serveWS(w, r)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	if len(body) > 0 && body[0] == '[' {
+		atomic.AddInt32(&n.batches, 1)
+		var reqs []btcjson.Request
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resps := make([]json.RawMessage, len(reqs))
+		for i := range reqs {
+			resps[i] = n.call(&reqs[i])
+		}
+		resp, _ := json.Marshal(resps)
+		w.Write(resp)
+		return
+	}
+	var req btcjson.Request
+	if err := json.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write(n.call(&req))
+}
+
+func (n *testNode) serveWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r, nil, 0, 0)
+	if err != nil {
+		return
+	}
+	atomic.AddInt32(&n.wsConns, 1)
+	n.wsMtx.Lock()
+	n.conns[conn] = true
+	n.wsMtx.Unlock()
+	defer func() {
+		n.wsMtx.Lock()
+		delete(n.conns, conn)
+		n.wsMtx.Unlock()
+		conn.Close()
+	}()
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req btcjson.Request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			return
+		}
+		go func() {
+			resp := n.call(&req)
+			n.wsMtx.Lock()
+			defer n.wsMtx.Unlock()
+			conn.WriteMessage(websocket.TextMessage, resp)
+		}()
+	}
+}
+
+//notify send a notification to all websocket connections
+func (n *testNode) notify(method string, params ...interface{}) {
+	ntfn, _ := json.Marshal(map[string]interface{}{"jsonrpc": "1.0", "method": method, "params": params, "id": nil})
+	n.wsMtx.Lock()
+	defer n.wsMtx.Unlock()
+	for conn := range n.conns {
+		conn.WriteMessage(websocket.TextMessage, ntfn)
+	}
+}
+
+func TestClientPoolReuse(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	node.handle("getblockcount", func(params []json.RawMessage) (interface{}, error) {
+		return 100, nil
+	})
+
+	pool := NewClientPool(rpcParams, ClientPoolConfig{Size: 2, CallTimeout: 5 * time.Second, Websocket: true})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := pool.Do(func(client *rpcclient.Client) error {
+				count, err := client.GetBlockCount()
+				if err == nil && count != 100 {
+					t.Errorf("unexpected block count - got: %v, "+"want: %v", count, 100)
+				}
+				return err
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if conns := atomic.LoadInt32(&node.wsConns); conns > 2 {
+		t.Errorf("unexpected connections - got: %v, "+"want: <= %v", conns, 2)
+	}
+}
+
+func TestClientPoolTimeout(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	var slow int32 = 1
+	node.handle("getblockcount", func(params []json.RawMessage) (interface{}, error) {
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(500 * time.Millisecond)
+		}
+		return 100, nil
+	})
+
+	pool := NewClientPool(rpcParams, ClientPoolConfig{Size: 1, CallTimeout: 100 * time.Millisecond, HealthInterval: -1,
+		Websocket: true})
+	ping := func(client *rpcclient.Client) error {
+		_, err := client.GetBlockCount()
+		return err
+	}
+	if err := pool.Do(ping); err == nil {
+		t.Errorf("expected timeout error")
+	}
+
+	//the broken client is replaced by a new connection
+	atomic.StoreInt32(&slow, 0)
+	if err := pool.Do(ping); err != nil {
+		t.Error(err)
+	}
+	if conns := atomic.LoadInt32(&node.wsConns); conns != 2 {
+		t.Errorf("unexpected connections - got: %v, "+"want: %v", conns, 2)
+	}
+
+	pool.Close()
+	if err := pool.Do(ping); err != ErrPoolClosed {
+		t.Errorf("unexpected error - got: %v, "+"want: %v", err, ErrPoolClosed)
+	}
+}
+
+func TestAdaptorBTCClientPool(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	txid := "494f0780bf219a1245e76314dd22471f87b7cec465d439343af0bf7ff7e1f66a"
+	node.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		return txid, nil
+	})
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	abtc.PoolConfig.Size = 1
+	abtc.PoolConfig.Websocket = true
+	defer abtc.Close()
+
+	tx, _ := hex.DecodeString("01000000016af6e1f77fbff03a3439d465c4ceb7871f4722dd1463e745129a21bf80670f49000000000000000000020000000000000000256a235031397a34723747394d705a7461594d5a63415457696e5477586547426a376657546420f40e00000000001976a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac00000000")
+	for i := 0; i < 5; i++ {
+		output, err := abtc.SendTransaction(&adaptor.SendTransactionInput{Transaction: tx})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(output.TxID) != txid {
+			t.Errorf("unexpected txid - got: %x, "+"want: %s", output.TxID, txid)
+		}
+	}
+	if conns := atomic.LoadInt32(&node.wsConns); conns != 1 {
+		t.Errorf("unexpected connections - got: %v, "+"want: %v", conns, 1)
+	}
+}
+
+func TestClientPoolCancel(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	node.handle("getblockcount", func(params []json.RawMessage) (interface{}, error) {
+		time.Sleep(300 * time.Millisecond)
+		return 100, nil
+	})
+
+	pool := NewClientPool(rpcParams, ClientPoolConfig{Size: 1, CallTimeout: 5 * time.Second, HealthInterval: -1,
+		Websocket: true})
+	defer pool.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := pool.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return client.GetBlockCount()
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("unexpected error - got: %v, "+"want: %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("call not canceled in time - elapsed: %v", elapsed)
+	}
+
+	//the client is not broken by the cancellation
+	count, err := pool.CallCtx(context.Background(), func(client *rpcclient.Client) (interface{}, error) {
+		return client.GetBlockCount()
+	})
+	if err != nil || count.(int64) != 100 {
+		t.Errorf("unexpected block count - got: %v %v, "+"want: %v", count, err, 100)
+	}
+	if conns := atomic.LoadInt32(&node.wsConns); conns != 1 {
+		t.Errorf("unexpected connections - got: %v, "+"want: %v", conns, 1)
+	}
+}
+
+func TestClientPoolHTTPPost(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	node.handle("getblockcount", func(params []json.RawMessage) (interface{}, error) {
+		return 100, nil
+	})
+
+	//HTTP POST mode by default, as Bitcoin core only supports
+	pool := NewClientPool(rpcParams, ClientPoolConfig{})
+	defer pool.Close()
+	count, err := pool.CallCtx(context.Background(), func(client *rpcclient.Client) (interface{}, error) {
+		return client.GetBlockCount()
+	})
+	if err != nil || count.(int64) != 100 {
+		t.Errorf("unexpected block count - got: %v %v, "+"want: %v", count, err, 100)
+	}
+	if conns := atomic.LoadInt32(&node.wsConns); conns != 0 {
+		t.Errorf("unexpected connections - got: %v, "+"want: %v", conns, 0)
+	}
+}
+
+func TestMayBeConnError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"transient", newError(ErrKindTransient, "timeout"), true},
+		{"transport", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"disconnected", rpcclient.ErrClientDisconnect, true},
+		{"rpc", btcjson.NewRPCError(btcjson.ErrRPCMisc, "error"), false},
+		{"input", newError(ErrKindInvalidParams, "Not support send 2+ tx "), false},
+		{"not classified", errors.New("Not support send 2+ tx "), false},
+	}
+	for _, test := range tests {
+		if got := mayBeConnError(test.err); got != test.want {
+			t.Errorf("%s: unexpected result - got: %v, "+"want: %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+
+//blkimport populate the address/UTXO index from the blk*.dat files of Bitcoin Core offline.
+//Stop bitcoind before importing, the files are read without locking.
+//
+//	blkimport -blocks ~/.bitcoin/blocks -db index.db -addrs addr1,addr2 -start 600000
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	btcadaptor "github.com/palletone/btc-adaptor"
+)
+
+func main() {
+	blocksDir := flag.String("blocks", "", "blocks dir of Bitcoin Core, which has blk*.dat")
+	dbPath := flag.String("db", "index.db", "file of the index db")
+	testnet := flag.Bool("testnet", false, "testnet3 instead of mainnet")
+	net := flag.String("net", "", "name of the network, like regtest, signet or testnet4, overrides -testnet")
+	addrs := flag.String("addrs", "", "comma separated addresses to watch, may be empty if the db watches some")
+	start := flag.Int("start", 0, "height to begin indexing, the birthday of watched addresses")
+	xorKey := flag.String("xor", "", "hex obfuscation key, read from xor.dat of the blocks dir if empty")
+	flag.Parse()
+	if *blocksDir == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *net == "" && *testnet {
+		*net = "testnet3"
+	}
+	if err := run(*blocksDir, *dbPath, *net, *addrs, int32(*start), *xorKey); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(blocksDir, dbPath string, net string, addrs string, start int32, xorKeyHex string) error {
+	netID := btcadaptor.NETID_MAIN
+	if net != "" {
+		var ok bool
+		if netID, ok = btcadaptor.NetIDByName(net); !ok {
+			return fmt.Errorf("unknown network %s", net)
+		}
+	}
+	params := btcadaptor.GetNet(netID)
+
+	var xorKey []byte
+	if xorKeyHex != "" {
+		key, err := hex.DecodeString(xorKeyHex)
+		if err != nil {
+			return fmt.Errorf("decode xor key failed : %s", err.Error())
+		}
+		xorKey = key
+	}
+	bf, err := btcadaptor.OpenBlockFiles(blocksDir, params, xorKey)
+	if err != nil {
+		return err
+	}
+	begin := time.Now()
+	if err := bf.Scan(); err != nil {
+		return err
+	}
+	fmt.Printf("scanned %d blocks in %s\n", bf.Count(), time.Since(begin))
+
+	indexer, err := btcadaptor.NewIndexer(btcadaptor.IndexerConfig{DBPath: dbPath, Params: params, StartHeight: start}, nil)
+	if err != nil {
+		return err
+	}
+	defer indexer.Close()
+	if addrs != "" {
+		if err := indexer.WatchAddress(strings.Split(addrs, ",")...); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	begin = time.Now()
+	height, err := btcadaptor.ImportBlockFiles(ctx, bf, indexer)
+	if err != nil {
+		return fmt.Errorf("import stopped at %d : %s", height, err.Error())
+	}
+	fmt.Printf("imported to height %d in %s\n", height, time.Since(begin))
+	return nil
+}
@@ -0,0 +1,100 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+
+//btcscript-debug step through the scripts of an input of a tx, print the stacks after each opcode,
+//the failed opcode and the signatures checked. The tx is read from stdin if -tx is "-".
+//
+//	btcscript-debug -tx 0100... -input 0 -pkscript a914...87 -amount 100000 -net testnet3 -json
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/btcsuite/btcd/wire"
+
+	btcadaptor "github.com/palletone/btc-adaptor"
+)
+
+func main() {
+	txHex := flag.String("tx", "", "hex of the tx, - to read from stdin")
+	input := flag.Int("input", 0, "index of the input")
+	pkScriptHex := flag.String("pkscript", "", "hex of the script of the prevout spent by the input")
+	amount := flag.Int64("amount", 0, "satoshis of the prevout, signed by segwit and BCH inputs")
+	net := flag.String("net", "mainnet", "name of the network, like testnet3, regtest or bchtestnet3, selects the flags")
+	asJSON := flag.Bool("json", false, "print the trace as JSON instead of text")
+	flag.Parse()
+	if *txHex == "" || *pkScriptHex == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(*txHex, *input, *pkScriptHex, *amount, *net, *asJSON); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(txHex string, input int, pkScriptHex string, amount int64, net string, asJSON bool) error {
+	netID, ok := btcadaptor.NetIDByName(net)
+	if !ok {
+		return fmt.Errorf("unknown network %s", net)
+	}
+	if txHex == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("read tx failed : %s", err.Error())
+		}
+		txHex = string(data)
+	}
+	rawTx, err := hex.DecodeString(strings.TrimSpace(txHex))
+	if err != nil {
+		return fmt.Errorf("decode tx failed : %s", err.Error())
+	}
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return fmt.Errorf("deserialize tx failed : %s", err.Error())
+	}
+	pkScript, err := hex.DecodeString(pkScriptHex)
+	if err != nil {
+		return fmt.Errorf("decode pkscript failed : %s", err.Error())
+	}
+
+	trace, err := btcadaptor.TraceInput(&tx, input, pkScript, amount, btcadaptor.GetNet(netID))
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		data, err := json.MarshalIndent(trace, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else if err := trace.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if !trace.Succeed() {
+		return fmt.Errorf("input %d failed at step %d : %s", input, trace.Failed, trace.Err)
+	}
+	return nil
+}
@@ -0,0 +1,263 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//ScriptTrace is the opcode by opcode execution of the scripts of an input
+type ScriptTrace struct {
+	Input   int         `json:"input"`
+	Amount  int64       `json:"amount"`  //of the prevout, in satoshis
+	Scripts []string    `json:"scripts"` //the disassembly of the scripts executed, the signature script first
+	Steps   []TraceStep `json:"steps"`
+	Failed  int         `json:"failed"`          //the index of the failed step, -1 if none failed
+	Err     string      `json:"error,omitempty"` //why the scripts failed, empty if succeed
+}
+
+//TraceStep is the execution of an opcode, the stacks are the ones after it
+type TraceStep struct {
+	Script    int             `json:"script"` //the index of the script in Scripts
+	Offset    int             `json:"offset"` //of the opcode in the script
+	Opcode    string          `json:"opcode"`
+	Stack     []string        `json:"stack"`              //hex of the items, the top is the last
+	AltStack  []string        `json:"altStack,omitempty"` //hex of the items, the top is the last
+	CondStack []string        `json:"condStack,omitempty"`
+	SigChecks []TraceSigCheck `json:"sigChecks,omitempty"` //of OP_CHECKSIG and OP_CHECKMULTISIG
+	Err       string          `json:"error,omitempty"`     //only of the failed step
+}
+
+//TraceSigCheck is a signature checked against a public key
+type TraceSigCheck struct {
+	Signature string `json:"signature"` //hex with the hash type, empty if the signature is missing
+	PubKey    string `json:"pubKey"`
+	HashType  string `json:"hashType,omitempty"`
+	SigHash   string `json:"sigHash,omitempty"` //empty if the signature or the public key is not parsed
+	Valid     bool   `json:"valid"`
+}
+
+//Succeed return whether the scripts of the input succeed
+func (trace *ScriptTrace) Succeed() bool {
+	return trace.Err == ""
+}
+
+//WriteText write the trace as text, the failed step is marked by ">>"
+func (trace *ScriptTrace) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "input %d, amount %d\n", trace.Input, trace.Amount)
+	for i, script := range trace.Scripts {
+		fmt.Fprintf(&buf, "script %d: %s\n", i, script)
+	}
+	for i, step := range trace.Steps {
+		mark := "  "
+		if i == trace.Failed {
+			mark = ">>"
+		}
+		fmt.Fprintf(&buf, "%s %02d:%04d %s\n", mark, step.Script, step.Offset, step.Opcode)
+		fmt.Fprintf(&buf, "           stack: [%s]\n", strings.Join(step.Stack, " "))
+		if len(step.AltStack) > 0 {
+			fmt.Fprintf(&buf, "        altstack: [%s]\n", strings.Join(step.AltStack, " "))
+		}
+		if len(step.CondStack) > 0 {
+			fmt.Fprintf(&buf, "       condstack: [%s]\n", strings.Join(step.CondStack, " "))
+		}
+		for j, check := range step.SigChecks {
+			result := "invalid"
+			if check.Valid {
+				result = "valid"
+			}
+			signature := check.Signature
+			if signature == "" {
+				signature = "(missing)"
+			}
+			fmt.Fprintf(&buf, "        sigcheck %d: %s\n          pubkey %s\n          signature %s %s\n", j, result,
+				check.PubKey, signature, check.HashType)
+			if check.SigHash != "" {
+				fmt.Fprintf(&buf, "          sighash %s\n", check.SigHash)
+			}
+		}
+		if step.Err != "" {
+			fmt.Fprintf(&buf, "           error: %s\n", step.Err)
+		}
+	}
+	if trace.Succeed() {
+		buf.WriteString("succeed\n")
+	} else {
+		fmt.Fprintf(&buf, "failed: %s\n", trace.Err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+//TraceInput execute the scripts of the input idx of tx step by step, pkScript and amount are of the prevout.
+//The flags are the ones of params the inputs are verified by. The failure of the scripts is in the trace,
+//the error is returned only if the scripts can't be executed.
+func TraceInput(tx *wire.MsgTx, idx int, pkScript []byte, amount int64, params *chaincfg.Params) (*ScriptTrace, error) {
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("input %d of %d inputs", idx, len(tx.TxIn)))
+	}
+	if params == nil {
+		params = GetNet(NETID_MAIN)
+	}
+	vm, err := txscript.NewEngine(pkScript, tx, idx, scriptFlags(params), nil, nil, amount)
+	if err != nil {
+		return nil, kindError(ErrKindInvalidTx, "NewEngine failed", err)
+	}
+
+	trace := &ScriptTrace{Input: idx, Amount: amount, Failed: -1}
+	for done := false; !done; {
+		scriptIdx, scriptOff, err := vm.PC()
+		if err != nil {
+			trace.Err = err.Error()
+			break
+		}
+		opcode, _ := vm.DisasmPC()
+		if i := strings.Index(opcode, ": "); i >= 0 {
+			opcode = opcode[i+2:] //without the pc
+		}
+		step := TraceStep{Script: scriptIdx, Offset: scriptOff, Opcode: opcode}
+		done, err = vm.Step()
+		step.Stack = hexItems(vm.GetStack())
+		step.AltStack = hexItems(vm.GetAltStack())
+		step.CondStack = condNames(vm.GetCondStack())
+		for _, check := range vm.SigChecks() {
+			step.SigChecks = append(step.SigChecks, traceSigCheck(check))
+		}
+		if err != nil {
+			step.Err = err.Error()
+			trace.Err = step.Err
+			trace.Failed = len(trace.Steps)
+		}
+		trace.Steps = append(trace.Steps, step)
+	}
+	if trace.Err == "" {
+		//the last opcode left a false or an unclean stack
+		if err := vm.CheckErrorCondition(true); err != nil {
+			trace.Err = err.Error()
+			trace.Failed = len(trace.Steps) - 1
+		}
+	}
+	for i := 0; ; i++ {
+		script, err := vm.DisasmScript(i)
+		if err != nil {
+			break
+		}
+		trace.Scripts = append(trace.Scripts, strings.Join(strings.Fields(dropPCs(script)), " "))
+	}
+	return trace, nil
+}
+
+//dropPCs remove the pcs of the lines of DisasmScript
+func dropPCs(script string) string {
+	lines := strings.Split(script, "\n")
+	for i, line := range lines {
+		if j := strings.Index(line, ": "); j >= 0 {
+			lines[i] = line[j+2:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func hexItems(items [][]byte) []string {
+	hexes := make([]string, len(items))
+	for i, item := range items {
+		hexes[i] = hex.EncodeToString(item)
+	}
+	return hexes
+}
+
+func condNames(condStack []int) []string {
+	var names []string
+	for _, cond := range condStack {
+		switch cond {
+		case txscript.OpCondTrue:
+			names = append(names, "true")
+		case txscript.OpCondFalse:
+			names = append(names, "false")
+		default:
+			names = append(names, "skip")
+		}
+	}
+	return names
+}
+
+func traceSigCheck(check txscript.SigCheck) TraceSigCheck {
+	traced := TraceSigCheck{Signature: hex.EncodeToString(check.Signature), PubKey: hex.EncodeToString(check.PubKey),
+		SigHash: hex.EncodeToString(check.SigHash), Valid: check.Valid}
+	if len(check.Signature) > 0 {
+		traced.HashType = hashTypeName(check.HashType)
+	}
+	return traced
+}
+
+//hashTypeName return the name of hashType as Bitcoin Core, like ALL or ALL|ANYONECANPAY
+func hashTypeName(hashType txscript.SigHashType) string {
+	var name string
+	switch hashType &^ (txscript.SigHashAnyOneCanPay | txscript.SigHashForkID) {
+	case txscript.SigHashAll:
+		name = "ALL"
+	case txscript.SigHashNone:
+		name = "NONE"
+	case txscript.SigHashSingle:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("0x%02x", uint8(hashType))
+	}
+	if hashType&txscript.SigHashForkID != 0 {
+		name += "|FORKID"
+	}
+	if hashType&txscript.SigHashAnyOneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+//TraceInput execute the scripts of the input idx of rawTx step by step, the prevout is resolved by the nodes
+func (abtc *AdaptorBTC) TraceInput(rawTx []byte, idx int) (*ScriptTrace, error) {
+	return abtc.TraceInputCtx(context.Background(), rawTx, idx)
+}
+
+func (abtc *AdaptorBTC) TraceInputCtx(ctx context.Context, rawTx []byte, idx int) (*ScriptTrace, error) {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, kindError(ErrKindInvalidTx, "Deserialize tx failed", err)
+	}
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("input %d of %d inputs", idx, len(tx.TxIn)))
+	}
+	prevouts, err := abtc.resolvePrevouts(ctx, &tx)
+	if err != nil {
+		return nil, err
+	}
+	prevout := prevouts[tx.TxIn[idx].PreviousOutPoint]
+	if prevout == nil {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("input %d is a coinbase", idx))
+	}
+	return TraceInput(&tx, idx, prevout.PkScript, prevout.Value, GetNet(abtc.NetID))
+}
@@ -0,0 +1,134 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+func TestTraceInput(t *testing.T) {
+	params := GetNet(NETID_TEST)
+	var keys []*btcec.PrivateKey
+	var pubKeys []*btcutil.AddressPubKey
+	for i := 0; i < 3; i++ {
+		key, _ := btcec.NewPrivateKey(btcec.S256())
+		pubKey, _ := btcutil.NewAddressPubKey(key.PubKey().SerializeCompressed(), params)
+		keys = append(keys, key)
+		pubKeys = append(pubKeys, pubKey)
+	}
+	redeemScript, _ := txscript.MultiSigScript(pubKeys, 2)
+	addr, _ := btcutil.NewAddressScriptHash(redeemScript, params)
+	pkScript, _ := txscript.PayToAddrScript(addr)
+
+	tx := wire.NewMsgTx(1)
+	prevOut := outPointOf(fmt.Sprintf("%064x", 1), 0)
+	tx.AddTxIn(wire.NewTxIn(&prevOut, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(90000, pkScript))
+	var sigs [][]byte
+	for _, key := range keys {
+		sig, err := txscript.RawTxInSignature(tx, 0, redeemScript, txscript.SigHashAll, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sigs = append(sigs, sig)
+	}
+
+	//only the third key signs, OP_CHECKMULTISIG of the redeem script fails with the signature not empty
+	tx.TxIn[0].SignatureScript, _ = txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddOp(txscript.OP_0).
+		AddData(sigs[2]).AddData(redeemScript).Script()
+	trace, err := TraceInput(tx, 0, pkScript, 100000, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trace.Succeed() || trace.Failed != len(trace.Steps)-1 || len(trace.Scripts) != 3 {
+		t.Fatalf("unexpected trace - got: %+v", trace)
+	}
+	failed := trace.Steps[trace.Failed]
+	if failed.Script != 2 || failed.Offset != 5 || failed.Opcode != "OP_CHECKMULTISIG" ||
+		len(failed.Stack) != 0 || failed.Err != trace.Err {
+		t.Errorf("unexpected failed step - got: %+v", failed)
+	}
+	var valid, missing int
+	for _, check := range failed.SigChecks {
+		switch {
+		case check.Valid && check.Signature == hex.EncodeToString(sigs[2]) && check.HashType == "ALL" &&
+			check.PubKey == hex.EncodeToString(pubKeys[2].ScriptAddress()) && len(check.SigHash) == 64:
+			valid++
+		case !check.Valid && check.Signature == "" && check.SigHash == "":
+			missing++
+		}
+	}
+	if valid != 1 || missing != 2 || len(failed.SigChecks) != 3 {
+		t.Errorf("unexpected signature checks - got: %+v", failed.SigChecks)
+	}
+	var buf bytes.Buffer
+	trace.WriteText(&buf)
+	if !strings.Contains(buf.String(), ">> 02:0005 OP_CHECKMULTISIG\n") ||
+		!strings.Contains(buf.String(), "signature (missing)") || !strings.Contains(buf.String(), "failed: ") {
+		t.Errorf("unexpected text - got: %s", buf.String())
+	}
+	data, _ := json.Marshal(trace)
+	if !strings.Contains(string(data), `"opcode":"OP_CHECKMULTISIG","stack":[],"sigChecks":[{"signature":"`+hex.EncodeToString(sigs[2])) {
+		t.Errorf("unexpected JSON - got: %s", data)
+	}
+
+	//signed by both, the conditions of IF are traced
+	tx.TxIn[0].SignatureScript, _ = txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(sigs[1]).
+		AddData(sigs[2]).AddData(redeemScript).Script()
+	trace, err = TraceInput(tx, 0, pkScript, 100000, params)
+	if err != nil || !trace.Succeed() || trace.Failed != -1 {
+		t.Errorf("unexpected trace of signed input - got: %+v %v", trace, err)
+	}
+	ifScript, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddOp(txscript.OP_IF).AddOp(txscript.OP_RETURN).
+		AddOp(txscript.OP_ENDIF).AddOp(txscript.OP_TRUE).Script()
+	tx.TxIn[0].SignatureScript = nil
+	trace, err = TraceInput(tx, 0, ifScript, 100000, params)
+	if err != nil || !trace.Succeed() || len(trace.Steps) != 5 ||
+		strings.Join(trace.Steps[2].CondStack, " ") != "false" || len(trace.Steps[3].CondStack) != 0 {
+		t.Errorf("unexpected trace of conditions - got: %+v %v", trace, err)
+	}
+
+	if _, err := TraceInput(tx, 1, pkScript, 100000, params); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of bad input - got: %v", err)
+	}
+	for hashType, want := range map[txscript.SigHashType]string{
+		txscript.SigHashAll: "ALL", txscript.SigHashSingle | txscript.SigHashAnyOneCanPay: "SINGLE|ANYONECANPAY",
+		txscript.SigHashAll | txscript.SigHashForkID: "ALL|FORKID", 0: "0x00"} {
+		if name := hashTypeName(hashType); name != want {
+			t.Errorf("unexpected name of hash type - got: %v, "+"want: %v", name, want)
+		}
+	}
+}
+
+func TestAdaptorTraceInput(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+
+	tx, prevouts := policyTestTx(t, func(tx *wire.MsgTx) {
+		tx.AddTxOut(wire.NewTxOut(990000, mustDecodeHex("76a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac")))
+	})
+	prevOut := tx.TxIn[0].PreviousOutPoint
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		return btcjson.TxRawResult{Txid: prevOut.Hash.String(), Vout: []btcjson.Vout{{Value: 0.01,
+			ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(prevouts[prevOut].PkScript)}}}}, nil
+	})
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	defer abtc.Close()
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	trace, err := abtc.TraceInput(buf.Bytes(), 0)
+	if err != nil || !trace.Succeed() || trace.Amount != 1000000 || len(trace.Steps[len(trace.Steps)-1].SigChecks) != 1 {
+		t.Errorf("unexpected trace - got: %+v %v", trace, err)
+	}
+}
@@ -0,0 +1,406 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//the script classes not of txscript, named as Bitcoin Core
+const (
+	witnessV1TaprootClass = "witness_v1_taproot"
+	witnessUnknownClass   = "witness_unknown"
+)
+
+//DecodedTx is the structured view of a tx. Its JSON is of decoderawtransaction of Bitcoin Core,
+//with the prevouts and the fee as getrawtransaction of verbosity 2 if the prevouts are supplied.
+type DecodedTx struct {
+	TxID     chainhash.Hash
+	WTxID    chainhash.Hash //the TxID if the tx has no witness
+	Version  int32
+	LockTime uint32
+	Size     int //serialized with the witness
+	VSize    int //virtual size of BIP141, the fee rate is by it
+	Weight   int
+	Inputs   []DecodedTxIn
+	Outputs  []DecodedTxOut
+	Fee      *btcutil.Amount //nil if a prevout is not supplied
+
+	decimals int32 //of the amounts in JSON
+}
+
+//DecodedTxIn is an input of DecodedTx
+type DecodedTxIn struct {
+	OutPoint        wire.OutPoint
+	Coinbase        bool
+	Sequence        uint32
+	SignatureScript []byte
+	Witness         [][]byte
+	Class           string        //of the spent script, inferred by the scriptSig and the witness without the prevout
+	RedeemScript    []byte        //of P2SH
+	WitnessScript   []byte        //of P2WSH
+	PubKeys         [][]byte      //of the signing key or the multisig script
+	Signatures      [][]byte      //with the hash type
+	Prevout         *DecodedTxOut //nil if not supplied
+}
+
+//DecodedTxOut is an output of DecodedTx
+type DecodedTxOut struct {
+	N         uint32
+	Value     btcutil.Amount
+	PkScript  []byte
+	Class     string
+	ReqSigs   int
+	Addresses []string
+	NullData  [][]byte //the payloads of OP_RETURN
+}
+
+//DecodeTransaction decode the raw tx of params, prevouts are the outputs spent by the inputs, which may be nil
+//or partial. The fee is computed if all prevouts are supplied.
+func DecodeTransaction(rawTx []byte, params *chaincfg.Params,
+	prevouts map[wire.OutPoint]*wire.TxOut) (*DecodedTx, error) {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, kindError(ErrKindInvalidTx, "Deserialize tx failed", err)
+	}
+	if params == nil {
+		params = GetNet(NETID_MAIN)
+	}
+
+	stripped := tx.SerializeSizeStripped()
+	decoded := &DecodedTx{TxID: tx.TxHash(), WTxID: tx.WitnessHash(), Version: tx.Version, LockTime: tx.LockTime,
+		Size: tx.SerializeSize(), decimals: ChainOf(params).Decimals}
+	decoded.Weight = stripped*(witnessScaleFactor-1) + decoded.Size
+	decoded.VSize = (decoded.Weight + witnessScaleFactor - 1) / witnessScaleFactor
+
+	var outputAmount btcutil.Amount
+	for i, out := range tx.TxOut {
+		decoded.Outputs = append(decoded.Outputs, *decodeTxOut(uint32(i), out, params))
+		outputAmount += btcutil.Amount(out.Value)
+	}
+
+	var inputAmount btcutil.Amount
+	coinbase := isCoinBase(&tx)
+	hasFee := !coinbase
+	for _, in := range tx.TxIn {
+		input := DecodedTxIn{OutPoint: in.PreviousOutPoint, Sequence: in.Sequence, SignatureScript: in.SignatureScript,
+			Witness: in.Witness, Coinbase: coinbase}
+		if coinbase {
+			decoded.Inputs = append(decoded.Inputs, input)
+			continue
+		}
+		if prevout := prevouts[in.PreviousOutPoint]; prevout != nil {
+			input.Prevout = decodeTxOut(in.PreviousOutPoint.Index, prevout, params)
+			inputAmount += btcutil.Amount(prevout.Value)
+		} else {
+			hasFee = false
+		}
+		input.analyze()
+		decoded.Inputs = append(decoded.Inputs, input)
+	}
+	if hasFee {
+		fee := inputAmount - outputAmount
+		decoded.Fee = &fee
+	}
+	return decoded, nil
+}
+
+//witnessScaleFactor is the weight of a byte not of witnesses, as WitnessScaleFactor of blockchain of btcd
+const witnessScaleFactor = 4
+
+//isCoinBase is IsCoinBaseTx of blockchain of btcd
+func isCoinBase(tx *wire.MsgTx) bool {
+	if len(tx.TxIn) != 1 {
+		return false
+	}
+	prevOut := &tx.TxIn[0].PreviousOutPoint
+	return prevOut.Index == wire.MaxPrevOutIndex && prevOut.Hash == (chainhash.Hash{})
+}
+
+func decodeTxOut(n uint32, out *wire.TxOut, params *chaincfg.Params) *DecodedTxOut {
+	decoded := &DecodedTxOut{N: n, Value: btcutil.Amount(out.Value), PkScript: out.PkScript,
+		Class: scriptClassName(out.PkScript)}
+	class, addrs, reqSigs, err := txscript.ExtractPkScriptAddrs(out.PkScript, params)
+	if err == nil && class != txscript.NonStandardTy && class != txscript.NullDataTy {
+		decoded.ReqSigs = reqSigs
+		for _, addr := range addrs {
+			decoded.Addresses = append(decoded.Addresses, EncodeAddress(addr, params))
+		}
+	}
+	if class == txscript.NullDataTy {
+		decoded.NullData, _ = txscript.PushedData(out.PkScript)
+	}
+	return decoded
+}
+
+//scriptClassName return the class of the script as the type of Bitcoin Core
+func scriptClassName(pkScript []byte) string {
+	class := txscript.GetScriptClass(pkScript)
+	if class == txscript.NonStandardTy && txscript.IsWitnessProgram(pkScript) {
+		version, program, _ := txscript.ExtractWitnessProgramInfo(pkScript)
+		if version == 1 && len(program) == 32 {
+			return witnessV1TaprootClass
+		}
+		return witnessUnknownClass
+	}
+	return class.String()
+}
+
+//analyze find the class, the scripts and the signers of the input, by the prevout if supplied
+func (in *DecodedTxIn) analyze() {
+	pushes, err := txscript.PushedData(in.SignatureScript)
+	if err != nil {
+		in.Class = txscript.NonStandardTy.String()
+		return
+	}
+	var last []byte
+	if len(pushes) > 0 {
+		last = pushes[len(pushes)-1]
+	}
+
+	switch {
+	case in.Prevout != nil:
+		in.Class = in.Prevout.Class
+	case len(in.Witness) != 0 && len(pushes) != 0:
+		in.Class = txscript.ScriptHashTy.String() //nested witness program
+	case len(in.Witness) == 2 && isPubKey(in.Witness[1]):
+		in.Class = txscript.WitnessV0PubKeyHashTy.String()
+	case len(in.Witness) == 1 && (len(in.Witness[0]) == 64 || len(in.Witness[0]) == 65):
+		in.Class = witnessV1TaprootClass
+	case len(in.Witness) != 0:
+		in.Class = txscript.WitnessV0ScriptHashTy.String()
+	case len(pushes) == 2 && isSignature(pushes[0]) && isPubKey(pushes[1]):
+		in.Class = txscript.PubKeyHashTy.String()
+	case len(pushes) == 1 && isSignature(pushes[0]):
+		in.Class = txscript.PubKeyTy.String()
+	case len(pushes) != 0 && !isSignature(last) && !isPubKey(last) &&
+		txscript.GetScriptClass(last) != txscript.NonStandardTy:
+		in.Class = txscript.ScriptHashTy.String()
+	default:
+		in.Class = txscript.NonStandardTy.String()
+	}
+
+	//the scripts
+	if in.Class == txscript.ScriptHashTy.String() {
+		in.RedeemScript = last
+	}
+	if len(in.Witness) > 1 && (in.Class == txscript.WitnessV0ScriptHashTy.String() ||
+		in.Class == txscript.ScriptHashTy.String() && txscript.GetScriptClass(last) == txscript.WitnessV0ScriptHashTy) {
+		in.WitnessScript = in.Witness[len(in.Witness)-1]
+	}
+
+	//the signers
+	items := append(append([][]byte{}, pushes...), in.Witness...)
+	for _, item := range items {
+		switch {
+		case isSignature(item):
+			in.Signatures = append(in.Signatures, item)
+		case isPubKey(item):
+			in.PubKeys = append(in.PubKeys, item)
+		}
+	}
+	if in.Class == witnessV1TaprootClass && len(in.Witness) == 1 { //the schnorr signature of the key path
+		in.Signatures = append(in.Signatures, in.Witness[0])
+	}
+	for _, script := range [][]byte{in.RedeemScript, in.WitnessScript} {
+		if txscript.GetScriptClass(script) != txscript.MultiSigTy {
+			continue
+		}
+		keys, _ := txscript.PushedData(script)
+		for _, key := range keys {
+			if isPubKey(key) {
+				in.PubKeys = append(in.PubKeys, key)
+			}
+		}
+	}
+}
+
+//isSignature return whether data is a DER signature with the hash type
+func isSignature(data []byte) bool {
+	if len(data) < 9 || data[0] != 0x30 {
+		return false
+	}
+	_, err := btcec.ParseDERSignature(data[:len(data)-1], btcec.S256())
+	return err == nil
+}
+
+//isPubKey return whether data is a compressed or uncompressed public key
+func isPubKey(data []byte) bool {
+	if !(len(data) == 33 && (data[0] == 0x02 || data[0] == 0x03) || len(data) == 65 && data[0] == 0x04) {
+		return false
+	}
+	_, err := btcec.ParsePubKey(data, btcec.S256())
+	return err == nil
+}
+
+//the JSON of Bitcoin Core
+type (
+	txJSON struct {
+		TxID     string       `json:"txid"`
+		Hash     string       `json:"hash"`
+		Version  int32        `json:"version"`
+		Size     int          `json:"size"`
+		VSize    int          `json:"vsize"`
+		Weight   int          `json:"weight"`
+		LockTime uint32       `json:"locktime"`
+		Vin      []txInJSON   `json:"vin"`
+		Vout     []txOutJSON  `json:"vout"`
+		Fee      *json.Number `json:"fee,omitempty"`
+	}
+	txInJSON struct {
+		Coinbase  string         `json:"coinbase,omitempty"`
+		TxID      string         `json:"txid,omitempty"`
+		Vout      *uint32        `json:"vout,omitempty"`
+		ScriptSig *scriptSigJSON `json:"scriptSig,omitempty"`
+		Witness   []string       `json:"txinwitness,omitempty"`
+		Prevout   *prevoutJSON   `json:"prevout,omitempty"`
+		Sequence  uint32         `json:"sequence"`
+	}
+	scriptSigJSON struct {
+		Asm string `json:"asm"`
+		Hex string `json:"hex"`
+	}
+	prevoutJSON struct {
+		Value        json.Number      `json:"value"`
+		ScriptPubKey scriptPubKeyJSON `json:"scriptPubKey"`
+	}
+	txOutJSON struct {
+		Value        json.Number      `json:"value"`
+		N            uint32           `json:"n"`
+		ScriptPubKey scriptPubKeyJSON `json:"scriptPubKey"`
+	}
+	scriptPubKeyJSON struct {
+		Asm       string   `json:"asm"`
+		Hex       string   `json:"hex"`
+		ReqSigs   int      `json:"reqSigs,omitempty"`
+		Type      string   `json:"type"`
+		Addresses []string `json:"addresses,omitempty"`
+	}
+)
+
+//MarshalJSON marshal the tx as decoderawtransaction of Bitcoin Core
+func (tx *DecodedTx) MarshalJSON() ([]byte, error) {
+	result := txJSON{TxID: tx.TxID.String(), Hash: tx.WTxID.String(), Version: tx.Version, Size: tx.Size,
+		VSize: tx.VSize, Weight: tx.Weight, LockTime: tx.LockTime, Vin: []txInJSON{}, Vout: []txOutJSON{}}
+	for _, in := range tx.Inputs {
+		var vin txInJSON
+		if in.Coinbase {
+			vin.Coinbase = hex.EncodeToString(in.SignatureScript)
+		} else {
+			vout := in.OutPoint.Index
+			asm, _ := txscript.DisasmString(in.SignatureScript)
+			vin.TxID, vin.Vout = in.OutPoint.Hash.String(), &vout
+			vin.ScriptSig = &scriptSigJSON{Asm: asm, Hex: hex.EncodeToString(in.SignatureScript)}
+		}
+		for _, item := range in.Witness {
+			vin.Witness = append(vin.Witness, hex.EncodeToString(item))
+		}
+		if in.Prevout != nil {
+			vin.Prevout = &prevoutJSON{Value: tx.coins(in.Prevout.Value), ScriptPubKey: in.Prevout.scriptPubKeyJSON()}
+		}
+		vin.Sequence = in.Sequence
+		result.Vin = append(result.Vin, vin)
+	}
+	for _, out := range tx.Outputs {
+		result.Vout = append(result.Vout, txOutJSON{Value: tx.coins(out.Value), N: out.N,
+			ScriptPubKey: out.scriptPubKeyJSON()})
+	}
+	if tx.Fee != nil {
+		fee := tx.coins(*tx.Fee)
+		result.Fee = &fee
+	}
+	return json.Marshal(&result)
+}
+
+func (tx *DecodedTx) coins(amount btcutil.Amount) json.Number {
+	decimals := tx.decimals
+	if decimals == 0 {
+		decimals = satoshiDecimals
+	}
+	return json.Number(formatCoins(amount, decimals))
+}
+
+func (out *DecodedTxOut) scriptPubKeyJSON() scriptPubKeyJSON {
+	asm, _ := txscript.DisasmString(out.PkScript)
+	return scriptPubKeyJSON{Asm: asm, Hex: hex.EncodeToString(out.PkScript), ReqSigs: out.ReqSigs, Type: out.Class,
+		Addresses: out.Addresses}
+}
+
+//DecodeTransaction decode the raw tx with its prevouts resolved by the nodes
+func (abtc *AdaptorBTC) DecodeTransaction(rawTx []byte) (*DecodedTx, error) {
+	return abtc.DecodeTransactionCtx(context.Background(), rawTx)
+}
+
+func (abtc *AdaptorBTC) DecodeTransactionCtx(ctx context.Context, rawTx []byte) (*DecodedTx, error) {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(rawTx)); err != nil {
+		return nil, kindError(ErrKindInvalidTx, "Deserialize tx failed", err)
+	}
+	prevouts, err := abtc.resolvePrevouts(ctx, &tx)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeTransaction(rawTx, GetNet(abtc.NetID), prevouts)
+}
+
+//resolvePrevouts return the outputs spent by the inputs of tx by the nodes, none of a coinbase
+func (abtc *AdaptorBTC) resolvePrevouts(ctx context.Context, tx *wire.MsgTx) (map[wire.OutPoint]*wire.TxOut, error) {
+	prevouts := map[wire.OutPoint]*wire.TxOut{}
+	if len(tx.TxIn) == 0 || isCoinBase(tx) {
+		return prevouts, nil
+	}
+	var txids []string
+	for _, in := range tx.TxIn {
+		txids = append(txids, in.PreviousOutPoint.Hash.String())
+	}
+	result, err := abtc.callResolver(ctx, func(client *rpcclient.Client, resolver *txResolver) (interface{}, error) {
+		return resolver.getTxs(ctx, txids)
+	})
+	if err != nil {
+		return nil, err
+	}
+	prevTxs := result.(map[string]*btcjson.TxRawResult)
+	chain := ChainOf(GetNet(abtc.NetID))
+	for _, in := range tx.TxIn {
+		prevTx := prevTxs[in.PreviousOutPoint.Hash.String()]
+		if prevTx == nil || int(in.PreviousOutPoint.Index) >= len(prevTx.Vout) {
+			return nil, newError(ErrKindNode, fmt.Sprintf("prevout %v is not found", in.PreviousOutPoint))
+		}
+		out := prevTx.Vout[in.PreviousOutPoint.Index]
+		pkScript, err := hex.DecodeString(out.ScriptPubKey.Hex)
+		if err != nil {
+			return nil, kindError(ErrKindNode, "hex.DecodeString scriptPubKey of prevout failed", err)
+		}
+		prevouts[in.PreviousOutPoint] = wire.NewTxOut(int64(chain.amountOf(out.Value)), pkScript)
+	}
+	return prevouts, nil
+}
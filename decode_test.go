@@ -0,0 +1,162 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+func TestDecodeTransaction(t *testing.T) {
+	//the unsigned tx of TestSignTransaction, OP_RETURN and P2PKH
+	rawTx, _ := hex.DecodeString("01000000016af6e1f77fbff03a3439d465c4ceb7871f4722dd1463e745129a21bf80670f49000000000000000000020000000000000000256a235031397a34723747394d705a7461594d5a63415457696e5477586547426a376657546420f40e00000000001976a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac00000000")
+	decoded, err := DecodeTransaction(rawTx, GetNet(NETID_TEST), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Size != len(rawTx) || decoded.VSize != len(rawTx) || decoded.Weight != len(rawTx)*4 ||
+		decoded.TxID != decoded.WTxID || decoded.Fee != nil {
+		t.Errorf("unexpected sizes - got: %v %v %v", decoded.Size, decoded.VSize, decoded.Weight)
+	}
+	if out := decoded.Outputs[0]; out.Class != "nulldata" || len(out.NullData) != 1 ||
+		string(out.NullData[0]) != "P19z4r7G9MpZtaYMZcATWinTwXeGBj7fWTd" {
+		t.Errorf("unexpected OP_RETURN output - got: %+v", out)
+	}
+	want := `{"txid":"` + decoded.TxID.String() + `","hash":"` + decoded.TxID.String() + `","version":1,"size":131,` +
+		`"vsize":131,"weight":524,"locktime":0,"vin":[{"txid":"490f6780bf219a1245e76314dd22471f87b7cec465d439343af0bf7ff7e1f66a",` +
+		`"vout":0,"scriptSig":{"asm":"","hex":""},"sequence":0}],"vout":[{"value":0.00000000,"n":0,"scriptPubKey":` +
+		`{"asm":"OP_RETURN 5031397a34723747394d705a7461594d5a63415457696e5477586547426a3766575464","hex":` +
+		`"6a235031397a34723747394d705a7461594d5a63415457696e5477586547426a3766575464","type":"nulldata"}},` +
+		`{"value":0.00980000,"n":1,"scriptPubKey":{"asm":"OP_DUP OP_HASH160 0f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e ` +
+		`OP_EQUALVERIFY OP_CHECKSIG","hex":"76a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac","reqSigs":1,` +
+		`"type":"pubkeyhash","addresses":["mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"]}}]}`
+	if data, _ := json.Marshal(decoded); string(data) != want {
+		t.Errorf("unexpected JSON - got: %s, "+"want: %s", data, want)
+	}
+
+	//the signed multisig of TestBindTxAndSignature
+	redeemHex := "522103940ab29fbf214da2d8ec99c47db63879957311bd90d2f1c635828604d541051421020106ca23b4f28dbc83838ee4745accf90e5621fe70df5b1ee8f7e1b3b41b64cb21029d80ff37838e4989a6aa26af41149d4f671976329e9ddb9b78fdea9814ae6ef553ae"
+	rawTx, _ = hex.DecodeString("010000000144080d1b48b02a483b5341ba70b2660616d72d1aeb006518f3e261f7f651e48d00000000b500483045022100f2e159bbcbe28829d75f68ac3cdda9851df5478a23c090b58e9a6189d3f7e1bc0220664fde594d470527f70a10542384a241487fcd209291819093a5e6d7c3f83c94014c69" + redeemHex + "0000000001301b0f00000000001976a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac00000000")
+	decoded, err = DecodeTransaction(rawTx, GetNet(NETID_TEST), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in := decoded.Inputs[0]; in.Class != "scripthash" || hex.EncodeToString(in.RedeemScript) != redeemHex ||
+		len(in.PubKeys) != 3 || len(in.Signatures) != 1 || in.Prevout != nil {
+		t.Errorf("unexpected multisig input - got: %v %x %v %v", in.Class, in.RedeemScript, len(in.PubKeys),
+			len(in.Signatures))
+	}
+
+	//the genesis coinbase
+	var buf bytes.Buffer
+	chaincfg.MainNetParams.GenesisBlock.Transactions[0].Serialize(&buf)
+	decoded, err = DecodeTransaction(buf.Bytes(), nil, nil)
+	if err != nil || !decoded.Inputs[0].Coinbase || decoded.TxID != chaincfg.MainNetParams.GenesisBlock.Header.MerkleRoot {
+		t.Fatalf("unexpected coinbase - got: %+v %v", decoded, err)
+	}
+	data, _ := json.Marshal(decoded)
+	if !strings.Contains(string(data), `"vin":[{"coinbase":"04ffff001d`) || strings.Contains(string(data), `"fee"`) ||
+		decoded.Outputs[0].Class != "pubkey" || decoded.Outputs[0].Addresses[0] != "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa" {
+		t.Errorf("unexpected coinbase JSON - got: %s", data)
+	}
+
+	if _, err := DecodeTransaction([]byte{0x01}, nil, nil); ErrorKindOf(err) != ErrKindInvalidTx {
+		t.Errorf("unexpected error of bad tx - got: %v", err)
+	}
+}
+
+func TestDecodeTransactionWitness(t *testing.T) {
+	key, _ := btcec.NewPrivateKey(btcec.S256())
+	pubKey := key.PubKey().SerializeCompressed()
+	sig, _ := key.Sign(chainhash.DoubleHashB([]byte("witness")))
+	addr, _ := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(pubKey), &chaincfg.TestNet3Params)
+	pkScript, _ := txscript.PayToAddrScript(addr)
+	taproot := append([]byte{txscript.OP_1, txscript.OP_DATA_32}, make([]byte, 32)...)
+
+	tx := wire.NewMsgTx(2)
+	prevOut := outPointOf(fmt.Sprintf("%064x", 1), 3)
+	tx.AddTxIn(wire.NewTxIn(&prevOut, nil, [][]byte{append(sig.Serialize(), 0x01), pubKey}))
+	tx.AddTxOut(wire.NewTxOut(90000, taproot))
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+
+	prevouts := map[wire.OutPoint]*wire.TxOut{prevOut: wire.NewTxOut(100000, pkScript)}
+	for _, prevouts := range []map[wire.OutPoint]*wire.TxOut{nil, prevouts} {
+		decoded, err := DecodeTransaction(buf.Bytes(), GetNet(NETID_TEST), prevouts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.TxID == decoded.WTxID || decoded.Weight != tx.SerializeSizeStripped()*3+tx.SerializeSize() ||
+			decoded.VSize != (decoded.Weight+3)/4 {
+			t.Errorf("unexpected witness sizes - got: %v %v", decoded.Weight, decoded.VSize)
+		}
+		in := decoded.Inputs[0]
+		if in.Class != "witness_v0_keyhash" || len(in.Signatures) != 1 || !bytes.Equal(in.PubKeys[0], pubKey) {
+			t.Errorf("unexpected witness input - got: %v %v %v", in.Class, len(in.Signatures), len(in.PubKeys))
+		}
+		if decoded.Outputs[0].Class != "witness_v1_taproot" {
+			t.Errorf("unexpected taproot output - got: %v", decoded.Outputs[0].Class)
+		}
+		data, _ := json.Marshal(decoded)
+		if prevouts == nil {
+			continue
+		}
+		if decoded.Fee == nil || *decoded.Fee != 10000 || in.Prevout.Addresses[0] != addr.EncodeAddress() ||
+			!strings.Contains(string(data), `"prevout":{"value":0.00100000,`) || !strings.Contains(string(data), `"fee":0.00010000}`) {
+			t.Errorf("unexpected fee - got: %s", data)
+		}
+	}
+}
+
+func TestAdaptorDecodeTransaction(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+
+	prevTxid := fmt.Sprintf("%064x", 1)
+	pkScript := "76a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac"
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		if txid != prevTxid {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No information available about transaction")
+		}
+		return btcjson.TxRawResult{Txid: prevTxid, Vout: []btcjson.Vout{{}, {N: 1, Value: 0.29,
+			ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: pkScript}}}}, nil
+	})
+
+	tx := wire.NewMsgTx(1)
+	prevOut := outPointOf(prevTxid, 1)
+	tx.AddTxIn(wire.NewTxIn(&prevOut, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(28990000, mustDecodeHex(pkScript)))
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	defer abtc.Close()
+	decoded, err := abtc.DecodeTransaction(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Fee == nil || *decoded.Fee != 10000 || decoded.Inputs[0].Prevout.Class != "pubkeyhash" {
+		t.Errorf("unexpected decoded tx - got: %+v", decoded)
+	}
+
+	missing := outPointOf(prevTxid, 2)
+	tx.TxIn[0].PreviousOutPoint = missing
+	buf.Reset()
+	tx.Serialize(&buf)
+	if _, err := abtc.DecodeTransaction(buf.Bytes()); ErrorKindOf(err) != ErrKindNode {
+		t.Errorf("unexpected error of missing prevout - got: %v", err)
+	}
+}
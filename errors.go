@@ -0,0 +1,142 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"context"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+//ErrorKind classify the errors, only ErrKindTransient is retried
+type ErrorKind int
+
+const (
+	ErrKindUnknown       ErrorKind = iota //not classified, not retried
+	ErrKindTransient                      //network error, timeout or node not ready, retry may succeed
+	ErrKindCanceled                       //the context is canceled or its deadline exceeded
+	ErrKindInvalidParams                  //the input is invalid
+	ErrKindBadAddress                     //the address can not be decoded for the net
+	ErrKindInvalidTx                      //the tx can not be decoded or is rejected by the node
+	ErrKindNotFound                       //the tx, block or address is not found by the node
+	ErrKindNode                           //other errors returned by the node
+)
+
+var errorKindNames = map[ErrorKind]string{
+	ErrKindUnknown:       "unknown",
+	ErrKindTransient:     "transient",
+	ErrKindCanceled:      "canceled",
+	ErrKindInvalidParams: "invalid params",
+	ErrKindBadAddress:    "bad address",
+	ErrKindInvalidTx:     "invalid tx",
+	ErrKindNotFound:      "not found",
+	ErrKindNode:          "node error",
+}
+
+func (kind ErrorKind) String() string {
+	if name, ok := errorKindNames[kind]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+//AdaptorError is an error with its kind, Cause is the original error
+type AdaptorError struct {
+	Kind  ErrorKind
+	Msg   string
+	Cause error
+}
+
+func (e *AdaptorError) Error() string {
+	if e.Cause == nil {
+		return e.Msg
+	}
+	if e.Msg == "" {
+		return e.Cause.Error()
+	}
+	return e.Msg + " : " + e.Cause.Error()
+}
+
+func newError(kind ErrorKind, msg string) error {
+	return &AdaptorError{Kind: kind, Msg: msg}
+}
+
+//wrapError wrap the error of a rpc call with msg, the kind of err is kept,
+//errors not returned by the node are transient
+func wrapError(msg string, err error) error {
+	kind := ErrorKindOf(err)
+	if kind == ErrKindUnknown {
+		kind = ErrKindTransient
+	}
+	return &AdaptorError{Kind: kind, Msg: msg, Cause: err}
+}
+
+//kindError wrap err with msg as kind
+func kindError(kind ErrorKind, msg string, err error) error {
+	return &AdaptorError{Kind: kind, Msg: msg, Cause: err}
+}
+
+//Cause return the original error of err
+func Cause(err error) error {
+	for {
+		adaptorErr, ok := err.(*AdaptorError)
+		if !ok || adaptorErr.Cause == nil {
+			return err
+		}
+		err = adaptorErr.Cause
+	}
+}
+
+//ErrorKindOf return the kind of err
+func ErrorKindOf(err error) ErrorKind {
+	switch e := err.(type) {
+	case nil:
+		return ErrKindUnknown
+	case *AdaptorError:
+		return e.Kind
+	case *btcjson.RPCError:
+		return rpcErrorKind(e.Code, e.Message)
+	}
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return ErrKindCanceled
+	}
+	return ErrKindUnknown
+}
+
+func rpcErrorKind(code btcjson.RPCErrorCode, message string) ErrorKind {
+	switch code {
+	case btcjson.ErrRPCDeserialization, btcjson.ErrRPCVerify, btcjson.ErrRPCTxRejected, btcjson.ErrRPCTxAlreadyInChain:
+		return ErrKindInvalidTx
+	case btcjson.ErrRPCInvalidAddressOrKey: //also returned by Bitcoin core for the malformed addresses
+		if strings.HasPrefix(message, "Invalid address") || strings.HasPrefix(message, "Invalid Bitcoin address") {
+			return ErrKindInvalidParams
+		}
+		return ErrKindNotFound
+	case btcjson.ErrRPCType, btcjson.ErrRPCInvalidParameter, btcjson.ErrRPCInvalidParams.Code, btcjson.ErrRPCInvalidRequest.Code:
+		return ErrKindInvalidParams
+	case btcjson.ErrRPCClientNotConnected, btcjson.ErrRPCClientInInitialDownload, -28: //-28 is RPC_IN_WARMUP of Bitcoin core
+		return ErrKindTransient
+	}
+	return ErrKindNode
+}
+
+//IsRetryable return true if a retry may succeed
+func IsRetryable(err error) bool {
+	return ErrorKindOf(err) == ErrKindTransient
+}
@@ -0,0 +1,171 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//ExtraVersion is the version of the Extra encoded by EncodeExtra
+const ExtraVersion = 1
+
+//legacyOutpointLen is the length of an outpoint in the Extra before versions, txid and a byte of vout
+const legacyOutpointLen = 33
+
+//Extra is the content of the Extra fields of the inputs and outputs of the adaptor,
+//the fields not used by a method are ignored by it
+type Extra struct {
+	Outpoints     []wire.OutPoint //the utxos excluded by CreateTransferTokenTx, or spent by the tx it created
+	Amounts       []int64         //of Outpoints, or of the inputs of the tx to sign, which are signed on BCH networks
+	Address       string          //of the key to sign the P2PKH inputs
+	RedeemScript  []byte          //of the P2SH inputs, like a multisig
+	WitnessScript []byte          //of the P2WSH inputs
+	ChangeAddress string          //the change is paid to it instead of the from address
+	FeeRate       int64           //per 1000 bytes, the fee is estimated by it if the fee is zero
+}
+
+//extraJSON is the encoding of Extra, tagged by its version
+type extraJSON struct {
+	Version       int      `json:"v"`
+	Outpoints     []string `json:"outpoints,omitempty"` //txid:vout
+	Amounts       []int64  `json:"amounts,omitempty"`
+	Address       string   `json:"address,omitempty"`
+	RedeemScript  string   `json:"redeemScript,omitempty"`  //hex
+	WitnessScript string   `json:"witnessScript,omitempty"` //hex
+	ChangeAddress string   `json:"changeAddress,omitempty"`
+	FeeRate       int64    `json:"feeRate,omitempty"`
+}
+
+//EncodeExtra encode the extra as JSON of ExtraVersion
+func EncodeExtra(extra *Extra) []byte {
+	e := extraJSON{Version: ExtraVersion, Amounts: extra.Amounts, Address: extra.Address,
+		RedeemScript: hex.EncodeToString(extra.RedeemScript), WitnessScript: hex.EncodeToString(extra.WitnessScript),
+		ChangeAddress: extra.ChangeAddress, FeeRate: extra.FeeRate}
+	for _, outpoint := range extra.Outpoints {
+		e.Outpoints = append(e.Outpoints, outpoint.String())
+	}
+	data, _ := json.Marshal(&e)
+	return data
+}
+
+//DecodeExtra decode the Extra encoded by EncodeExtra, or of the formats before versions:
+//the hex redeem script or the address to sign, the redeem script returned by CreateMultiSigAddress,
+//and the outpoints of 33 bytes of txid and vout. An empty Extra is an empty one.
+func DecodeExtra(data []byte) (*Extra, error) {
+	if len(data) == 0 {
+		return &Extra{}, nil
+	}
+	if isPrintable(data) {
+		if data[0] == '{' {
+			return decodeExtraJSON(data)
+		}
+		str := string(data)
+		if redeem, err := hex.DecodeString(str); err == nil && len(str) > 35 { //longer than addresses of P2PKH
+			return &Extra{RedeemScript: redeem}, nil
+		}
+		return &Extra{Address: str}, nil
+	}
+	if txscript.GetScriptClass(data) == txscript.MultiSigTy {
+		return &Extra{RedeemScript: data}, nil
+	}
+	if len(data)%legacyOutpointLen != 0 {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("the Extra of %d bytes is not of any format", len(data)))
+	}
+	var extra Extra
+	for i := 0; i < len(data); i += legacyOutpointLen {
+		//the txid is in the byte order of its string
+		hash, err := chainhash.NewHashFromStr(hex.EncodeToString(data[i : i+chainhash.HashSize]))
+		if err != nil {
+			return nil, kindError(ErrKindInvalidParams, "NewHashFromStr outpoint of the Extra failed", err)
+		}
+		extra.Outpoints = append(extra.Outpoints, wire.OutPoint{Hash: *hash, Index: uint32(data[i+chainhash.HashSize])})
+	}
+	return &extra, nil
+}
+
+func decodeExtraJSON(data []byte) (*Extra, error) {
+	var e extraJSON
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, kindError(ErrKindInvalidParams, "Unmarshal the Extra failed", err)
+	}
+	if e.Version < 1 || e.Version > ExtraVersion {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("the version %d of the Extra is not supported", e.Version))
+	}
+	extra := Extra{Amounts: e.Amounts, Address: e.Address, ChangeAddress: e.ChangeAddress, FeeRate: e.FeeRate}
+	var err error
+	if extra.RedeemScript, err = decodeExtraHex(e.RedeemScript); err != nil {
+		return nil, kindError(ErrKindInvalidParams, "hex.DecodeString redeemScript of the Extra failed", err)
+	}
+	if extra.WitnessScript, err = decodeExtraHex(e.WitnessScript); err != nil {
+		return nil, kindError(ErrKindInvalidParams, "hex.DecodeString witnessScript of the Extra failed", err)
+	}
+	for _, str := range e.Outpoints {
+		outpoint, err := parseOutpoint(str)
+		if err != nil {
+			return nil, err
+		}
+		extra.Outpoints = append(extra.Outpoints, *outpoint)
+	}
+	if extra.FeeRate < 0 {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("the feeRate %d of the Extra is negative", extra.FeeRate))
+	}
+	return &extra, nil
+}
+
+func decodeExtraHex(str string) ([]byte, error) {
+	if str == "" {
+		return nil, nil
+	}
+	return hex.DecodeString(str)
+}
+
+//parseOutpoint parse the outpoint of txid:vout
+func parseOutpoint(str string) (*wire.OutPoint, error) {
+	i := strings.LastIndex(str, ":")
+	if i < 0 {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("the outpoint %s is not txid:vout", str))
+	}
+	hash, err := chainhash.NewHashFromStr(str[:i])
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "NewHashFromStr outpoint failed", err)
+	}
+	index, err := strconv.ParseUint(str[i+1:], 10, 32)
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "ParseUint vout of outpoint failed", err)
+	}
+	return wire.NewOutPoint(hash, uint32(index)), nil
+}
+
+//isPrintable return whether data is printable ASCII, which the Extra of addresses and hex scripts is
+func isPrintable(data []byte) bool {
+	for _, b := range data {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+func TestExtra(t *testing.T) {
+	txid := "101d482b60cd3f74a61ce265d62e383456b9c21c84477931d207ea8f503d84cc"
+	redeemHex := "522103940ab29fbf214da2d8ec99c47db63879957311bd90d2f1c635828604d541051421020106ca23b4f28dbc83838ee4745accf90e5621fe70df5b1ee8f7e1b3b41b64cb21029d80ff37838e4989a6aa26af41149d4f671976329e9ddb9b78fdea9814ae6ef553ae"
+	redeem, _ := hex.DecodeString(redeemHex)
+
+	extra := &Extra{Outpoints: []wire.OutPoint{outPointOf(txid, 0), outPointOf(txid, 300)}, Amounts: []int64{1, 2},
+		Address: "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5", RedeemScript: redeem, WitnessScript: []byte{0x51},
+		ChangeAddress: "2N4jXJyMo8eRKLPWqi5iykAyFLXd6szehwA", FeeRate: 2000}
+	encoded := EncodeExtra(extra)
+	decoded, err := DecodeExtra(encoded)
+	if err != nil || !reflect.DeepEqual(decoded, extra) {
+		t.Errorf("unexpected decoded Extra of %s - got: %+v %v, "+"want: %+v", encoded, decoded, err, extra)
+	}
+	if encoded := string(EncodeExtra(&Extra{FeeRate: 1000})); encoded != `{"v":1,"feeRate":1000}` {
+		t.Errorf("unexpected encoded Extra - got: %s", encoded)
+	}
+
+	//the formats before versions
+	legacy, _ := hex.DecodeString(txid + "01" + txid + "ff")
+	multisig, _ := CreateMultiSigAddress(&adaptor.CreateMultiSigAddressInput{Keys: [][]byte{redeem[2:35], redeem[36:69]},
+		SignCount: 2}, NETID_TEST)
+	tests := []struct {
+		data []byte
+		want *Extra
+	}{
+		{nil, &Extra{}},
+		{legacy, &Extra{Outpoints: []wire.OutPoint{outPointOf(txid, 1), outPointOf(txid, 255)}}},
+		{[]byte(redeemHex), &Extra{RedeemScript: redeem}},
+		{multisig.Extra, &Extra{RedeemScript: multisig.Extra}},
+		{[]byte("mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"), &Extra{Address: "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"}},
+		{[]byte("bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvqcw003ap"),
+			&Extra{Address: "bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvqcw003ap"}},
+	}
+	for _, test := range tests {
+		decoded, err := DecodeExtra(test.data)
+		if err != nil || !reflect.DeepEqual(decoded, test.want) {
+			t.Errorf("unexpected decoded Extra of %x - got: %+v %v, "+"want: %+v", test.data, decoded, err, test.want)
+		}
+	}
+
+	for _, bad := range []string{`{"v":2}`, `{"v":0}`, `{"v":1`, `{"v":1,"outpoints":["` + txid + `"]}`,
+		`{"v":1,"redeemScript":"5"}`, `{"v":1,"feeRate":-1}`, "\x00\x01"} {
+		if _, err := DecodeExtra([]byte(bad)); ErrorKindOf(err) != ErrKindInvalidParams {
+			t.Errorf("unexpected error of %q - got: %v", bad, err)
+		}
+	}
+}
+
+func TestCreateTransferTxExtra(t *testing.T) {
+	key, _ := hex.DecodeString("d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0")
+	pubKey, _ := GetPublicKey(key, NETID_BCH_TEST)
+	from, _ := PubKeyToAddress(pubKey, NETID_BCH_TEST)
+	change := "bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvqcw003ap"
+	txid := func(n int) string { return fmt.Sprintf("%064x", n) }
+	utxos := map[wire.OutPoint]btcutil.Amount{
+		outPointOf(txid(1), 300): 1000000,
+		outPointOf(txid(2), 1):   2000000,
+		outPointOf(txid(3), 2):   4000000,
+	}
+	create := func(extra []byte) (*wire.MsgTx, *Extra, error) {
+		input := &adaptor.CreateTransferTokenTxInput{FromAddress: from, ToAddress: from,
+			Amount: adaptor.NewAmountAssetString("900000", "BCH"), Extra: extra}
+		output, err := createTransferTokenTxByUnspend(input, NETID_BCH_TEST,
+			func(addr btcutil.Address) (map[wire.OutPoint]btcutil.Amount, error) { return utxos, nil })
+		if err != nil {
+			return nil, nil, err
+		}
+		var tx wire.MsgTx
+		tx.Deserialize(bytes.NewReader(output.Transaction))
+		outputExtra, err := DecodeExtra(output.Extra)
+		return &tx, outputExtra, err
+	}
+
+	//the utxo of the vout above 255 is spent, the change is paid to the change address by the fee rate
+	tx, outputExtra, err := create(EncodeExtra(&Extra{ChangeAddress: change, FeeRate: 5000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Extra{Outpoints: []wire.OutPoint{outPointOf(txid(1), 300)}, Amounts: []int64{1000000}, ChangeAddress: change}
+	if !reflect.DeepEqual(outputExtra, want) || tx.TxIn[0].PreviousOutPoint != want.Outpoints[0] {
+		t.Fatalf("unexpected Extra of the output - got: %+v, "+"want: %+v", outputExtra, want)
+	}
+	changeAddr, _ := DecodeAddress(change, &BCHTestNet3Params)
+	if fee := 1000000 - 900000 - tx.TxOut[1].Value; !bytes.Equal(tx.TxOut[1].PkScript[3:23], changeAddr.ScriptAddress()) ||
+		fee != Fee(5000, estimateTxSize(1, 2)) {
+		t.Errorf("unexpected change - got: %x %v", tx.TxOut[1].PkScript, fee)
+	}
+
+	//the Extra of the output excludes the utxos, also of the format before versions
+	legacy, _ := hex.DecodeString(txid(2) + "01")
+	_, outputExtra, err = create(EncodeExtra(&Extra{Outpoints: want.Outpoints}))
+	if err != nil || outputExtra.Outpoints[0] != outPointOf(txid(2), 1) {
+		t.Errorf("unexpected excluded outpoint - got: %+v %v", outputExtra, err)
+	}
+	tx, outputExtra, err = create(legacy)
+	if err != nil || outputExtra.Outpoints[0] == outPointOf(txid(2), 1) {
+		t.Errorf("unexpected excluded legacy outpoint - got: %+v %v", outputExtra, err)
+	}
+
+	//the amounts of the Extra are signed on BCH
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	signExtra := EncodeExtra(&Extra{Address: from, Amounts: outputExtra.Amounts})
+	signed, err := SignTransaction(&adaptor.SignTransactionInput{PrivateKey: key, Transaction: buf.Bytes(),
+		Extra: signExtra}, NETID_BCH_TEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signedTx wire.MsgTx
+	signedTx.Deserialize(bytes.NewReader(signed.SignedTx))
+	addr, _ := DecodeAddress(from, &BCHTestNet3Params)
+	pkScript, _ := txscript.PayToAddrScript(addr)
+	if err := verifyInput(&BCHTestNet3Params, pkScript, &signedTx, 0, outputExtra.Amounts[0]); err != nil {
+		t.Errorf("unexpected error of the signed input - got: %v", err)
+	}
+
+	if _, _, err := create([]byte(`{"v":1,"changeAddress":"bad"}`)); ErrorKindOf(err) != ErrKindBadAddress {
+		t.Errorf("unexpected error of bad change address - got: %v", err)
+	}
+}
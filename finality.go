@@ -0,0 +1,165 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"math/big"
+	"strconv"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+//AmountTier require more confirmations for large amounts
+type AmountTier struct {
+	MinAmount     int64 //satoshi, the tier applies to amounts >= MinAmount
+	Confirmations int64
+}
+
+//FinalityPolicy decide when a block or a tx is stable, and when a utxo can be spent.
+//The zero value of a field means its default.
+type FinalityPolicy struct {
+	Confirmations       int64        //confirmations of blocks and deposits, MinConfirm, or 1 on regtest and simnet
+	ChangeConfirmations int64        //confirmations of our own change to be spent, default Confirmations
+	Tiers               []AmountTier //amounts of a tier need at least its confirmations
+	//if set, the work of the confirmations must also reach it,
+	//the work of a confirmation is estimated by the bits of the block
+	RequiredWork *big.Int
+}
+
+//DefaultFinalityPolicy return the default policy of the network
+func DefaultFinalityPolicy(params *chaincfg.Params) FinalityPolicy {
+	return FinalityPolicy{}.withDefault(params)
+}
+
+func (p FinalityPolicy) withDefault(params *chaincfg.Params) FinalityPolicy {
+	if p.Confirmations <= 0 {
+		p.Confirmations = MinConfirm
+		if chain := ChainOf(params); chain.Confirmations > 0 {
+			p.Confirmations = chain.Confirmations
+		}
+		if params != nil && (params.Name == chaincfg.RegressionNetParams.Name || params.Name == chaincfg.SimNetParams.Name) {
+			p.Confirmations = 1
+		}
+	}
+	if p.ChangeConfirmations <= 0 {
+		p.ChangeConfirmations = p.Confirmations
+	}
+	return p
+}
+
+//defaultFinality return the default policy of netID, for the functions without an adaptor
+func defaultFinality(netID int) *FinalityPolicy {
+	policy := DefaultFinalityPolicy(GetNet(netID))
+	return &policy
+}
+
+//Required return the confirmations required for amount
+func (p *FinalityPolicy) Required(amount int64) int64 {
+	required := p.Confirmations
+	for _, tier := range p.Tiers {
+		if amount >= tier.MinAmount && tier.Confirmations > required {
+			required = tier.Confirmations
+		}
+	}
+	return required
+}
+
+//workEnough check the work of confirmations on a block with bits, bits 0 means unknown
+func (p *FinalityPolicy) workEnough(confirmations int64, bits uint32) bool {
+	if p.RequiredWork == nil {
+		return true
+	}
+	if bits == 0 || confirmations <= 0 {
+		return false
+	}
+	work := new(big.Int).Mul(calcWork(bits), big.NewInt(confirmations))
+	return work.Cmp(p.RequiredWork) >= 0
+}
+
+//IsFinal return whether a block or a tx of amount with confirmations is stable,
+//bits of the block is only needed if RequiredWork is set
+func (p *FinalityPolicy) IsFinal(confirmations, amount int64, bits uint32) bool {
+	return confirmations >= p.Required(amount) && p.workEnough(confirmations, bits)
+}
+
+//CanSpend return whether a utxo of amount can be spent, isChange is true if it is our own change
+func (p *FinalityPolicy) CanSpend(confirmations, amount int64, bits uint32, isChange bool) bool {
+	if isChange {
+		return confirmations >= p.ChangeConfirmations && p.workEnough(confirmations, bits)
+	}
+	return p.IsFinal(confirmations, amount, bits)
+}
+
+//needBits return whether IsFinal and CanSpend need the bits of blocks
+func (p *FinalityPolicy) needBits() bool {
+	return p.RequiredWork != nil
+}
+
+//blockBitsByClient return the bits of the block, 0 if the policy does not need it
+func (p *FinalityPolicy) blockBitsByClient(client *rpcclient.Client, blockHash string) (uint32, error) {
+	if !p.needBits() || blockHash == "" {
+		return 0, nil
+	}
+	hash, err := chainhash.NewHashFromStr(blockHash)
+	if err != nil {
+		return 0, kindError(ErrKindInvalidParams, "NewHashFromStr block failed", err)
+	}
+	header, err := client.GetBlockHeader(hash)
+	if err != nil {
+		return 0, wrapError("GetBlockHeader failed", err)
+	}
+	return header.Bits, nil
+}
+
+//sumVout return the total value of outputs in satoshi, a tx is tiered by it
+func sumVout(vouts []btcjson.Vout) int64 {
+	var total int64
+	for _, out := range vouts {
+		total += int64(satoshiOf(out.Value))
+	}
+	return total
+}
+
+//sumTxOut is sumVout of a decoded tx
+func sumTxOut(tx *wire.MsgTx) int64 {
+	var total int64
+	for _, out := range tx.TxOut {
+		total += out.Value
+	}
+	return total
+}
+
+//rawTxAmount is sumTxOut of a serialized tx, 0 if it can not be decoded
+func rawTxAmount(raw []byte) int64 {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return 0
+	}
+	return sumTxOut(&tx)
+}
+
+//parseBits parse the hex bits of rpc results
+func parseBits(bits string) uint32 {
+	value, _ := strconv.ParseUint(bits, 16, 32)
+	return uint32(value)
+}
@@ -0,0 +1,140 @@
+package btcadaptor
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+func TestFinalityPolicy(t *testing.T) {
+	if policy := DefaultFinalityPolicy(&chaincfg.MainNetParams); policy.Confirmations != MinConfirm ||
+		policy.ChangeConfirmations != MinConfirm {
+		t.Errorf("unexpected mainnet policy - got: %v, "+"want: %v", policy, MinConfirm)
+	}
+	if policy := DefaultFinalityPolicy(&chaincfg.RegressionNetParams); policy.Confirmations != 1 {
+		t.Errorf("unexpected regtest confirmations - got: %v, "+"want: %v", policy.Confirmations, 1)
+	}
+
+	policy := FinalityPolicy{ChangeConfirmations: 1,
+		Tiers: []AmountTier{{MinAmount: 10e8, Confirmations: 12}, {MinAmount: 100e8, Confirmations: 30}}}
+	policy = policy.withDefault(&chaincfg.TestNet3Params)
+	tests := []struct {
+		amount int64
+		want   int64
+	}{
+		{1e8, MinConfirm},
+		{10e8, 12},
+		{50e8, 12},
+		{100e8, 30},
+	}
+	for _, test := range tests {
+		if got := policy.Required(test.amount); got != test.want {
+			t.Errorf("unexpected required of %d - got: %v, "+"want: %v", test.amount, got, test.want)
+		}
+	}
+	if policy.IsFinal(11, 10e8, 0) || !policy.IsFinal(12, 10e8, 0) {
+		t.Errorf("unexpected IsFinal of tier 10 BTC")
+	}
+	if !policy.CanSpend(1, 100e8, 0, true) || policy.CanSpend(1, 1e8, 0, false) {
+		t.Errorf("unexpected CanSpend of change and deposit")
+	}
+
+	//a block of the easiest regtest target is 2 hashes of work
+	policy.RequiredWork = big.NewInt(4)
+	bits := chaincfg.RegressionNetParams.PowLimitBits
+	if policy.IsFinal(MinConfirm, 1e8, 0) {
+		t.Errorf("unexpected IsFinal without bits")
+	}
+	if !policy.IsFinal(MinConfirm, 1e8, bits) || policy.CanSpend(1, 1e8, bits, true) || !policy.CanSpend(2, 1e8, bits, true) {
+		t.Errorf("unexpected work check - got: %v", calcWork(bits))
+	}
+}
+
+func TestIndexerFinality(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	dir, err := ioutil.TempDir("", "btcindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addrB := "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"
+	pkScript := func(addrStr string) []byte {
+		addr, _ := btcutil.DecodeAddress(addrStr, &chaincfg.TestNet3Params)
+		script, _ := txscript.PayToAddrScript(addr)
+		return script
+	}
+	fund := wire.NewMsgTx(1)
+	fund.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	fund.AddTxOut(wire.NewTxOut(20e8, pkScript(addrA)))
+	fund.AddTxOut(wire.NewTxOut(1e8, pkScript(addrA)))
+	spend := wire.NewMsgTx(1)
+	spend.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: fund.TxHash(), Index: 1}})
+	spend.AddTxOut(wire.NewTxOut(3e7, pkScript(addrB)))
+	spend.AddTxOut(wire.NewTxOut(6e7, pkScript(addrA)))
+
+	chain := &testRPCChain{}
+	chain.serve(node)
+	chain.add(0, 0)
+	chain.add(1, 0, fund)
+	for height := 2; height <= 7; height++ {
+		chain.add(height, 0)
+	}
+	chain.add(8, 0, spend)
+
+	policy := FinalityPolicy{ChangeConfirmations: 1, Tiers: []AmountTier{{MinAmount: 10e8, Confirmations: 10}}}
+	abtc, err := NewAdaptorBTCIndexer(NETID_TEST, rpcParams,
+		IndexerConfig{DBPath: filepath.Join(dir, "index.db"), StartHeight: 1, Finality: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer abtc.Close()
+	if err := abtc.Indexer.WatchAddress(addrA); err != nil {
+		t.Fatal(err)
+	}
+	if err := abtc.Indexer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	//the 20 BTC deposit has 8 confirmations of 10, the change has 1 of 1
+	balance, err := abtc.GetBalance(&adaptor.GetBalanceInput{Address: addrA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance.Amount.Int64() != 6e7 {
+		t.Errorf("unexpected balance - got: %v, "+"want: %v", balance.Balance.Amount, 6e7)
+	}
+	history, err := abtc.GetAddrTxHistory(&adaptor.GetAddrTxHistoryInput{FromAddress: addrA, Asc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Count != 2 || history.Txs[0].IsStable || history.Txs[1].IsStable {
+		t.Fatalf("unexpected history - got: %v", history.Txs)
+	}
+
+	for height := 9; height <= 10; height++ {
+		chain.add(height, 0)
+	}
+	if err := abtc.Indexer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	balance, err = abtc.GetBalance(&adaptor.GetBalanceInput{Address: addrA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance.Amount.Int64() != 20e8+6e7 {
+		t.Errorf("unexpected balance - got: %v, "+"want: %v", balance.Balance.Amount, 20e8+6e7)
+	}
+}
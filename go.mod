@@ -7,6 +7,7 @@ require (
 	github.com/btcsuite/btcd v0.0.0-20190807005414-4063feeff79a
 	github.com/btcsuite/btclog v0.0.0-20170628155309-84c8d2346e9f
 	github.com/btcsuite/btcutil v0.0.0-20190425235716-9e5f4b9a998d
+	github.com/btcsuite/websocket v0.0.0-20150119174127-31079b680792
 	github.com/copernet/copernicus v0.0.7 // indirect
 	github.com/copernet/secp256k1-go v0.0.0-20181006070353-9754f07cc8d3 // indirect
 	github.com/davecgh/go-spew v1.1.1
@@ -14,6 +15,7 @@ require (
 	github.com/palletone/go-palletone v1.0.4 // indirect
 	github.com/shiena/ansicolor v0.0.0-20151119151921-a422bbe96644 // indirect
 	github.com/shopspring/decimal v0.0.0-20180709203117-cd690d0c9e24
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4
 	gopkg.in/go-playground/validator.v8 v8.18.2 // indirect
 )
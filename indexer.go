@@ -0,0 +1,929 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+const defaultIndexerPollInterval = 10 * time.Second
+
+//buckets of the index db, keys of addresses are address|0|..., so they can be scanned by prefix
+var (
+	bucketMeta      = []byte("meta")      //tip -> height|block hash
+	bucketWatched   = []byte("watched")   //address -> pkScript
+	bucketBackfill  = []byte("backfill")  //address -> height of the next block to backfill, for addresses watched after indexing
+	bucketBlocks    = []byte("blocks")    //height -> block hash
+	bucketUndo      = []byte("undo")      //height -> blockUndo json, kept for maxReorgDepth blocks
+	bucketUtxos     = []byte("utxos")     //address|0|outpoint -> value|height|bits|change
+	bucketOutpoints = []byte("outpoints") //outpoint -> address
+	bucketTxs       = []byte("txs")       //txid -> indexedTx json
+	bucketHistory   = []byte("history")   //address|0|height|index -> txid
+
+	keyTip = []byte("tip")
+
+	dataBuckets = [][]byte{bucketBlocks, bucketUndo, bucketUtxos, bucketOutpoints, bucketTxs, bucketHistory}
+)
+
+//IndexerConfig is the config of Indexer
+type IndexerConfig struct {
+	DBPath       string           //file of the bbolt db
+	Params       *chaincfg.Params //network of the nodes
+	StartHeight  int32            //height to begin indexing, the birthday of watched addresses
+	PollInterval time.Duration    //interval of Run to follow the chain
+	Finality     FinalityPolicy   //when utxos can be spent and txs are stable, zero value means the default of Params
+}
+
+//IndexedUtxo is an unspent output of a watched address
+type IndexedUtxo struct {
+	OutPoint      wire.OutPoint
+	Value         int64 //satoshi
+	Height        int32
+	Confirmations int32
+	Bits          uint32 //of the block
+	IsChange      bool   //paid back to the address by a tx spending it
+}
+
+type indexedTx struct {
+	BlockHash   string `json:"blockHash"`
+	Height      int32  `json:"height"`
+	Index       uint32 `json:"index"`
+	Bits        uint32 `json:"bits,omitempty"`
+	Timestamp   int64  `json:"timestamp"`
+	InputValue  int64  `json:"inputValue"`
+	KnownInputs int    `json:"knownInputs"` //inputs of watched addresses, the fee is known if all are
+	Raw         []byte `json:"raw"`
+}
+
+type undoUtxo struct {
+	Address  string `json:"address"`
+	OutPoint []byte `json:"outPoint"`
+	Value    []byte `json:"value,omitempty"` //only for spent utxos
+}
+
+//blockUndo is what a block changed in the index, to roll it back on reorg
+type blockUndo struct {
+	Spent   []undoUtxo `json:"spent"`
+	Created []undoUtxo `json:"created"`
+	Txs     [][]byte   `json:"txs"`
+	History [][]byte   `json:"history"`
+}
+
+//Indexer follows the chain from StartHeight by rpc nodes, and stores the utxos and the history
+//of watched addresses in a local bbolt db. Blocks are rolled back on reorg by the undo data of
+//the last maxReorgDepth blocks, the index is rebuilt if a reorg is deeper. Addresses watched
+//after blocks are indexed are backfilled from StartHeight by Sync or Backfill, the others are not touched.
+type Indexer struct {
+	cfg   IndexerConfig
+	db    *bolt.DB
+	nodes *NodeSet
+
+	syncMtx sync.Mutex //one Sync at a time
+
+	mtx     sync.RWMutex
+	scripts map[string]string //pkScript -> address, of the backfilled addresses
+	synced  bool              //the last Sync reached the tip of the nodes
+	lastErr error
+}
+
+//NewIndexer open or create the index db, blocks are fetched by nodes, nodes is nil for offline import
+func NewIndexer(cfg IndexerConfig, nodes *NodeSet) (*Indexer, error) {
+	if cfg.Params == nil {
+		cfg.Params = &chaincfg.MainNetParams
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = defaultIndexerPollInterval
+	}
+	cfg.Finality = cfg.Finality.withDefault(cfg.Params)
+	db, err := bolt.Open(cfg.DBPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open index db failed : %s", err.Error())
+	}
+	idx := &Indexer{cfg: cfg, db: db, nodes: nodes, scripts: map[string]string{}}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append([][]byte{bucketMeta, bucketWatched, bucketBackfill}, dataBuckets...) {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		backfill := tx.Bucket(bucketBackfill)
+		return tx.Bucket(bucketWatched).ForEach(func(addr, pkScript []byte) error {
+			if backfill.Get(addr) == nil {
+				idx.scripts[string(pkScript)] = string(addr)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init index db failed : %s", err.Error())
+	}
+	return idx, nil
+}
+
+//Close close the db
+func (idx *Indexer) Close() error {
+	return idx.db.Close()
+}
+
+func heightKey(height int32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(height))
+	return key
+}
+
+func outPointKey(op *wire.OutPoint) []byte {
+	key := make([]byte, chainhash.HashSize+4)
+	copy(key, op.Hash[:])
+	binary.BigEndian.PutUint32(key[chainhash.HashSize:], op.Index)
+	return key
+}
+
+func addrPrefix(addr string) []byte {
+	return append([]byte(addr), 0)
+}
+
+func utxoKey(addr string, opKey []byte) []byte {
+	return append(addrPrefix(addr), opKey...)
+}
+
+func historyKey(addr string, height int32, index uint32) []byte {
+	key := append(addrPrefix(addr), heightKey(height)...)
+	return append(key, heightKey(int32(index))...)
+}
+
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+//getTip return the last indexed block, ok is false if no block is indexed
+func getTip(tx *bolt.Tx) (height int32, hash chainhash.Hash, ok bool) {
+	value := tx.Bucket(bucketMeta).Get(keyTip)
+	if len(value) != 4+chainhash.HashSize {
+		return 0, hash, false
+	}
+	copy(hash[:], value[4:])
+	return int32(binary.BigEndian.Uint32(value)), hash, true
+}
+
+func putTip(tx *bolt.Tx, height int32, hash *chainhash.Hash) error {
+	return tx.Bucket(bucketMeta).Put(keyTip, append(heightKey(height), hash[:]...))
+}
+
+//resetIndex remove all indexed data, the index is rebuilt from StartHeight on next Sync for all watched addresses
+func (idx *Indexer) resetIndex(tx *bolt.Tx) error {
+	for _, name := range append(dataBuckets, bucketBackfill) {
+		if err := tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if _, err := tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+	err := tx.Bucket(bucketWatched).ForEach(func(addr, pkScript []byte) error {
+		idx.scripts[string(pkScript)] = string(addr)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucketMeta).Delete(keyTip)
+}
+
+//WatchAddress add addresses to index, new addresses are backfilled from StartHeight by next Sync or Backfill,
+//they are not served until then
+func (idx *Indexer) WatchAddress(addrs ...string) error {
+	pkScripts := map[string][]byte{}
+	for _, addrStr := range addrs {
+		addr, err := btcutil.DecodeAddress(addrStr, idx.cfg.Params)
+		if err != nil {
+			return kindError(ErrKindBadAddress, "DecodeAddress "+addrStr+" failed", err)
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return fmt.Errorf("PayToAddrScript %s failed : %s", addrStr, err.Error())
+		}
+		pkScripts[addrStr] = pkScript
+	}
+
+	//the tip is read in the db transaction, so the addresses are either indexed by the next block or backfilled
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		watched := tx.Bucket(bucketWatched)
+		_, _, indexed := getTip(tx)
+		for addr, pkScript := range pkScripts {
+			if watched.Get([]byte(addr)) != nil {
+				continue
+			}
+			if err := watched.Put([]byte(addr), pkScript); err != nil {
+				return err
+			}
+			if !indexed {
+				idx.mtx.Lock()
+				idx.scripts[string(pkScript)] = addr
+				idx.mtx.Unlock()
+				continue
+			}
+			if err := tx.Bucket(bucketBackfill).Put([]byte(addr), heightKey(idx.cfg.StartHeight)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//Watched return whether addr is indexed
+func (idx *Indexer) Watched(addr string) bool {
+	var watched bool
+	idx.db.View(func(tx *bolt.Tx) error {
+		watched = tx.Bucket(bucketWatched).Get([]byte(addr)) != nil
+		return nil
+	})
+	return watched
+}
+
+//Ready return whether addr is served by the index: it is watched and backfilled,
+//and the last Sync reached the tip of the nodes
+func (idx *Indexer) Ready(addr string) bool {
+	idx.mtx.RLock()
+	synced := idx.synced
+	idx.mtx.RUnlock()
+	if !synced {
+		return false
+	}
+	var ready bool
+	idx.db.View(func(tx *bolt.Tx) error {
+		ready = checkWatched(tx, addr) == nil
+		return nil
+	})
+	return ready
+}
+
+//BestHeight return the height of the last indexed block, StartHeight-1 if none
+func (idx *Indexer) BestHeight() int32 {
+	height := idx.cfg.StartHeight - 1
+	idx.db.View(func(tx *bolt.Tx) error {
+		if tipHeight, _, ok := getTip(tx); ok {
+			height = tipHeight
+		}
+		return nil
+	})
+	return height
+}
+
+//LastError return the error of the last Sync in Run, nil if it succeeded
+func (idx *Indexer) LastError() error {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+	return idx.lastErr
+}
+
+//Run Sync every PollInterval until ctx is done, errors are kept by LastError and retried on next poll
+func (idx *Indexer) Run(ctx context.Context) error {
+	for {
+		err := idx.SyncCtx(ctx)
+		idx.mtx.Lock()
+		idx.lastErr = err
+		idx.mtx.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(idx.cfg.PollInterval):
+		}
+	}
+}
+
+//Sync index the blocks to the tip of the nodes
+func (idx *Indexer) Sync() error {
+	return idx.SyncCtx(context.Background())
+}
+
+//SyncCtx is Sync which stop between blocks when ctx is done
+func (idx *Indexer) SyncCtx(ctx context.Context) error {
+	if idx.nodes == nil {
+		return ErrNoNode
+	}
+	idx.syncMtx.Lock()
+	defer idx.syncMtx.Unlock()
+	err := idx.sync(ctx)
+	idx.mtx.Lock()
+	idx.synced = err == nil
+	idx.mtx.Unlock()
+	return err
+}
+
+//sync backfill the new addresses, then index the blocks to the tip of the nodes
+func (idx *Indexer) sync(ctx context.Context) error {
+	getBlock := func(hash *chainhash.Hash) (*wire.MsgBlock, error) {
+		return idx.getBlockByHash(ctx, hash)
+	}
+	if err := idx.backfill(ctx, getBlock); err != nil {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result, err := idx.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+			return client.GetBlockCount()
+		})
+		if err != nil {
+			return wrapError("GetBlockCount failed", err)
+		}
+		best := int32(result.(int64))
+
+		var height int32
+		var tipHash chainhash.Hash
+		var ok bool
+		idx.db.View(func(tx *bolt.Tx) error {
+			height, tipHash, ok = getTip(tx)
+			return nil
+		})
+		if !ok {
+			height = idx.cfg.StartHeight - 1
+		}
+
+		if height >= best {
+			if !ok {
+				return nil
+			}
+			//nothing to index, the tip must be still in the main chain
+			hash, err := idx.getBlockHash(ctx, best)
+			if err != nil {
+				return err
+			}
+			if height == best && hash.IsEqual(&tipHash) {
+				return nil
+			}
+			if err := idx.disconnectTip(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		block, err := idx.getBlock(ctx, height+1)
+		if err != nil {
+			return err
+		}
+		if ok && !block.Header.PrevBlock.IsEqual(&tipHash) { //the tip is orphaned
+			if err := idx.disconnectTip(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := idx.connectBlock(block, height+1); err != nil {
+			return err
+		}
+	}
+}
+
+//ImportBlock index block offline, it must be the next block of the indexed tip.
+//The addresses watched after blocks are indexed are not served until Backfill.
+func (idx *Indexer) ImportBlock(block *wire.MsgBlock, height int32) error {
+	idx.syncMtx.Lock()
+	defer idx.syncMtx.Unlock()
+	var tipHeight int32
+	var tipHash chainhash.Hash
+	var ok bool
+	idx.db.View(func(tx *bolt.Tx) error {
+		tipHeight, tipHash, ok = getTip(tx)
+		return nil
+	})
+	if !ok {
+		tipHeight = idx.cfg.StartHeight - 1
+	}
+	if height != tipHeight+1 {
+		return fmt.Errorf("import block at %d failed : the index tip is %d", height, tipHeight)
+	}
+	if ok && !block.Header.PrevBlock.IsEqual(&tipHash) {
+		return fmt.Errorf("import block at %d failed : previous block %s is not the index tip %s",
+			height, block.Header.PrevBlock, tipHash)
+	}
+	return idx.connectBlock(block, height)
+}
+
+//blockHash return the hash of the indexed block at height
+func (idx *Indexer) blockHash(height int32) (*chainhash.Hash, error) {
+	var hash *chainhash.Hash
+	idx.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(bucketBlocks).Get(heightKey(height)); value != nil {
+			hash = new(chainhash.Hash)
+			copy(hash[:], value)
+		}
+		return nil
+	})
+	if hash == nil {
+		return nil, fmt.Errorf("block at %d is not indexed", height)
+	}
+	return hash, nil
+}
+
+func (idx *Indexer) getBlockHash(ctx context.Context, height int32) (*chainhash.Hash, error) {
+	result, err := idx.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return client.GetBlockHash(int64(height))
+	})
+	if err != nil {
+		return nil, wrapError(fmt.Sprintf("GetBlockHash %d failed", height), err)
+	}
+	return result.(*chainhash.Hash), nil
+}
+
+func (idx *Indexer) getBlock(ctx context.Context, height int32) (*wire.MsgBlock, error) {
+	hash, err := idx.getBlockHash(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	return idx.getBlockByHash(ctx, hash)
+}
+
+func (idx *Indexer) getBlockByHash(ctx context.Context, hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	result, err := idx.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return client.GetBlock(hash)
+	})
+	if err != nil {
+		return nil, wrapError("GetBlock "+hash.String()+" failed", err)
+	}
+	return result.(*wire.MsgBlock), nil
+}
+
+//connectBlock index the txs of block in one db transaction
+func (idx *Indexer) connectBlock(block *wire.MsgBlock, height int32) error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		//the scripts are copied in the db transaction, WatchAddress changes them in its own
+		idx.mtx.RLock()
+		scripts := make(map[string]string, len(idx.scripts))
+		for pkScript, addr := range idx.scripts {
+			scripts[pkScript] = addr
+		}
+		idx.mtx.RUnlock()
+		var undo blockUndo
+		if err := indexBlock(tx, block, height, scripts, &undo); err != nil {
+			return err
+		}
+		data, err := json.Marshal(&undo)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketUndo).Put(heightKey(height), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketUndo).Delete(heightKey(height - maxReorgDepth)); err != nil {
+			return err
+		}
+		blockHash := block.BlockHash()
+		if err := tx.Bucket(bucketBlocks).Put(heightKey(height), blockHash[:]); err != nil {
+			return err
+		}
+		return putTip(tx, height, &blockHash)
+	})
+}
+
+//indexBlock index the txs of block paying to or spending from the addresses of scripts, the changes are appended to undo.
+//The utxos of each address are only touched by its own scripts, so addresses can be indexed block by block separately.
+func indexBlock(tx *bolt.Tx, block *wire.MsgBlock, height int32, scripts map[string]string, undo *blockUndo) error {
+	utxos := tx.Bucket(bucketUtxos)
+	outpoints := tx.Bucket(bucketOutpoints)
+	txs := tx.Bucket(bucketTxs)
+	history := tx.Bucket(bucketHistory)
+
+	addrs := map[string]bool{}
+	for _, addr := range scripts {
+		addrs[addr] = true
+	}
+	blockHash := block.BlockHash()
+	for i, msgTx := range block.Transactions {
+		txHash := msgTx.TxHash()
+		itx := indexedTx{BlockHash: blockHash.String(), Height: height, Index: uint32(i), Bits: block.Header.Bits,
+			Timestamp: block.Header.Timestamp.Unix()}
+		related := map[string]bool{}
+		spenders := map[string]bool{} //outputs to the spenders are change
+		for _, in := range msgTx.TxIn {
+			opKey := outPointKey(&in.PreviousOutPoint)
+			addrBytes := outpoints.Get(opKey)
+			if addrBytes == nil || !addrs[string(addrBytes)] {
+				continue
+			}
+			addr := string(addrBytes)
+			key := utxoKey(addr, opKey)
+			value := copyBytes(utxos.Get(key))
+			itx.InputValue += int64(binary.BigEndian.Uint64(value))
+			itx.KnownInputs++
+			related[addr] = true
+			spenders[addr] = true
+			undo.Spent = append(undo.Spent, undoUtxo{Address: addr, OutPoint: opKey, Value: value})
+			if err := utxos.Delete(key); err != nil {
+				return err
+			}
+			if err := outpoints.Delete(opKey); err != nil {
+				return err
+			}
+		}
+		for j, out := range msgTx.TxOut {
+			addr := scripts[string(out.PkScript)]
+			if addr == "" {
+				continue
+			}
+			opKey := outPointKey(wire.NewOutPoint(&txHash, uint32(j)))
+			value := make([]byte, 17)
+			binary.BigEndian.PutUint64(value, uint64(out.Value))
+			binary.BigEndian.PutUint32(value[8:], uint32(height))
+			binary.BigEndian.PutUint32(value[12:], block.Header.Bits)
+			if spenders[addr] {
+				value[16] = 1
+			}
+			related[addr] = true
+			if err := utxos.Put(utxoKey(addr, opKey), value); err != nil {
+				return err
+			}
+			if err := outpoints.Put(opKey, []byte(addr)); err != nil {
+				return err
+			}
+			undo.Created = append(undo.Created, undoUtxo{Address: addr, OutPoint: opKey})
+		}
+		if len(related) == 0 {
+			continue
+		}
+
+		if data := txs.Get(txHash[:]); data != nil { //indexed for other addresses before a backfill
+			var prev indexedTx
+			if err := json.Unmarshal(data, &prev); err != nil {
+				return err
+			}
+			itx.InputValue += prev.InputValue
+			itx.KnownInputs += prev.KnownInputs
+		}
+		itx.Raw = serializeTx(msgTx)
+		data, err := json.Marshal(&itx)
+		if err != nil {
+			return err
+		}
+		if err := txs.Put(txHash[:], data); err != nil {
+			return err
+		}
+		undo.Txs = append(undo.Txs, copyBytes(txHash[:]))
+		for addr := range related {
+			key := historyKey(addr, height, uint32(i))
+			if err := history.Put(key, copyBytes(txHash[:])); err != nil {
+				return err
+			}
+			undo.History = append(undo.History, key)
+		}
+	}
+	return nil
+}
+
+//Backfill index the indexed blocks for the addresses watched after them, the blocks are read by getBlock,
+//like ReadBlock of BlockFiles. It is done by Sync, it is for the index fed by ImportBlock.
+func (idx *Indexer) Backfill(ctx context.Context, getBlock func(hash *chainhash.Hash) (*wire.MsgBlock, error)) error {
+	idx.syncMtx.Lock()
+	defer idx.syncMtx.Unlock()
+	return idx.backfill(ctx, getBlock)
+}
+
+//backfill is Backfill, one block per db transaction.
+//An address is served when its next block is above the tip, the blocks above are indexed for it by Sync.
+func (idx *Indexer) backfill(ctx context.Context, getBlock func(hash *chainhash.Hash) (*wire.MsgBlock, error)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var tipHeight, next int32
+		var indexed bool
+		var blockHash *chainhash.Hash
+		pending := map[string]int32{}
+		idx.db.View(func(tx *bolt.Tx) error {
+			tipHeight, _, indexed = getTip(tx)
+			tx.Bucket(bucketBackfill).ForEach(func(addr, value []byte) error {
+				pending[string(addr)] = int32(binary.BigEndian.Uint32(value))
+				return nil
+			})
+			next = tipHeight + 1
+			for _, height := range pending {
+				if height < next {
+					next = height
+				}
+			}
+			if value := tx.Bucket(bucketBlocks).Get(heightKey(next)); value != nil {
+				blockHash = new(chainhash.Hash)
+				copy(blockHash[:], value)
+			}
+			return nil
+		})
+		if len(pending) == 0 {
+			return nil
+		}
+		if !indexed || next > tipHeight {
+			if err := idx.db.Update(idx.finishBackfill); err != nil {
+				return err
+			}
+			continue
+		}
+		if blockHash == nil {
+			return fmt.Errorf("backfill block at %d failed : it is not indexed", next)
+		}
+		block, err := getBlock(blockHash)
+		if err != nil {
+			return err
+		}
+		if block.BlockHash() != *blockHash {
+			return fmt.Errorf("backfill block at %d failed : got block %s, want %s", next, block.BlockHash(), blockHash)
+		}
+
+		err = idx.db.Update(func(tx *bolt.Tx) error {
+			watched := tx.Bucket(bucketWatched)
+			backfill := tx.Bucket(bucketBackfill)
+			scripts := map[string]string{}
+			for addr, height := range pending {
+				if height != next {
+					continue
+				}
+				scripts[string(watched.Get([]byte(addr)))] = addr
+				if err := backfill.Put([]byte(addr), heightKey(next+1)); err != nil {
+					return err
+				}
+			}
+			undoBucket := tx.Bucket(bucketUndo)
+			var undo blockUndo
+			data := undoBucket.Get(heightKey(next))
+			if data != nil {
+				if err := json.Unmarshal(data, &undo); err != nil {
+					return err
+				}
+			}
+			if err := indexBlock(tx, block, next, scripts, &undo); err != nil {
+				return err
+			}
+			if data == nil { //pruned, the block can not be rolled back
+				return nil
+			}
+			undoData, err := json.Marshal(&undo)
+			if err != nil {
+				return err
+			}
+			return undoBucket.Put(heightKey(next), undoData)
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+//finishBackfill serve the addresses whose next block to backfill is above the tip
+func (idx *Indexer) finishBackfill(tx *bolt.Tx) error {
+	tipHeight, _, indexed := getTip(tx)
+	watched := tx.Bucket(bucketWatched)
+	backfill := tx.Bucket(bucketBackfill)
+	var done [][]byte
+	backfill.ForEach(func(addr, value []byte) error {
+		if !indexed || int32(binary.BigEndian.Uint32(value)) > tipHeight {
+			done = append(done, copyBytes(addr))
+		}
+		return nil
+	})
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+	for _, addr := range done {
+		if err := backfill.Delete(addr); err != nil {
+			return err
+		}
+		idx.scripts[string(watched.Get(addr))] = string(addr)
+	}
+	return nil
+}
+
+//disconnectTip roll back the last indexed block, the index is reset if its undo data is pruned
+func (idx *Indexer) disconnectTip() error {
+	return idx.db.Update(func(tx *bolt.Tx) error {
+		height, _, ok := getTip(tx)
+		if !ok {
+			return nil
+		}
+		data := tx.Bucket(bucketUndo).Get(heightKey(height))
+		if data == nil {
+			return idx.resetIndex(tx)
+		}
+		var undo blockUndo
+		if err := json.Unmarshal(data, &undo); err != nil {
+			return err
+		}
+
+		utxos := tx.Bucket(bucketUtxos)
+		outpoints := tx.Bucket(bucketOutpoints)
+		//restore the spent first, a utxo may be created and spent in the same block
+		for _, spent := range undo.Spent {
+			if err := utxos.Put(utxoKey(spent.Address, spent.OutPoint), spent.Value); err != nil {
+				return err
+			}
+			if err := outpoints.Put(spent.OutPoint, []byte(spent.Address)); err != nil {
+				return err
+			}
+		}
+		for _, created := range undo.Created {
+			if err := utxos.Delete(utxoKey(created.Address, created.OutPoint)); err != nil {
+				return err
+			}
+			if err := outpoints.Delete(created.OutPoint); err != nil {
+				return err
+			}
+		}
+		for _, txid := range undo.Txs {
+			if err := tx.Bucket(bucketTxs).Delete(txid); err != nil {
+				return err
+			}
+		}
+		for _, key := range undo.History {
+			if err := tx.Bucket(bucketHistory).Delete(key); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket(bucketUndo).Delete(heightKey(height)); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketBlocks).Delete(heightKey(height)); err != nil {
+			return err
+		}
+
+		if height-1 < idx.cfg.StartHeight {
+			return tx.Bucket(bucketMeta).Delete(keyTip)
+		}
+		prevHash := tx.Bucket(bucketBlocks).Get(heightKey(height - 1))
+		if prevHash == nil {
+			return idx.resetIndex(tx)
+		}
+		var hash chainhash.Hash
+		copy(hash[:], prevHash)
+		return putTip(tx, height-1, &hash)
+	})
+}
+
+//checkWatched return an error if addr is not indexed or not backfilled
+func checkWatched(tx *bolt.Tx, addr string) error {
+	if tx.Bucket(bucketWatched).Get([]byte(addr)) == nil {
+		return newError(ErrKindInvalidParams, "the address "+addr+" is not watched by the indexer")
+	}
+	if tx.Bucket(bucketBackfill).Get([]byte(addr)) != nil {
+		return newError(ErrKindTransient, "the address "+addr+" is being backfilled by the indexer")
+	}
+	return nil
+}
+
+//ListUnspent return the utxos of addr confirmed at least minConf blocks
+func (idx *Indexer) ListUnspent(addr string, minConf int32) ([]IndexedUtxo, error) {
+	var result []IndexedUtxo
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		if err := checkWatched(tx, addr); err != nil {
+			return err
+		}
+		bestHeight, _, _ := getTip(tx)
+		prefix := addrPrefix(addr)
+		c := tx.Bucket(bucketUtxos).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			opKey := k[len(prefix):]
+			var utxo IndexedUtxo
+			copy(utxo.OutPoint.Hash[:], opKey)
+			utxo.OutPoint.Index = binary.BigEndian.Uint32(opKey[chainhash.HashSize:])
+			utxo.Value = int64(binary.BigEndian.Uint64(v))
+			utxo.Height = int32(binary.BigEndian.Uint32(v[8:]))
+			utxo.Bits = binary.BigEndian.Uint32(v[12:])
+			utxo.IsChange = v[16] == 1
+			utxo.Confirmations = bestHeight - utxo.Height + 1
+			if utxo.Confirmations < minConf {
+				continue
+			}
+			result = append(result, utxo)
+		}
+		return nil
+	})
+	return result, err
+}
+
+//spendable return the utxos of addr which can be spent by the Finality policy
+func (idx *Indexer) spendable(addr string) ([]IndexedUtxo, error) {
+	utxos, err := idx.ListUnspent(addr, 0)
+	if err != nil {
+		return nil, err
+	}
+	result := utxos[:0]
+	for _, utxo := range utxos {
+		if idx.cfg.Finality.CanSpend(int64(utxo.Confirmations), utxo.Value, utxo.Bits, utxo.IsChange) {
+			result = append(result, utxo)
+		}
+	}
+	return result, nil
+}
+
+//unspendMap is spendable in the format of getAllUnspend
+func (idx *Indexer) unspendMap(addr btcutil.Address) (map[wire.OutPoint]btcutil.Amount, error) {
+	utxos, err := idx.spendable(addr.EncodeAddress())
+	if err != nil {
+		return nil, err
+	}
+	outputIndex := map[wire.OutPoint]btcutil.Amount{}
+	for _, utxo := range utxos {
+		outputIndex[utxo.OutPoint] = btcutil.Amount(utxo.Value)
+	}
+	return outputIndex, nil
+}
+
+//GetBalance return the balance of input.Address by indexed utxos, only count utxos can be spent by the Finality policy
+func (idx *Indexer) GetBalance(input *adaptor.GetBalanceInput) (*adaptor.GetBalanceOutput, error) {
+	if input.Address == "" {
+		return nil, newError(ErrKindInvalidParams, "the Address is empty")
+	}
+	utxos, err := idx.spendable(input.Address)
+	if err != nil {
+		return nil, err
+	}
+	var allAmount int64
+	for _, utxo := range utxos {
+		allAmount += utxo.Value
+	}
+
+	var result adaptor.GetBalanceOutput
+	result.Balance.Amount = big.NewInt(allAmount)
+	result.Balance.Asset = ChainOf(idx.cfg.Params).Symbol
+	return &result, nil
+}
+
+//GetTransactions return the indexed txs history of input.FromAddress
+func (idx *Indexer) GetTransactions(input *adaptor.GetAddrTxHistoryInput) (*adaptor.GetAddrTxHistoryOutput, error) {
+	if input.FromAddress == "" {
+		return nil, newError(ErrKindInvalidParams, "the FromAddress is empty")
+	}
+	var output adaptor.GetAddrTxHistoryOutput
+	err := idx.db.View(func(tx *bolt.Tx) error {
+		if err := checkWatched(tx, input.FromAddress); err != nil {
+			return err
+		}
+		bestHeight, _, _ := getTip(tx)
+		txs := tx.Bucket(bucketTxs)
+		prefix := addrPrefix(input.FromAddress)
+		c := tx.Bucket(bucketHistory).Cursor()
+		for k, txid := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, txid = c.Next() {
+			var itx indexedTx
+			if err := json.Unmarshal(txs.Get(txid), &itx); err != nil {
+				return fmt.Errorf("Unmarshal indexed tx failed : %s", err.Error())
+			}
+			var msgTx wire.MsgTx
+			if err := msgTx.Deserialize(bytes.NewReader(itx.Raw)); err != nil {
+				return fmt.Errorf("Deserialize indexed tx failed : %s", err.Error())
+			}
+			blockHash, err := chainhash.NewHashFromStr(itx.BlockHash)
+			if err != nil {
+				return fmt.Errorf("NewHashFromStr indexed block failed : %s", err.Error())
+			}
+			ltx := &lightTx{tx: &msgTx, blockHash: *blockHash, height: itx.Height, index: itx.Index,
+				timestamp: itx.Timestamp, inputValue: itx.InputValue, knownInputs: itx.KnownInputs}
+			simpleTx := simpleTxOf(ltx, input.FromAddress, input.ToAddress, idx.cfg.Params)
+			if "" != input.ToAddress && input.AddressLogicAndOr && simpleTx.ToAddress != input.ToAddress {
+				continue
+			}
+			simpleTx.IsStable = idx.cfg.Finality.IsFinal(int64(bestHeight-itx.Height+1), sumTxOut(&msgTx), itx.Bits)
+			output.Txs = append(output.Txs, simpleTx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !input.Asc { //the index is in chain order
+		for i, j := 0, len(output.Txs)-1; i < j; i, j = i+1, j-1 {
+			output.Txs[i], output.Txs[j] = output.Txs[j], output.Txs[i]
+		}
+	}
+	output.Count = uint32(len(output.Txs))
+	return &output, nil
+}
@@ -0,0 +1,246 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//testRPCChain serve the chain rpcs of blocks by a test node
+type testRPCChain struct {
+	mtx    sync.Mutex
+	blocks []*wire.MsgBlock
+}
+
+func (c *testRPCChain) serve(node *testNode) {
+	node.handle("getblockcount", func(params []json.RawMessage) (interface{}, error) {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		return len(c.blocks) - 1, nil
+	})
+	node.handle("getblockhash", func(params []json.RawMessage) (interface{}, error) {
+		var height int
+		json.Unmarshal(params[0], &height)
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		if height >= len(c.blocks) {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCOutOfRange, "Block number out of range")
+		}
+		return c.blocks[height].BlockHash().String(), nil
+	})
+	node.handle("getbestblockhash", func(params []json.RawMessage) (interface{}, error) {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		return c.blocks[len(c.blocks)-1].BlockHash().String(), nil
+	})
+	node.handle("getblockheader", func(params []json.RawMessage) (interface{}, error) {
+		var hash string
+		json.Unmarshal(params[0], &hash)
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		for height, block := range c.blocks {
+			if block.BlockHash().String() == hash {
+				return btcjson.GetBlockHeaderVerboseResult{Hash: hash, Height: int32(height),
+					Confirmations: int64(len(c.blocks) - height)}, nil
+			}
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCBlockNotFound, "Block not found")
+	})
+	node.handle("getblock", func(params []json.RawMessage) (interface{}, error) {
+		var hash string
+		json.Unmarshal(params[0], &hash)
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+		for _, block := range c.blocks {
+			if block.BlockHash().String() == hash {
+				var buf bytes.Buffer
+				block.Serialize(&buf)
+				return hex.EncodeToString(buf.Bytes()), nil
+			}
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCBlockNotFound, "Block not found")
+	})
+}
+
+//add append a block of txs after the block at height-1, the blocks above are removed
+func (c *testRPCChain) add(height int, tag byte, txs ...*wire.MsgTx) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript: []byte{byte(height), tag}})
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, []byte{txscript.OP_TRUE}))
+	block := wire.NewMsgBlock(&wire.BlockHeader{Timestamp: time.Unix(int64(1500000000+height*600), 0)})
+	if height > 0 {
+		block.Header.PrevBlock = c.blocks[height-1].BlockHash()
+	}
+	block.AddTransaction(coinbase)
+	for _, tx := range txs {
+		block.AddTransaction(tx)
+	}
+	var hashes []chainhash.Hash
+	for _, tx := range block.Transactions {
+		hashes = append(hashes, tx.TxHash())
+	}
+	block.Header.MerkleRoot = calcMerkleRoot(hashes)
+	c.blocks = append(c.blocks[:height], block)
+}
+
+func TestIndexerReorg(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	dir, err := ioutil.TempDir("", "btcindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addrB := "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"
+	pkScript := func(addrStr string) []byte {
+		addr, _ := btcutil.DecodeAddress(addrStr, &chaincfg.TestNet3Params)
+		script, _ := txscript.PayToAddrScript(addr)
+		return script
+	}
+	fund := wire.NewMsgTx(1)
+	fund.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	fund.AddTxOut(wire.NewTxOut(5000000000, pkScript(addrA)))
+	fundHash := fund.TxHash()
+	spend := wire.NewMsgTx(1)
+	spend.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: fundHash}})
+	spend.AddTxOut(wire.NewTxOut(1000000000, pkScript(addrB)))
+	spend.AddTxOut(wire.NewTxOut(3900000000, pkScript(addrA)))
+
+	chain := &testRPCChain{}
+	chain.serve(node)
+	chain.add(0, 0)
+	chain.add(1, 0, fund)
+	chain.add(2, 0, spend)
+	for height := 3; height <= 8; height++ {
+		chain.add(height, 0)
+	}
+
+	abtc, err := NewAdaptorBTCIndexer(NETID_TEST, rpcParams, IndexerConfig{DBPath: filepath.Join(dir, "index.db"), StartHeight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer abtc.Close()
+	if err := abtc.Indexer.WatchAddress(addrA); err != nil {
+		t.Fatal(err)
+	}
+	if err := abtc.Indexer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if height := abtc.Indexer.BestHeight(); height != 8 {
+		t.Fatalf("unexpected best height - got: %v, "+"want: %v", height, 8)
+	}
+	balance, err := abtc.GetBalance(&adaptor.GetBalanceInput{Address: addrA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance.Amount.Int64() != 3900000000 {
+		t.Errorf("unexpected balance - got: %v, "+"want: %v", balance.Balance.Amount, 3900000000)
+	}
+	history, err := abtc.GetAddrTxHistory(&adaptor.GetAddrTxHistoryInput{FromAddress: addrA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Count != 2 {
+		t.Fatalf("unexpected history count - got: %v, "+"want: %v", history.Count, 2)
+	}
+	latest := history.Txs[0]
+	if latest.ToAddress != addrB || latest.Amount.Amount.Int64() != 1000000000 || latest.Fee.Amount.Int64() != 100000000 ||
+		latest.BlockHeight != 2 || !latest.IsStable {
+		t.Errorf("unexpected tx - got: %v %v %v %v %v", latest.ToAddress, latest.Amount.Amount,
+			latest.Fee.Amount, latest.BlockHeight, latest.IsStable)
+	}
+
+	//a new address is backfilled without touching the index of the others
+	if err := abtc.Indexer.WatchAddress(addrB); err != nil {
+		t.Fatal(err)
+	}
+	if height := abtc.Indexer.BestHeight(); height != 8 {
+		t.Fatalf("unexpected best height after watching - got: %v, "+"want: %v", height, 8)
+	}
+	if _, err := abtc.Indexer.ListUnspent(addrB, 0); ErrorKindOf(err) != ErrKindTransient {
+		t.Errorf("unexpected error of address being backfilled - got: %v", err)
+	}
+	if abtc.Indexer.Ready(addrB) || !abtc.Indexer.Ready(addrA) {
+		t.Errorf("unexpected ready - got: %v %v, "+"want: %v %v", abtc.Indexer.Ready(addrB), abtc.Indexer.Ready(addrA), false, true)
+	}
+	if utxos, err := abtc.Indexer.ListUnspent(addrA, 0); err != nil || len(utxos) != 1 {
+		t.Errorf("unexpected utxos while backfilling - got: %v %v", utxos, err)
+	}
+	if err := abtc.Indexer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	utxos, err := abtc.Indexer.ListUnspent(addrB, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint.Hash != spend.TxHash() || utxos[0].Value != 1000000000 || utxos[0].Height != 2 {
+		t.Fatalf("unexpected backfilled utxos - got: %v", utxos)
+	}
+	history, err = abtc.GetAddrTxHistory(&adaptor.GetAddrTxHistoryInput{FromAddress: addrB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Count != 1 || history.Txs[0].Fee.Amount.Int64() != 100000000 {
+		t.Errorf("unexpected backfilled history - got: %v", history.Txs)
+	}
+
+	//blocks from 2 are replaced by a longer branch without the spend
+	for height := 2; height <= 9; height++ {
+		chain.add(height, 1)
+	}
+	if err := abtc.Indexer.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	utxos, err = abtc.Indexer.ListUnspent(addrA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 1 || utxos[0].OutPoint.Hash != fundHash || utxos[0].Value != 5000000000 || utxos[0].Confirmations != 9 {
+		t.Fatalf("unexpected utxos after reorg - got: %v", utxos)
+	}
+	history, err = abtc.Indexer.GetTransactions(&adaptor.GetAddrTxHistoryInput{FromAddress: addrA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Count != 1 {
+		t.Errorf("unexpected history count after reorg - got: %v, "+"want: %v", history.Count, 1)
+	}
+	if utxos, err := abtc.Indexer.ListUnspent(addrB, 0); err != nil || len(utxos) != 0 {
+		t.Errorf("unexpected backfilled utxos after reorg - got: %v %v", utxos, err)
+	}
+
+	//the index is persistent
+	abtc.Indexer.Close()
+	indexer, err := NewIndexer(IndexerConfig{DBPath: filepath.Join(dir, "index.db"), Params: &chaincfg.TestNet3Params, StartHeight: 1},
+		abtc.nodeSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	abtc.Indexer = indexer
+	if !indexer.Watched(addrA) || !indexer.Watched(addrB) || indexer.BestHeight() != 9 {
+		t.Errorf("unexpected reopened index - got: %v %v %v", indexer.Watched(addrA), indexer.Watched(addrB), indexer.BestHeight())
+	}
+	if _, err := indexer.ListUnspent("mp6DHyYNuD28aiE1MQdKuRAdH7ZNydqUBC", 0); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of unwatched address - got: %v", err)
+	}
+}
@@ -50,7 +50,7 @@ func PubKeyToAddress(pubKey []byte, netID int) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return addressPubKey.EncodeAddress(), nil
+	return EncodeAddress(addressPubKey, realNet), nil
 }
 
 func CreateMultiSigAddress(input *adaptor.CreateMultiSigAddressInput, netID int) (*adaptor.CreateMultiSigAddressOutput, error) {
@@ -91,7 +91,7 @@ func CreateMultiSigAddress(input *adaptor.CreateMultiSigAddressInput, netID int)
 	}
 	//result for return
 	var output adaptor.CreateMultiSigAddressOutput
-	output.Address = scriptAddr.String()
+	output.Address = EncodeAddress(scriptAddr, realNet)
 	output.Extra = pkScript
 
 	return &output, nil
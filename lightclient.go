@@ -0,0 +1,806 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//max items per request, BIP157
+const (
+	maxCFHeadersPerQuery = 2000
+	maxCFiltersPerQuery  = 1000
+)
+
+const defaultLightClientTimeout = 30 * time.Second
+
+//LightClientConfig is the config of LightClient
+type LightClientConfig struct {
+	PeerAddr    string           //host:port of a full node serving BIP157 filters
+	Params      *chaincfg.Params //network of the peer
+	StartHeight uint32           //height to begin filter scanning, the birthday of watched addresses
+	Timeout     time.Duration    //read/write timeout of the peer connection
+	Finality    FinalityPolicy   //when utxos can be spent and txs are stable, zero value means the default of Params
+}
+
+type lightUtxo struct {
+	address string
+	value    int64
+	height   int32
+	isChange bool //paid back to the address by a tx spending it
+}
+
+type lightTx struct {
+	tx          *wire.MsgTx
+	blockHash   chainhash.Hash
+	height      int32
+	index       uint32
+	timestamp   int64
+	inputValue  int64 //sum of the inputs we know
+	knownInputs int   //the fee can be computed if all inputs are known
+}
+
+//pendingAddress is an address watched after the filters were scanned, it is scanned to the others on next Sync
+type pendingAddress struct {
+	pkScript []byte
+	scanned  int32 //the last height whose filter was scanned for it
+}
+
+//LightClient is a BIP157/158 client, which downloads and verifies block headers and
+//filter headers, matches the watched scripts against filters, and fetches only matching blocks.
+//Headers are verified by a HeaderChain, a reorg is only followed to a valid branch of more work.
+type LightClient struct {
+	cfg  LightClientConfig
+	conn net.Conn
+
+	mtx           sync.Mutex
+	chain         *HeaderChain
+	chainErr      error //HeaderChain can not verify the headers of Params, Sync fails
+	filterHeaders []chainhash.Hash
+	scanned       int32 //the last height whose filter was scanned
+
+	watched map[string][]byte //address -> pkScript
+	pending map[string]*pendingAddress
+	utxos   map[wire.OutPoint]*lightUtxo
+	txs     map[chainhash.Hash]*lightTx
+	history map[string][]chainhash.Hash //address -> txids, in chain order
+}
+
+func NewLightClient(cfg LightClientConfig) *LightClient {
+	if cfg.Params == nil {
+		cfg.Params = &chaincfg.MainNetParams
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultLightClientTimeout
+	}
+	cfg.Finality = cfg.Finality.withDefault(cfg.Params)
+	lc := &LightClient{cfg: cfg, watched: map[string][]byte{}, pending: map[string]*pendingAddress{}}
+	lc.chain, lc.chainErr = NewHeaderChain(HeaderChainConfig{Params: cfg.Params})
+	lc.resetScan()
+	return lc
+}
+
+//resetScan remove the scanned data, all addresses are scanned from StartHeight on next Sync
+func (lc *LightClient) resetScan() {
+	lc.scanned = int32(lc.cfg.StartHeight) - 1
+	for addr, pending := range lc.pending {
+		lc.watched[addr] = pending.pkScript
+	}
+	lc.pending = map[string]*pendingAddress{}
+	lc.utxos = map[wire.OutPoint]*lightUtxo{}
+	lc.txs = map[chainhash.Hash]*lightTx{}
+	lc.history = map[string][]chainhash.Hash{}
+}
+
+//WatchAddress add addresses to watch, the scanned filters are scanned again only for the new addresses on next Sync
+func (lc *LightClient) WatchAddress(addrs ...string) error {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	for _, addrStr := range addrs {
+		if _, exist := lc.watched[addrStr]; exist {
+			continue
+		}
+		if _, exist := lc.pending[addrStr]; exist {
+			continue
+		}
+		addr, err := btcutil.DecodeAddress(addrStr, lc.cfg.Params)
+		if err != nil {
+			return kindError(ErrKindBadAddress, "DecodeAddress "+addrStr+" failed", err)
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return fmt.Errorf("PayToAddrScript %s failed : %s", addrStr, err.Error())
+		}
+		if lc.scanned < int32(lc.cfg.StartHeight) {
+			lc.watched[addrStr] = pkScript
+		} else {
+			lc.pending[addrStr] = &pendingAddress{pkScript: pkScript, scanned: int32(lc.cfg.StartHeight) - 1}
+		}
+	}
+	return nil
+}
+
+//BestHeight return the height of the verified header chain
+func (lc *LightClient) BestHeight() int32 {
+	if lc.chain == nil {
+		return 0
+	}
+	_, height := lc.chain.Tip()
+	return height
+}
+
+//hashOf return the hash of the verified header at height
+func (lc *LightClient) hashOf(height int) chainhash.Hash {
+	hash, _ := lc.chain.Hash(int32(height))
+	return hash
+}
+
+//Close disconnect from the peer
+func (lc *LightClient) Close() {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	lc.disconnect()
+}
+
+func (lc *LightClient) disconnect() {
+	if lc.conn != nil {
+		lc.conn.Close()
+		lc.conn = nil
+	}
+}
+
+//Sync connect to the peer if need, download headers, filter headers, and scan filters to the tip
+func (lc *LightClient) Sync() error {
+	return lc.SyncCtx(context.Background())
+}
+
+//SyncCtx is Sync which is interrupted by closing the connection when ctx is done
+func (lc *LightClient) SyncCtx(ctx context.Context) error {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+
+	if lc.chainErr != nil {
+		return lc.chainErr
+	}
+	if lc.conn == nil {
+		if err := lc.connect(ctx); err != nil {
+			return err
+		}
+	}
+	conn := lc.conn
+	stop := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	err := lc.syncHeaders()
+	if err == nil {
+		err = lc.syncFilterHeaders()
+	}
+	if err == nil {
+		err = lc.scanFilters()
+	}
+	close(stop)
+	<-exited
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		lc.disconnect() //the connection may be closed
+		return ctxErr
+	}
+	if err != nil {
+		lc.disconnect() //reconnect next time
+		return err
+	}
+	return nil
+}
+
+func (lc *LightClient) writeMsg(msg wire.Message) error {
+	lc.conn.SetWriteDeadline(time.Now().Add(lc.cfg.Timeout))
+	return wire.WriteMessage(lc.conn, msg, wire.ProtocolVersion, lc.cfg.Params.Net)
+}
+
+//readMsg read until a message accepted by match, answers pings and skips others
+func (lc *LightClient) readMsg(match func(wire.Message) bool) (wire.Message, error) {
+	for {
+		lc.conn.SetReadDeadline(time.Now().Add(lc.cfg.Timeout))
+		msg, _, err := wire.ReadMessage(lc.conn, wire.ProtocolVersion, lc.cfg.Params.Net)
+		if err != nil {
+			if _, ok := err.(*wire.MessageError); ok { //unknown command, skip it
+				continue
+			}
+			return nil, err
+		}
+		switch m := msg.(type) {
+		case *wire.MsgPing:
+			if err := lc.writeMsg(wire.NewMsgPong(m.Nonce)); err != nil {
+				return nil, err
+			}
+			continue
+		case *wire.MsgReject:
+			return nil, fmt.Errorf("peer reject %s : %s", m.Cmd, m.Reason)
+		}
+		if match(msg) {
+			return msg, nil
+		}
+	}
+}
+
+func (lc *LightClient) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: lc.cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", lc.cfg.PeerAddr)
+	if err != nil {
+		return wrapError("Dial peer failed", err)
+	}
+	lc.conn = conn
+
+	me := wire.NewNetAddress(&net.TCPAddr{IP: net.IPv4zero}, 0)
+	you := wire.NewNetAddress(&net.TCPAddr{IP: net.IPv4zero}, wire.SFNodeNetwork)
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		you = wire.NewNetAddress(tcpAddr, wire.SFNodeNetwork)
+	}
+	version := wire.NewMsgVersion(me, you, rand.Uint64(), 0)
+	version.ProtocolVersion = int32(wire.ProtocolVersion)
+	version.DisableRelayTx = true
+	if err = lc.writeMsg(version); err != nil {
+		lc.disconnect()
+		return fmt.Errorf("Send version failed : %s", err.Error())
+	}
+
+	msg, err := lc.readMsg(func(m wire.Message) bool { _, ok := m.(*wire.MsgVersion); return ok })
+	if err != nil {
+		lc.disconnect()
+		return fmt.Errorf("Read version failed : %s", err.Error())
+	}
+	peerVersion := msg.(*wire.MsgVersion)
+	if peerVersion.Services&wire.SFNodeCF != wire.SFNodeCF {
+		lc.disconnect()
+		return errors.New("the peer not support compact filters")
+	}
+	if err = lc.writeMsg(wire.NewMsgVerAck()); err != nil {
+		lc.disconnect()
+		return fmt.Errorf("Send verack failed : %s", err.Error())
+	}
+	_, err = lc.readMsg(func(m wire.Message) bool { _, ok := m.(*wire.MsgVerAck); return ok })
+	if err != nil {
+		lc.disconnect()
+		return fmt.Errorf("Read verack failed : %s", err.Error())
+	}
+	return nil
+}
+
+//blockLocator return the locator of current header chain
+func (lc *LightClient) blockLocator() []*chainhash.Hash {
+	var locator []*chainhash.Hash
+	step := 1
+	for height := int(lc.BestHeight()); height > 0; height -= step {
+		hash := lc.hashOf(height)
+		locator = append(locator, &hash)
+		if len(locator) > 10 {
+			step *= 2
+		}
+	}
+	genesis := lc.hashOf(0)
+	return append(locator, &genesis)
+}
+
+//compactToBig convert the compact representation of target to a big integer
+func compactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+	return bn
+}
+
+//hashToBig convert a hash to a big integer, hash is little-endian
+func hashToBig(hash *chainhash.Hash) *big.Int {
+	buf := *hash
+	for i := 0; i < chainhash.HashSize/2; i++ {
+		buf[i], buf[chainhash.HashSize-1-i] = buf[chainhash.HashSize-1-i], buf[i]
+	}
+	return new(big.Int).SetBytes(buf[:])
+}
+
+//checkProofOfWork check the header hash is not above the target of bits
+func checkProofOfWork(header *wire.BlockHeader, powLimit *big.Int) error {
+	target := compactToBig(header.Bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("block target difficulty of %064x is too low", target)
+	}
+	if target.Cmp(powLimit) > 0 {
+		return fmt.Errorf("block target difficulty of %064x is higher than max of %064x", target, powLimit)
+	}
+	hash := header.BlockHash()
+	if hashToBig(&hash).Cmp(target) > 0 {
+		return fmt.Errorf("block hash of %064x is higher than expected max of %064x", hashToBig(&hash), target)
+	}
+	return nil
+}
+
+func (lc *LightClient) syncHeaders() error {
+	for {
+		getHeaders := wire.NewMsgGetHeaders()
+		for _, hash := range lc.blockLocator() {
+			getHeaders.AddBlockLocatorHash(hash)
+		}
+		if err := lc.writeMsg(getHeaders); err != nil {
+			return fmt.Errorf("Send getheaders failed : %s", err.Error())
+		}
+		msg, err := lc.readMsg(func(m wire.Message) bool { _, ok := m.(*wire.MsgHeaders); return ok })
+		if err != nil {
+			return fmt.Errorf("Read headers failed : %s", err.Error())
+		}
+		msgHeaders := msg.(*wire.MsgHeaders).Headers
+		if len(msgHeaders) == 0 {
+			return nil
+		}
+
+		//the headers are verified before connected, a fork replaces the chain only if it has more work
+		headers := make([]wire.BlockHeader, len(msgHeaders))
+		for i, header := range msgHeaders {
+			headers[i] = *header
+		}
+		tip, _ := lc.chain.Tip()
+		if err := lc.chain.Connect(headers...); err != nil {
+			if Cause(err) == ErrLessWork { //a competing branch, keep the current chain
+				return nil
+			}
+			return fmt.Errorf("Connect headers failed : %s", err.Error())
+		}
+		if lc.chain.HeightOf(tip) < 0 { //reorganized, the blocks above the fork are replaced
+			lc.rollback(lc.chain.HeightOf(headers[0].PrevBlock))
+		}
+		if len(msgHeaders) < wire.MaxBlockHeadersPerMsg {
+			return nil
+		}
+	}
+}
+
+//rollback remove the filter headers above height, the scanned data above height are invalid
+func (lc *LightClient) rollback(height int32) {
+	if len(lc.filterHeaders) > int(height)+1 {
+		lc.filterHeaders = lc.filterHeaders[:height+1]
+	}
+	rescan := lc.scanned > height
+	for _, pending := range lc.pending {
+		rescan = rescan || pending.scanned > height
+	}
+	if rescan {
+		lc.resetScan()
+	}
+}
+
+func (lc *LightClient) syncFilterHeaders() error {
+	bestHeight := int(lc.BestHeight())
+	for len(lc.filterHeaders) <= bestHeight {
+		startHeight := len(lc.filterHeaders)
+		stopHeight := startHeight + maxCFHeadersPerQuery - 1
+		if stopHeight > bestHeight {
+			stopHeight = bestHeight
+		}
+		stopHash := lc.hashOf(stopHeight)
+		if err := lc.writeMsg(wire.NewMsgGetCFHeaders(wire.GCSFilterRegular, uint32(startHeight), &stopHash)); err != nil {
+			return fmt.Errorf("Send getcfheaders failed : %s", err.Error())
+		}
+		msg, err := lc.readMsg(func(m wire.Message) bool {
+			cfh, ok := m.(*wire.MsgCFHeaders)
+			return ok && cfh.StopHash == stopHash
+		})
+		if err != nil {
+			return fmt.Errorf("Read cfheaders failed : %s", err.Error())
+		}
+		cfheaders := msg.(*wire.MsgCFHeaders)
+		if len(cfheaders.FilterHashes) != stopHeight-startHeight+1 {
+			return fmt.Errorf("cfheaders count %d invalid, want %d", len(cfheaders.FilterHashes), stopHeight-startHeight+1)
+		}
+		prevHeader := chainhash.Hash{}
+		if startHeight > 0 {
+			prevHeader = lc.filterHeaders[startHeight-1]
+		}
+		if cfheaders.PrevFilterHeader != prevHeader {
+			return fmt.Errorf("cfheaders prev header %s not match %s", cfheaders.PrevFilterHeader.String(), prevHeader.String())
+		}
+		for _, filterHash := range cfheaders.FilterHashes {
+			prevHeader = CalcFilterHeader(filterHash, &prevHeader)
+			lc.filterHeaders = append(lc.filterHeaders, prevHeader)
+		}
+	}
+	return nil
+}
+
+//scanFilters scan the filters of the pending addresses to the scanned height first, then of all to the tip
+func (lc *LightClient) scanFilters() error {
+	for len(lc.pending) > 0 {
+		//the pending addresses of the lowest progress are scanned together
+		scanned := lc.scanned
+		for _, pending := range lc.pending {
+			if pending.scanned < scanned {
+				scanned = pending.scanned
+			}
+		}
+		addrs := map[string][]byte{}
+		for addr, pending := range lc.pending {
+			if pending.scanned == scanned {
+				addrs[addr] = pending.pkScript
+			}
+		}
+		err := lc.scanRange(addrs, &scanned, lc.scanned)
+		for addr := range addrs {
+			lc.pending[addr].scanned = scanned
+		}
+		if err != nil {
+			return err
+		}
+		for addr, pkScript := range addrs {
+			delete(lc.pending, addr)
+			lc.watched[addr] = pkScript
+		}
+	}
+	return lc.scanRange(lc.watched, &lc.scanned, lc.BestHeight())
+}
+
+//scanRange match the scripts of addrs against the filters above scanned to stopAt, and process the matched blocks.
+//scanned follows the processed blocks, so the blocks are not processed twice if it fails.
+func (lc *LightClient) scanRange(addrs map[string][]byte, scanned *int32, stopAt int32) error {
+	if len(addrs) == 0 {
+		*scanned = stopAt
+		return nil
+	}
+	scripts := make([][]byte, 0, len(addrs))
+	for _, pkScript := range addrs {
+		scripts = append(scripts, pkScript)
+	}
+	for *scanned < stopAt {
+		startHeight := int(*scanned) + 1
+		stopHeight := startHeight + maxCFiltersPerQuery - 1
+		if stopHeight > int(stopAt) {
+			stopHeight = int(stopAt)
+		}
+		stopHash := lc.hashOf(stopHeight)
+		if err := lc.writeMsg(wire.NewMsgGetCFilters(wire.GCSFilterRegular, uint32(startHeight), &stopHash)); err != nil {
+			return fmt.Errorf("Send getcfilters failed : %s", err.Error())
+		}
+
+		//the filters are returned in order, fetch matched blocks after all received
+		var matched []int
+		for height := startHeight; height <= stopHeight; height++ {
+			blockHash := lc.hashOf(height)
+			msg, err := lc.readMsg(func(m wire.Message) bool { _, ok := m.(*wire.MsgCFilter); return ok })
+			if err != nil {
+				return fmt.Errorf("Read cfilter failed : %s", err.Error())
+			}
+			cfilter := msg.(*wire.MsgCFilter)
+			if cfilter.BlockHash != blockHash {
+				return fmt.Errorf("cfilter of %s not expected, want %s", cfilter.BlockHash.String(), blockHash.String())
+			}
+			//verify the filter against the filter header chain
+			filterHash := chainhash.DoubleHashH(cfilter.Data)
+			prevHeader := chainhash.Hash{}
+			if height > 0 {
+				prevHeader = lc.filterHeaders[height-1]
+			}
+			if CalcFilterHeader(&filterHash, &prevHeader) != lc.filterHeaders[height] {
+				return fmt.Errorf("cfilter of %s not match the filter header", blockHash.String())
+			}
+			filter, err := parseGCSFilter(cfilter.Data)
+			if err != nil {
+				return err
+			}
+			isMatch, err := filter.MatchAny(&blockHash, scripts)
+			if err != nil {
+				return fmt.Errorf("MatchAny filter of %s failed : %s", blockHash.String(), err.Error())
+			}
+			if isMatch {
+				matched = append(matched, height)
+			}
+		}
+
+		for _, height := range matched {
+			block, err := lc.getBlock(height)
+			if err != nil {
+				return err
+			}
+			lc.processBlock(block, int32(height), addrs)
+			*scanned = int32(height)
+		}
+		*scanned = int32(stopHeight)
+	}
+	return nil
+}
+
+func (lc *LightClient) getBlock(height int) (*wire.MsgBlock, error) {
+	blockHash := lc.hashOf(height)
+	getData := wire.NewMsgGetData()
+	getData.AddInvVect(wire.NewInvVect(wire.InvTypeWitnessBlock, &blockHash))
+	if err := lc.writeMsg(getData); err != nil {
+		return nil, fmt.Errorf("Send getdata failed : %s", err.Error())
+	}
+	msg, err := lc.readMsg(func(m wire.Message) bool {
+		switch msg := m.(type) {
+		case *wire.MsgBlock:
+			return msg.BlockHash() == blockHash
+		case *wire.MsgNotFound:
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Read block %s failed : %s", blockHash.String(), err.Error())
+	}
+	block, ok := msg.(*wire.MsgBlock)
+	if !ok {
+		return nil, fmt.Errorf("block %s not found", blockHash.String())
+	}
+	//the txs must commit to the verified header
+	var txHashes []chainhash.Hash
+	for _, tx := range block.Transactions {
+		txHashes = append(txHashes, tx.TxHash())
+	}
+	header, _ := lc.chain.Header(int32(height))
+	if calcMerkleRoot(txHashes) != header.MerkleRoot {
+		return nil, fmt.Errorf("block %s merkle root not match", blockHash.String())
+	}
+	return block, nil
+}
+
+//calcMerkleRoot compute the merkle root of txids
+func calcMerkleRoot(hashes []chainhash.Hash) chainhash.Hash {
+	if len(hashes) == 0 {
+		return chainhash.Hash{}
+	}
+	level := append([]chainhash.Hash{}, hashes...)
+	for len(level) > 1 {
+		if len(level)%2 != 0 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]chainhash.Hash, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, merkleParent(&level[i], &level[i+1]))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func addressOfScript(addrs map[string][]byte, pkScript []byte) string {
+	for addr, script := range addrs {
+		if string(script) == string(pkScript) {
+			return addr
+		}
+	}
+	return ""
+}
+
+//processBlock update utxos and history of addrs by the txs of block. The utxos of each address are only
+//touched by its own scripts, so addresses can be scanned separately.
+func (lc *LightClient) processBlock(block *wire.MsgBlock, height int32, addrs map[string][]byte) {
+	blockHash := block.BlockHash()
+	for i, tx := range block.Transactions {
+		txHash := tx.TxHash()
+		ltx := &lightTx{tx: tx, blockHash: blockHash, height: height, index: uint32(i),
+			timestamp: block.Header.Timestamp.Unix()}
+		related := map[string]bool{}
+		spenders := map[string]bool{} //outputs to the spenders are change
+		for _, in := range tx.TxIn {
+			utxo, exist := lc.utxos[in.PreviousOutPoint]
+			if !exist || addrs[utxo.address] == nil {
+				continue
+			}
+			ltx.inputValue += utxo.value
+			ltx.knownInputs++
+			related[utxo.address] = true
+			spenders[utxo.address] = true
+			delete(lc.utxos, in.PreviousOutPoint)
+		}
+		for j, out := range tx.TxOut {
+			addr := addressOfScript(addrs, out.PkScript)
+			if addr == "" {
+				continue
+			}
+			related[addr] = true
+			lc.utxos[wire.OutPoint{Hash: txHash, Index: uint32(j)}] = &lightUtxo{address: addr, value: out.Value, height: height,
+				isChange: spenders[addr]}
+		}
+		if len(related) == 0 {
+			continue
+		}
+		if prev, exist := lc.txs[txHash]; exist { //scanned for other addresses
+			ltx.inputValue += prev.inputValue
+			ltx.knownInputs += prev.knownInputs
+		}
+		lc.txs[txHash] = ltx
+		for addr := range related {
+			lc.history[addr] = append(lc.history[addr], txHash)
+		}
+	}
+}
+
+//prepare watch the address and sync to the tip
+func (lc *LightClient) prepare(ctx context.Context, addr string) error {
+	if err := lc.WatchAddress(addr); err != nil {
+		return err
+	}
+	return lc.SyncCtx(ctx)
+}
+
+//GetBalance return the balance of address by scanned utxos, only count utxos can be spent by the Finality policy
+func (lc *LightClient) GetBalance(input *adaptor.GetBalanceInput) (*adaptor.GetBalanceOutput, error) {
+	return lc.GetBalanceCtx(context.Background(), input)
+}
+
+//GetBalanceCtx is GetBalance which stop syncing when ctx is done
+func (lc *LightClient) GetBalanceCtx(ctx context.Context, input *adaptor.GetBalanceInput) (*adaptor.GetBalanceOutput, error) {
+	if input.Address == "" {
+		return nil, newError(ErrKindInvalidParams, "the Address is empty")
+	}
+	if err := lc.prepare(ctx, input.Address); err != nil {
+		return nil, err
+	}
+
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	bestHeight := lc.BestHeight()
+	var allAmount int64
+	for _, utxo := range lc.utxos {
+		if utxo.address != input.Address {
+			continue
+		}
+		confirmations := int64(bestHeight - utxo.height + 1)
+		header, _ := lc.chain.Header(utxo.height)
+		if !lc.cfg.Finality.CanSpend(confirmations, utxo.value, header.Bits, utxo.isChange) {
+			continue
+		}
+		allAmount += utxo.value
+	}
+
+	var result adaptor.GetBalanceOutput
+	result.Balance.Amount = big.NewInt(allAmount)
+	result.Balance.Asset = ChainOf(lc.cfg.Params).Symbol
+	return &result, nil
+}
+
+//GetTransactions return the txs history of input.FromAddress
+func (lc *LightClient) GetTransactions(input *adaptor.GetAddrTxHistoryInput) (*adaptor.GetAddrTxHistoryOutput, error) {
+	return lc.GetTransactionsCtx(context.Background(), input)
+}
+
+//GetTransactionsCtx is GetTransactions which stop syncing when ctx is done
+func (lc *LightClient) GetTransactionsCtx(ctx context.Context, input *adaptor.GetAddrTxHistoryInput) (*adaptor.GetAddrTxHistoryOutput, error) {
+	if input.FromAddress == "" {
+		return nil, newError(ErrKindInvalidParams, "the FromAddress is empty")
+	}
+	if err := lc.prepare(ctx, input.FromAddress); err != nil {
+		return nil, err
+	}
+
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	bestHeight := lc.BestHeight()
+	var output adaptor.GetAddrTxHistoryOutput
+	for _, txHash := range lc.history[input.FromAddress] {
+		ltx := lc.txs[txHash]
+		tx := simpleTxOf(ltx, input.FromAddress, input.ToAddress, lc.cfg.Params)
+		if "" != input.ToAddress && input.AddressLogicAndOr && tx.ToAddress != input.ToAddress {
+			continue
+		}
+		header, _ := lc.chain.Header(ltx.height)
+		tx.IsStable = lc.cfg.Finality.IsFinal(int64(bestHeight-ltx.height+1), sumTxOut(ltx.tx), header.Bits)
+		output.Txs = append(output.Txs, tx)
+	}
+	if !input.Asc {
+		sort.SliceStable(output.Txs, func(i, j int) bool { return output.Txs[i].BlockHeight > output.Txs[j].BlockHeight })
+	}
+	output.Count = uint32(len(output.Txs))
+	return &output, nil
+}
+
+//simpleTxOf convert a scanned tx to the transfer of fromAddr
+func simpleTxOf(ltx *lightTx, fromAddr, toAddr string, params *chaincfg.Params) *adaptor.SimpleTransferTokenTx {
+	var tx adaptor.SimpleTransferTokenTx
+	change := int64(0)
+	amount := int64(0)
+	amountOther := int64(0)
+	for _, out := range ltx.tx.TxOut {
+		class, addrs, _, _ := txscript.ExtractPkScriptAddrs(out.PkScript, params)
+		if class == txscript.NullDataTy {
+			pushes, err := txscript.PushedData(out.PkScript)
+			if err == nil && len(pushes) > 0 {
+				tx.AttachData = pushes[0]
+			}
+			continue
+		}
+		if len(addrs) == 0 {
+			amountOther += out.Value
+			continue
+		}
+		outAddr := addrs[0].EncodeAddress()
+		switch {
+		case outAddr == fromAddr:
+			change += out.Value
+		case toAddr != "" && outAddr == toAddr:
+			tx.ToAddress = toAddr
+			amount += out.Value
+		case toAddr == "" && (tx.ToAddress == "" || tx.ToAddress == outAddr):
+			tx.ToAddress = outAddr
+			amount += out.Value
+		default:
+			amountOther += out.Value
+		}
+	}
+	fee := int64(0)
+	if ltx.knownInputs == len(ltx.tx.TxIn) && !ltx.tx.TxIn[0].PreviousOutPoint.Hash.IsEqual(&chainhash.Hash{}) {
+		fee = ltx.inputValue - change - amount - amountOther
+	}
+
+	txHash := ltx.tx.TxHash()
+	tx.TxID, _ = hex.DecodeString(txHash.String())
+	tx.TxRawData = serializeTx(ltx.tx)
+	tx.CreatorAddress = fromAddr
+	tx.FromAddress = fromAddr
+	tx.TargetAddress = tx.ToAddress
+	symbol := ChainOf(params).Symbol
+	tx.Amount = adaptor.NewAmountAsset(big.NewInt(amount), symbol)
+	tx.Fee = adaptor.NewAmountAsset(big.NewInt(fee), symbol)
+	tx.IsInBlock = true
+	tx.IsSuccess = true
+	tx.BlockID, _ = hex.DecodeString(ltx.blockHash.String())
+	tx.BlockHeight = uint(ltx.height)
+	tx.TxIndex = uint(ltx.index)
+	tx.Timestamp = uint64(ltx.timestamp)
+	return &tx
+}
+
+func serializeTx(tx *wire.MsgTx) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, tx.SerializeSize()))
+	tx.Serialize(buf)
+	return buf.Bytes()
+}
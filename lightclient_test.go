@@ -0,0 +1,363 @@
+package btcadaptor
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//testChain is a regtest chain served by testPeer, mtx must be held to change it once served
+type testChain struct {
+	mtx     sync.Mutex
+	params  *chaincfg.Params
+	blocks  []*wire.MsgBlock
+	filters [][]byte
+	headers []chainhash.Hash //filter headers
+	fetched int              //the count of blocks served
+}
+
+func newTestChain() *testChain {
+	params := &chaincfg.RegressionNetParams
+	chain := &testChain{params: params}
+	chain.addBlock(params.GenesisBlock, nil)
+	return chain
+}
+
+func (c *testChain) tip() *wire.MsgBlock {
+	return c.blocks[len(c.blocks)-1]
+}
+
+func (c *testChain) addBlock(block *wire.MsgBlock, prevScripts [][]byte) {
+	filter := BuildBasicFilter(block, prevScripts)
+	filterHash := chainhash.DoubleHashH(filter)
+	prevHeader := chainhash.Hash{}
+	if len(c.headers) > 0 {
+		prevHeader = c.headers[len(c.headers)-1]
+	}
+	c.blocks = append(c.blocks, block)
+	c.filters = append(c.filters, filter)
+	c.headers = append(c.headers, CalcFilterHeader(&filterHash, &prevHeader))
+}
+
+//mine a block on the tip with a coinbase paying to coinbaseScript
+func (c *testChain) mine(coinbaseScript []byte, txs []*wire.MsgTx, prevScripts [][]byte) *wire.MsgBlock {
+	height := len(c.blocks)
+	coinbase := wire.NewMsgTx(1)
+	heightScript, _ := txscript.NewScriptBuilder().AddInt64(int64(height)).AddOp(txscript.OP_0).Script()
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, wire.MaxPrevOutIndex), heightScript, nil))
+	coinbase.AddTxOut(wire.NewTxOut(50*btcutil.SatoshiPerBitcoin, coinbaseScript))
+
+	block := wire.NewMsgBlock(&wire.BlockHeader{
+		Version:   1,
+		PrevBlock: c.tip().BlockHash(),
+		Timestamp: c.tip().Header.Timestamp.Add(10 * time.Minute),
+		Bits:      c.params.PowLimitBits,
+	})
+	block.AddTransaction(coinbase)
+	for _, tx := range txs {
+		block.AddTransaction(tx)
+	}
+	var txHashes []chainhash.Hash
+	for _, tx := range block.Transactions {
+		txHashes = append(txHashes, tx.TxHash())
+	}
+	block.Header.MerkleRoot = calcMerkleRoot(txHashes)
+	for checkProofOfWork(&block.Header, c.params.PowLimit) != nil {
+		block.Header.Nonce++
+	}
+	c.addBlock(block, prevScripts)
+	return block
+}
+
+//truncate remove the blocks above height
+func (c *testChain) truncate(height int) {
+	c.blocks = c.blocks[:height+1]
+	c.filters = c.filters[:height+1]
+	c.headers = c.headers[:height+1]
+}
+
+func (c *testChain) heightOf(hash *chainhash.Hash) int {
+	for i, block := range c.blocks {
+		if block.BlockHash() == *hash {
+			return i
+		}
+	}
+	return -1
+}
+
+//testPeer serve the chain by BIP157 messages, it accepts again after the client disconnected
+func testPeer(t *testing.T, chain *testChain) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			servePeer(conn, chain)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func servePeer(conn net.Conn, chain *testChain) {
+	defer conn.Close()
+	btcnet := chain.params.Net
+	write := func(msg wire.Message) {
+		wire.WriteMessage(conn, msg, wire.ProtocolVersion, btcnet)
+	}
+	for {
+		msg, _, err := wire.ReadMessage(conn, wire.ProtocolVersion, btcnet)
+		if err != nil {
+			return
+		}
+		chain.mtx.Lock()
+		switch m := msg.(type) {
+		case *wire.MsgVersion:
+			me := wire.NewNetAddress(&net.TCPAddr{IP: net.IPv4zero}, 0)
+			version := wire.NewMsgVersion(me, me, 1, int32(len(chain.blocks)-1))
+			version.Services = wire.SFNodeNetwork | wire.SFNodeWitness | wire.SFNodeCF
+			write(version)
+			write(wire.NewMsgVerAck())
+		case *wire.MsgGetHeaders:
+			start := 0
+			for _, hash := range m.BlockLocatorHashes {
+				if height := chain.heightOf(hash); height >= 0 {
+					start = height + 1
+					break
+				}
+			}
+			headers := wire.NewMsgHeaders()
+			for i := start; i < len(chain.blocks); i++ {
+				headers.AddBlockHeader(&chain.blocks[i].Header)
+			}
+			write(headers)
+		case *wire.MsgGetCFHeaders:
+			stop := chain.heightOf(&m.StopHash)
+			cfheaders := wire.NewMsgCFHeaders()
+			cfheaders.StopHash = m.StopHash
+			if m.StartHeight > 0 {
+				cfheaders.PrevFilterHeader = chain.headers[m.StartHeight-1]
+			}
+			for i := int(m.StartHeight); i <= stop; i++ {
+				filterHash := chainhash.DoubleHashH(chain.filters[i])
+				cfheaders.AddCFHash(&filterHash)
+			}
+			write(cfheaders)
+		case *wire.MsgGetCFilters:
+			stop := chain.heightOf(&m.StopHash)
+			for i := int(m.StartHeight); i <= stop; i++ {
+				blockHash := chain.blocks[i].BlockHash()
+				write(wire.NewMsgCFilter(wire.GCSFilterRegular, &blockHash, chain.filters[i]))
+			}
+		case *wire.MsgGetData:
+			for _, inv := range m.InvList {
+				if height := chain.heightOf(&inv.Hash); height >= 0 {
+					write(chain.blocks[height])
+					chain.fetched++
+				}
+			}
+		}
+		chain.mtx.Unlock()
+	}
+}
+
+func TestLightClient(t *testing.T) {
+	chain := newTestChain()
+	params := chain.params
+
+	watchAddr := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addr, _ := btcutil.DecodeAddress(watchAddr, params)
+	watchScript, _ := txscript.PayToAddrScript(addr)
+	otherAddr, _ := btcutil.DecodeAddress("mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5", params)
+	otherScript, _ := txscript.PayToAddrScript(otherAddr)
+
+	//block 1 pays 50 BTC to the watched address
+	block1 := chain.mine(watchScript, nil, nil)
+	//block 2 spends it, 10 BTC to other and 39.9999 BTC change
+	spend := wire.NewMsgTx(1)
+	coinbaseHash := block1.Transactions[0].TxHash()
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&coinbaseHash, 0), nil, nil))
+	spend.AddTxOut(wire.NewTxOut(10*btcutil.SatoshiPerBitcoin, otherScript))
+	spend.AddTxOut(wire.NewTxOut(3999990000, watchScript))
+	chain.mine(otherScript, []*wire.MsgTx{spend}, [][]byte{watchScript})
+	for i := 0; i < 6; i++ {
+		chain.mine(otherScript, nil, nil)
+	}
+
+	lc := NewLightClient(LightClientConfig{PeerAddr: testPeer(t, chain), Params: params, Timeout: 5 * time.Second})
+	defer lc.Close()
+
+	balance, err := lc.GetBalance(&adaptor.GetBalanceInput{Address: watchAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Balance.Amount.Int64() != 3999990000 {
+		t.Errorf("unexpected balance - got: %v, "+"want: %v", balance.Balance.Amount, 3999990000)
+	}
+	if lc.BestHeight() != 8 {
+		t.Errorf("unexpected best height - got: %v, "+"want: %v", lc.BestHeight(), 8)
+	}
+
+	history, err := lc.GetTransactions(&adaptor.GetAddrTxHistoryInput{FromAddress: watchAddr, Asc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Count != 2 {
+		t.Fatalf("unexpected history count - got: %v, "+"want: %v", history.Count, 2)
+	}
+	tx := history.Txs[1]
+	if tx.BlockHeight != 2 || tx.TxIndex != 1 || !tx.IsStable {
+		t.Errorf("unexpected tx position - got: height %d index %d stable %v", tx.BlockHeight, tx.TxIndex, tx.IsStable)
+	}
+	if tx.Amount.Amount.Int64() != 10*btcutil.SatoshiPerBitcoin || tx.Fee.Amount.Int64() != 10000 {
+		t.Errorf("unexpected amount and fee - got: %v %v", tx.Amount.Amount, tx.Fee.Amount)
+	}
+}
+
+func TestLightClientWatchAfterSync(t *testing.T) {
+	chain := newTestChain()
+	params := chain.params
+
+	watchAddr := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addr, _ := btcutil.DecodeAddress(watchAddr, params)
+	watchScript, _ := txscript.PayToAddrScript(addr)
+	otherAddr := "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"
+	addr, _ = btcutil.DecodeAddress(otherAddr, params)
+	otherScript, _ := txscript.PayToAddrScript(addr)
+
+	//block 1 pays the watched address, block 2 spends it to other
+	block1 := chain.mine(watchScript, nil, nil)
+	spend := wire.NewMsgTx(1)
+	coinbaseHash := block1.Transactions[0].TxHash()
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&coinbaseHash, 0), nil, nil))
+	spend.AddTxOut(wire.NewTxOut(10*btcutil.SatoshiPerBitcoin, otherScript))
+	spend.AddTxOut(wire.NewTxOut(3999990000, watchScript))
+	chain.mine(otherScript, []*wire.MsgTx{spend}, [][]byte{watchScript})
+	for i := 0; i < 6; i++ {
+		chain.mine(otherScript, nil, nil)
+	}
+
+	lc := NewLightClient(LightClientConfig{PeerAddr: testPeer(t, chain), Params: params, Timeout: 5 * time.Second})
+	defer lc.Close()
+	if _, err := lc.GetTransactions(&adaptor.GetAddrTxHistoryInput{FromAddress: watchAddr}); err != nil {
+		t.Fatal(err)
+	}
+	chain.mtx.Lock()
+	fetched := chain.fetched
+	chain.mtx.Unlock()
+
+	//only the blocks matching the new address are fetched, the watched address is not rescanned
+	history, err := lc.GetTransactions(&adaptor.GetAddrTxHistoryInput{FromAddress: otherAddr, Asc: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.mtx.Lock()
+	fetched = chain.fetched - fetched
+	chain.mtx.Unlock()
+	if fetched != 7 {
+		t.Errorf("unexpected fetched blocks - got: %v, "+"want: %v", fetched, 7)
+	}
+	if history.Count != 8 {
+		t.Fatalf("unexpected history count - got: %v, "+"want: %v", history.Count, 8)
+	}
+	//the input scanned for the watched address is still known
+	tx := history.Txs[1]
+	if tx.BlockHeight != 2 || tx.TxIndex != 1 || tx.Fee.Amount.Int64() != 10000 {
+		t.Errorf("unexpected spend - got: height %d index %d fee %v", tx.BlockHeight, tx.TxIndex, tx.Fee.Amount)
+	}
+
+	history, err = lc.GetTransactions(&adaptor.GetAddrTxHistoryInput{FromAddress: watchAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Count != 2 {
+		t.Errorf("unexpected history count - got: %v, "+"want: %v", history.Count, 2)
+	}
+	if lc.scanned != 8 || len(lc.pending) != 0 || len(lc.watched) != 2 {
+		t.Errorf("unexpected scan state - got: scanned %d pending %d watched %d", lc.scanned, len(lc.pending), len(lc.watched))
+	}
+}
+
+func TestLightClientReorg(t *testing.T) {
+	chain := newTestChain()
+	params := chain.params
+
+	watchAddr := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addr, _ := btcutil.DecodeAddress(watchAddr, params)
+	watchScript, _ := txscript.PayToAddrScript(addr)
+	addr, _ = btcutil.DecodeAddress("mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5", params)
+	otherScript, _ := txscript.PayToAddrScript(addr)
+
+	//block 6 pays the watched address
+	for i := 0; i < 5; i++ {
+		chain.mine(otherScript, nil, nil)
+	}
+	chain.mine(watchScript, nil, nil)
+	tip := chain.tip().BlockHash()
+
+	lc := NewLightClient(LightClientConfig{PeerAddr: testPeer(t, chain), Params: params, Timeout: 5 * time.Second})
+	defer lc.Close()
+	checkHistory := func(wantHeight int32, wantCount uint32) {
+		t.Helper()
+		history, err := lc.GetTransactions(&adaptor.GetAddrTxHistoryInput{FromAddress: watchAddr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lc.BestHeight() != wantHeight {
+			t.Errorf("unexpected best height - got: %v, "+"want: %v", lc.BestHeight(), wantHeight)
+		}
+		if history.Count != wantCount {
+			t.Errorf("unexpected history count - got: %v, "+"want: %v", history.Count, wantCount)
+		}
+	}
+	checkHistory(6, 1)
+
+	//a fork of the same work is not followed
+	chain.mtx.Lock()
+	chain.truncate(5)
+	chain.mine(otherScript, nil, nil)
+	chain.mtx.Unlock()
+	checkHistory(6, 1)
+	if hash, _ := lc.chain.Hash(6); hash != tip {
+		t.Errorf("unexpected tip - got: %v, "+"want: %v", hash, tip)
+	}
+
+	//a longer fork with a header below the median time past is rejected before any rollback
+	chain.mtx.Lock()
+	chain.truncate(5)
+	bad := chain.mine(otherScript, nil, nil)
+	bad.Header.Timestamp = chain.blocks[1].Header.Timestamp
+	for checkProofOfWork(&bad.Header, params.PowLimit) != nil {
+		bad.Header.Nonce++
+	}
+	chain.mine(otherScript, nil, nil)
+	chain.mine(otherScript, nil, nil)
+	chain.mtx.Unlock()
+	if err := lc.Sync(); err == nil {
+		t.Errorf("expected the invalid fork to be rejected")
+	}
+	chain.mtx.Lock()
+	chain.truncate(5)
+	chain.mtx.Unlock()
+	checkHistory(6, 1)
+
+	//a valid fork of more work replaces the block paying the watched address
+	chain.mtx.Lock()
+	chain.mine(otherScript, nil, nil)
+	chain.mine(otherScript, nil, nil)
+	chain.mtx.Unlock()
+	checkHistory(7, 0)
+}
@@ -0,0 +1,313 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+//MerkleProof proves a tx is in a block: the header, the position of the tx,
+//and the hashes of the siblings on the path from the tx to the merkle root, from the leaf up.
+//It converts to and from the partial merkle tree of gettxoutproof (MsgMerkleBlock) of one tx.
+type MerkleProof struct {
+	Header  wire.BlockHeader
+	TxID    chainhash.Hash
+	TxIndex uint32
+	NumTxs  uint32
+	Branch  []chainhash.Hash
+}
+
+//merkleParent return the double sha256 of left and right
+func merkleParent(left, right *chainhash.Hash) chainhash.Hash {
+	var buf [chainhash.HashSize * 2]byte
+	copy(buf[:chainhash.HashSize], left[:])
+	copy(buf[chainhash.HashSize:], right[:])
+	return chainhash.DoubleHashH(buf[:])
+}
+
+//merkleWidth return the number of nodes at height of a tree of numTxs leaves
+func merkleWidth(numTxs uint32, height uint) uint32 {
+	return uint32((uint64(numTxs) + (1 << height) - 1) >> height)
+}
+
+//merkleHeight return the height of the root of a tree of numTxs leaves
+func merkleHeight(numTxs uint32) uint {
+	height := uint(0)
+	for merkleWidth(numTxs, height) > 1 {
+		height++
+	}
+	return height
+}
+
+//NewMerkleProof build the proof of the tx of txID in block
+func NewMerkleProof(block *wire.MsgBlock, txID chainhash.Hash) (*MerkleProof, error) {
+	level := make([]chainhash.Hash, len(block.Transactions))
+	index := -1
+	for i, tx := range block.Transactions {
+		level[i] = tx.TxHash()
+		if level[i] == txID {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, newError(ErrKindNotFound, fmt.Sprintf("tx %s is not in block %s", txID, block.BlockHash()))
+	}
+	return merkleProofOf(block.Header, level, index), nil
+}
+
+//merkleProofOf build the proof of the tx at index of the txids of the block of header
+func merkleProofOf(header wire.BlockHeader, level []chainhash.Hash, index int) *MerkleProof {
+	proof := &MerkleProof{Header: header, TxID: level[index], TxIndex: uint32(index), NumTxs: uint32(len(level))}
+	for pos := index; len(level) > 1; pos >>= 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		proof.Branch = append(proof.Branch, level[pos^1])
+		next := make([]chainhash.Hash, len(level)/2)
+		for i := range next {
+			next[i] = merkleParent(&level[2*i], &level[2*i+1])
+		}
+		level = next
+	}
+	return proof
+}
+
+//Root compute the merkle root by the branch, the duplicated last node (CVE-2012-2459) is rejected
+func (p *MerkleProof) Root() (chainhash.Hash, error) {
+	if p.NumTxs == 0 || p.TxIndex >= p.NumTxs {
+		return chainhash.Hash{}, newError(ErrKindInvalidParams, fmt.Sprintf("tx index %d of %d txs", p.TxIndex, p.NumTxs))
+	}
+	if uint(len(p.Branch)) != merkleHeight(p.NumTxs) {
+		return chainhash.Hash{}, newError(ErrKindInvalidParams,
+			fmt.Sprintf("branch of %d hashes for %d txs", len(p.Branch), p.NumTxs))
+	}
+	hash := p.TxID
+	pos := p.TxIndex
+	for height, sibling := range p.Branch {
+		//the last node of an odd level is paired with itself, other nodes must differ from their siblings
+		last := pos^1 >= merkleWidth(p.NumTxs, uint(height))
+		if last != (sibling == hash) {
+			return chainhash.Hash{}, newError(ErrKindInvalidParams, fmt.Sprintf("invalid sibling at height %d", height))
+		}
+		if pos&1 == 1 {
+			hash = merkleParent(&sibling, &hash)
+		} else {
+			hash = merkleParent(&hash, &sibling)
+		}
+		pos >>= 1
+	}
+	return hash, nil
+}
+
+//Verify check the branch leads to the merkle root of the header
+func (p *MerkleProof) Verify() error {
+	root, err := p.Root()
+	if err != nil {
+		return err
+	}
+	if root != p.Header.MerkleRoot {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("merkle root %s is not %s", root, p.Header.MerkleRoot))
+	}
+	return nil
+}
+
+//MerkleBlock return the partial merkle tree of the proof, as returned by gettxoutproof
+func (p *MerkleProof) MerkleBlock() *wire.MsgMerkleBlock {
+	mb := &wire.MsgMerkleBlock{Header: p.Header, Transactions: p.NumTxs}
+	var bits []bool
+	var traverse func(height uint, pos uint32)
+	traverse = func(height uint, pos uint32) {
+		onPath := pos == p.TxIndex>>height
+		bits = append(bits, onPath)
+		switch {
+		case !onPath: //the sibling of a node on the path
+			hash := p.Branch[height]
+			mb.Hashes = append(mb.Hashes, &hash)
+		case height == 0:
+			hash := p.TxID
+			mb.Hashes = append(mb.Hashes, &hash)
+		default:
+			traverse(height-1, pos*2)
+			if pos*2+1 < merkleWidth(p.NumTxs, height-1) {
+				traverse(height-1, pos*2+1)
+			}
+		}
+	}
+	traverse(merkleHeight(p.NumTxs), 0)
+	mb.Flags = make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			mb.Flags[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return mb
+}
+
+//Bytes serialize the proof in the format of gettxoutproof
+func (p *MerkleProof) Bytes() []byte {
+	var buf bytes.Buffer
+	p.MerkleBlock().BtcEncode(&buf, wire.ProtocolVersion, wire.BaseEncoding)
+	return buf.Bytes()
+}
+
+//ParseMerkleBlock extract the proof of the only matched tx of a partial merkle tree, and verify it
+func ParseMerkleBlock(mb *wire.MsgMerkleBlock) (*MerkleProof, error) {
+	if mb.Transactions == 0 {
+		return nil, newError(ErrKindInvalidParams, "merkle block of no tx")
+	}
+	if uint32(len(mb.Hashes)) > mb.Transactions {
+		return nil, newError(ErrKindInvalidParams, "merkle block of more hashes than txs")
+	}
+	proof := &MerkleProof{Header: mb.Header, NumTxs: mb.Transactions}
+	var bitUsed, hashUsed, matches int
+	var errTree error
+	//traverse return the hash of the node and whether the matched tx is under it,
+	//the siblings of the nodes on the path are appended to the branch from the leaf up
+	var traverse func(height uint, pos uint32) (chainhash.Hash, bool)
+	traverse = func(height uint, pos uint32) (chainhash.Hash, bool) {
+		if errTree != nil {
+			return chainhash.Hash{}, false
+		}
+		if bitUsed >= len(mb.Flags)*8 {
+			errTree = newError(ErrKindInvalidParams, "merkle block runs out of flags")
+			return chainhash.Hash{}, false
+		}
+		parentOfMatch := mb.Flags[bitUsed/8]&(1<<uint(bitUsed%8)) != 0
+		bitUsed++
+		if height == 0 || !parentOfMatch {
+			if hashUsed >= len(mb.Hashes) {
+				errTree = newError(ErrKindInvalidParams, "merkle block runs out of hashes")
+				return chainhash.Hash{}, false
+			}
+			hash := *mb.Hashes[hashUsed]
+			hashUsed++
+			if height == 0 && parentOfMatch {
+				matches++
+				proof.TxID, proof.TxIndex = hash, pos
+			}
+			return hash, height == 0 && parentOfMatch
+		}
+		left, leftMatch := traverse(height-1, pos*2)
+		right, rightMatch := left, false
+		if pos*2+1 < merkleWidth(mb.Transactions, height-1) {
+			right, rightMatch = traverse(height-1, pos*2+1)
+			if right == left {
+				errTree = newError(ErrKindInvalidParams, "merkle block of duplicated nodes")
+			}
+		}
+		if leftMatch {
+			proof.Branch = append(proof.Branch, right)
+		} else if rightMatch {
+			proof.Branch = append(proof.Branch, left)
+		}
+		return merkleParent(&left, &right), leftMatch || rightMatch
+	}
+	root, _ := traverse(merkleHeight(mb.Transactions), 0)
+	if errTree != nil {
+		return nil, errTree
+	}
+	if (bitUsed+7)/8 != len(mb.Flags) || hashUsed != len(mb.Hashes) {
+		return nil, newError(ErrKindInvalidParams, "merkle block of unused flags or hashes")
+	}
+	if matches != 1 {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("merkle block of %d matched txs, want 1", matches))
+	}
+	if root != mb.Header.MerkleRoot {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("merkle root %s is not %s", root, mb.Header.MerkleRoot))
+	}
+	return proof, nil
+}
+
+//ParseTxOutProof parse the result of gettxoutproof of one tx
+func ParseTxOutProof(data []byte) (*MerkleProof, error) {
+	var mb wire.MsgMerkleBlock
+	if err := mb.BtcDecode(bytes.NewReader(data), wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		return nil, kindError(ErrKindInvalidParams, "decode merkle block failed", err)
+	}
+	return ParseMerkleBlock(&mb)
+}
+
+//VerifyTxProof verify proof (gettxoutproof format) proves txID is in the block of txsRoot,
+//txID and txsRoot are as TxBasicInfo.TxID and BlockInfo.TxsRoot
+func VerifyTxProof(proof, txID, txsRoot []byte) error {
+	proofTx, err := chainhash.NewHashFromStr(hex.EncodeToString(txID))
+	if err != nil {
+		return kindError(ErrKindInvalidParams, "NewHashFromStr tx failed", err)
+	}
+	root, err := chainhash.NewHashFromStr(hex.EncodeToString(txsRoot))
+	if err != nil {
+		return kindError(ErrKindInvalidParams, "NewHashFromStr root failed", err)
+	}
+	p, err := ParseTxOutProof(proof)
+	if err != nil {
+		return err
+	}
+	if p.TxID != *proofTx {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("the proof is of tx %s, not %s", p.TxID, proofTx))
+	}
+	if p.Header.MerkleRoot != *root {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("the proof is of merkle root %s, not %s", p.Header.MerkleRoot, root))
+	}
+	return nil
+}
+
+//GetTxMerkleProof return the proof of a mined tx, built from its block
+func GetTxMerkleProof(txID []byte, rpcParams *RPCParams) (*MerkleProof, error) {
+	client, err := GetClient(rpcParams)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Shutdown()
+	return getTxMerkleProofByClient(txID, client)
+}
+
+func getTxMerkleProofByClient(txID []byte, client *rpcclient.Client) (*MerkleProof, error) {
+	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(txID))
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "NewHashFromStr tx failed", err)
+	}
+	txResult, err := client.GetRawTransactionVerbose(hash)
+	if err != nil {
+		return nil, wrapError("GetRawTransactionVerbose tx failed", err)
+	}
+	if txResult.BlockHash == "" {
+		return nil, newError(ErrKindNotFound, "tx "+hash.String()+" is not mined")
+	}
+	blockHash, err := chainhash.NewHashFromStr(txResult.BlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("NewHashFromStr block failed : %s", err.Error())
+	}
+	block, err := client.GetBlock(blockHash)
+	if err != nil {
+		return nil, wrapError("GetBlock failed", err)
+	}
+	proof, err := NewMerkleProof(block, *hash)
+	if err != nil {
+		return nil, err
+	}
+	if err := proof.Verify(); err != nil { //the block is not checked by the client
+		return nil, kindError(ErrKindNode, "the block of the node is invalid", err)
+	}
+	return proof, nil
+}
@@ -0,0 +1,143 @@
+package btcadaptor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+//testMerkleBlock return a block of n txs with its merkle root
+func testMerkleBlock(n int) *wire.MsgBlock {
+	block := wire.NewMsgBlock(&wire.BlockHeader{Timestamp: time.Unix(1500000000, 0)})
+	var level []chainhash.Hash
+	for i := 0; i < n; i++ {
+		tx := wire.NewMsgTx(1)
+		tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Index: uint32(i)}})
+		tx.AddTxOut(wire.NewTxOut(int64(i), nil))
+		block.AddTransaction(tx)
+		level = append(level, tx.TxHash())
+	}
+	for len(level) > 1 {
+		var next []chainhash.Hash
+		for i := 0; i < len(level); i += 2 {
+			right := level[i]
+			if i+1 < len(level) {
+				right = level[i+1]
+			}
+			next = append(next, chainhash.DoubleHashH(append(level[i][:], right[:]...)))
+		}
+		level = next
+	}
+	block.Header.MerkleRoot = level[0]
+	return block
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		block := testMerkleBlock(n)
+		for i, tx := range block.Transactions {
+			proof, err := NewMerkleProof(block, tx.TxHash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := proof.Verify(); err != nil {
+				t.Errorf("unexpected error of tx %d of %d - got: %v", i, n, err)
+			}
+			parsed, err := ParseTxOutProof(proof.Bytes())
+			if err != nil {
+				t.Fatalf("unexpected error of parsing tx %d of %d - got: %v", i, n, err)
+			}
+			if !reflect.DeepEqual(parsed, proof) {
+				t.Errorf("unexpected parsed proof of tx %d of %d - got: %v, "+"want: %v", i, n, parsed, proof)
+			}
+			txHash := tx.TxHash()
+			root := block.Header.MerkleRoot
+			txID, _ := hex.DecodeString(txHash.String())
+			txsRoot, _ := hex.DecodeString(root.String())
+			if err := VerifyTxProof(proof.Bytes(), txID, txsRoot); err != nil {
+				t.Errorf("unexpected error of verifying tx %d of %d - got: %v", i, n, err)
+			}
+			if err := VerifyTxProof(proof.Bytes(), make([]byte, 32), txsRoot); err == nil {
+				t.Errorf("proof of tx %d of %d verified for another tx", i, n)
+			}
+		}
+	}
+
+	//the partial merkle tree is the same as gettxoutproof
+	block := testMerkleBlock(3)
+	proof, _ := NewMerkleProof(block, block.Transactions[2].TxHash())
+	mb := proof.MerkleBlock()
+	hash0, hash1 := block.Transactions[0].TxHash(), block.Transactions[1].TxHash()
+	left := chainhash.DoubleHashH(append(hash0[:], hash1[:]...))
+	if len(mb.Flags) != 1 || mb.Flags[0] != 0x0d || len(mb.Hashes) != 2 || *mb.Hashes[0] != left ||
+		*mb.Hashes[1] != block.Transactions[2].TxHash() || mb.Transactions != 3 {
+		t.Errorf("unexpected merkle block - got: %x %v", mb.Flags, mb.Hashes)
+	}
+
+	//the duplicated last tx of CVE-2012-2459 is rejected
+	forged := *proof
+	forged.NumTxs = 4
+	forged.TxIndex = 3
+	forged.Branch = []chainhash.Hash{forged.TxID, left}
+	if err := forged.Verify(); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of forged proof - got: %v", err)
+	}
+	if _, err := ParseMerkleBlock(forged.MerkleBlock()); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of forged merkle block - got: %v", err)
+	}
+	tampered := *proof
+	tampered.Branch = []chainhash.Hash{proof.Branch[0], {1}}
+	if err := tampered.Verify(); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of tampered proof - got: %v", err)
+	}
+	if _, err := ParseTxOutProof(proof.Bytes()[:len(proof.Bytes())-1]); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of truncated proof - got: %v", err)
+	}
+}
+
+func TestGetTxMerkleProof(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	chain := &testRPCChain{}
+	chain.serve(node)
+	block := testMerkleBlock(5)
+	chain.add(0, 0)
+	chain.add(1, 0, block.Transactions...)
+	mined := chain.blocks[1]
+	txHash := block.Transactions[3].TxHash()
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		for _, tx := range mined.Transactions {
+			if tx.TxHash().String() == txid {
+				return btcjson.TxRawResult{Txid: txid, BlockHash: mined.BlockHash().String(), Confirmations: 1}, nil
+			}
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No information available about transaction")
+	})
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	defer abtc.Close()
+	txID, _ := hex.DecodeString(txHash.String())
+	proof, err := abtc.GetTxMerkleProof(txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.TxIndex != 4 || proof.NumTxs != 6 || proof.Header.BlockHash() != mined.BlockHash() { //after the coinbase
+		t.Errorf("unexpected proof - got: %v %v %v, "+"want: %v %v %v", proof.TxIndex, proof.NumTxs,
+			proof.Header.BlockHash(), 4, 6, mined.BlockHash())
+	}
+	txsRoot, _ := hex.DecodeString(mined.Header.MerkleRoot.String())
+	if err := VerifyTxProof(proof.Bytes(), txID, txsRoot); err != nil {
+		t.Errorf("unexpected error of verifying - got: %v", err)
+	}
+	if _, err := abtc.GetTxMerkleProof(make([]byte, 32)); ErrorKindOf(err) != ErrKindNotFound {
+		t.Errorf("unexpected error of unknown tx - got: %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+const firstCustomNetID = 100 //the first netID of RegisterNet
+
+//DefaultSignetChallenge is the block script of the default signet, a 1-of-2 multisig
+var DefaultSignetChallenge, _ = hex.DecodeString("512103ad5e0edad18cb1f0fc0d28a3d4f1f3e445640337489abb10404f2d1e086be43" +
+	"0210359ef5021964fe22d6f8e05b2463c9540ce96883fe3b278760f048f5189f2e6c452ae")
+
+//SigNetParams is the default signet of BIP325
+var SigNetParams = CustomSignetParams(DefaultSignetChallenge)
+
+//TestNet4Params is testnet4 of BIP94, the rules of BIP94 are followed by HeaderChain
+var TestNet4Params = func() chaincfg.Params {
+	params := chaincfg.TestNet3Params
+	params.Name = "testnet4"
+	params.Net = 0x283f161c
+	params.DefaultPort = "48333"
+	params.DNSSeeds = []chaincfg.DNSSeed{
+		{Host: "seed.testnet4.bitcoin.sprovoost.nl", HasFiltering: true},
+		{Host: "seed.testnet4.wiz.biz", HasFiltering: true},
+	}
+	params.GenesisBlock = &testNet4GenesisBlock
+	params.GenesisHash = newBlockHash(&testNet4GenesisBlock)
+	params.BIP0034Height = 1
+	params.BIP0065Height = 1
+	params.BIP0066Height = 1
+	params.Checkpoints = nil
+	return params
+}()
+
+//testNet4GenesisBlock is the genesis block of testnet4, its coinbase pays to a zero public key
+var testNet4GenesisBlock = func() wire.MsgBlock {
+	message := "03/May/2024 000000000000000000001ebd58c244970b3aa9d783bb001011fbe8ea8e98e00e"
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		SignatureScript: append([]byte{txscript.OP_DATA_4, 0xff, 0xff, 0x00, 0x1d, txscript.OP_DATA_1, 0x04,
+			txscript.OP_PUSHDATA1, byte(len(message))}, message...),
+		Sequence: wire.MaxTxInSequenceNum,
+	})
+	pkScript := append([]byte{txscript.OP_DATA_33}, make([]byte, 33)...)
+	coinbase.AddTxOut(wire.NewTxOut(50*btcutil.SatoshiPerBitcoin, append(pkScript, txscript.OP_CHECKSIG)))
+	return wire.MsgBlock{
+		Header: wire.BlockHeader{Version: 1, MerkleRoot: coinbase.TxHash(), Timestamp: time.Unix(1714777860, 0),
+			Bits: 0x1d00ffff, Nonce: 393743547},
+		Transactions: []*wire.MsgTx{coinbase},
+	}
+}()
+
+//signetGenesisBlock is the genesis block of all signets, the mainnet coinbase in a block of other time and bits
+var signetGenesisBlock = wire.MsgBlock{
+	Header: wire.BlockHeader{Version: 1, MerkleRoot: chaincfg.MainNetParams.GenesisBlock.Header.MerkleRoot,
+		Timestamp: time.Unix(1598918400, 0), Bits: 0x1e0377ae, Nonce: 52613770},
+	Transactions: chaincfg.MainNetParams.GenesisBlock.Transactions,
+}
+
+//signetPowLimit is 0x00000377ae << 216
+var signetPowLimit = new(big.Int).Lsh(big.NewInt(0x0377ae), 216)
+
+func newBlockHash(block *wire.MsgBlock) *chainhash.Hash {
+	hash := block.BlockHash()
+	return &hash
+}
+
+//CustomSignetParams return the params of a signet of challenge, the network magic is derived from it as BIP325.
+//The block signatures of the challenge are not verified, the params should be registered by RegisterNet.
+func CustomSignetParams(challenge []byte) chaincfg.Params {
+	var buf bytes.Buffer
+	wire.WriteVarBytes(&buf, 0, challenge)
+	magic := chainhash.DoubleHashB(buf.Bytes())
+
+	params := chaincfg.TestNet3Params
+	params.Name = "signet"
+	params.Net = wire.BitcoinNet(binary.LittleEndian.Uint32(magic[:4]))
+	params.DefaultPort = "38333"
+	params.DNSSeeds = nil
+	if bytes.Equal(challenge, DefaultSignetChallenge) {
+		params.DNSSeeds = []chaincfg.DNSSeed{{Host: "seed.signet.bitcoin.sprovoost.nl", HasFiltering: true}}
+	}
+	params.GenesisBlock = &signetGenesisBlock
+	params.GenesisHash = newBlockHash(&signetGenesisBlock)
+	params.PowLimit = signetPowLimit
+	params.PowLimitBits = 0x1e0377ae
+	params.BIP0034Height = 1
+	params.BIP0065Height = 1
+	params.BIP0066Height = 1
+	params.ReduceMinDifficulty = false
+	params.MinDiffReductionTime = 0
+	params.Checkpoints = nil
+	params.RuleChangeActivationThreshold = 1815 //90% of 2016
+	return params
+}
+
+var (
+	netsMtx sync.RWMutex
+	nets    = map[int]*chaincfg.Params{
+		NETID_MAIN:     &chaincfg.MainNetParams,
+		NETID_TEST:     &chaincfg.TestNet3Params,
+		NETID_REGTEST:  &chaincfg.RegressionNetParams,
+		NETID_SIGNET:   &SigNetParams,
+		NETID_TESTNET4: &TestNet4Params,
+
+		NETID_BCH_MAIN:    &BCHMainNetParams,
+		NETID_BCH_TEST:    &BCHTestNet3Params,
+		NETID_BCH_REGTEST: &BCHRegTestParams,
+
+		NETID_LTC_MAIN:  &LTCMainNetParams,
+		NETID_LTC_TEST:  &LTCTestNet4Params,
+		NETID_DOGE_MAIN: &DOGEMainNetParams,
+		NETID_DOGE_TEST: &DOGETestNetParams,
+	}
+	nextNetID = firstCustomNetID
+)
+
+func init() {
+	//the address, WIF and HD key magics of the networks are known to btcutil once registered
+	for _, params := range []*chaincfg.Params{&SigNetParams, &TestNet4Params,
+		&BCHMainNetParams, &BCHTestNet3Params, &BCHRegTestParams,
+		&LTCMainNetParams, &LTCTestNet4Params, &DOGEMainNetParams, &DOGETestNetParams} {
+		if err := chaincfg.Register(params); err != nil {
+			panic("failed to register network " + params.Name + " : " + err.Error())
+		}
+	}
+}
+
+//RegisterNet register params of a network as a new netID, which can be used as the netID of AdaptorBTC and
+//the functions. Registering the same params again return the same netID, while other params of a registered
+//network magic are rejected.
+func RegisterNet(params *chaincfg.Params) (int, error) {
+	if params == nil {
+		return 0, newError(ErrKindInvalidParams, "params is nil")
+	}
+	netsMtx.Lock()
+	defer netsMtx.Unlock()
+	for netID, registered := range nets {
+		if registered == params {
+			return netID, nil
+		}
+		if registered.Net == params.Net {
+			return 0, newError(ErrKindInvalidParams, fmt.Sprintf("network magic %08x of %s is registered by %s as %d",
+				uint32(params.Net), params.Name, registered.Name, netID))
+		}
+	}
+	//the default networks of chaincfg, like simnet, are already registered
+	if err := chaincfg.Register(params); err != nil && err != chaincfg.ErrDuplicateNet {
+		return 0, kindError(ErrKindInvalidParams, "Register "+params.Name+" failed", err)
+	}
+	netID := nextNetID
+	nextNetID++
+	nets[netID] = params
+	return netID, nil
+}
+
+//NetIDByName return the netID of the network of name, like "mainnet", "testnet3", "regtest", "signet" or "testnet4".
+//If several networks have the name, the first registered is returned.
+func NetIDByName(name string) (int, bool) {
+	netsMtx.RLock()
+	defer netsMtx.RUnlock()
+	var netIDs []int
+	for netID, params := range nets {
+		if params.Name == name {
+			netIDs = append(netIDs, netID)
+		}
+	}
+	if len(netIDs) == 0 {
+		return 0, false
+	}
+	sort.Ints(netIDs)
+	return netIDs[0], true
+}
+
+//GetNet return the params of netID, testnet3 if it is not registered
+func GetNet(netID int) *chaincfg.Params {
+	netsMtx.RLock()
+	defer netsMtx.RUnlock()
+	if params, ok := nets[netID]; ok {
+		return params
+	}
+	return &chaincfg.TestNet3Params
+}
@@ -0,0 +1,98 @@
+package btcadaptor
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+)
+
+//testCustomParams is simnet of another magic and HD key magics
+var testCustomParams = func() chaincfg.Params {
+	params := chaincfg.SimNetParams
+	params.Name = "custom"
+	params.Net = 0xdeadbeef
+	params.HDPrivateKeyID = [4]byte{0x01, 0x02, 0x03, 0x04}
+	params.HDPublicKeyID = [4]byte{0x05, 0x06, 0x07, 0x08}
+	return params
+}()
+
+func TestNetParams(t *testing.T) {
+	tests := []struct {
+		params  *chaincfg.Params
+		net     uint32
+		genesis string
+	}{
+		{&SigNetParams, 0x40cf030a, "00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6"},
+		{&TestNet4Params, 0x283f161c, "00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043"},
+	}
+	for _, test := range tests {
+		if uint32(test.params.Net) != test.net {
+			t.Errorf("unexpected magic of %s - got: %08x, "+"want: %08x", test.params.Name, uint32(test.params.Net), test.net)
+		}
+		if hash := test.params.GenesisBlock.BlockHash(); hash.String() != test.genesis || *test.params.GenesisHash != hash {
+			t.Errorf("unexpected genesis of %s - got: %v, "+"want: %v", test.params.Name, hash, test.genesis)
+		}
+		if err := checkProofOfWork(&test.params.GenesisBlock.Header, test.params.PowLimit); err != nil {
+			t.Errorf("unexpected error of genesis pow of %s - got: %v", test.params.Name, err)
+		}
+	}
+	if root := TestNet4Params.GenesisBlock.Header.MerkleRoot.String(); root != "7aa0a7ae1e223414cb807e40cd57e667b718e42aaf9306db9102fe28912b7b4e" {
+		t.Errorf("unexpected testnet4 merkle root - got: %v", root)
+	}
+	if custom := CustomSignetParams([]byte{0x51}); custom.Net == SigNetParams.Net || *custom.GenesisHash != *SigNetParams.GenesisHash {
+		t.Errorf("unexpected custom signet - got: %08x %v", uint32(custom.Net), custom.GenesisHash)
+	}
+
+	for name, want := range map[string]int{"mainnet": NETID_MAIN, "testnet3": NETID_TEST, "regtest": NETID_REGTEST,
+		"signet": NETID_SIGNET, "testnet4": NETID_TESTNET4} {
+		if netID, ok := NetIDByName(name); !ok || netID != want || GetNet(netID).Name != name {
+			t.Errorf("unexpected netID of %s - got: %v %v, "+"want: %v", name, netID, ok, want)
+		}
+	}
+	if _, ok := NetIDByName("unknown"); ok {
+		t.Errorf("unknown network is found")
+	}
+}
+
+func TestRegisterNet(t *testing.T) {
+	abtc, err := NewAdaptorBTCParams(&testCustomParams, RPCParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if abtc.NetID < firstCustomNetID || GetNet(abtc.NetID) != &testCustomParams {
+		t.Errorf("unexpected netID - got: %v", abtc.NetID)
+	}
+	if netID, _ := RegisterNet(&testCustomParams); netID != abtc.NetID {
+		t.Errorf("unexpected netID of registering again - got: %v, "+"want: %v", netID, abtc.NetID)
+	}
+	other := testCustomParams
+	if _, err := RegisterNet(&other); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of registered magic - got: %v", err)
+	}
+
+	//addresses, WIF and HD keys follow the network
+	key, _ := hex.DecodeString("d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0")
+	pubKey, _ := GetPublicKey(key, abtc.NetID)
+	for netID, want := range map[int]string{NETID_MAIN: "1", NETID_REGTEST: "m", NETID_SIGNET: "m",
+		NETID_TESTNET4: "m", abtc.NetID: "S"} {
+		addr, err := PubKeyToAddress(pubKey, netID)
+		if err != nil || addr[:1] != want {
+			t.Errorf("unexpected address of %d - got: %v %v, "+"want: %v...", netID, addr, err, want)
+		}
+		if _, err := btcutil.DecodeAddress(addr, GetNet(netID)); err != nil {
+			t.Errorf("unexpected error of decoding address of %d - got: %v", netID, err)
+		}
+	}
+	priKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), key)
+	wif, _ := btcutil.NewWIF(priKey, GetNet(abtc.NetID), true)
+	if decoded, err := btcutil.DecodeWIF(wif.String()); err != nil || !decoded.IsForNet(&testCustomParams) {
+		t.Errorf("unexpected WIF - got: %v %v", wif, err)
+	}
+	if pubID, err := chaincfg.HDPrivateKeyToPublicKeyID(testCustomParams.HDPrivateKeyID[:]); err != nil ||
+		hex.EncodeToString(pubID) != "05060708" {
+		t.Errorf("unexpected HD public key id - got: %x %v", pubID, err)
+	}
+}
@@ -0,0 +1,358 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+//a node failed the ping is skipped for this duration, unless all nodes are down
+const nodeDownDuration = 30 * time.Second
+
+var ErrNoNode = errors.New("no rpc node")
+
+//QuorumError is the cause of the error returned when the nodes not agree on a quorum read,
+//the kind is ErrKindNode, or ErrKindTransient if some nodes failed transiently
+type QuorumError struct {
+	Op      string
+	Quorum  int
+	Results map[string]string //host -> result or error
+}
+
+func (e *QuorumError) Error() string {
+	hosts := make([]string, 0, len(e.Results))
+	for host := range e.Results {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	results := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		results = append(results, host+": "+e.Results[host])
+	}
+	return fmt.Sprintf("%s quorum %d not reached : %s", e.Op, e.Quorum, strings.Join(results, ", "))
+}
+
+type rpcNode struct {
+	params    RPCParams
+	pool      *ClientPool
+	downUntil time.Time //guard by NodeSet.mtx
+
+	resolverMtx sync.Mutex
+	resolver    *txResolver //batched requests and cache of the node
+}
+
+//getResolver return the tx resolver of the node, create it on first use
+func (node *rpcNode) getResolver() (*txResolver, error) {
+	node.resolverMtx.Lock()
+	defer node.resolverMtx.Unlock()
+	if node.resolver == nil {
+		batch, err := newBatchClient(&node.params, node.pool.cfg.CallTimeout)
+		if err != nil {
+			return nil, err
+		}
+		cache := newTxCache(node.pool.cfg.CacheSize, node.pool.cfg.CacheSize)
+		node.resolver = newTxResolver(batch, cache)
+	}
+	return node.resolver, nil
+}
+
+//NodeSet is a list of nodes, each has its own ClientPool.
+//Calls fail over to the next node if a node is down,
+//security-sensitive reads can require a quorum of nodes to agree.
+type NodeSet struct {
+	nodes []*rpcNode
+
+	mtx       sync.Mutex
+	preferred int //index of the node used first
+}
+
+func NewNodeSet(nodes []RPCParams, cfg ClientPoolConfig) *NodeSet {
+	ns := &NodeSet{}
+	for _, params := range nodes {
+		ns.nodes = append(ns.nodes, &rpcNode{params: params, pool: NewClientPool(params, cfg)})
+	}
+	return ns
+}
+
+//order return the nodes to try, start from the preferred one, the down nodes at last
+func (ns *NodeSet) order() []*rpcNode {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+	now := time.Now()
+	var up, down []*rpcNode
+	for i := range ns.nodes {
+		node := ns.nodes[(ns.preferred+i)%len(ns.nodes)]
+		if now.Before(node.downUntil) {
+			down = append(down, node)
+		} else {
+			up = append(up, node)
+		}
+	}
+	return append(up, down...)
+}
+
+func (ns *NodeSet) setPreferred(node *rpcNode) {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+	for i := range ns.nodes {
+		if ns.nodes[i] == node {
+			ns.preferred = i
+		}
+	}
+	node.downUntil = time.Time{}
+}
+
+func (ns *NodeSet) setDown(node *rpcNode) {
+	ns.mtx.Lock()
+	defer ns.mtx.Unlock()
+	node.downUntil = time.Now().Add(nodeDownDuration)
+}
+
+//alive ping the node, an error returned by the node means the node is alive
+func (node *rpcNode) alive(ctx context.Context) bool {
+	err := node.pool.DoCtx(ctx, func(client *rpcclient.Client) error {
+		_, err := client.GetBlockCount()
+		return err
+	})
+	return err == nil || isRPCError(err)
+}
+
+//Do call f with a client of the preferred node, fail over to the next node if the node is down.
+//The errors of a alive node are returned directly, other nodes would return the same.
+func (ns *NodeSet) Do(f func(client *rpcclient.Client) error) error {
+	return ns.DoCtx(context.Background(), f)
+}
+
+//DoCtx is Do which stop calling and failing over when ctx is done
+func (ns *NodeSet) DoCtx(ctx context.Context, f func(client *rpcclient.Client) error) error {
+	_, err := ns.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return nil, f(client)
+	})
+	return err
+}
+
+//CallCtx is DoCtx which return the value of f, see ClientPool.CallCtx
+func (ns *NodeSet) CallCtx(ctx context.Context, f func(client *rpcclient.Client) (interface{}, error)) (interface{}, error) {
+	return ns.callNodeCtx(ctx, func(node *rpcNode, client *rpcclient.Client) (interface{}, error) {
+		return f(client)
+	})
+}
+
+//callNodeCtx is CallCtx which pass the node to f
+func (ns *NodeSet) callNodeCtx(ctx context.Context, f func(node *rpcNode, client *rpcclient.Client) (interface{}, error)) (interface{}, error) {
+	if len(ns.nodes) == 0 {
+		return nil, ErrNoNode
+	}
+	var lastErr error
+	for _, node := range ns.order() {
+		value, err := node.pool.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+			return f(node, client)
+		})
+		if err == nil {
+			ns.setPreferred(node)
+			return value, nil
+		}
+		if err == ErrPoolClosed || ctx.Err() != nil || !mayBeConnError(err) || node.alive(ctx) {
+			return nil, err
+		}
+		ns.setDown(node)
+		lastErr = err
+	}
+	return nil, wrapError("all rpc nodes failed, last error", lastErr)
+}
+
+type quorumResult struct {
+	host  string
+	key   string //results with the same key agree
+	value interface{}
+	err   error
+}
+
+//quorum call f on all nodes concurrently, at least quorum nodes must return the same key
+//and no node return a different key, return the values of the agreed nodes.
+//If quorum <= 1, f is called by Do with failover only.
+func (ns *NodeSet) quorum(ctx context.Context, op string, quorum int,
+	f func(client *rpcclient.Client) (string, interface{}, error)) ([]interface{}, error) {
+	if quorum <= 1 {
+		value, err := ns.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+			_, value, err := f(client)
+			return value, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{value}, nil
+	}
+	if quorum > len(ns.nodes) {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("%s quorum %d is more than nodes %d",
+			op, quorum, len(ns.nodes)))
+	}
+
+	results := ns.callAll(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		key, value, err := f(client)
+		return quorumResult{key: key, value: value}, err
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		if results[i].err == nil {
+			result := results[i].value.(quorumResult)
+			results[i].key, results[i].value = result.key, result.value
+		}
+	}
+
+	qErr := &QuorumError{Op: op, Quorum: quorum, Results: map[string]string{}}
+	keys := map[string][]interface{}{}
+	kind := ErrKindNode
+	for _, result := range results {
+		if result.err != nil {
+			qErr.Results[result.host] = "error " + result.err.Error()
+			if IsRetryable(result.err) {
+				kind = ErrKindTransient
+			}
+			continue
+		}
+		qErr.Results[result.host] = result.key
+		keys[result.key] = append(keys[result.key], result.value)
+	}
+	if len(keys) > 1 { //the nodes disagree, retry will not help
+		return nil, kindError(ErrKindNode, "", qErr)
+	}
+	for _, values := range keys {
+		if len(values) >= quorum {
+			return values, nil
+		}
+	}
+	//not enough nodes succeed, it may be reached again if a failure is transient
+	return nil, kindError(kind, "", qErr)
+}
+
+//callAll call f on all nodes concurrently without failover, return the value or the error of each node
+//in the order of the nodes
+func (ns *NodeSet) callAll(ctx context.Context, f func(client *rpcclient.Client) (interface{}, error)) []quorumResult {
+	results := make([]quorumResult, len(ns.nodes))
+	var wg sync.WaitGroup
+	for i, node := range ns.nodes {
+		wg.Add(1)
+		go func(i int, node *rpcNode) {
+			defer wg.Done()
+			value, err := node.pool.CallCtx(ctx, f)
+			results[i] = quorumResult{host: node.params.Host, value: value, err: err}
+		}(i, node)
+	}
+	wg.Wait()
+	return results
+}
+
+//GetBlockHash return the hash of the main chain block at height
+func (ns *NodeSet) GetBlockHash(ctx context.Context, height int64, quorum int) (*chainhash.Hash, error) {
+	values, err := ns.quorum(ctx, "GetBlockHash", quorum, func(client *rpcclient.Client) (string, interface{}, error) {
+		hash, err := client.GetBlockHash(height) //BTCD API
+		if err != nil {
+			return "", nil, err
+		}
+		return hash.String(), hash, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values[0].(*chainhash.Hash), nil
+}
+
+//TxConfirm is the confirmation of a transaction agreed by the nodes
+type TxConfirm struct {
+	BlockHash     string //empty if the tx is not in block
+	Confirmations int64  //the minimum of the nodes
+}
+
+//GetTxConfirm return the block and confirmations of the tx, not found is a valid result
+func (ns *NodeSet) GetTxConfirm(ctx context.Context, txHash *chainhash.Hash, quorum int) (*TxConfirm, error) {
+	values, err := ns.quorum(ctx, "GetTxConfirm", quorum, func(client *rpcclient.Client) (string, interface{}, error) {
+		txResult, err := client.GetRawTransactionVerbose(txHash) //BTCD API
+		if err != nil {
+			if rpcErr, ok := err.(*btcjson.RPCError); ok && rpcErr.Code == btcjson.ErrRPCNoTxInfo {
+				return "notfound", &TxConfirm{}, nil
+			}
+			return "", nil, err
+		}
+		confirm := &TxConfirm{BlockHash: txResult.BlockHash, Confirmations: int64(txResult.Confirmations)}
+		return "block " + txResult.BlockHash, confirm, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	confirm := *values[0].(*TxConfirm)
+	for _, value := range values[1:] {
+		if value.(*TxConfirm).Confirmations < confirm.Confirmations {
+			confirm.Confirmations = value.(*TxConfirm).Confirmations
+		}
+	}
+	return &confirm, nil
+}
+
+//GetTxOut return the unspent output, nil if it is spent or not exist
+func (ns *NodeSet) GetTxOut(ctx context.Context, txHash *chainhash.Hash, index uint32, quorum int) (*btcjson.GetTxOutResult, error) {
+	values, err := ns.quorum(ctx, "GetTxOut", quorum, func(client *rpcclient.Client) (string, interface{}, error) {
+		result, err := client.GetTxOut(txHash, index, false)
+		if err != nil {
+			return "", nil, err
+		}
+		if result == nil {
+			return "spent", result, nil
+		}
+		key := fmt.Sprintf("unspent %v %s", result.Value, result.ScriptPubKey.Hex)
+		return key, result, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, _ := values[0].(*btcjson.GetTxOutResult)
+	for _, value := range values[1:] {
+		if other := value.(*btcjson.GetTxOutResult); other != nil && other.Confirmations < result.Confirmations {
+			result = other
+		}
+	}
+	return result, nil
+}
+
+//Close close the pools of all nodes
+func (ns *NodeSet) Close() {
+	for _, node := range ns.nodes {
+		node.pool.Close()
+	}
+}
+
+//checkTxConfirm check the tx info returned by one node with the quorum of nodes
+func checkTxConfirm(confirm *TxConfirm, blockID []byte, isInBlock bool) error {
+	if isInBlock != (confirm.BlockHash != "") || hex.EncodeToString(blockID) != confirm.BlockHash {
+		return fmt.Errorf("tx block %x not agreed by quorum, quorum block : %s", blockID, confirm.BlockHash)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package btcadaptor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/rpcclient"
+)
+
+func TestNodeSetFailover(t *testing.T) {
+	down, downParams := newTestNode(t)
+	down.server.Close() //the cert is kept, the node is not listening
+	defer down.Close()
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	node.handle("getblockcount", func(params []json.RawMessage) (interface{}, error) {
+		return 100, nil
+	})
+
+	ns := NewNodeSet([]RPCParams{downParams, rpcParams}, ClientPoolConfig{Size: 1, CallTimeout: 5 * time.Second, HealthInterval: -1})
+	defer ns.Close()
+	for i := 0; i < 3; i++ {
+		err := ns.Do(func(client *rpcclient.Client) error {
+			_, err := client.GetBlockCount()
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	//the down node is skipped after the first failure
+	if calls := atomic.LoadInt32(&node.calls); calls != 3 {
+		t.Errorf("unexpected calls - got: %v, "+"want: %v", calls, 3)
+	}
+
+	//the errors returned by a alive node are not failed over
+	err := ns.Do(func(client *rpcclient.Client) error {
+		_, err := client.GetBestBlockHash()
+		return err
+	})
+	if !isRPCError(err) {
+		t.Errorf("unexpected error - got: %v, "+"want: rpc error", err)
+	}
+}
+
+func TestNodeSetQuorum(t *testing.T) {
+	blockHash := "000000000000003f8d4e3b9a43fcb57f2dd3a4e4e3b2b7f4dc1a6d6b6a8d5f10"
+	forkHash := "000000000000001a5f1f4a2e9b0f6a4f7c8e3d2c1b0a99887766554433221100"
+	txid := "494f0780bf219a1245e76314dd22471f87b7cec465d439343af0bf7ff7e1f66a"
+	var rpcNodes []RPCParams
+	var liar *testNode
+	for i := 0; i < 3; i++ {
+		node, rpcParams := newTestNode(t)
+		defer node.Close()
+		hash := blockHash
+		node.handle("getblockhash", func(params []json.RawMessage) (interface{}, error) {
+			return hash, nil
+		})
+		confirmations := uint64(10 + i)
+		node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+			return btcjson.TxRawResult{Txid: txid, BlockHash: blockHash, Confirmations: confirmations}, nil
+		})
+		node.handle("gettxout", func(params []json.RawMessage) (interface{}, error) {
+			return btcjson.GetTxOutResult{BestBlock: blockHash, Confirmations: 10, Value: 0.1,
+				ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: "76a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac"}}, nil
+		})
+		rpcNodes = append(rpcNodes, rpcParams)
+		liar = node
+	}
+
+	abtc := NewAdaptorBTCNodes(NETID_TEST, rpcNodes, 2)
+	abtc.PoolConfig.Size = 1
+	defer abtc.Close()
+
+	hash, err := abtc.GetBlockHash(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash.String() != blockHash {
+		t.Errorf("unexpected block hash - got: %v, "+"want: %v", hash, blockHash)
+	}
+	txID, _ := hex.DecodeString(txid)
+	confirm, err := abtc.GetTxConfirm(txID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if confirm.BlockHash != blockHash || confirm.Confirmations != 10 {
+		t.Errorf("unexpected confirm - got: %v, "+"want: %v %v", confirm, blockHash, 10)
+	}
+	txOut, err := abtc.GetTxOut(txID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txOut == nil || txOut.Value != 0.1 {
+		t.Errorf("unexpected tx out - got: %v", txOut)
+	}
+
+	//one node disagree
+	liar.handle("getblockhash", func(params []json.RawMessage) (interface{}, error) {
+		return forkHash, nil
+	})
+	liar.handle("gettxout", func(params []json.RawMessage) (interface{}, error) {
+		return nil, nil
+	})
+	if _, err := abtc.GetBlockHash(100); err == nil {
+		t.Errorf("expected quorum error")
+	} else if _, ok := Cause(err).(*QuorumError); !ok || ErrorKindOf(err) != ErrKindNode {
+		t.Errorf("unexpected error - got: %v, "+"want: QuorumError", err)
+	}
+	if _, err := abtc.GetTxOut(txID, 0); err == nil {
+		t.Errorf("expected quorum error")
+	}
+
+	//one node failed, the quorum of all nodes is not reached
+	liar.handle("getblockhash", func(params []json.RawMessage) (interface{}, error) {
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCMisc, "misc")
+	})
+	abtc.Quorum = 3
+	if _, err := abtc.GetBlockHash(100); ErrorKindOf(err) != ErrKindNode {
+		t.Errorf("unexpected error of a failed node - got: %v, "+"want: %v", err, ErrKindNode)
+	}
+	abtc.Quorum = 4
+	if _, err := abtc.GetBlockHash(100); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of quorum 4 - got: %v, "+"want: %v", err, ErrKindInvalidParams)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//the reasons of PolicyViolation, named as the reject reasons of Bitcoin Core
+const (
+	ReasonScript       = "script-verify-flag-failed" //an input script fails
+	ReasonMissingInput = "missing-inputs"            //the prevout of an input is not supplied
+	ReasonScriptPubKey = "scriptpubkey"              //an output script is not standard
+	ReasonDust         = "dust"                      //an output is below the dust limit
+	ReasonDataCarrier  = "datacarrier"               //an OP_RETURN output is too large
+	ReasonMultiOpRet   = "multi-op-return"           //too many OP_RETURN outputs
+	ReasonSigOps       = "bad-txns-too-many-sigops"  //the sigop cost is too high
+	ReasonTxSize       = "tx-size"                   //the weight is too high
+	ReasonInBelowOut   = "bad-txns-in-belowout"      //the outputs are more than the inputs
+	ReasonAbsurdFee    = "absurdly-high-fee"         //the fee rate is absurd
+	ReasonMaxFee       = "max-fee-exceeded"          //the fee is more than the max fee to pay
+)
+
+//the standard limits of Bitcoin Core
+const (
+	defaultMaxStandardWeight = 400000
+	defaultMaxDataCarrier    = txscript.MaxDataCarrierSize + 3 //of the script, OP_RETURN and the push of the data
+	bchMaxDataCarrier        = 223
+	defaultMaxSigOpsCost     = 16000
+	absurdFeeRateFactor      = 1000 //of DefaultFeeRate, 0.1 BTC per 1000 bytes as Bitcoin Core
+	p2pkhDustSize            = 182  //a P2PKH output and the input spending it, 546 is the dust of it at 3 sat/byte
+	witnessInputDustSize     = 67   //the input spending a witness program, the witness is discounted
+)
+
+//TxPolicy is the standardness and fee rules checked before a tx is broadcast.
+//The zero value of a field means its default.
+type TxPolicy struct {
+	DustLimit             int64                //P2PKH outputs below it are rejected, default DustLimit of the chain
+	MaxWeight             int                  //the weight of BIP141
+	MaxDataCarrierSize    int                  //of an OP_RETURN script, 83 bytes or 223 on BCH
+	MaxDataCarrierOutputs int                  //OP_RETURN outputs of a tx, default 1
+	MaxSigOpsCost         int                  //legacy and P2SH sigops cost 4, witness sigops cost 1
+	MaxFeeRate            int64                //per 1000 vbytes, default 1000 times DefaultFeeRate of the chain
+	Flags                 txscript.ScriptFlags //of the input scripts, StandardVerifyFlags or BCHVerifyFlags on BCH
+}
+
+//DefaultTxPolicy return the default policy of the network
+func DefaultTxPolicy(params *chaincfg.Params) TxPolicy {
+	return TxPolicy{}.withDefault(params)
+}
+
+func (p TxPolicy) withDefault(params *chaincfg.Params) TxPolicy {
+	chain := ChainOf(params)
+	if p.DustLimit <= 0 {
+		p.DustLimit = chain.DustLimit
+	}
+	if p.MaxWeight <= 0 {
+		p.MaxWeight = defaultMaxStandardWeight
+	}
+	if p.MaxDataCarrierSize <= 0 {
+		p.MaxDataCarrierSize = defaultMaxDataCarrier
+		if IsBCH(params) {
+			p.MaxDataCarrierSize = bchMaxDataCarrier
+		}
+	}
+	if p.MaxDataCarrierOutputs <= 0 {
+		p.MaxDataCarrierOutputs = 1
+	}
+	if p.MaxSigOpsCost <= 0 {
+		p.MaxSigOpsCost = defaultMaxSigOpsCost
+	}
+	if p.MaxFeeRate <= 0 {
+		p.MaxFeeRate = chain.DefaultFeeRate * absurdFeeRateFactor
+	}
+	if p.Flags == 0 {
+		p.Flags = txscript.StandardVerifyFlags
+		if IsBCH(params) {
+			p.Flags = txscript.BCHVerifyFlags
+		}
+	}
+	return p
+}
+
+//PolicyViolation is a rule of TxPolicy the tx violates
+type PolicyViolation struct {
+	Reason string
+	Input  int //the index of the input, -1 if not of an input
+	Output int //the index of the output, -1 if not of an output
+	Msg    string
+}
+
+func (v PolicyViolation) String() string {
+	switch {
+	case v.Input >= 0:
+		return fmt.Sprintf("%s of input %d : %s", v.Reason, v.Input, v.Msg)
+	case v.Output >= 0:
+		return fmt.Sprintf("%s of output %d : %s", v.Reason, v.Output, v.Msg)
+	}
+	return v.Reason + " : " + v.Msg
+}
+
+//PolicyError is the Cause of the error of SendTransaction if the tx violates the Policy of the adaptor
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	var msgs []string
+	for _, v := range e.Violations {
+		msgs = append(msgs, v.String())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+//CheckPolicy check tx of params by policy, prevouts are the outputs spent by the inputs.
+//maxFee is the most fee to pay, 0 means not limited. All violations are returned, none if tx is standard.
+func CheckPolicy(tx *wire.MsgTx, prevouts map[wire.OutPoint]*wire.TxOut, params *chaincfg.Params, policy TxPolicy,
+	maxFee btcutil.Amount) []PolicyViolation {
+	policy = policy.withDefault(params)
+	chain := ChainOf(params)
+	var violations []PolicyViolation
+	add := func(reason string, input, output int, format string, a ...interface{}) {
+		violations = append(violations, PolicyViolation{Reason: reason, Input: input, Output: output,
+			Msg: fmt.Sprintf(format, a...)})
+	}
+
+	weight := tx.SerializeSizeStripped()*(witnessScaleFactor-1) + tx.SerializeSize()
+	if weight > policy.MaxWeight {
+		add(ReasonTxSize, -1, -1, "weight %d is more than %d", weight, policy.MaxWeight)
+	}
+
+	//the outputs
+	var outputAmount btcutil.Amount
+	legacySigOps := 0
+	dataCarriers := 0
+	for i, out := range tx.TxOut {
+		outputAmount += btcutil.Amount(out.Value)
+		legacySigOps += txscript.GetSigOpCount(out.PkScript)
+		if chain.IgnoreOutput != nil && chain.IgnoreOutput(out.PkScript) {
+			continue
+		}
+		switch {
+		case isDataCarrier(out.PkScript):
+			dataCarriers++
+			if len(out.PkScript) > policy.MaxDataCarrierSize {
+				add(ReasonDataCarrier, -1, i, "OP_RETURN script of %d bytes is more than %d", len(out.PkScript),
+					policy.MaxDataCarrierSize)
+			}
+		case scriptClassName(out.PkScript) == txscript.NonStandardTy.String():
+			add(ReasonScriptPubKey, -1, i, "the script is not standard")
+		default:
+			if dust := dustThreshold(out, policy.DustLimit); out.Value < dust {
+				add(ReasonDust, -1, i, "value %d is below %d", out.Value, dust)
+			}
+		}
+	}
+	if dataCarriers > policy.MaxDataCarrierOutputs {
+		add(ReasonMultiOpRet, -1, -1, "%d OP_RETURN outputs are more than %d", dataCarriers,
+			policy.MaxDataCarrierOutputs)
+	}
+
+	//the inputs
+	var inputAmount btcutil.Amount
+	hasFee := !isCoinBase(tx)
+	witnessSigOps := 0
+	for i, in := range tx.TxIn {
+		legacySigOps += txscript.GetSigOpCount(in.SignatureScript)
+		prevout := prevouts[in.PreviousOutPoint]
+		if prevout == nil {
+			add(ReasonMissingInput, i, -1, "the prevout %v is not supplied", in.PreviousOutPoint)
+			hasFee = false
+			continue
+		}
+		inputAmount += btcutil.Amount(prevout.Value)
+		if txscript.IsPayToScriptHash(prevout.PkScript) {
+			legacySigOps += txscript.GetPreciseSigOpCount(in.SignatureScript, prevout.PkScript, true)
+		}
+		witnessSigOps += txscript.GetWitnessSigOpCount(in.SignatureScript, prevout.PkScript, in.Witness)
+
+		//the engine verifies witness v0 only, the scripts of taproot and later versions are left to the nodes
+		if version, _, err := txscript.ExtractWitnessProgramInfo(prevout.PkScript); err == nil && version >= 1 {
+			continue
+		}
+		vm, err := txscript.NewEngine(prevout.PkScript, tx, i, policy.Flags, nil, nil, prevout.Value)
+		if err == nil {
+			err = vm.Execute()
+		}
+		if err != nil {
+			add(ReasonScript, i, -1, "%s", err.Error())
+		}
+	}
+	if cost := legacySigOps*witnessScaleFactor + witnessSigOps; cost > policy.MaxSigOpsCost {
+		add(ReasonSigOps, -1, -1, "sigop cost %d is more than %d", cost, policy.MaxSigOpsCost)
+	}
+
+	//the fee
+	if !hasFee {
+		return violations
+	}
+	fee := inputAmount - outputAmount
+	if fee < 0 {
+		add(ReasonInBelowOut, -1, -1, "inputs %d are less than outputs %d", int64(inputAmount), int64(outputAmount))
+		return violations
+	}
+	vsize := (weight + witnessScaleFactor - 1) / witnessScaleFactor
+	if maxRateFee := Fee(policy.MaxFeeRate, vsize); int64(fee) > maxRateFee {
+		add(ReasonAbsurdFee, -1, -1, "fee %d of %d vbytes is more than %d of the max fee rate %d", int64(fee), vsize,
+			maxRateFee, policy.MaxFeeRate)
+	}
+	if maxFee > 0 && fee > maxFee {
+		add(ReasonMaxFee, -1, -1, "fee %d is more than the max fee %d", int64(fee), int64(maxFee))
+	}
+	return violations
+}
+
+//dustThreshold return the dust limit of out as GetDustThreshold of Bitcoin Core, limit is of a P2PKH output.
+//The limit of a witness program is less by the size of the input spending it.
+func dustThreshold(out *wire.TxOut, limit int64) int64 {
+	if !txscript.IsWitnessProgram(out.PkScript) {
+		return limit
+	}
+	return limit * int64(out.SerializeSize()+witnessInputDustSize) / p2pkhDustSize
+}
+
+//isDataCarrier return whether pkScript is OP_RETURN and pushes of any size, which is NullDataTy if the data
+//is not more than MaxDataCarrierSize
+func isDataCarrier(pkScript []byte) bool {
+	return len(pkScript) > 0 && pkScript[0] == txscript.OP_RETURN && txscript.IsPushOnlyScript(pkScript[1:])
+}
+
+//CheckTransaction check the tx to send by the Policy of the adaptor, or the default one of the network,
+//the prevouts are resolved by the nodes. Fee of input is the most fee to pay.
+func (abtc *AdaptorBTC) CheckTransaction(input *adaptor.SendTransactionInput) ([]PolicyViolation, error) {
+	return abtc.CheckTransactionCtx(context.Background(), input)
+}
+
+func (abtc *AdaptorBTC) CheckTransactionCtx(ctx context.Context, input *adaptor.SendTransactionInput) ([]PolicyViolation, error) {
+	var tx wire.MsgTx
+	if err := tx.Deserialize(bytes.NewReader(input.Transaction)); err != nil {
+		return nil, kindError(ErrKindInvalidTx, "Deserialize failed", err)
+	}
+	maxFee, err := amountInput(input.Fee, "fee")
+	if err != nil {
+		return nil, err
+	}
+	prevouts, err := abtc.resolvePrevouts(ctx, &tx)
+	if err != nil {
+		return nil, err
+	}
+	var policy TxPolicy
+	if abtc.Policy != nil {
+		policy = *abtc.Policy
+	}
+	return CheckPolicy(&tx, prevouts, GetNet(abtc.NetID), policy, maxFee), nil
+}
@@ -0,0 +1,190 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/adaptor"
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//policyTestTx return a tx spending a P2PKH prevout of 1000000, with the outputs added by build, and signed
+func policyTestTx(t *testing.T, build func(tx *wire.MsgTx)) (*wire.MsgTx, map[wire.OutPoint]*wire.TxOut) {
+	key, _ := hex.DecodeString("d0e26e9189b9f047036ed21294c8f36d41df6b51852fc932595d849d727223d0")
+	privKey, pubKey := btcec.PrivKeyFromBytes(btcec.S256(), key)
+	addr, _ := btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubKey.SerializeCompressed()), GetNet(NETID_TEST))
+	pkScript, _ := txscript.PayToAddrScript(addr)
+
+	prevOut := outPointOf(fmt.Sprintf("%064x", 1), 0)
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(wire.NewTxIn(&prevOut, nil, nil))
+	build(tx)
+	sigScript, err := txscript.SignatureScript(tx, 0, pkScript, txscript.SigHashAll, privKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+	return tx, map[wire.OutPoint]*wire.TxOut{prevOut: wire.NewTxOut(1000000, pkScript)}
+}
+
+func reasonsOf(violations []PolicyViolation) []string {
+	var reasons []string
+	for _, v := range violations {
+		reasons = append(reasons, v.Reason)
+	}
+	return reasons
+}
+
+func TestCheckPolicy(t *testing.T) {
+	pkScript := mustDecodeHex("76a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac")
+	data := func(n int) []byte {
+		script, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddData(make([]byte, n)).Script()
+		return script
+	}
+	p2wpkh := append([]byte{txscript.OP_0, txscript.OP_DATA_20}, make([]byte, 20)...)
+	p2wsh := append([]byte{txscript.OP_0, txscript.OP_DATA_32}, make([]byte, 32)...)
+	params := GetNet(NETID_TEST)
+
+	//the standard tx of an OP_RETURN and a P2PKH output
+	tx, prevouts := policyTestTx(t, func(tx *wire.MsgTx) {
+		tx.AddTxOut(wire.NewTxOut(0, data(80)))
+		tx.AddTxOut(wire.NewTxOut(990000, pkScript))
+	})
+	if violations := CheckPolicy(tx, prevouts, params, TxPolicy{}, 10000); len(violations) != 0 {
+		t.Errorf("unexpected violations of standard tx - got: %v", violations)
+	}
+
+	tests := []struct {
+		name   string
+		build  func(tx *wire.MsgTx)
+		policy TxPolicy
+		maxFee btcutil.Amount
+		want   []string
+	}{
+		{"dust", func(tx *wire.MsgTx) {
+			tx.AddTxOut(wire.NewTxOut(545, pkScript))
+			tx.AddTxOut(wire.NewTxOut(990000, pkScript))
+		}, TxPolicy{}, 0, []string{ReasonDust}},
+		{"witness dust", func(tx *wire.MsgTx) {
+			tx.AddTxOut(wire.NewTxOut(293, p2wpkh))
+			tx.AddTxOut(wire.NewTxOut(294, p2wpkh))
+			tx.AddTxOut(wire.NewTxOut(329, p2wsh))
+			tx.AddTxOut(wire.NewTxOut(330, p2wsh))
+			tx.AddTxOut(wire.NewTxOut(990000, pkScript))
+		}, TxPolicy{}, 0, []string{ReasonDust, ReasonDust}},
+		{"data carriers", func(tx *wire.MsgTx) {
+			tx.AddTxOut(wire.NewTxOut(0, data(81)))
+			tx.AddTxOut(wire.NewTxOut(0, data(1)))
+			tx.AddTxOut(wire.NewTxOut(990000, pkScript))
+		}, TxPolicy{}, 0, []string{ReasonDataCarrier, ReasonMultiOpRet}},
+		{"nonstandard", func(tx *wire.MsgTx) {
+			tx.AddTxOut(wire.NewTxOut(990000, []byte{txscript.OP_TRUE}))
+		}, TxPolicy{}, 0, []string{ReasonScriptPubKey}},
+		{"sigops and weight", func(tx *wire.MsgTx) {
+			tx.AddTxOut(wire.NewTxOut(490000, pkScript))
+			tx.AddTxOut(wire.NewTxOut(500000, pkScript))
+		}, TxPolicy{MaxSigOpsCost: 4, MaxWeight: 400}, 0, []string{ReasonTxSize, ReasonSigOps}},
+		{"in below out", func(tx *wire.MsgTx) {
+			tx.AddTxOut(wire.NewTxOut(1000001, pkScript))
+		}, TxPolicy{}, 0, []string{ReasonInBelowOut}},
+		{"fee", func(tx *wire.MsgTx) {
+			tx.AddTxOut(wire.NewTxOut(1000, pkScript))
+		}, TxPolicy{}, 10000, []string{ReasonMaxFee}},
+		{"fee rate", func(tx *wire.MsgTx) {
+			tx.AddTxOut(wire.NewTxOut(900000, pkScript))
+		}, TxPolicy{MaxFeeRate: 100000}, 0, []string{ReasonAbsurdFee}},
+	}
+	for _, test := range tests {
+		tx, prevouts := policyTestTx(t, test.build)
+		violations := CheckPolicy(tx, prevouts, params, test.policy, test.maxFee)
+		if reasons := reasonsOf(violations); !reflect.DeepEqual(reasons, test.want) {
+			t.Errorf("unexpected violations of %s - got: %v, "+"want: %v", test.name, violations, test.want)
+		}
+	}
+
+	//the input of a changed tx fails, the input of another prevout is missing
+	tx.TxOut[1].Value--
+	violations := CheckPolicy(tx, prevouts, params, TxPolicy{}, 0)
+	if len(violations) != 1 || violations[0].Reason != ReasonScript || violations[0].Input != 0 {
+		t.Errorf("unexpected violations of changed tx - got: %v", violations)
+	}
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&tx.TxIn[0].PreviousOutPoint.Hash, 1), nil, nil))
+	violations = CheckPolicy(tx, prevouts, params, TxPolicy{}, 0)
+	if reasons := reasonsOf(violations); !reflect.DeepEqual(reasons, []string{ReasonScript, ReasonMissingInput}) ||
+		violations[1].Input != 1 || violations[1].Output != -1 {
+		t.Errorf("unexpected violations of missing prevout - got: %v", violations)
+	}
+
+	//the script of a taproot prevout is not verified by the engine
+	p2tr := append([]byte{txscript.OP_1, txscript.OP_DATA_32}, make([]byte, 32)...)
+	taprootOut := outPointOf(fmt.Sprintf("%064x", 2), 0)
+	tx, prevouts = policyTestTx(t, func(tx *wire.MsgTx) {
+		tx.AddTxIn(&wire.TxIn{PreviousOutPoint: taprootOut, Witness: wire.TxWitness{make([]byte, 64)}})
+		tx.AddTxOut(wire.NewTxOut(1990000, pkScript))
+	})
+	prevouts[taprootOut] = wire.NewTxOut(1000000, p2tr)
+	if violations := CheckPolicy(tx, prevouts, params, TxPolicy{}, 0); len(violations) != 0 {
+		t.Errorf("unexpected violations of taproot input - got: %v", violations)
+	}
+
+	if policy := DefaultTxPolicy(GetNet(NETID_BCH_TEST)); policy.MaxDataCarrierSize != 223 ||
+		policy.Flags != txscript.BCHVerifyFlags || policy.MaxFeeRate != 1000000 {
+		t.Errorf("unexpected BCH policy - got: %+v", policy)
+	}
+}
+
+func TestSendTransactionPolicy(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+
+	tx, prevouts := policyTestTx(t, func(tx *wire.MsgTx) {
+		tx.AddTxOut(wire.NewTxOut(990000, mustDecodeHex("76a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac")))
+	})
+	prevOut := tx.TxIn[0].PreviousOutPoint
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		return btcjson.TxRawResult{Txid: prevOut.Hash.String(), Vout: []btcjson.Vout{{Value: 0.01,
+			ScriptPubKey: btcjson.ScriptPubKeyResult{Hex: hex.EncodeToString(prevouts[prevOut].PkScript)}}}}, nil
+	})
+	var sent int32
+	node.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		atomic.AddInt32(&sent, 1)
+		return tx.TxHash().String(), nil
+	})
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	abtc.Policy = &TxPolicy{}
+	defer abtc.Close()
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	send := func(fee int64) error {
+		_, err := abtc.SendTransaction(&adaptor.SendTransactionInput{Transaction: buf.Bytes(),
+			Fee: adaptor.NewAmountAsset(big.NewInt(fee), "BTC")})
+		return err
+	}
+	if err := send(10000); err != nil {
+		t.Fatal(err)
+	}
+
+	//the fee is more than the max fee, the tx is not sent
+	err := send(9999)
+	policyErr, ok := Cause(err).(*PolicyError)
+	if ErrorKindOf(err) != ErrKindInvalidTx || !ok || len(policyErr.Violations) != 1 ||
+		!strings.Contains(err.Error(), "max-fee-exceeded : fee 10000 is more than the max fee 9999") {
+		t.Errorf("unexpected error of max fee - got: %v", err)
+	}
+	if calls := atomic.LoadInt32(&sent); calls != 1 {
+		t.Errorf("unexpected sent - got: %v, "+"want: %v", calls, 1)
+	}
+}
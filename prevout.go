@@ -0,0 +1,329 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	defaultBatchSize      = 100  //requests per batch
+	defaultResolveWorkers = 4    //batches in parallel
+	maxReorgDepth         = 100  //the cache is cleared if a reorg is deeper
+	maxTxIndexProofs      = 1000 //gettxoutproof requests of a call to resolve the positions of txs
+)
+
+//txResolver resolve prevout transactions and block heights by batched requests in parallel,
+//the results are cached
+type txResolver struct {
+	batch     *batchClient
+	cache     *txCache
+	batchSize int
+	workers   int
+}
+
+func newTxResolver(batch *batchClient, cache *txCache) *txResolver {
+	return &txResolver{batch: batch, cache: cache, batchSize: defaultBatchSize, workers: defaultResolveWorkers}
+}
+
+//newRPCResolver create a resolver with its own cache for one call
+func newRPCResolver(rpcParams *RPCParams) (*txResolver, error) {
+	batch, err := newBatchClient(rpcParams, defaultCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newTxResolver(batch, newTxCache(0, 0)), nil
+}
+
+//batchAll send reqs by batches of batchSize with a pool of workers, the results are in the order of reqs
+func (r *txResolver) batchAll(ctx context.Context, reqs []batchRequest) ([]batchResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type chunk struct {
+		start int
+		reqs  []batchRequest
+	}
+	chunks := make(chan chunk)
+	results := make([]batchResult, len(reqs))
+	var once sync.Once
+	var firstErr error
+
+	workers := r.workers
+	if n := (len(reqs) + r.batchSize - 1) / r.batchSize; n < workers {
+		workers = n
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				chunkResults, err := r.batch.Call(ctx, c.reqs)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					continue
+				}
+				copy(results[c.start:], chunkResults) //the chunks not overlap
+			}
+		}()
+	}
+send:
+	for start := 0; start < len(reqs); start += r.batchSize {
+		end := start + r.batchSize
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		select {
+		case chunks <- chunk{start: start, reqs: reqs[start:end]}:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(chunks)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+//getTxs return the verbose transactions of txids, from the cache if possible
+func (r *txResolver) getTxs(ctx context.Context, txids []string) (map[string]*btcjson.TxRawResult, error) {
+	txs := map[string]*btcjson.TxRawResult{}
+	var reqs []batchRequest
+	for _, txid := range txids {
+		if _, exist := txs[txid]; exist {
+			continue
+		}
+		if tx := r.cache.getTx(txid); tx != nil {
+			txs[txid] = tx
+			continue
+		}
+		txs[txid] = nil //requested
+		reqs = append(reqs, batchRequest{Method: "getrawtransaction", Params: []interface{}{txid, 1}})
+	}
+	results, err := r.batchAll(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		var tx btcjson.TxRawResult
+		if err := json.Unmarshal(result.Result, &tx); err != nil {
+			return nil, kindError(ErrKindNode, "Unmarshal getrawtransaction failed", err)
+		}
+		txid := reqs[i].Params[0].(string)
+		if tx.Txid != txid {
+			return nil, newError(ErrKindNode, fmt.Sprintf("getrawtransaction %s return tx %s", txid, tx.Txid))
+		}
+		r.cache.addTx(&tx)
+		txs[txid] = &tx
+	}
+	return txs, nil
+}
+
+//getRawTxs return the txs of hashes in order, the txs not found are skipped, as removed from the mempool
+func (r *txResolver) getRawTxs(ctx context.Context, hashes []*chainhash.Hash) ([]*wire.MsgTx, error) {
+	reqs := make([]batchRequest, len(hashes))
+	for i, hash := range hashes {
+		reqs[i] = batchRequest{Method: "getrawtransaction", Params: []interface{}{hash.String(), 0}}
+	}
+	results, err := r.batchAll(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+	var txs []*wire.MsgTx
+	for i, result := range results {
+		if result.Err != nil {
+			if rpcErr, ok := Cause(result.Err).(*btcjson.RPCError); ok && rpcErr.Code == btcjson.ErrRPCNoTxInfo {
+				continue
+			}
+			return nil, result.Err
+		}
+		var txHex string
+		if err := json.Unmarshal(result.Result, &txHex); err != nil {
+			return nil, kindError(ErrKindNode, "Unmarshal getrawtransaction failed", err)
+		}
+		raw, err := hex.DecodeString(txHex)
+		if err != nil {
+			return nil, kindError(ErrKindNode, "DecodeString getrawtransaction failed", err)
+		}
+		var tx wire.MsgTx
+		if err := tx.Deserialize(bytes.NewReader(raw)); err != nil {
+			return nil, kindError(ErrKindNode, "Deserialize getrawtransaction failed", err)
+		}
+		if tx.TxHash() != *hashes[i] {
+			return nil, newError(ErrKindNode, fmt.Sprintf("getrawtransaction %s return tx %s", hashes[i], tx.TxHash()))
+		}
+		txs = append(txs, &tx)
+	}
+	return txs, nil
+}
+
+//getBlockHeights return the heights of the blocks, only main chain blocks are cached
+func (r *txResolver) getBlockHeights(ctx context.Context, hashes []string) (map[string]int64, error) {
+	heights := map[string]int64{}
+	var reqs []batchRequest
+	for _, hash := range hashes {
+		if _, exist := heights[hash]; exist {
+			continue
+		}
+		if block := r.cache.getBlock(hash); block != nil {
+			heights[hash] = block.height
+			continue
+		}
+		heights[hash] = -1 //requested
+		reqs = append(reqs, batchRequest{Method: "getblockheader", Params: []interface{}{hash, true}})
+	}
+	results, err := r.batchAll(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		var header btcjson.GetBlockHeaderVerboseResult
+		if err := json.Unmarshal(result.Result, &header); err != nil {
+			return nil, kindError(ErrKindNode, "Unmarshal getblockheader failed", err)
+		}
+		hash := reqs[i].Params[0].(string)
+		if header.Confirmations >= 0 { //-1 if orphaned
+			r.cache.addBlock(hash, int64(header.Height), header.PreviousHash)
+		}
+		heights[hash] = int64(header.Height)
+	}
+	return heights, nil
+}
+
+//getTxIndexes return the positions of txids in their blocks, blockHashes[i] is the block of txids[i].
+//The positions are read from gettxoutproof of each tx, which is small and needs no -txindex.
+//At most maxTxIndexProofs txs are resolved per call, the last ones, the positions of the others are unknown.
+func (r *txResolver) getTxIndexes(ctx context.Context, txids, blockHashes []string) (map[string]uint, error) {
+	indexes := map[string]uint{}
+	var reqs []batchRequest
+	for i := len(txids) - 1; i >= 0; i-- {
+		txid, hash := txids[i], blockHashes[i]
+		if _, exist := indexes[txid]; exist || hash == "" {
+			continue
+		}
+		if index, ok := r.cache.getTxIndex(hash, txid); ok {
+			indexes[txid] = index
+			continue
+		}
+		if len(reqs) >= maxTxIndexProofs {
+			continue
+		}
+		indexes[txid] = 0 //requested
+		reqs = append(reqs, batchRequest{Method: "gettxoutproof", Params: []interface{}{[]string{txid}, hash}})
+	}
+	results, err := r.batchAll(ctx, reqs)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		var proofHex string
+		if err := json.Unmarshal(result.Result, &proofHex); err != nil {
+			return nil, kindError(ErrKindNode, "Unmarshal gettxoutproof failed", err)
+		}
+		data, err := hex.DecodeString(proofHex)
+		if err != nil {
+			return nil, kindError(ErrKindNode, "DecodeString gettxoutproof failed", err)
+		}
+		proof, err := ParseTxOutProof(data)
+		if err != nil {
+			return nil, kindError(ErrKindNode, "ParseTxOutProof failed", err)
+		}
+		txid, hash := reqs[i].Params[0].([]string)[0], reqs[i].Params[1].(string)
+		if proof.TxID.String() != txid {
+			return nil, newError(ErrKindNode, fmt.Sprintf("gettxoutproof %s return the proof of %s", txid, proof.TxID))
+		}
+		indexes[txid] = uint(proof.TxIndex)
+		r.cache.addTxIndex(hash, txid, indexes[txid])
+	}
+	return indexes, nil
+}
+
+func (r *txResolver) call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	results, err := r.batch.Call(ctx, []batchRequest{{Method: method, Params: params}})
+	if err != nil {
+		return err
+	}
+	if results[0].Err != nil {
+		return results[0].Err
+	}
+	if err := json.Unmarshal(results[0].Result, result); err != nil {
+		return kindError(ErrKindNode, "Unmarshal "+method+" failed", err)
+	}
+	return nil
+}
+
+//checkReorg compare the best block with the last seen one, if the last seen one is orphaned,
+//remove it and its orphaned ancestors from the cache
+func (r *txResolver) checkReorg(ctx context.Context) error {
+	var tip string
+	if err := r.call(ctx, "getbestblockhash", nil, &tip); err != nil {
+		return err
+	}
+	old := r.cache.setTip(tip)
+	if old == "" || old == tip {
+		return nil
+	}
+	hash := old
+	for depth := 0; hash != ""; depth++ {
+		if depth >= maxReorgDepth {
+			r.cache.clear()
+			return nil
+		}
+		var header btcjson.GetBlockHeaderVerboseResult
+		err := r.call(ctx, "getblockheader", []interface{}{hash, true}, &header)
+		if err != nil && ErrorKindOf(err) != ErrKindNotFound {
+			return err
+		}
+		if err == nil && header.Confirmations >= 0 { //in the main chain, the reorg is ended
+			return nil
+		}
+		prevHash := r.cache.removeBlock(hash)
+		if err == nil {
+			prevHash = header.PreviousHash
+		}
+		hash = prevHash
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package btcadaptor
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/palletone/adaptor"
+)
+
+func TestGetAddrTxHistoryBatched(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+
+	hash := func(n int) string { return fmt.Sprintf("%064x", n) }
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addrB := "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"
+	vout := func(n uint32, value float64, addr string) btcjson.Vout {
+		return btcjson.Vout{Value: value, N: n, ScriptPubKey: btcjson.ScriptPubKeyResult{Addresses: []string{addr}}}
+	}
+	prevTx := btcjson.TxRawResult{Txid: hash(1), BlockHash: hash(100), Confirmations: 20,
+		Vout: []btcjson.Vout{vout(0, 1, addrA)}}
+	tx1 := btcjson.SearchRawTransactionsResult{Txid: hash(2), BlockHash: hash(101), Confirmations: 10,
+		Vin:  []btcjson.VinPrevOut{{Txid: hash(1), Vout: 0}}, //the prevout is resolved by getrawtransaction
+		Vout: []btcjson.Vout{vout(0, 0.25, addrB), vout(1, 0.625, addrA)}}
+	tx2 := btcjson.SearchRawTransactionsResult{Txid: hash(3), BlockHash: hash(102), Confirmations: 3,
+		Vin:  []btcjson.VinPrevOut{{Txid: hash(2), Vout: 1, PrevOut: &btcjson.PrevOut{Addresses: []string{addrA}, Value: 0.625}}},
+		Vout: []btcjson.Vout{vout(0, 0.5, addrB), vout(1, 0.0625, addrA)}}
+
+	var mtx sync.Mutex
+	tip := hash(102)
+	headers := map[string]btcjson.GetBlockHeaderVerboseResult{
+		hash(100): {Hash: hash(100), Height: 99, Confirmations: 21},
+		hash(101): {Hash: hash(101), Height: 100, Confirmations: 10, PreviousHash: hash(100)},
+		hash(102): {Hash: hash(102), Height: 107, Confirmations: 3, PreviousHash: hash(101)},
+	}
+	var txCalls, headerCalls int32
+	node.handle("searchrawtransactions", func(params []json.RawMessage) (interface{}, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return []btcjson.SearchRawTransactionsResult{tx1, tx2}, nil
+	})
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		atomic.AddInt32(&txCalls, 1)
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		switch txid {
+		case prevTx.Txid:
+			return prevTx, nil
+		case tx1.Txid:
+			return btcjson.TxRawResult{Txid: tx1.Txid, BlockHash: tx1.BlockHash, Confirmations: tx1.Confirmations,
+				Vin: []btcjson.Vin{{Txid: hash(1), Vout: 0}}, Vout: tx1.Vout}, nil
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No information available about transaction")
+	})
+	node.handle("getblockheader", func(params []json.RawMessage) (interface{}, error) {
+		atomic.AddInt32(&headerCalls, 1)
+		var blockHash string
+		json.Unmarshal(params[0], &blockHash)
+		mtx.Lock()
+		defer mtx.Unlock()
+		if header, ok := headers[blockHash]; ok {
+			return header, nil
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCBlockNotFound, "Block not found")
+	})
+	var proofCalls int32
+	node.handle("gettxoutproof", func(params []json.RawMessage) (interface{}, error) {
+		atomic.AddInt32(&proofCalls, 1)
+		var txids []string
+		var blockHash string
+		json.Unmarshal(params[0], &txids)
+		json.Unmarshal(params[1], &blockHash)
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch {
+		case blockHash == tx1.BlockHash:
+			return txOutProof([]string{hash(10), tx1.Txid}, txids[0])
+		case blockHash == tx2.BlockHash:
+			return txOutProof([]string{hash(10), hash(11), tx2.Txid}, txids[0])
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCBlockNotFound, "Block not found")
+	})
+	node.handle("getbestblockhash", func(params []json.RawMessage) (interface{}, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return tip, nil
+	})
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	abtc.PoolConfig.Size = 1
+	defer abtc.Close()
+
+	history, err := abtc.GetAddrTxHistory(&adaptor.GetAddrTxHistoryInput{FromAddress: addrA})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.Count != 2 {
+		t.Fatalf("unexpected history count - got: %v, "+"want: %v", history.Count, 2)
+	}
+	want := []struct {
+		amount, fee int64
+		height      uint
+		stable      bool
+		index       uint
+	}{
+		{25000000, 12500000, 100, true, 1},
+		{50000000, 6250000, 107, false, 2},
+	}
+	for i, tx := range history.Txs {
+		if tx.Amount.Amount.Int64() != want[i].amount || tx.Fee.Amount.Int64() != want[i].fee {
+			t.Errorf("unexpected amount and fee of tx %d - got: %v %v, "+"want: %v %v",
+				i, tx.Amount.Amount, tx.Fee.Amount, want[i].amount, want[i].fee)
+		}
+		if tx.BlockHeight != want[i].height || tx.IsStable != want[i].stable || tx.TxIndex != want[i].index {
+			t.Errorf("unexpected height, stable and index of tx %d - got: %v %v %v, "+"want: %v %v %v",
+				i, tx.BlockHeight, tx.IsStable, tx.TxIndex, want[i].height, want[i].stable, want[i].index)
+		}
+	}
+	if txCalls != 1 || headerCalls != 2 {
+		t.Errorf("unexpected calls - got: %v %v, "+"want: %v %v", txCalls, headerCalls, 1, 2)
+	}
+
+	//the prevout and the heights are cached
+	if _, err := abtc.GetAddrTxHistory(&adaptor.GetAddrTxHistoryInput{FromAddress: addrA}); err != nil {
+		t.Fatal(err)
+	}
+	transfer, err := abtc.GetTransferTx(&adaptor.GetTransferTxInput{TxID: mustDecodeHex(tx1.Txid)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Tx.FromAddress != addrA || transfer.Tx.ToAddress != addrB || transfer.Tx.Fee.Amount.Int64() != 12500000 ||
+		transfer.Tx.BlockHeight != 100 || transfer.Tx.TxIndex != 1 {
+		t.Errorf("unexpected transfer - got: %v %v %v %v %v", transfer.Tx.FromAddress, transfer.Tx.ToAddress,
+			transfer.Tx.Fee.Amount, transfer.Tx.BlockHeight, transfer.Tx.TxIndex)
+	}
+	if txCalls != 2 || headerCalls != 2 || proofCalls != 2 { //only tx1 itself is requested
+		t.Errorf("unexpected calls - got: %v %v %v, "+"want: %v %v %v", txCalls, headerCalls, proofCalls, 2, 2, 2)
+	}
+	if atomic.LoadInt32(&node.batches) == 0 {
+		t.Errorf("expected batch requests")
+	}
+
+	//block 102 is orphaned, tx2 is mined in block 103 at the same height
+	mtx.Lock()
+	orphan := headers[hash(102)]
+	orphan.Confirmations = -1
+	headers[hash(102)] = orphan
+	headers[hash(103)] = btcjson.GetBlockHeaderVerboseResult{Hash: hash(103), Height: 107, Confirmations: 1, PreviousHash: hash(101)}
+	tip = hash(103)
+	tx2.BlockHash = hash(103)
+	mtx.Unlock()
+	if _, err := abtc.GetAddrTxHistory(&adaptor.GetAddrTxHistoryInput{FromAddress: addrA}); err != nil {
+		t.Fatal(err)
+	}
+	cache := abtc.nodeSet().nodes[0].resolver.cache
+	if cache.getBlock(hash(102)) != nil {
+		t.Errorf("orphaned block is not removed from cache")
+	}
+	if cache.getBlock(hash(101)) == nil || cache.getBlock(hash(103)) == nil {
+		t.Errorf("main chain blocks should be cached")
+	}
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+//txOutProof return the result of gettxoutproof of txid in the block of txids
+func txOutProof(txids []string, txid string) (string, error) {
+	level := make([]chainhash.Hash, len(txids))
+	index := -1
+	for i, id := range txids {
+		hash, _ := chainhash.NewHashFromStr(id)
+		level[i] = *hash
+		if id == txid {
+			index = i
+		}
+	}
+	if index < 0 {
+		return "", btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Not all transactions found in specified or retrieved block")
+	}
+	proof := merkleProofOf(wire.BlockHeader{}, level, index)
+	proof.Header.MerkleRoot, _ = proof.Root()
+	return hex.EncodeToString(proof.Bytes()), nil
+}
@@ -0,0 +1,92 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	defaultMaxAttempts    = 3
+	defaultInitialBackoff = 200 * time.Millisecond
+	defaultMaxBackoff     = 5 * time.Second
+	defaultMultiplier     = 2
+)
+
+//RetryConfig is the config of retrying transient errors, zero value means default
+type RetryConfig struct {
+	MaxAttempts    int           //attempts including the first one, 1 to disable retry
+	InitialBackoff time.Duration //wait before the second attempt
+	MaxBackoff     time.Duration //the maximum wait between attempts
+	Multiplier     float64       //the wait is multiplied after each attempt
+}
+
+func (cfg RetryConfig) withDefault() RetryConfig {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.Multiplier < 1 {
+		cfg.Multiplier = defaultMultiplier
+	}
+	return cfg
+}
+
+//backoff return the wait before the attempt, attempt starts from 1
+func (cfg RetryConfig) backoff(attempt int) time.Duration {
+	wait := float64(cfg.InitialBackoff)
+	for i := 2; i < attempt; i++ {
+		wait *= cfg.Multiplier
+		if wait >= float64(cfg.MaxBackoff) {
+			return cfg.MaxBackoff
+		}
+	}
+	return time.Duration(wait)
+}
+
+//retry call f until it succeed, return a not retryable error, or the attempts are used up.
+//The wait between attempts is interrupted by ctx.
+func retry(ctx context.Context, cfg RetryConfig, f func(attempt int) error) error {
+	cfg = cfg.withDefault()
+	var err error
+	for attempt := 1; attempt <= cfg.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(cfg.backoff(attempt))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return kindError(ErrKindCanceled, "retry canceled, last error : "+err.Error(), ctx.Err())
+			case <-timer.C:
+			}
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		err = f(attempt)
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+	}
+	return err
+}
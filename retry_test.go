@@ -0,0 +1,132 @@
+package btcadaptor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+
+	"github.com/palletone/adaptor"
+)
+
+func TestRetry(t *testing.T) {
+	cfg := RetryConfig{MaxAttempts: 4, InitialBackoff: time.Millisecond, MaxBackoff: 3 * time.Millisecond}
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"transient", newError(ErrKindTransient, "timeout"), 4},
+		{"rpc warmup", wrapError("GetBlockCount failed", btcjson.NewRPCError(-28, "Loading block index")), 4},
+		{"connection", wrapError("GetBlockCount failed", errors.New("connection refused")), 4},
+		{"bad address", kindError(ErrKindBadAddress, "DecodeAddress failed", errors.New("checksum mismatch")), 1},
+		{"rejected", wrapError("SendRawTransaction failed", btcjson.NewRPCError(btcjson.ErrRPCVerify, "TX rejected")), 1},
+		{"not classified", errors.New("Not support send 2+ tx "), 1},
+	}
+	for _, test := range tests {
+		attempts := 0
+		err := retry(context.Background(), cfg, func(attempt int) error {
+			attempts++
+			return test.err
+		})
+		if err != test.err {
+			t.Errorf("%s: unexpected error - got: %v, "+"want: %v", test.name, err, test.err)
+		}
+		if attempts != test.attempts {
+			t.Errorf("%s: unexpected attempts - got: %v, "+"want: %v", test.name, attempts, test.attempts)
+		}
+	}
+
+	backoffs := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond, 3 * time.Millisecond}
+	for i, want := range backoffs {
+		if got := cfg.withDefault().backoff(i + 2); got != want {
+			t.Errorf("unexpected backoff of attempt %d - got: %v, "+"want: %v", i+2, got, want)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg.InitialBackoff = time.Hour
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := retry(ctx, cfg, func(attempt int) error {
+		return newError(ErrKindTransient, "timeout")
+	})
+	if ErrorKindOf(err) != ErrKindCanceled || Cause(err) != context.Canceled {
+		t.Errorf("unexpected error - got: %v, "+"want: canceled", err)
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{nil, ErrKindUnknown},
+		{context.DeadlineExceeded, ErrKindCanceled},
+		{btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No information available about transaction"), ErrKindNotFound},
+		{btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "No such mempool or blockchain transaction"), ErrKindNotFound},
+		{btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Invalid address"), ErrKindInvalidParams},
+		{btcjson.NewRPCError(btcjson.ErrRPCInvalidAddressOrKey, "Invalid Bitcoin address: 1abc"), ErrKindInvalidParams},
+		{btcjson.NewRPCError(btcjson.ErrRPCTxAlreadyInChain, "transaction already in block chain"), ErrKindInvalidTx},
+		{btcjson.NewRPCError(btcjson.ErrRPCInvalidParameter, "Invalid parameter"), ErrKindInvalidParams},
+		{btcjson.NewRPCError(btcjson.ErrRPCMisc, "misc"), ErrKindNode},
+		{wrapError("a", wrapError("b", btcjson.NewRPCError(btcjson.ErrRPCClientInInitialDownload, "ibd"))), ErrKindTransient},
+	}
+	for _, test := range tests {
+		if kind := ErrorKindOf(test.err); kind != test.kind {
+			t.Errorf("unexpected kind of %v - got: %v, "+"want: %v", test.err, kind, test.kind)
+		}
+	}
+	err := wrapError("SendRawTransaction failed", btcjson.NewRPCError(btcjson.ErrRPCVerify, "TX rejected"))
+	if err.Error() != "SendRawTransaction failed : -25: TX rejected" {
+		t.Errorf("unexpected message - got: %v", err)
+	}
+}
+
+func TestSendTransactionRetry(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	var sent int32
+	node.handle("sendrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		if atomic.AddInt32(&sent, 1) == 1 {
+			time.Sleep(300 * time.Millisecond) //the first attempt timeout, but the tx is accepted
+			return nil, nil
+		}
+		return nil, btcjson.NewRPCError(btcjson.ErrRPCVerify, "TX rejected: already have transaction")
+	})
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	abtc.PoolConfig = ClientPoolConfig{Size: 1, CallTimeout: 100 * time.Millisecond, HealthInterval: -1}
+	abtc.Retry = RetryConfig{InitialBackoff: time.Millisecond}
+	defer abtc.Close()
+
+	tx, _ := hex.DecodeString("01000000016af6e1f77fbff03a3439d465c4ceb7871f4722dd1463e745129a21bf80670f49000000000000000000020000000000000000256a235031397a34723747394d705a7461594d5a63415457696e5477586547426a376657546420f40e00000000001976a9140f08e55bcfc207632d2dcfc3d4db4b6d8d91b22e88ac00000000")
+	output, err := abtc.SendTransaction(&adaptor.SendTransactionInput{Transaction: tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := abtc.CalcTxHash(&adaptor.CalcTxHashInput{Transaction: tx})
+	if hex.EncodeToString(output.TxID) != hex.EncodeToString(hash.Hash) {
+		t.Errorf("unexpected txid - got: %x, "+"want: %x", output.TxID, hash.Hash)
+	}
+
+	//a rejected tx is not retried
+	atomic.StoreInt32(&sent, 1)
+	if _, err := abtc.SendTransaction(&adaptor.SendTransactionInput{Transaction: tx}); ErrorKindOf(err) != ErrKindInvalidTx {
+		t.Errorf("unexpected error - got: %v, "+"want: invalid tx", err)
+	}
+	if calls := atomic.LoadInt32(&sent); calls != 2 {
+		t.Errorf("unexpected calls - got: %v, "+"want: %v", calls, 2)
+	}
+
+	//canceled by ctx
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := abtc.GetBlockInfoCtx(ctx, &adaptor.GetBlockInfoInput{Latest: true}); err != context.Canceled {
+		t.Errorf("unexpected error - got: %v, "+"want: %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+//batchRequest is one request of a batch
+type batchRequest struct {
+	Method string
+	Params []interface{}
+}
+
+//batchResult is the result of one request, Err is set if the node return an error for it
+type batchResult struct {
+	Result json.RawMessage
+	Err    error
+}
+
+//batchClient send json-rpc batch requests (a json array) by HTTP POST,
+//the vendored rpcclient sends one request per HTTP request
+type batchClient struct {
+	url        string
+	user       string
+	pass       string
+	httpClient *http.Client
+}
+
+func newBatchClient(rpcParams *RPCParams, timeout time.Duration) (*batchClient, error) {
+	connCfg, err := newConnConfig(rpcParams)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{}
+	scheme := "http"
+	if !connCfg.DisableTLS {
+		scheme = "https"
+		tlsConfig := &tls.Config{}
+		if len(connCfg.Certificates) > 0 {
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(connCfg.Certificates)
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &batchClient{
+		url:        scheme + "://" + connCfg.Host,
+		user:       connCfg.User,
+		pass:       connCfg.Pass,
+		httpClient: &http.Client{Transport: transport, Timeout: timeout},
+	}, nil
+}
+
+//Call send reqs in one HTTP request, the results are in the order of reqs
+func (bc *batchClient) Call(ctx context.Context, reqs []batchRequest) ([]batchResult, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	batch := make([]*btcjson.Request, len(reqs))
+	for i, req := range reqs {
+		params := req.Params
+		if params == nil {
+			params = []interface{}{}
+		}
+		rawParams := make([]json.RawMessage, len(params))
+		for j, param := range params {
+			rawParam, err := json.Marshal(param)
+			if err != nil {
+				return nil, kindError(ErrKindInvalidParams, "Marshal param failed", err)
+			}
+			rawParams[j] = rawParam
+		}
+		batch[i] = &btcjson.Request{Jsonrpc: "1.0", Method: req.Method, Params: rawParams, ID: uint64(i)}
+	}
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "Marshal batch failed", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", bc.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.SetBasicAuth(bc.user, bc.pass)
+	resp, err := bc.httpClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, wrapError("batch request failed", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, wrapError("read batch response failed", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newError(ErrKindTransient, fmt.Sprintf("batch request failed : status %d %s", resp.StatusCode, respBody))
+	}
+
+	var responses []struct {
+		Result json.RawMessage   `json:"result"`
+		Error  *btcjson.RPCError `json:"error"`
+		ID     *uint64           `json:"id"`
+	}
+	if err := json.Unmarshal(respBody, &responses); err != nil {
+		return nil, kindError(ErrKindNode, "Unmarshal batch response failed", err)
+	}
+	results := make([]batchResult, len(reqs))
+	received := make([]bool, len(reqs))
+	for _, response := range responses {
+		if response.ID == nil || *response.ID >= uint64(len(reqs)) {
+			return nil, newError(ErrKindNode, "batch response with unknown id")
+		}
+		id := *response.ID
+		received[id] = true
+		if response.Error != nil {
+			results[id].Err = wrapError(reqs[id].Method+" failed", response.Error)
+			continue
+		}
+		results[id].Result = response.Result
+	}
+	for i := range received {
+		if !received[i] {
+			return nil, newError(ErrKindNode, fmt.Sprintf("batch response miss %s of id %d", reqs[i].Method, i))
+		}
+	}
+	return results, nil
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
 
@@ -96,7 +97,43 @@ type signatureError struct {
 	Error      error
 }
 
-func signTransactionReal(tx *wire.MsgTx, hashType txscript.SigHashType,
+//signTxOutput sign the input of amt, with SIGHASH_FORKID on BCH networks
+func signTxOutput(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int, amt int64, pkScript []byte,
+	hashType txscript.SigHashType, kdb txscript.KeyDB, sdb txscript.ScriptDB, previousScript []byte) ([]byte, error) {
+	if IsBCH(chainParams) {
+		return txscript.SignTxOutputForkID(chainParams, tx, idx, amt, pkScript, hashType, kdb, sdb, previousScript)
+	}
+	return txscript.SignTxOutput(chainParams, tx, idx, pkScript, hashType, kdb, sdb, previousScript)
+}
+
+//scriptFlags return the flags the inputs of the network are verified by
+func scriptFlags(chainParams *chaincfg.Params) txscript.ScriptFlags {
+	if IsBCH(chainParams) {
+		return txscript.BCHVerifyFlags
+	}
+	return txscript.StandardVerifyFlags
+}
+
+//verifyInput execute the scripts of the input of amt with the flags of the network
+func verifyInput(chainParams *chaincfg.Params, pkScript []byte, tx *wire.MsgTx, idx int, amt int64) error {
+	vm, err := txscript.NewEngine(pkScript, tx, idx, scriptFlags(chainParams), nil, nil, amt)
+	if err != nil {
+		return err
+	}
+	return vm.Execute()
+}
+
+//checkAmounts check there is an amount of each input on BCH networks, where the amounts are signed
+func checkAmounts(tx *wire.MsgTx, amounts []int64, chainParams *chaincfg.Params) error {
+	if IsBCH(chainParams) && len(amounts) != len(tx.TxIn) {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("%d amounts of %d inputs, the amounts of the inputs are "+
+			"signed on %s", len(amounts), len(tx.TxIn), chainParams.Name))
+	}
+	return nil
+}
+
+//amounts are of the inputs of tx, which are only needed on BCH networks
+func signTransactionReal(tx *wire.MsgTx, hashType txscript.SigHashType, amounts []int64,
 	additionalPrevScripts map[wire.OutPoint][]byte,
 	additionalKeysByAddress map[string]*btcutil.WIF,
 	p2shRedeemScriptsByAddress map[string][]byte, chainParams *chaincfg.Params) []signatureError {
@@ -106,6 +143,10 @@ func signTransactionReal(tx *wire.MsgTx, hashType txscript.SigHashType,
 	//addrmgrNs := dbtx.ReadBucket(waddrmgrNamespaceKey)
 	//txmgrNs := dbtx.ReadBucket(wtxmgrNamespaceKey)
 	for i, txIn := range tx.TxIn {
+		var amt int64
+		if i < len(amounts) {
+			amt = amounts[i]
+		}
 		prevOutScript, ok := additionalPrevScripts[txIn.PreviousOutPoint]
 		if !ok {
 			/*prevHash := &txIn.PreviousOutPoint.Hash
@@ -155,8 +196,8 @@ func signTransactionReal(tx *wire.MsgTx, hashType txscript.SigHashType,
 		if (hashType&txscript.SigHashSingle) !=
 			txscript.SigHashSingle || i < len(tx.TxOut) {
 
-			script, err := txscript.SignTxOutput(chainParams,
-				tx, i, prevOutScript, hashType, getKey,
+			script, err := signTxOutput(chainParams,
+				tx, i, amt, prevOutScript, hashType, getKey,
 				getScript, txIn.SignatureScript)
 			// Failure to sign isn't an error, it just means that
 			// the tx isn't complete.
@@ -172,11 +213,7 @@ func signTransactionReal(tx *wire.MsgTx, hashType txscript.SigHashType,
 
 		// Either it was already signed or we just signed it.
 		// Find out if it is completely satisfied or still needs more.
-		vm, err := txscript.NewEngine(prevOutScript, tx, i,
-			txscript.StandardVerifyFlags, nil, nil, 0)
-		if err == nil {
-			err = vm.Execute()
-		}
+		err := verifyInput(chainParams, prevOutScript, tx, i, amt)
 		if err != nil {
 			signErrors = append(signErrors, signatureError{
 				InputIndex: uint32(i),
@@ -190,6 +227,13 @@ func signTransactionReal(tx *wire.MsgTx, hashType txscript.SigHashType,
 }
 
 func SignTransaction(input *adaptor.SignTransactionInput, netID int) (*adaptor.SignTransactionOutput, error) {
+	return SignTransactionAmounts(input, nil, netID)
+}
+
+//SignTransactionAmounts is SignTransaction of the amounts of the inputs, which are required on BCH networks,
+//the Amounts of the Extra are used if amounts is nil
+func SignTransactionAmounts(input *adaptor.SignTransactionInput, amounts []int64,
+	netID int) (*adaptor.SignTransactionOutput, error) {
 	//check empty
 	if 0 == len(input.Transaction) {
 		return nil, errors.New("the Transaction is empty")
@@ -197,10 +241,19 @@ func SignTransaction(input *adaptor.SignTransactionInput, netID int) (*adaptor.S
 	if 0 == len(input.PrivateKey) {
 		return nil, errors.New("the PrivateKey is empty")
 	}
-	extraLen := len(input.Extra)
-	if 0 == extraLen {
+	extra, err := DecodeExtra(input.Extra)
+	if err != nil {
+		return nil, err
+	}
+	if extra.Address == "" && len(extra.RedeemScript) == 0 {
 		return nil, errors.New("the Extra is empty, must be oneSigAddr or multiSigRedeem")
 	}
+	if len(extra.WitnessScript) != 0 {
+		return nil, newError(ErrKindInvalidParams, "the witnessScript of the Extra is not supported by signing")
+	}
+	if amounts == nil {
+		amounts = extra.Amounts
+	}
 
 	//chainnet
 	realNet := GetNet(netID)
@@ -225,18 +278,16 @@ func SignTransaction(input *adaptor.SignTransactionInput, netID int) (*adaptor.S
 		return nil, fmt.Errorf("Deserialize tx failed : %s", err.Error())
 	}
 
-	//sign the UTXO hash, must know RedeemHex which contains in RawTxInput
-	isRedeem := false
-	if extraLen > 35 {
-		isRedeem = true
+	if err := checkAmounts(&tx, amounts, realNet); err != nil {
+		return nil, err
 	}
+
+	//sign the UTXO hash, must know RedeemHex which contains in RawTxInput
+	isRedeem := len(extra.RedeemScript) != 0
 	scripts := make(map[string][]byte)
 	var scriptPkScript []byte
 	if isRedeem {
-		redeem, err := hex.DecodeString(string(input.Extra))
-		if err != nil {
-			return nil, fmt.Errorf("hex.DecodeString redeem in the Extra failed : %s", err.Error())
-		}
+		redeem := extra.RedeemScript
 		//get multisig payScript
 		scriptAddr, err := btcutil.NewAddressScriptHash(redeem, realNet)
 		if err != nil {
@@ -248,13 +299,13 @@ func SignTransaction(input *adaptor.SignTransactionInput, netID int) (*adaptor.S
 			return nil, fmt.Errorf("PayToAddrScript redeem failed : %s", err.Error())
 		}
 	} else {
-		if addrStr != string(input.Extra) {
-			return nil, fmt.Errorf("address in the Extra is not match with the PrivateKey")
-		}
-		address, err := btcutil.DecodeAddress(string(input.Extra), realNet)
+		address, err := DecodeAddress(extra.Address, realNet)
 		if err != nil {
 			return nil, fmt.Errorf("DecodeAddress oneAddr failed : %s", err.Error())
 		}
+		if addrStr != address.EncodeAddress() {
+			return nil, fmt.Errorf("address in the Extra is not match with the PrivateKey")
+		}
 		// Create a public key script that pays to the address.
 		scriptPkScript, err = txscript.PayToAddrScript(address)
 		if err != nil {
@@ -268,7 +319,7 @@ func SignTransaction(input *adaptor.SignTransactionInput, netID int) (*adaptor.S
 		inputs[wire.OutPoint{Hash: txinOne.PreviousOutPoint.Hash, Index: txinOne.PreviousOutPoint.Index}] = scriptPkScript
 	}
 
-	signErrs := signTransactionReal(&tx, txscript.SigHashAll, inputs, keys, scripts, realNet)
+	signErrs := signTransactionReal(&tx, txscript.SigHashAll, amounts, inputs, keys, scripts, realNet)
 	if !isRedeem && len(signErrs) != 0 {
 		return nil, fmt.Errorf("signTransactionReal failed : not Complete")
 	}
@@ -341,29 +392,33 @@ func SignTransaction(input *adaptor.SignTransactionInput, netID int) (*adaptor.S
 //}
 
 func SendTransaction(input *adaptor.SendTransactionInput, rpcParams *RPCParams) (*adaptor.SendTransactionOutput, error) {
+	//get rpc client
+	client, err := GetClient(rpcParams)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Shutdown()
+
+	return sendTransactionByClient(input, client)
+}
+
+func sendTransactionByClient(input *adaptor.SendTransactionInput, client *rpcclient.Client) (*adaptor.SendTransactionOutput, error) {
 	//check empty string
 	if 0 == len(input.Transaction) {
-		return nil, errors.New("the Transaction is empty")
+		return nil, newError(ErrKindInvalidParams, "the Transaction is empty")
 	}
 
 	//deserialize to MsgTx
 	var tx wire.MsgTx
 	err := tx.Deserialize(bytes.NewReader(input.Transaction))
 	if err != nil {
-		return nil, fmt.Errorf("Deserialize failed : %s", err.Error())
+		return nil, kindError(ErrKindInvalidTx, "Deserialize failed", err)
 	}
 
-	//get rpc client
-	client, err := GetClient(rpcParams)
-	if err != nil {
-		return nil, err
-	}
-	defer client.Shutdown()
-
 	//send to network
 	hashTX, err := client.SendRawTransaction(&tx, false) //BTC API
 	if err != nil {
-		return nil, fmt.Errorf("SendRawTransaction failed : %s", err.Error())
+		return nil, wrapError("SendRawTransaction failed", err)
 	}
 
 	//result for return
@@ -375,22 +430,32 @@ func SendTransaction(input *adaptor.SendTransactionInput, rpcParams *RPCParams)
 }
 
 func BindTxAndSignature(input *adaptor.BindTxAndSignatureInput, netID int) (*adaptor.BindTxAndSignatureOutput, error) {
+	return BindTxAndSignatureAmounts(input, nil, netID)
+}
+
+//BindTxAndSignatureAmounts is BindTxAndSignature of the amounts of the inputs, which are required on BCH networks,
+//the Amounts of the Extra are used if amounts is nil
+func BindTxAndSignatureAmounts(input *adaptor.BindTxAndSignatureInput, amounts []int64,
+	netID int) (*adaptor.BindTxAndSignatureOutput, error) {
 	//check empty string
 	if 0 == len(input.SignedTxs) {
 		return nil, errors.New("Params error : NO Merge TransactionHexs.")
 	}
-	if 0 == len(input.Extra) {
+	extra, err := DecodeExtra(input.Extra)
+	if err != nil {
+		return nil, err
+	}
+	if 0 == len(extra.RedeemScript) {
 		return nil, errors.New("the Extra is empty, must be multiSigRedeem")
 	}
+	if amounts == nil {
+		amounts = extra.Amounts
+	}
 
 	//chainnet
 	realNet := GetNet(netID)
 
-	//decode redeem's hexString to bytes
-	redeem, err := hex.DecodeString(string(input.Extra))
-	if err != nil {
-		return nil, fmt.Errorf("hex.DecodeString redeem in the Extra failed : %s", err.Error())
-	}
+	redeem := extra.RedeemScript
 	//get addresses an n of multisig redeem
 	_, addresses, nrequired, err := txscript.ExtractPkScriptAddrs(redeem, realNet)
 	if err != nil {
@@ -412,6 +477,10 @@ func BindTxAndSignature(input *adaptor.BindTxAndSignatureInput, netID int) (*ada
 	if err != nil {
 		return nil, fmt.Errorf("Deserialize tx failed : %s", err.Error())
 	}
+	if err := checkAmounts(&tx, amounts, realNet); err != nil {
+		return nil, err
+	}
+	isBCH := IsBCH(realNet)
 
 	//deal merge txs
 	var txs []wire.MsgTx
@@ -439,19 +508,22 @@ func BindTxAndSignature(input *adaptor.BindTxAndSignatureInput, netID int) (*ada
 		}
 
 		//
-		script, doneSigs := txscript.MergeMultiSigScript(&tx, i, addresses, nrequired, redeem, sigScripts)
+		var amt int64
+		var script []byte
+		var doneSigs int
+		if isBCH {
+			amt = amounts[i]
+			script, doneSigs = txscript.MergeMultiSigScriptForkID(&tx, i, amt, addresses, nrequired, redeem, sigScripts)
+		} else {
+			script, doneSigs = txscript.MergeMultiSigScript(&tx, i, addresses, nrequired, redeem, sigScripts)
+		}
 		if doneSigs > 0 {
 			tx.TxIn[i].SignatureScript = script
 		}
 
 		// Either it was already signed or we just signed it.
 		// Find out if it is completely satisfied or still needs more.
-		vm, err := txscript.NewEngine(scriptPkScript, &tx, i,
-			txscript.StandardVerifyFlags, nil, nil, 0)
-		if err == nil {
-			err = vm.Execute()
-		}
-		if err != nil {
+		if err := verifyInput(realNet, scriptPkScript, &tx, i, amt); err != nil {
 			return nil, fmt.Errorf("signTransactionReal failed : not Complete")
 		}
 	}
@@ -544,7 +616,7 @@ func MultisignOneByOne(prevTxHash string, index uint,
 
 	//
 	var recvAmount = amount - fee
-	addr, err := btcutil.DecodeAddress(recvAddress, realNet)
+	addr, err := DecodeAddress(recvAddress, realNet)
 	if err != nil {
 		return "", "", false
 	}
@@ -596,8 +668,8 @@ func MultisignOneByOne(prevTxHash string, index uint,
 
 	// Two part multisig, sign with one key then the other.
 	// Sign with the other key and merge
-	sigScript, err := txscript.SignTxOutput(realNet,
-		tx, 0, scriptPkScript, txscript.SigHashAll,
+	sigScript, err := signTxOutput(realNet,
+		tx, 0, amount, scriptPkScript, txscript.SigHashAll,
 		mkGetKey(map[string]addressToKey{
 			pub.EncodeAddress(): {key.PrivKey, true},
 		}), mkGetScript(map[string][]byte{
@@ -615,7 +687,11 @@ func MultisignOneByOne(prevTxHash string, index uint,
 	}
 
 	//
-	err = checkScripts(tx, 0, amount, scriptPkScript)
+	if IsBCH(realNet) {
+		err = verifyInput(realNet, scriptPkScript, tx, 0, amount)
+	} else {
+		err = checkScripts(tx, 0, amount, scriptPkScript)
+	}
 	if err != nil {
 		complete = false
 	} else {
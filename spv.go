@@ -0,0 +1,410 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	medianTimeBlocks = 11               //the number of previous blocks of median time past
+	maxTimeOffset    = 2 * time.Hour    //how far the timestamp of a header can be in the future
+	maxTimewarp      = 10 * time.Minute //BIP94: how far the first header of a period can be before the previous
+)
+
+//ErrLessWork is the cause of Connect failed with a valid fork which does not have more work than the main chain
+var ErrLessWork = errors.New("fork does not have more work")
+
+//HeaderChainConfig is the config of HeaderChain
+type HeaderChainConfig struct {
+	Params *chaincfg.Params //network rules, default mainnet
+	//Checkpoint is the trusted headers the chain starts from, the first is at Height, default the genesis block.
+	//To verify the retargeting and the median time past of the following headers, it should start at
+	//a retarget boundary (Height is a multiple of 2016 on mainnet) and have at least 11 headers.
+	Checkpoint []wire.BlockHeader
+	Height     int32
+	Now        func() time.Time //the clock to reject headers too far in the future, default time.Now
+}
+
+//HeaderChain verifies a chain of block headers like an SPV client: the linkage, the proof of work against bits,
+//bits by the difficulty retargeting of Params, and timestamps against the median time past.
+//Headers of a fork replace the main chain if it has more work. Regtest headers are not retargeted, as bitcoind,
+//and testnet4 follows BIP94.
+type HeaderChain struct {
+	cfg           HeaderChainConfig
+	noRetargeting bool
+	bip94         bool
+	interval      int32 //blocks per retarget
+
+	mtx     sync.RWMutex
+	headers []wire.BlockHeader //index is height - cfg.Height
+	hashes  []chainhash.Hash
+	work    []*big.Int //the work of the chain up to each header, from the checkpoint
+}
+
+//NewHeaderChain create a header chain from the checkpoint
+func NewHeaderChain(cfg HeaderChainConfig) (*HeaderChain, error) {
+	if cfg.Params == nil {
+		cfg.Params = &chaincfg.MainNetParams
+	}
+	if !ChainOf(cfg.Params).VerifyHeaders {
+		return nil, newError(ErrKindInvalidParams, "the proof of work or the difficulty adjustment of "+
+			cfg.Params.Name+" is not supported")
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	if len(cfg.Checkpoint) == 0 {
+		cfg.Checkpoint = []wire.BlockHeader{cfg.Params.GenesisBlock.Header}
+		cfg.Height = 0
+	}
+	hc := &HeaderChain{cfg: cfg, noRetargeting: cfg.Params.Net == chaincfg.RegressionNetParams.Net,
+		bip94: cfg.Params.Net == TestNet4Params.Net, interval: int32(cfg.Params.TargetTimespan / cfg.Params.TargetTimePerBlock)}
+	if cfg.Height > 0 { //the genesis block is at a retarget boundary and has no previous blocks
+		if !hc.noRetargeting && cfg.Height%hc.interval != 0 {
+			return nil, newError(ErrKindInvalidParams, fmt.Sprintf("checkpoint height %d is not a multiple of %d",
+				cfg.Height, hc.interval))
+		}
+		if len(cfg.Checkpoint) < medianTimeBlocks {
+			return nil, newError(ErrKindInvalidParams, fmt.Sprintf("checkpoint of %d headers, want at least %d",
+				len(cfg.Checkpoint), medianTimeBlocks))
+		}
+	}
+	work := new(big.Int)
+	for i := range cfg.Checkpoint {
+		header := cfg.Checkpoint[i]
+		if i > 0 && header.PrevBlock != hc.hashes[i-1] {
+			return nil, newError(ErrKindInvalidParams, fmt.Sprintf("checkpoint header %d is not linked", i))
+		}
+		work = new(big.Int).Add(work, calcWork(header.Bits))
+		hc.headers = append(hc.headers, header)
+		hc.hashes = append(hc.hashes, header.BlockHash())
+		hc.work = append(hc.work, work)
+	}
+	return hc, nil
+}
+
+//Tip return the hash and the height of the best header
+func (hc *HeaderChain) Tip() (chainhash.Hash, int32) {
+	hc.mtx.RLock()
+	defer hc.mtx.RUnlock()
+	return hc.hashes[len(hc.hashes)-1], hc.cfg.Height + int32(len(hc.hashes)-1)
+}
+
+//Header return the main chain header at height
+func (hc *HeaderChain) Header(height int32) (*wire.BlockHeader, bool) {
+	hc.mtx.RLock()
+	defer hc.mtx.RUnlock()
+	i := height - hc.cfg.Height
+	if i < 0 || int(i) >= len(hc.headers) {
+		return nil, false
+	}
+	header := hc.headers[i]
+	return &header, true
+}
+
+//Hash return the hash of the main chain header at height
+func (hc *HeaderChain) Hash(height int32) (chainhash.Hash, bool) {
+	hc.mtx.RLock()
+	defer hc.mtx.RUnlock()
+	i := height - hc.cfg.Height
+	if i < 0 || int(i) >= len(hc.hashes) {
+		return chainhash.Hash{}, false
+	}
+	return hc.hashes[i], true
+}
+
+//HeightOf return the height of a main chain header, -1 if unknown
+func (hc *HeaderChain) HeightOf(hash chainhash.Hash) int32 {
+	hc.mtx.RLock()
+	defer hc.mtx.RUnlock()
+	return hc.heightOf(hash)
+}
+
+//heightOf is HeightOf, the caller must hold mtx, recent headers are searched first
+func (hc *HeaderChain) heightOf(hash chainhash.Hash) int32 {
+	for i := len(hc.hashes) - 1; i >= 0; i-- {
+		if hc.hashes[i] == hash {
+			return hc.cfg.Height + int32(i)
+		}
+	}
+	return -1
+}
+
+//ConnectRaw connect serialized headers, as HeaderRawData of BlockInfo
+func (hc *HeaderChain) ConnectRaw(raws ...[]byte) error {
+	headers := make([]wire.BlockHeader, len(raws))
+	for i, raw := range raws {
+		if err := headers[i].Deserialize(bytes.NewReader(raw)); err != nil {
+			return kindError(ErrKindInvalidParams, "Deserialize header failed", err)
+		}
+	}
+	return hc.Connect(headers...)
+}
+
+//Connect verify and connect headers in order, the first must follow a main chain header.
+//If it does not follow the tip, the headers are a fork and replace the main chain above the fork
+//only if the fork has more work. Nothing is connected if any header is invalid.
+func (hc *HeaderChain) Connect(headers ...wire.BlockHeader) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	hc.mtx.Lock()
+	defer hc.mtx.Unlock()
+	forkHeight := hc.heightOf(headers[0].PrevBlock)
+	if forkHeight < 0 {
+		return newError(ErrKindNotFound, fmt.Sprintf("previous header %s is unknown", headers[0].PrevBlock))
+	}
+	fork := int(forkHeight - hc.cfg.Height)
+	//skip the headers already on the main chain
+	for len(headers) > 0 && fork+1 < len(hc.hashes) && headers[0].BlockHash() == hc.hashes[fork+1] {
+		headers = headers[1:]
+		fork++
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	//verify against the chain up to the fork, then switch if the fork has more work
+	chain := &HeaderChain{cfg: hc.cfg, noRetargeting: hc.noRetargeting, bip94: hc.bip94, interval: hc.interval,
+		headers: append([]wire.BlockHeader{}, hc.headers[:fork+1]...),
+		hashes:  append([]chainhash.Hash{}, hc.hashes[:fork+1]...),
+		work:    append([]*big.Int{}, hc.work[:fork+1]...)}
+	for i := range headers {
+		if err := chain.connect(&headers[i]); err != nil {
+			return err
+		}
+	}
+	if fork+1 < len(hc.headers) && chain.work[len(chain.work)-1].Cmp(hc.work[len(hc.work)-1]) <= 0 {
+		return kindError(ErrKindInvalidParams, fmt.Sprintf("fork at height %d", forkHeight), ErrLessWork)
+	}
+	hc.headers, hc.hashes, hc.work = chain.headers, chain.hashes, chain.work
+	return nil
+}
+
+//connect verify and append a header to the tip, the caller must hold mtx
+func (hc *HeaderChain) connect(header *wire.BlockHeader) error {
+	hash := header.BlockHash()
+	height := hc.cfg.Height + int32(len(hc.headers))
+	prev := &hc.headers[len(hc.headers)-1]
+	if header.PrevBlock != hc.hashes[len(hc.hashes)-1] {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("header %s is not linked to %s", hash, hc.hashes[len(hc.hashes)-1]))
+	}
+	if bits := hc.requiredBits(prev, header, height); header.Bits != bits {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("header %s at height %d has bits %08x, want %08x",
+			hash, height, header.Bits, bits))
+	}
+	if err := checkProofOfWork(header, hc.cfg.Params.PowLimit); err != nil {
+		return kindError(ErrKindInvalidParams, fmt.Sprintf("header %s", hash), err)
+	}
+	if mtp := hc.medianTimePast(); !header.Timestamp.After(mtp) {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("header %s time %v is not after median time past %v",
+			hash, header.Timestamp, mtp))
+	}
+	if hc.bip94 && height%hc.interval == 0 && header.Timestamp.Before(prev.Timestamp.Add(-maxTimewarp)) {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("header %s time %v is too far before the previous %v",
+			hash, header.Timestamp, prev.Timestamp))
+	}
+	if header.Timestamp.After(hc.cfg.Now().Add(maxTimeOffset)) {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("header %s time %v is too far in the future", hash, header.Timestamp))
+	}
+	hc.headers = append(hc.headers, *header)
+	hc.hashes = append(hc.hashes, hash)
+	hc.work = append(hc.work, new(big.Int).Add(hc.work[len(hc.work)-1], calcWork(header.Bits)))
+	return nil
+}
+
+//medianTimePast return the median timestamp of the last 11 headers, the caller must hold mtx
+func (hc *HeaderChain) medianTimePast() time.Time {
+	start := len(hc.headers) - medianTimeBlocks
+	if start < 0 {
+		start = 0
+	}
+	var timestamps []int64
+	for _, header := range hc.headers[start:] {
+		timestamps = append(timestamps, header.Timestamp.Unix())
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return time.Unix(timestamps[len(timestamps)/2], 0)
+}
+
+//requiredBits return the bits of the header at height after prev, as GetNextWorkRequired of bitcoind.
+//The caller must hold mtx.
+func (hc *HeaderChain) requiredBits(prev, header *wire.BlockHeader, height int32) uint32 {
+	params := hc.cfg.Params
+	if hc.noRetargeting {
+		return prev.Bits
+	}
+	if height%hc.interval != 0 {
+		if !params.ReduceMinDifficulty {
+			return prev.Bits
+		}
+		//testnet: a block after 20 minutes can have the minimum difficulty,
+		//otherwise the bits of the last block not of the minimum difficulty in the period
+		if header.Timestamp.After(prev.Timestamp.Add(params.MinDiffReductionTime)) {
+			return params.PowLimitBits
+		}
+		i := len(hc.headers) - 1
+		for i > 0 && (hc.cfg.Height+int32(i))%hc.interval != 0 && hc.headers[i].Bits == params.PowLimitBits {
+			i--
+		}
+		return hc.headers[i].Bits
+	}
+
+	first := len(hc.headers) - int(hc.interval)
+	if first < 0 { //the period starts before the checkpoint
+		return prev.Bits
+	}
+	actual := prev.Timestamp.Unix() - hc.headers[first].Timestamp.Unix()
+	targetTimespan := int64(params.TargetTimespan / time.Second)
+	if min := targetTimespan / params.RetargetAdjustmentFactor; actual < min {
+		actual = min
+	}
+	if max := targetTimespan * params.RetargetAdjustmentFactor; actual > max {
+		actual = max
+	}
+	bits := prev.Bits
+	if hc.bip94 { //the last block can have the minimum difficulty
+		bits = hc.headers[first].Bits
+	}
+	target := new(big.Int).Mul(compactToBig(bits), big.NewInt(actual))
+	target.Div(target, big.NewInt(targetTimespan))
+	if target.Cmp(params.PowLimit) > 0 {
+		target.Set(params.PowLimit)
+	}
+	return bigToCompact(target)
+}
+
+//bigToCompact convert a target to its compact representation, the reverse of compactToBig
+func bigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Rsh(n, 8*(exponent-3))
+		mantissa = uint32(tn.Bits()[0])
+	}
+	//the sign bit is set, use one more byte of exponent
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+//Depth return the number of main chain headers above the header of hash, -1 if it is not on the main chain
+func (hc *HeaderChain) Depth(hash chainhash.Hash) int32 {
+	hc.mtx.RLock()
+	defer hc.mtx.RUnlock()
+	height := hc.heightOf(hash)
+	if height < 0 {
+		return -1
+	}
+	return hc.cfg.Height + int32(len(hc.hashes)-1) - height
+}
+
+//VerifyBuried check the block of hash is on the main chain and buried under at least n headers
+func (hc *HeaderChain) VerifyBuried(hash chainhash.Hash, n int32) error {
+	depth := hc.Depth(hash)
+	if depth < 0 {
+		return newError(ErrKindNotFound, fmt.Sprintf("block %s is not on the header chain", hash))
+	}
+	if depth < n {
+		return newError(ErrKindInvalidParams, fmt.Sprintf("block %s is buried under %d headers, want %d", hash, depth, n))
+	}
+	return nil
+}
+
+//VerifyTx check the merkle proof of a tx, and its block is buried under at least n headers
+func (hc *HeaderChain) VerifyTx(proof *MerkleProof, n int32) error {
+	if err := proof.Verify(); err != nil {
+		return err
+	}
+	return hc.VerifyBuried(proof.Header.BlockHash(), n)
+}
+
+//SyncHeaders connect the headers of the node above the tip of hc, a reorg of the node up to
+//maxReorgDepth blocks is followed if the node's chain has more work
+func (abtc *AdaptorBTC) SyncHeaders(ctx context.Context, hc *HeaderChain) error {
+	_, err := abtc.call(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return nil, syncHeadersByClient(ctx, client, hc)
+	})
+	return err
+}
+
+func syncHeadersByClient(ctx context.Context, client *rpcclient.Client, hc *HeaderChain) error {
+	best, err := client.GetBlockCount()
+	if err != nil {
+		return wrapError("GetBlockCount failed", err)
+	}
+	_, tip := hc.Tip()
+	var headers []wire.BlockHeader
+	getHeader := func(height int64) (*wire.BlockHeader, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hash, err := client.GetBlockHash(height)
+		if err != nil {
+			return nil, wrapError("GetBlockHash failed", err)
+		}
+		header, err := client.GetBlockHeader(hash)
+		if err != nil {
+			return nil, wrapError("GetBlockHeader failed", err)
+		}
+		return header, nil
+	}
+	for height := int64(tip) + 1; height <= best; height++ {
+		header, err := getHeader(height)
+		if err != nil {
+			return err
+		}
+		headers = append(headers, *header)
+	}
+	//walk back to the fork of the node's chain
+	for start := int64(tip); len(headers) > 0 && hc.HeightOf(headers[0].PrevBlock) < 0; start-- {
+		if int64(tip)-start >= maxReorgDepth || start <= int64(hc.cfg.Height) {
+			return newError(ErrKindNode, "the chain of the node is not linked to the header chain")
+		}
+		header, err := getHeader(start)
+		if err != nil {
+			return err
+		}
+		headers = append([]wire.BlockHeader{*header}, headers...)
+	}
+	return hc.Connect(headers...)
+}
@@ -0,0 +1,220 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+)
+
+//testSPVParams is regtest with a retarget every 4 blocks, Net is changed to enable retargeting
+func testSPVParams(reduceMinDifficulty bool) *chaincfg.Params {
+	params := chaincfg.RegressionNetParams
+	params.Net = wire.SimNet
+	params.PowLimit = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 252), big.NewInt(1))
+	params.PowLimitBits = bigToCompact(params.PowLimit)
+	params.TargetTimespan = 40 * time.Minute
+	params.TargetTimePerBlock = 10 * time.Minute
+	params.ReduceMinDifficulty = reduceMinDifficulty
+	return &params
+}
+
+//mineHeader return a header after prev with a valid proof of work
+func mineHeader(prev *wire.BlockHeader, bits uint32, timestamp time.Time, powLimit *big.Int) wire.BlockHeader {
+	header := wire.BlockHeader{Version: 1, PrevBlock: prev.BlockHash(), Bits: bits, Timestamp: timestamp}
+	for checkProofOfWork(&header, powLimit) != nil {
+		header.Nonce++
+	}
+	return header
+}
+
+func TestHeaderChain(t *testing.T) {
+	params := testSPVParams(false)
+	start := time.Unix(1500000000, 0)
+	genesis := wire.BlockHeader{Version: 1, Bits: params.PowLimitBits, Timestamp: start}
+	hc, err := NewHeaderChain(HeaderChainConfig{Params: params, Checkpoint: []wire.BlockHeader{genesis}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewHeaderChain(HeaderChainConfig{Params: GetNet(NETID_SIGNET)}); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of signet - got: %v", err)
+	}
+
+	//blocks 1-3 in 20 minutes, the target of block 4 is halved
+	headers := []wire.BlockHeader{genesis}
+	for height, minutes := range []time.Duration{5, 10, 20} {
+		headers = append(headers, mineHeader(&headers[height], params.PowLimitBits, start.Add(minutes*time.Minute),
+			params.PowLimit))
+	}
+	if err := hc.Connect(headers[1:]...); err != nil {
+		t.Fatal(err)
+	}
+	halved := bigToCompact(new(big.Int).Div(params.PowLimit, big.NewInt(2)))
+	easy := mineHeader(&headers[3], params.PowLimitBits, start.Add(25*time.Minute), params.PowLimit)
+	if err := hc.Connect(easy); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of not retargeted bits - got: %v", err)
+	}
+	headers = append(headers, mineHeader(&headers[3], halved, start.Add(25*time.Minute), params.PowLimit))
+	var buf bytes.Buffer
+	headers[4].Serialize(&buf)
+	if err := hc.ConnectRaw(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if hash, height := hc.Tip(); height != 4 || hash != headers[4].BlockHash() {
+		t.Errorf("unexpected tip - got: %v %v, "+"want: %v", hash, height, 4)
+	}
+
+	//invalid headers
+	badPoW := mineHeader(&headers[4], halved, start.Add(30*time.Minute), params.PowLimit)
+	for checkProofOfWork(&badPoW, params.PowLimit) == nil {
+		badPoW.Nonce++
+	}
+	oldTime := mineHeader(&headers[4], halved, start.Add(10*time.Minute), params.PowLimit)
+	future := mineHeader(&headers[4], halved, time.Now().Add(3*time.Hour), params.PowLimit)
+	unlinked := mineHeader(&headers[2], halved, start.Add(30*time.Minute), params.PowLimit)
+	unlinked.PrevBlock = chainhash.Hash{1}
+	for name, header := range map[string]wire.BlockHeader{"pow": badPoW, "median time past": oldTime, "future": future} {
+		if err := hc.Connect(header); ErrorKindOf(err) != ErrKindInvalidParams {
+			t.Errorf("unexpected error of %s - got: %v", name, err)
+		}
+	}
+	if err := hc.Connect(unlinked); ErrorKindOf(err) != ErrKindNotFound {
+		t.Errorf("unexpected error of unlinked header - got: %v", err)
+	}
+
+	//a fork from block 2 of the same work is rejected, a longer one replaces the main chain
+	fork := []wire.BlockHeader{mineHeader(&headers[2], params.PowLimitBits, start.Add(20*time.Minute), params.PowLimit)}
+	fork = append(fork, mineHeader(&fork[0], halved, start.Add(21*time.Minute), params.PowLimit))
+	if err := hc.Connect(fork...); ErrorKindOf(err) != ErrKindInvalidParams || Cause(err) != ErrLessWork {
+		t.Errorf("unexpected error of fork of the same work - got: %v", err)
+	}
+	fork = append(fork, mineHeader(&fork[1], halved, start.Add(22*time.Minute), params.PowLimit))
+	if err := hc.Connect(fork...); err != nil {
+		t.Fatal(err)
+	}
+	if hash, height := hc.Tip(); height != 5 || hash != fork[2].BlockHash() || hc.HeightOf(headers[4].BlockHash()) != -1 {
+		t.Errorf("unexpected tip after reorg - got: %v %v, "+"want: %v", hash, height, 5)
+	}
+	if err := hc.Connect(fork...); err != nil {
+		t.Errorf("unexpected error of connecting again - got: %v", err)
+	}
+
+	//burial and merkle proofs
+	if depth := hc.Depth(headers[1].BlockHash()); depth != 4 {
+		t.Errorf("unexpected depth - got: %v, "+"want: %v", depth, 4)
+	}
+	if err := hc.VerifyBuried(headers[1].BlockHash(), 5); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of not buried - got: %v", err)
+	}
+	if err := hc.VerifyBuried(headers[4].BlockHash(), 0); ErrorKindOf(err) != ErrKindNotFound {
+		t.Errorf("unexpected error of orphaned block - got: %v", err)
+	}
+	block := testMerkleBlock(3)
+	block.Header = wire.BlockHeader{Version: 1, PrevBlock: fork[2].BlockHash(), MerkleRoot: block.Header.MerkleRoot,
+		Bits: halved, Timestamp: start.Add(23 * time.Minute)}
+	for checkProofOfWork(&block.Header, params.PowLimit) != nil {
+		block.Header.Nonce++
+	}
+	if err := hc.Connect(block.Header, mineHeader(&block.Header, halved, start.Add(24*time.Minute), params.PowLimit)); err != nil {
+		t.Fatal(err)
+	}
+	proof, _ := NewMerkleProof(block, block.Transactions[1].TxHash())
+	if err := hc.VerifyTx(proof, 1); err != nil {
+		t.Errorf("unexpected error of verifying tx - got: %v", err)
+	}
+	if err := hc.VerifyTx(proof, 2); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of tx not buried - got: %v", err)
+	}
+}
+
+func TestHeaderChainMinDifficulty(t *testing.T) {
+	params := testSPVParams(true)
+	start := time.Unix(1500000000, 0)
+	genesis := wire.BlockHeader{Version: 1, Bits: params.PowLimitBits, Timestamp: start}
+	hard := bigToCompact(new(big.Int).Div(params.PowLimit, big.NewInt(4)))
+	//the checkpoint of the hard target starts at a retarget boundary, and has the headers of the median time past
+	checkpoint := []wire.BlockHeader{genesis}
+	checkpoint[0].Bits = hard
+	for i := 1; i < 13; i++ {
+		checkpoint = append(checkpoint, mineHeader(&checkpoint[i-1], hard, start.Add(time.Duration(i)*time.Minute), params.PowLimit))
+	}
+	if _, err := NewHeaderChain(HeaderChainConfig{Params: params, Checkpoint: checkpoint, Height: 5}); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of the checkpoint not at a retarget boundary - got: %v", err)
+	}
+	if _, err := NewHeaderChain(HeaderChainConfig{Params: params, Checkpoint: checkpoint[:10], Height: 4}); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of the checkpoint of 10 headers - got: %v", err)
+	}
+	hc, err := NewHeaderChain(HeaderChainConfig{Params: params, Checkpoint: checkpoint, Height: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a block after 20 minutes has the minimum difficulty, the next block returns to the hard target
+	last := checkpoint[len(checkpoint)-1]
+	slow := mineHeader(&last, params.PowLimitBits, last.Timestamp.Add(21*time.Minute), params.PowLimit)
+	if err := hc.Connect(slow); err != nil {
+		t.Fatal(err)
+	}
+	easy := mineHeader(&slow, params.PowLimitBits, last.Timestamp.Add(22*time.Minute), params.PowLimit)
+	if err := hc.Connect(easy); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of minimum difficulty - got: %v", err)
+	}
+	if err := hc.Connect(mineHeader(&slow, hard, last.Timestamp.Add(22*time.Minute), params.PowLimit)); err != nil {
+		t.Errorf("unexpected error of the hard target - got: %v", err)
+	}
+
+	//regtest is not retargeted
+	regtest, _ := NewHeaderChain(HeaderChainConfig{Params: &chaincfg.RegressionNetParams})
+	genesisHeader := chaincfg.RegressionNetParams.GenesisBlock.Header
+	next := mineHeader(&genesisHeader, genesisHeader.Bits, genesisHeader.Timestamp.Add(time.Second),
+		chaincfg.RegressionNetParams.PowLimit)
+	if err := regtest.Connect(next); err != nil {
+		t.Errorf("unexpected error of regtest - got: %v", err)
+	}
+}
+
+func TestHeaderChainBIP94(t *testing.T) {
+	params := testSPVParams(true)
+	params.Net = TestNet4Params.Net
+	start := time.Unix(1500000000, 0)
+	hard := bigToCompact(new(big.Int).Div(params.PowLimit, big.NewInt(4)))
+	genesis := wire.BlockHeader{Version: 1, Bits: hard, Timestamp: start}
+	hc, err := NewHeaderChain(HeaderChainConfig{Params: params, Checkpoint: []wire.BlockHeader{genesis}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the last block of the period has the minimum difficulty
+	headers := []wire.BlockHeader{genesis}
+	for i, minutes := range []time.Duration{30, 31, 60} {
+		bits := params.PowLimitBits
+		if minutes == 31 {
+			bits = hard
+		}
+		headers = append(headers, mineHeader(&headers[i], bits, start.Add(minutes*time.Minute), params.PowLimit))
+	}
+	if err := hc.Connect(headers[1:]...); err != nil {
+		t.Fatal(err)
+	}
+	//the retarget is of the bits of the first block of the period
+	bits := bigToCompact(new(big.Int).Div(new(big.Int).Mul(compactToBig(hard), big.NewInt(3)), big.NewInt(2)))
+	if err := hc.Connect(mineHeader(&headers[3], params.PowLimitBits, start.Add(61*time.Minute), params.PowLimit)); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of the bits of the last block - got: %v", err)
+	}
+	if err := hc.Connect(mineHeader(&headers[3], bits, start.Add(49*time.Minute), params.PowLimit)); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of timewarp - got: %v", err)
+	}
+	if err := hc.Connect(mineHeader(&headers[3], bits, start.Add(51*time.Minute), params.PowLimit)); err != nil {
+		t.Errorf("unexpected error of the first block of the period - got: %v", err)
+	}
+}
+
+func TestBigToCompact(t *testing.T) {
+	for _, bits := range []uint32{0x1d00ffff, 0x207fffff, 0x1b0404cb, 0x03123456, 0x04123456} {
+		if got := bigToCompact(compactToBig(bits)); got != bits {
+			t.Errorf("unexpected compact - got: %08x, "+"want: %08x", got, bits)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/palletone/adaptor"
+)
+
+const (
+	defaultTrackerPollInterval = 10 * time.Second
+	defaultTrackerEventBuffer  = 100
+)
+
+//ChainEventType is the type of ChainEvent
+type ChainEventType int
+
+const (
+	ChainEventConnect    ChainEventType = iota //the block is added to the main chain
+	ChainEventDisconnect                       //the block is removed from the main chain by a reorg
+)
+
+func (t ChainEventType) String() string {
+	if t == ChainEventDisconnect {
+		return "disconnect"
+	}
+	return "connect"
+}
+
+//ChainEvent is a change of the main chain, TxIDs are the txs of the block affected by the change
+type ChainEvent struct {
+	Type   ChainEventType
+	Hash   chainhash.Hash
+	Height int32
+	Header wire.BlockHeader
+	TxIDs  []chainhash.Hash
+}
+
+//TipTrackerConfig is the config of TipTracker
+type TipTrackerConfig struct {
+	Depth        int           //number of recent main chain blocks kept, default maxReorgDepth
+	PollInterval time.Duration //interval of Run to poll the best block
+	EventBuffer  int           //buffer of each subscription channel
+}
+
+type trackedBlock struct {
+	hash   chainhash.Hash
+	height int32
+	header wire.BlockHeader
+	txids  []chainhash.Hash
+}
+
+type subscription struct {
+	events chan ChainEvent
+	done   chan struct{}
+}
+
+//TipTracker follows the best block of the nodes, keeps the recent main chain, detects reorgs,
+//and emits ChainEvents to subscribers. Blocks and txs reported before can be checked against it.
+type TipTracker struct {
+	cfg   TipTrackerConfig
+	nodes *NodeSet
+
+	syncMtx sync.Mutex //one Sync at a time
+
+	mtx      sync.RWMutex
+	chain    []*trackedBlock                  //recent main chain in height order
+	blocks   map[chainhash.Hash]*trackedBlock //hash -> block of chain
+	orphaned map[chainhash.Hash]bool          //blocks disconnected by the tracker
+	subs     map[*subscription]bool
+	lastErr  error
+}
+
+//NewTipTracker create a tracker which polls nodes, call Sync or Run to follow the chain
+func NewTipTracker(cfg TipTrackerConfig, nodes *NodeSet) *TipTracker {
+	if cfg.Depth <= 0 {
+		cfg.Depth = maxReorgDepth
+	}
+	if cfg.PollInterval == 0 {
+		cfg.PollInterval = defaultTrackerPollInterval
+	}
+	if cfg.EventBuffer <= 0 {
+		cfg.EventBuffer = defaultTrackerEventBuffer
+	}
+	return &TipTracker{cfg: cfg, nodes: nodes, blocks: map[chainhash.Hash]*trackedBlock{},
+		orphaned: map[chainhash.Hash]bool{}, subs: map[*subscription]bool{}}
+}
+
+//Subscribe return a channel of ChainEvents and a func to cancel it.
+//Sync blocks while the channel is full, so events are never lost.
+func (tt *TipTracker) Subscribe() (<-chan ChainEvent, func()) {
+	sub := &subscription{events: make(chan ChainEvent, tt.cfg.EventBuffer), done: make(chan struct{})}
+	tt.mtx.Lock()
+	tt.subs[sub] = true
+	tt.mtx.Unlock()
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			tt.mtx.Lock()
+			delete(tt.subs, sub)
+			tt.mtx.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+//Tip return the best block tracked, ok is false before the first Sync
+func (tt *TipTracker) Tip() (hash chainhash.Hash, height int32, ok bool) {
+	tt.mtx.RLock()
+	defer tt.mtx.RUnlock()
+	if len(tt.chain) == 0 {
+		return hash, 0, false
+	}
+	tip := tt.chain[len(tt.chain)-1]
+	return tip.hash, tip.height, true
+}
+
+//LastError return the error of the last Sync in Run, nil if it succeeded
+func (tt *TipTracker) LastError() error {
+	tt.mtx.RLock()
+	defer tt.mtx.RUnlock()
+	return tt.lastErr
+}
+
+//Run Sync every PollInterval until ctx is done, errors are kept by LastError and retried on next poll
+func (tt *TipTracker) Run(ctx context.Context) error {
+	for {
+		err := tt.SyncCtx(ctx)
+		tt.mtx.Lock()
+		tt.lastErr = err
+		tt.mtx.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(tt.cfg.PollInterval):
+		}
+	}
+}
+
+//Sync poll the best block, and update the tracked chain
+func (tt *TipTracker) Sync() error {
+	return tt.SyncCtx(context.Background())
+}
+
+//SyncCtx is Sync which stop when ctx is done
+func (tt *TipTracker) SyncCtx(ctx context.Context) error {
+	tt.syncMtx.Lock()
+	defer tt.syncMtx.Unlock()
+
+	result, err := tt.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return client.GetBestBlockHash()
+	})
+	if err != nil {
+		return wrapError("GetBestBlockHash failed", err)
+	}
+	best := result.(*chainhash.Hash)
+
+	tt.mtx.RLock()
+	_, known := tt.blocks[*best]
+	tt.mtx.RUnlock()
+	if known { //the best block is the tip, or an ancestor if the chain is reorged to a shorter one
+		return tt.emit(ctx, tt.apply(tt.blocksAbove(*best), true, nil))
+	}
+
+	//fetch blocks back from the best until a tracked one
+	var newBlocks []*trackedBlock
+	hash := *best
+	for {
+		blockHash := hash
+		block, err := tt.getBlock(ctx, &blockHash)
+		if err != nil {
+			return err
+		}
+		newBlocks = append([]*trackedBlock{block}, newBlocks...)
+		hash = block.header.PrevBlock
+		tt.mtx.RLock()
+		_, known = tt.blocks[hash]
+		tt.mtx.RUnlock()
+		if known || len(newBlocks) >= tt.cfg.Depth || hash == (chainhash.Hash{}) { //the first Sync fill Depth blocks
+			break
+		}
+	}
+
+	//heights of new blocks follow the fork point, or are got from the best one
+	var forkHeight int32
+	var orphans int
+	if known {
+		tt.mtx.RLock()
+		forkHeight = tt.blocks[hash].height
+		tt.mtx.RUnlock()
+		orphans = tt.blocksAbove(hash)
+	} else {
+		//the best is more than Depth blocks ahead, only the tracked blocks left the main chain are disconnected
+		if orphans, err = tt.leftMainChain(ctx); err != nil {
+			return err
+		}
+		result, err := tt.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+			return client.GetBlockHeaderVerbose(best)
+		})
+		if err != nil {
+			return wrapError("GetBlockHeaderVerbose failed", err)
+		}
+		forkHeight = result.(*btcjson.GetBlockHeaderVerboseResult).Height - int32(len(newBlocks))
+	}
+	for i, block := range newBlocks {
+		block.height = forkHeight + 1 + int32(i)
+	}
+
+	events := tt.apply(orphans, known, newBlocks)
+	return tt.emit(ctx, events)
+}
+
+//blocksAbove return the number of tracked blocks above the tracked block of hash
+func (tt *TipTracker) blocksAbove(hash chainhash.Hash) int {
+	tt.mtx.RLock()
+	defer tt.mtx.RUnlock()
+	for i, block := range tt.chain {
+		if block.hash == hash {
+			return len(tt.chain) - 1 - i
+		}
+	}
+	return len(tt.chain)
+}
+
+//leftMainChain return the number of tracked blocks from the tip which are not on the main chain of the nodes
+func (tt *TipTracker) leftMainChain(ctx context.Context) (int, error) {
+	tt.mtx.RLock()
+	chain := append([]*trackedBlock{}, tt.chain...)
+	tt.mtx.RUnlock()
+	for i := len(chain) - 1; i >= 0; i-- {
+		hash := chain[i].hash
+		result, err := tt.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+			return client.GetBlockHeaderVerbose(&hash)
+		})
+		if err != nil {
+			if ErrorKindOf(err) == ErrKindNotFound {
+				continue
+			}
+			return 0, wrapError("GetBlockHeaderVerbose failed", err)
+		}
+		if result.(*btcjson.GetBlockHeaderVerboseResult).Confirmations >= 0 { //-1 if orphaned
+			return len(chain) - 1 - i, nil
+		}
+	}
+	return len(chain), nil
+}
+
+func (tt *TipTracker) getBlock(ctx context.Context, hash *chainhash.Hash) (*trackedBlock, error) {
+	result, err := tt.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return client.GetBlock(hash)
+	})
+	if err != nil {
+		return nil, wrapError("GetBlock "+hash.String()+" failed", err)
+	}
+	msgBlock := result.(*wire.MsgBlock)
+	block := &trackedBlock{hash: *hash, header: msgBlock.Header}
+	for _, tx := range msgBlock.Transactions {
+		block.txids = append(block.txids, tx.TxHash())
+	}
+	return block, nil
+}
+
+//apply disconnect the top orphans tracked blocks, then connect newBlocks. If newBlocks are not linked
+//to the tracked chain, the tracked blocks still on the main chain are dropped without events.
+func (tt *TipTracker) apply(orphans int, linked bool, newBlocks []*trackedBlock) []ChainEvent {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	var events []ChainEvent
+	keep := len(tt.chain) - orphans
+	for i := len(tt.chain) - 1; i >= keep; i-- {
+		block := tt.chain[i]
+		delete(tt.blocks, block.hash)
+		tt.orphaned[block.hash] = true
+		events = append(events, block.event(ChainEventDisconnect))
+	}
+	tt.chain = tt.chain[:keep]
+	if !linked {
+		for _, block := range tt.chain {
+			delete(tt.blocks, block.hash)
+		}
+		tt.chain = nil
+	}
+	for _, block := range newBlocks {
+		tt.chain = append(tt.chain, block)
+		tt.blocks[block.hash] = block
+		delete(tt.orphaned, block.hash) //reconnected
+		events = append(events, block.event(ChainEventConnect))
+	}
+	for len(tt.chain) > tt.cfg.Depth {
+		delete(tt.blocks, tt.chain[0].hash)
+		tt.chain = tt.chain[1:]
+	}
+	if len(tt.orphaned) > tt.cfg.Depth*10 { //orphans are rare, just bound the memory
+		tt.orphaned = map[chainhash.Hash]bool{}
+	}
+	return events
+}
+
+func (block *trackedBlock) event(eventType ChainEventType) ChainEvent {
+	return ChainEvent{Type: eventType, Hash: block.hash, Height: block.height, Header: block.header, TxIDs: block.txids}
+}
+
+//emit send events to all subscribers in order
+func (tt *TipTracker) emit(ctx context.Context, events []ChainEvent) error {
+	tt.mtx.RLock()
+	subs := make([]*subscription, 0, len(tt.subs))
+	for sub := range tt.subs {
+		subs = append(subs, sub)
+	}
+	tt.mtx.RUnlock()
+	for _, sub := range subs {
+		for _, event := range events {
+			select {
+			case sub.events <- event:
+			case <-sub.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+	return nil
+}
+
+//Confirmations return the confirmations of a block at height by the tracked tip, 0 before the first Sync
+func (tt *TipTracker) Confirmations(height int32) int32 {
+	_, tipHeight, ok := tt.Tip()
+	if !ok || height > tipHeight {
+		return 0
+	}
+	return tipHeight - height + 1
+}
+
+//IsBlockOnMainChain return whether the block is still on the main chain,
+//blocks older than the tracked chain are checked by the nodes
+func (tt *TipTracker) IsBlockOnMainChain(ctx context.Context, blockID []byte) (bool, error) {
+	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(blockID))
+	if err != nil {
+		return false, kindError(ErrKindInvalidParams, "NewHashFromStr blockID failed", err)
+	}
+	tt.mtx.RLock()
+	_, tracked := tt.blocks[*hash]
+	orphaned := tt.orphaned[*hash]
+	tt.mtx.RUnlock()
+	if tracked {
+		return true, nil
+	}
+	if orphaned {
+		return false, nil
+	}
+	result, err := tt.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		return client.GetBlockHeaderVerbose(hash)
+	})
+	if err != nil {
+		if ErrorKindOf(err) == ErrKindNotFound {
+			return false, nil
+		}
+		return false, wrapError("GetBlockHeaderVerbose failed", err)
+	}
+	return result.(*btcjson.GetBlockHeaderVerboseResult).Confirmations >= 0, nil //-1 if orphaned
+}
+
+//IsTxOnMainChain return whether the block of a reported tx is still on the main chain
+func (tt *TipTracker) IsTxOnMainChain(ctx context.Context, tx *adaptor.TxBasicInfo) (bool, error) {
+	if !tx.IsInBlock {
+		return false, nil
+	}
+	return tt.IsBlockOnMainChain(ctx, tx.BlockID)
+}
+
+//checkStable recompute IsStable of a block at height by the tracked tip and the required confirmations,
+//stable is kept before the first Sync
+func (tt *TipTracker) checkStable(ctx context.Context, blockID []byte, height uint, required int64, stable bool) (bool, error) {
+	if _, _, ok := tt.Tip(); !ok {
+		return stable, nil
+	}
+	onMain, err := tt.IsBlockOnMainChain(ctx, blockID)
+	if err != nil || !onMain {
+		return false, err
+	}
+	return int64(tt.Confirmations(int32(height))) >= required, nil
+}
+
+func (e ChainEvent) String() string {
+	return fmt.Sprintf("%s %d %s", e.Type, e.Height, e.Hash)
+}
@@ -0,0 +1,170 @@
+package btcadaptor
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+
+	"github.com/palletone/adaptor"
+)
+
+func TestTipTrackerReorg(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	chain := &testRPCChain{}
+	chain.serve(node)
+	for height := 0; height <= 5; height++ {
+		chain.add(height, 0)
+	}
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	chain.add(6, 0, tx)
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	defer abtc.Close()
+	tracker := abtc.TrackTip(TipTrackerConfig{Depth: 4})
+	if err := tracker.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if hash, height, _ := tracker.Tip(); height != 6 || hash != chain.blocks[6].BlockHash() {
+		t.Fatalf("unexpected tip - got: %v %v, "+"want: %v", height, hash, 6)
+	}
+	events, cancel := tracker.Subscribe()
+	defer cancel()
+
+	old5, old6 := chain.blocks[5].BlockHash(), chain.blocks[6].BlockHash()
+	txInfo := &adaptor.TxBasicInfo{IsInBlock: true, BlockHeight: 6}
+	txInfo.BlockID, _ = hex.DecodeString(old6.String())
+	for height := 5; height <= 7; height++ {
+		chain.add(height, 1)
+	}
+	if err := tracker.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		eventType ChainEventType
+		hash      chainhash.Hash
+		height    int32
+	}{
+		{ChainEventDisconnect, old6, 6},
+		{ChainEventDisconnect, old5, 5},
+		{ChainEventConnect, chain.blocks[5].BlockHash(), 5},
+		{ChainEventConnect, chain.blocks[6].BlockHash(), 6},
+		{ChainEventConnect, chain.blocks[7].BlockHash(), 7},
+	}
+	for i, w := range want {
+		event := <-events
+		if event.Type != w.eventType || event.Hash != w.hash || event.Height != w.height {
+			t.Errorf("unexpected event %d - got: %v, "+"want: %v %v %v", i, event, w.eventType, w.height, w.hash)
+		}
+		if i == 0 && (len(event.TxIDs) != 2 || event.TxIDs[1] != tx.TxHash()) {
+			t.Errorf("unexpected txs of disconnected block - got: %v", event.TxIDs)
+		}
+	}
+
+	ctx := context.Background()
+	if onMain, err := tracker.IsTxOnMainChain(ctx, txInfo); err != nil || onMain {
+		t.Errorf("unexpected main chain of orphaned tx - got: %v %v, "+"want: %v", onMain, err, false)
+	}
+	blockID, _ := hex.DecodeString(chain.blocks[1].BlockHash().String())
+	if onMain, err := tracker.IsBlockOnMainChain(ctx, blockID); err != nil || !onMain { //older than the tracked
+		t.Errorf("unexpected main chain of block 1 - got: %v %v, "+"want: %v", onMain, err, true)
+	}
+	stable := false
+	if err := abtc.checkStable(ctx, blockID, 1, 0, &stable); err != nil || !stable {
+		t.Errorf("unexpected stable of block 1 - got: %v %v, "+"want: %v", stable, err, true)
+	}
+
+	//reorg to a shorter chain
+	chain.mtx.Lock()
+	chain.blocks = chain.blocks[:7]
+	chain.mtx.Unlock()
+	if err := tracker.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-events; event.Type != ChainEventDisconnect || event.Height != 7 {
+		t.Errorf("unexpected event - got: %v", event)
+	}
+	if _, height, _ := tracker.Tip(); height != 6 {
+		t.Errorf("unexpected tip height - got: %v, "+"want: %v", height, 6)
+	}
+}
+
+func TestTipTrackerGap(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	chain := &testRPCChain{}
+	chain.serve(node)
+	for height := 0; height <= 6; height++ {
+		chain.add(height, 0)
+	}
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	defer abtc.Close()
+	tracker := abtc.TrackTip(TipTrackerConfig{Depth: 4})
+	if err := tracker.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := tracker.Subscribe()
+	defer cancel()
+	ctx := context.Background()
+	checkEvents := func(want []ChainEvent) {
+		for i, w := range want {
+			event := <-events
+			if event.Type != w.Type || event.Hash != w.Hash || event.Height != w.Height {
+				t.Errorf("unexpected event %d - got: %v, "+"want: %v", i, event, w)
+			}
+		}
+		select {
+		case event := <-events:
+			t.Errorf("unexpected event - got: %v", event)
+		default:
+		}
+	}
+	connect := func(height int) ChainEvent {
+		return ChainEvent{Type: ChainEventConnect, Hash: chain.blocks[height].BlockHash(), Height: int32(height)}
+	}
+
+	//the best is more than Depth blocks ahead, the tracked blocks are still on the main chain
+	old6 := chain.blocks[6].BlockHash()
+	for height := 7; height <= 12; height++ {
+		chain.add(height, 0)
+	}
+	if err := tracker.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents([]ChainEvent{connect(9), connect(10), connect(11), connect(12)})
+	blockID, _ := hex.DecodeString(old6.String())
+	if onMain, err := tracker.IsBlockOnMainChain(ctx, blockID); err != nil || !onMain {
+		t.Errorf("unexpected main chain of block 6 - got: %v %v, "+"want: %v", onMain, err, true)
+	}
+
+	//a reorg from 11 to a branch more than Depth blocks ahead, only the tracked blocks above 10 are disconnected
+	old11, old12 := chain.blocks[11].BlockHash(), chain.blocks[12].BlockHash()
+	for height := 11; height <= 17; height++ {
+		chain.add(height, 1)
+	}
+	if err := tracker.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkEvents([]ChainEvent{
+		{Type: ChainEventDisconnect, Hash: old12, Height: 12},
+		{Type: ChainEventDisconnect, Hash: old11, Height: 11},
+		connect(14), connect(15), connect(16), connect(17),
+	})
+	blockID, _ = hex.DecodeString(chain.blocks[10].BlockHash().String())
+	if onMain, err := tracker.IsBlockOnMainChain(ctx, blockID); err != nil || !onMain {
+		t.Errorf("unexpected main chain of block 10 - got: %v %v, "+"want: %v", onMain, err, true)
+	}
+	blockID, _ = hex.DecodeString(old11.String())
+	if onMain, err := tracker.IsBlockOnMainChain(ctx, blockID); err != nil || onMain {
+		t.Errorf("unexpected main chain of block 11 - got: %v %v, "+"want: %v", onMain, err, false)
+	}
+	if hash, height, _ := tracker.Tip(); height != 17 || hash != chain.blocks[17].BlockHash() {
+		t.Errorf("unexpected tip - got: %v %v, "+"want: %v", height, hash, 17)
+	}
+}
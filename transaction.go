@@ -19,19 +19,19 @@ package btcadaptor
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"math/big"
 	"net/http"
 	"sort"
 	"strings"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
-	"github.com/shopspring/decimal"
 
 	"github.com/palletone/btc-adaptor/txscript"
 
@@ -39,8 +39,8 @@ import (
 )
 
 type outputIndexValue struct {
-	OutputIndex string
-	Value       uint64
+	OutputIndex wire.OutPoint
+	Value       btcutil.Amount
 }
 
 // A slice of outputIndexValue that implements sort.Interface to sort by Value.
@@ -56,12 +56,11 @@ func sortByValue(tpl outputIndexValueList) outputIndexValueList {
 	return tpl
 }
 
-func selUnspends(outputIndexMap map[string]float64, btcAmout uint64) []outputIndexValue {
+func selUnspends(outputIndexMap map[wire.OutPoint]btcutil.Amount, btcAmout btcutil.Amount) []outputIndexValue {
 	var smlUnspends []outputIndexValue
 	var bigUnspends []outputIndexValue
 	var selUnspends []outputIndexValue
-	for outputIndex, value := range outputIndexMap {
-		amount := uint64(decimal.NewFromFloat(value).Mul(decimal.New(1, 8)).IntPart())
+	for outputIndex, amount := range outputIndexMap {
 		if amount == btcAmout {
 			selUnspends = append(selUnspends, outputIndexValue{outputIndex, amount})
 			break
@@ -76,7 +75,7 @@ func selUnspends(outputIndexMap map[string]float64, btcAmout uint64) []outputInd
 		return selUnspends
 	}
 	//
-	selAmount := uint64(0)
+	selAmount := btcutil.Amount(0)
 	if len(smlUnspends) > 0 {
 		smlUnspendsSort := sortByValue(smlUnspends)
 		for i := range smlUnspendsSort {
@@ -107,57 +106,96 @@ func selUnspends(outputIndexMap map[string]float64, btcAmout uint64) []outputInd
 	return selUnspends
 }
 func CreateTransferTokenTx(input *adaptor.CreateTransferTokenTxInput, rpcParams *RPCParams, netID int) (*adaptor.CreateTransferTokenTxOutput, error) {
+	//get rpc client
+	client, err := GetClient(rpcParams)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Shutdown()
+
+	return createTransferTokenTxByClient(input, client, netID, defaultFinality(netID))
+}
+
+func createTransferTokenTxByClient(input *adaptor.CreateTransferTokenTxInput, client *rpcclient.Client, netID int,
+	policy *FinalityPolicy) (*adaptor.CreateTransferTokenTxOutput, error) {
+	return createTransferTokenTxByUnspend(input, netID, func(addr btcutil.Address) (map[wire.OutPoint]btcutil.Amount, error) {
+		return getAllUnspend(client, addr, ChainOf(GetNet(netID)), policy)
+	})
+}
+
+//createTransferTokenTxByUnspend create the tx with the utxos returned by getUnspend, in the format of getAllUnspend.
+//The Extra of the input may exclude utxos, set the change address and the fee rate,
+//the Extra of the output has the utxos spent and their amounts.
+func createTransferTokenTxByUnspend(input *adaptor.CreateTransferTokenTxInput, netID int,
+	getUnspend func(addr btcutil.Address) (map[wire.OutPoint]btcutil.Amount, error)) (*adaptor.CreateTransferTokenTxOutput, error) {
 	//chainnet
 	realNet := GetNet(netID)
+	chain := ChainOf(realNet)
 
 	//convert address from string
-	addr, err := btcutil.DecodeAddress(input.FromAddress, realNet)
+	addr, err := DecodeAddress(input.FromAddress, realNet)
 	if err != nil {
-		return nil, fmt.Errorf("DecodeAddress FromAddress failed %s", err.Error())
+		return nil, kindError(ErrKindBadAddress, "DecodeAddress FromAddress failed", err)
 	}
-	if len(input.Extra)%33 != 0 {
-		return nil, fmt.Errorf("input.Extra len invalid, txid:22+index:1")
+	inputExtra, err := DecodeExtra(input.Extra)
+	if err != nil {
+		return nil, err
+	}
+	changeAddr := addr
+	if inputExtra.ChangeAddress != "" {
+		changeAddr, err = DecodeAddress(inputExtra.ChangeAddress, realNet)
+		if err != nil {
+			return nil, kindError(ErrKindBadAddress, "DecodeAddress changeAddress of the Extra failed", err)
+		}
+	}
+	feeRate := chain.DefaultFeeRate
+	if inputExtra.FeeRate > 0 {
+		feeRate = inputExtra.FeeRate
 	}
 
-	//get rpc client
-	client, err := GetClient(rpcParams)
+	//check amount, the fee is of the fee rate of the Extra or the chain if it is zero
+	fee, err := amountInput(input.Fee, "fee")
 	if err != nil {
 		return nil, err
 	}
-	defer client.Shutdown()
-
-	//check amount
-	fee := +input.Fee.Amount.Uint64()
-	if 0 == fee {
-		return nil, fmt.Errorf("input.Fee invalid, must not be zero")
+	autoFee := 0 == fee
+	if autoFee {
+		fee = btcutil.Amount(Fee(feeRate, estimateTxSize(1, 2)))
+	}
+	amount, err := amountInput(input.Amount, "amount")
+	if err != nil {
+		return nil, err
 	}
-	amount := input.Amount.Amount.Uint64()
-	btcAmount := amount + fee
 
 	//1.get all unspend
-	outputIndexMap, err := getAllUnspend(client, addr)
+	outputIndexMap, err := getUnspend(addr)
 	if err != nil {
 		return nil, err
 	}
 	if len(outputIndexMap) == 0 {
-		return nil, fmt.Errorf("getAllUnspend failed : no utxos")
+		return nil, newError(ErrKindInvalidParams, "getAllUnspend failed : no utxos")
 	}
 	//for outputIndex, value := range outputIndexMap {
 	//	fmt.Println(outputIndex, value)
 	//}
 
 	//2.remove extra utxo
-	for i := 0; i < len(input.Extra); i += 33 {
-		idIndexHex := hex.EncodeToString(input.Extra[i:33])
-		if _, exist := outputIndexMap[idIndexHex]; exist {
-			delete(outputIndexMap, idIndexHex)
-		}
+	for _, outpoint := range inputExtra.Outpoints {
+		delete(outputIndexMap, outpoint)
 	}
 
-	//3.select greet
-	outputIndexSel := selUnspends(outputIndexMap, btcAmount)
+	//3.select greet, again with the default fee of the selected inputs
+	outputIndexSel := selUnspends(outputIndexMap, amount+fee)
+	for autoFee && len(outputIndexSel) != 0 {
+		required := btcutil.Amount(Fee(feeRate, estimateTxSize(len(outputIndexSel), 2)))
+		if required <= fee {
+			break
+		}
+		fee = required
+		outputIndexSel = selUnspends(outputIndexMap, amount+fee)
+	}
 	if len(outputIndexSel) == 0 {
-		return nil, fmt.Errorf("selUnspends failed : balance is not enough")
+		return nil, newError(ErrKindInvalidParams, "selUnspends failed : balance is not enough")
 	}
 	//for _, out := range outputIndexSel { //Debug
 	//	fmt.Println(out.OutputIndex, out.Value)
@@ -165,28 +203,22 @@ func CreateTransferTokenTx(input *adaptor.CreateTransferTokenTxInput, rpcParams
 
 	msgTx := wire.NewMsgTx(1)
 	//transaction inputs
-	allInputAmount := uint64(0)
-	extra := []byte{}
+	allInputAmount := btcutil.Amount(0)
+	var extra Extra
 	for _, outputIndexV := range outputIndexSel {
 		//fmt.Println(outputIndexV.OutputIndex, outputIndexV.Value)
-		voutByte, _ := hex.DecodeString(outputIndexV.OutputIndex[64:66])
-		vout := uint64(voutByte[0])
-		hash, err := chainhash.NewHashFromStr(outputIndexV.OutputIndex[0:64])
-		if err != nil {
-			return nil, fmt.Errorf("NewHashFromStr outputIndexSel failed")
-		}
-		input := &wire.TxIn{PreviousOutPoint: wire.OutPoint{*hash, uint32(vout)}}
+		input := &wire.TxIn{PreviousOutPoint: outputIndexV.OutputIndex}
 		msgTx.AddTxIn(input)
 		allInputAmount += outputIndexV.Value
-		outputIndexByte, _ := hex.DecodeString(outputIndexV.OutputIndex)
-		extra = append(extra, outputIndexByte...)
+		extra.Outpoints = append(extra.Outpoints, outputIndexV.OutputIndex)
+		extra.Amounts = append(extra.Amounts, int64(outputIndexV.Value))
 	}
 	if len(msgTx.TxIn) == 0 {
-		return nil, fmt.Errorf("Process TxIn error : NO Input.")
+		return nil, newError(ErrKindInvalidParams, "Process TxIn error : NO Input.")
 	}
 
 	//transaction outputs
-	addrTo, err := btcutil.DecodeAddress(input.ToAddress, realNet)
+	addrTo, err := DecodeAddress(input.ToAddress, realNet)
 	if err != nil {
 		amount = 0 //op_return set amount 0
 		pkScript, _ := txscript.NullDataScript([]byte(input.ToAddress))
@@ -194,21 +226,32 @@ func CreateTransferTokenTx(input *adaptor.CreateTransferTokenTxInput, rpcParams
 		msgTx.AddTxOut(txOut)
 		//return nil, fmt.Errorf("DecodeAddress ToAddress failed %s", err.Error())
 	} else {
+		if int64(amount) < chain.DustLimit {
+			return nil, newError(ErrKindInvalidParams, fmt.Sprintf("amount %d is below the dust limit %d of %s",
+				amount, chain.DustLimit, chain.Symbol))
+		}
 		pkScript, _ := txscript.PayToAddrScript(addrTo)
 		txOut := wire.NewTxOut(int64(amount), pkScript)
 		msgTx.AddTxOut(txOut)
 	}
 
-	//change
+	//change, the dust is added to the fee
 	change := allInputAmount - amount - fee
-	//fmt.Println(change, allInputAmount, btcAmount, fee) //Debug
-	if change > 0 {
-		pkScript, _ := txscript.PayToAddrScript(addr)
+	//fmt.Println(change, allInputAmount, amount, fee) //Debug
+	if int64(change) >= chain.DustLimit && change > 0 {
+		pkScript, _ := txscript.PayToAddrScript(changeAddr)
 		txOut := wire.NewTxOut(int64(change), pkScript)
 		msgTx.AddTxOut(txOut)
+		extra.ChangeAddress = EncodeAddress(changeAddr, realNet)
+	} else {
+		fee += change
 	}
 	if len(msgTx.TxOut) == 0 {
-		return nil, fmt.Errorf("Process TxOut error : NO Output.")
+		return nil, newError(ErrKindInvalidParams, "Process TxOut error : NO Output.")
+	}
+	if minFee := Fee(chain.MinRelayFee, estimateTxSize(len(msgTx.TxIn), len(msgTx.TxOut))); int64(fee) < minFee {
+		return nil, newError(ErrKindInvalidParams, fmt.Sprintf("fee %d is below the min relay fee %d of %s",
+			fee, minFee, chain.Symbol))
 	}
 	//for _, out := range msgTx.TxOut { //Debug
 	//	fmt.Println(out.Value)
@@ -222,53 +265,17 @@ func CreateTransferTokenTx(input *adaptor.CreateTransferTokenTxInput, rpcParams
 	//result for return
 	var output adaptor.CreateTransferTokenTxOutput
 	output.Transaction = buf.Bytes()
-	output.Extra = extra
+	output.Extra = EncodeExtra(&extra)
 
 	return &output, nil
 }
 
-func decodeRawTransaction(rawTxHex string, netID int) (interface{}, error) {
-	//covert rawtransaction hexString to bytes
-	rawTXBytes, err := hex.DecodeString(rawTxHex)
-	if err != nil {
-		return nil, err
-	}
-
-	var mtx wire.MsgTx
-	err = mtx.Deserialize(bytes.NewReader(rawTXBytes))
-	if err != nil {
-		return nil, err
-	}
-
-	realNet := GetNet(netID)
-
-	//
-	fmt.Println("Input : ")
-	for i := range mtx.TxIn {
-		fmt.Println(mtx.TxIn[i].PreviousOutPoint.Hash.String(), mtx.TxIn[i].PreviousOutPoint.Index)
-	}
-	fmt.Println("Output : ")
-	for i := range mtx.TxOut {
-		_, addrs, _, _ := txscript.ExtractPkScriptAddrs(mtx.TxOut[i].PkScript, realNet)
-		if 0 != len(addrs) {
-			fmt.Println(addrs[0].EncodeAddress(), btcutil.Amount(mtx.TxOut[i].Value).ToBTC())
-		} else {
-			disbuf, _ := txscript.DisasmString(mtx.TxOut[i].PkScript)
-			dataHex := disbuf[len("OP_RETURN "):]
-			data, _ := hex.DecodeString(dataHex)
-			fmt.Println("data : ", string(data))
-		}
-	}
-
-	return &mtx, nil
-}
-
 func CalcTxHash(input *adaptor.CalcTxHashInput) (*adaptor.CalcTxHashOutput, error) {
 	//deserialize to MsgTx
 	var tx wire.MsgTx
 	err := tx.Deserialize(bytes.NewReader(input.Transaction))
 	if err != nil {
-		return nil, fmt.Errorf("Deserialize tx failed : %s", err.Error())
+		return nil, kindError(ErrKindInvalidTx, "Deserialize tx failed", err)
 	}
 
 	//result for return
@@ -288,28 +295,34 @@ func GetBlockInfo(input *adaptor.GetBlockInfoInput, rpcParams *RPCParams) (*adap
 	}
 	defer client.Shutdown()
 
+	policy := DefaultFinalityPolicy(nil)
+	return getBlockInfoByClient(input, client, &policy)
+}
+
+func getBlockInfoByClient(input *adaptor.GetBlockInfoInput, client *rpcclient.Client, policy *FinalityPolicy) (*adaptor.GetBlockInfoOutput, error) {
 	//
 	var blkHash *chainhash.Hash
+	var err error
 	if input.Latest {
 		blkHash, _, err = client.GetBestBlock() //BTCD API
 		if err != nil {
-			return nil, fmt.Errorf("GetBestBlock Latest failed : %s", err.Error())
+			return nil, wrapError("GetBestBlock Latest failed", err)
 		}
 	} else if len(input.BlockID) != 0 {
 		blkHash, err = chainhash.NewHashFromStr(hex.EncodeToString(input.BlockID))
 		if err != nil {
-			return nil, fmt.Errorf("NewHashFromStr BlockID failed : %s", err.Error())
+			return nil, kindError(ErrKindInvalidParams, "NewHashFromStr BlockID failed", err)
 		}
 	} else {
 		blkHash, err = client.GetBlockHash(int64(input.Height)) //BTCD API
 		if err != nil {
-			return nil, fmt.Errorf("GetBlockHash Height failed : %s", err.Error())
+			return nil, wrapError("GetBlockHash Height failed", err)
 		}
 	}
 
 	blkResult, err := client.GetBlockVerbose(blkHash) //BTCD API
 	if err != nil {
-		return nil, fmt.Errorf("GetBlockVerbose failed : %s", err.Error())
+		return nil, wrapError("GetBlockVerbose failed", err)
 	}
 	blkHeader, err := client.GetBlockHeader(blkHash) //BTCD API
 	buf := bytes.NewBuffer(make([]byte, 0, 80))
@@ -338,7 +351,7 @@ func GetBlockInfo(input *adaptor.GetBlockInfoInput, rpcParams *RPCParams) (*adap
 	} else if len(blkResult.Tx) > 0 {
 		hash, err := chainhash.NewHashFromStr(blkResult.Tx[0])
 		if err != nil {
-			return nil, fmt.Errorf("NewHashFromStr tx failed : %s", err.Error())
+			return nil, kindError(ErrKindNode, "NewHashFromStr tx failed", err)
 		}
 		txResult, err := client.GetRawTransactionVerbose(hash) //BTCD API
 		if 0 != len(txResult.Vout[0].ScriptPubKey.Addresses) {
@@ -346,22 +359,12 @@ func GetBlockInfo(input *adaptor.GetBlockInfoInput, rpcParams *RPCParams) (*adap
 		}
 	}
 
-	if blkResult.Confirmations >= MinConfirm { //GetBlockVerbose
-		output.Block.IsStable = true
-	} else {
-		output.Block.IsStable = false
-	}
+	output.Block.IsStable = policy.IsFinal(blkResult.Confirmations, 0, parseBits(blkResult.Bits)) //GetBlockVerbose
 
 	return &output, nil
 }
 
 func GetPalletOneMappingAddress(input *adaptor.GetPalletOneMappingAddressInput, rpcParams *RPCParams) (*adaptor.GetPalletOneMappingAddressOutput, error) {
-	//covert TxHash
-	hash, err := chainhash.NewHashFromStr(input.MappingDataSource)
-	if err != nil {
-		return nil, fmt.Errorf("NewHashFromStr MappingDataSource failed : %s", err.Error())
-	}
-
 	//get rpc client
 	client, err := GetClient(rpcParams)
 	if err != nil {
@@ -369,10 +372,20 @@ func GetPalletOneMappingAddress(input *adaptor.GetPalletOneMappingAddressInput,
 	}
 	defer client.Shutdown()
 
+	return getPalletOneMappingAddressByClient(input, client)
+}
+
+func getPalletOneMappingAddressByClient(input *adaptor.GetPalletOneMappingAddressInput, client *rpcclient.Client) (*adaptor.GetPalletOneMappingAddressOutput, error) {
+	//covert TxHash
+	hash, err := chainhash.NewHashFromStr(input.MappingDataSource)
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "NewHashFromStr MappingDataSource failed", err)
+	}
+
 	//rpc GetRawTransactionVerbose
 	txResult, err := client.GetRawTransactionVerbose(hash) //BTCD API
 	if err != nil {
-		return nil, fmt.Errorf("GetRawTransactionVerbose tx failed : %s", err.Error())
+		return nil, wrapError("GetRawTransactionVerbose tx failed", err)
 	}
 
 	//result for return
@@ -380,17 +393,17 @@ func GetPalletOneMappingAddress(input *adaptor.GetPalletOneMappingAddressInput,
 	//get from address
 	hashPre, err := chainhash.NewHashFromStr(txResult.Vin[0].Txid)
 	if err != nil {
-		return nil, fmt.Errorf("NewHashFromStr txPre failed : %s", err.Error())
+		return nil, kindError(ErrKindNode, "NewHashFromStr txPre failed", err)
 	}
 	txPreResult, err := client.GetRawTransactionVerbose(hashPre) //BTCD API
 	if err != nil {
-		return nil, fmt.Errorf("GetRawTransactionVerbose txPre 0 failed : %s", err.Error())
+		return nil, wrapError("GetRawTransactionVerbose txPre 0 failed", err)
 	}
 	fromAddr := txPreResult.Vout[txResult.Vin[0].Vout].ScriptPubKey.Addresses[0]
 	if input.ChainAddress == "" {
 		output.ChainAddress = fromAddr
 	} else if fromAddr != input.ChainAddress {
-		return nil, fmt.Errorf("the ChainAddress is not match")
+		return nil, newError(ErrKindInvalidParams, "the ChainAddress is not match")
 	}
 
 	//get op_return data
@@ -406,19 +419,13 @@ func GetPalletOneMappingAddress(input *adaptor.GetPalletOneMappingAddressInput,
 		}
 	}
 	if !exist {
-		return nil, fmt.Errorf("the PalletOneAddress not exist in MappingDataSource")
+		return nil, newError(ErrKindInvalidParams, "the PalletOneAddress not exist in MappingDataSource")
 	}
 
 	return &output, nil
 }
 
 func GetTxBasicInfo(input *adaptor.GetTxBasicInfoInput, rpcParams *RPCParams) (*adaptor.GetTxBasicInfoOutput, error) {
-	//covert TxHash
-	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(input.TxID))
-	if err != nil {
-		return nil, fmt.Errorf("NewHashFromStr tx failed : %s", err.Error())
-	}
-
 	//get rpc client
 	client, err := GetClient(rpcParams)
 	if err != nil {
@@ -426,10 +433,21 @@ func GetTxBasicInfo(input *adaptor.GetTxBasicInfoInput, rpcParams *RPCParams) (*
 	}
 	defer client.Shutdown()
 
+	policy := DefaultFinalityPolicy(nil)
+	return getTxBasicInfoByClient(input, client, &policy)
+}
+
+func getTxBasicInfoByClient(input *adaptor.GetTxBasicInfoInput, client *rpcclient.Client, policy *FinalityPolicy) (*adaptor.GetTxBasicInfoOutput, error) {
+	//covert TxHash
+	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(input.TxID))
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "NewHashFromStr tx failed", err)
+	}
+
 	//rpc GetRawTransactionVerbose
 	txResult, err := client.GetRawTransactionVerbose(hash) //BTCD API
 	if err != nil {
-		return nil, fmt.Errorf("GetRawTransactionVerbose tx failed : %s", err.Error())
+		return nil, wrapError("GetRawTransactionVerbose tx failed", err)
 	}
 
 	//result for return
@@ -437,11 +455,11 @@ func GetTxBasicInfo(input *adaptor.GetTxBasicInfoInput, rpcParams *RPCParams) (*
 	//get from address
 	hashPre, err := chainhash.NewHashFromStr(txResult.Vin[0].Txid)
 	if err != nil {
-		return nil, fmt.Errorf("NewHashFromStr hashPre failed : %s", err.Error())
+		return nil, kindError(ErrKindNode, "NewHashFromStr hashPre failed", err)
 	}
 	txPreResult, err := client.GetRawTransactionVerbose(hashPre) //BTCD API
 	if err != nil {
-		return nil, fmt.Errorf("GetRawTransactionVerbose txPre 0 failed : %s", err.Error())
+		return nil, wrapError("GetRawTransactionVerbose txPre 0 failed", err)
 	}
 	fromAddr := txPreResult.Vout[txResult.Vin[0].Vout].ScriptPubKey.Addresses[0]
 
@@ -455,7 +473,7 @@ func GetTxBasicInfo(input *adaptor.GetTxBasicInfoInput, rpcParams *RPCParams) (*
 			continue
 		}
 		if toAddr != "" && toAddr != out.ScriptPubKey.Addresses[0] {
-			return nil, fmt.Errorf("Not support send 2+ tx ")
+			return nil, newError(ErrKindInvalidParams, "Not support send 2+ tx ")
 		}
 		toAddr = out.ScriptPubKey.Addresses[0]
 		break
@@ -472,79 +490,102 @@ func GetTxBasicInfo(input *adaptor.GetTxBasicInfoInput, rpcParams *RPCParams) (*
 		blockID, _ := hex.DecodeString(txResult.BlockHash)
 		output.Tx.BlockID = blockID
 		blkHash, err := chainhash.NewHashFromStr(txResult.BlockHash)
-		if err == nil {
-			blkResult, err := client.GetBlockVerbose(blkHash) //BTCD API
-			if err == nil {
-				output.Tx.BlockHeight = uint(blkResult.Height)
-			}
+		if err != nil {
+			return nil, kindError(ErrKindNode, "NewHashFromStr block failed", err)
+		}
+		blkResult, err := client.GetBlockVerbose(blkHash) //BTCD API
+		if err != nil {
+			return nil, wrapError("GetBlockVerbose failed", err)
 		}
+		output.Tx.BlockHeight = uint(blkResult.Height)
+		output.Tx.TxIndex = txIndexes(blkResult.Tx)[txResult.Txid]
 	} else {
 		output.Tx.IsInBlock = false
 		output.Tx.IsSuccess = false
 	}
-	if txResult.Confirmations >= MinConfirm {
-		output.Tx.IsStable = true
-	} else {
-		output.Tx.IsStable = false
+	bits, err := policy.blockBitsByClient(client, txResult.BlockHash)
+	if err != nil {
+		return nil, err
 	}
-	output.Tx.TxIndex = 0 //todo
+	output.Tx.IsStable = policy.IsFinal(int64(txResult.Confirmations), sumVout(txResult.Vout), bits)
 	output.Tx.Timestamp = uint64(txResult.Blocktime)
 
 	return &output, nil
 }
 
 func GetTransferTx(input *adaptor.GetTransferTxInput, rpcParams *RPCParams) (*adaptor.GetTransferTxOutput, error) {
-	//covert TxHash
-	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(input.TxID))
-	//hash, err := chainhash.NewHash(input.TxID)//hash.String() is not same
-	if err != nil {
-		return nil, fmt.Errorf("NewHashFromStr tx failed : %s", err.Error())
-	}
-	//fmt.Println(hash.String())
 	//get rpc client
 	client, err := GetClient(rpcParams)
 	if err != nil {
 		return nil, err
 	}
 	defer client.Shutdown()
+	resolver, err := newRPCResolver(rpcParams)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := DefaultFinalityPolicy(nil)
+	return getTransferTxByClient(context.Background(), input, client, resolver, &policy, ChainOf(nil))
+}
+
+func getTransferTxByClient(ctx context.Context, input *adaptor.GetTransferTxInput, client *rpcclient.Client,
+	resolver *txResolver, policy *FinalityPolicy, chain *Chain) (*adaptor.GetTransferTxOutput, error) {
+	//covert TxHash
+	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(input.TxID))
+	//hash, err := chainhash.NewHash(input.TxID)//hash.String() is not same
+	if err != nil {
+		return nil, kindError(ErrKindInvalidParams, "NewHashFromStr tx failed", err)
+	}
+	//fmt.Println(hash.String())
 
 	//rpc GetRawTransactionVerbose
 	txResult, err := client.GetRawTransactionVerbose(hash) //BTCD API
 	if err != nil {
-		return nil, fmt.Errorf("GetRawTransactionVerbose tx failed : %s", err.Error())
+		return nil, wrapError("GetRawTransactionVerbose tx failed", err)
 	}
 
-	//result for return
-	var output adaptor.GetTransferTxOutput
-	//get from address
-	hashPre, err := chainhash.NewHashFromStr(txResult.Vin[0].Txid)
-	if err != nil {
-		return nil, fmt.Errorf("NewHashFromStr hashPre failed : %s", err.Error())
+	//resolve all prevouts in batches
+	if err := resolver.checkReorg(ctx); err != nil {
+		return nil, err
 	}
-	txPreResult, err := client.GetRawTransactionVerbose(hashPre) //BTCD API
+	var prevTxids []string
+	for _, in := range txResult.Vin {
+		if in.IsCoinBase() {
+			return nil, newError(ErrKindInvalidParams, "the tx is coinbase, not a transfer")
+		}
+		prevTxids = append(prevTxids, in.Txid)
+	}
+	prevTxs, err := resolver.getTxs(ctx, prevTxids)
 	if err != nil {
-		return nil, fmt.Errorf("GetRawTransactionVerbose txPre 0 failed : %s", err.Error())
+		return nil, err
 	}
-	fromAddr := txPreResult.Vout[txResult.Vin[0].Vout].ScriptPubKey.Addresses[0]
-	output.Tx.FromAddress = fromAddr
 
-	//get input amount
-	inputAmount := txPreResult.Vout[txResult.Vin[0].Vout].Value
-	for i := 1; i < len(txResult.Vin); i++ {
-		hashPre, err := chainhash.NewHashFromStr(txResult.Vin[i].Txid)
-		if err != nil {
-			return nil, fmt.Errorf("hashPre failed : %s", err.Error())
+	//result for return
+	var output adaptor.GetTransferTxOutput
+	//get input amount and from address
+	inputAmount := btcutil.Amount(0)
+	fromAddr := ""
+	for i, in := range txResult.Vin {
+		txPreResult := prevTxs[in.Txid]
+		if int(in.Vout) >= len(txPreResult.Vout) {
+			return nil, newError(ErrKindNode, fmt.Sprintf("txPre %d %s has no output %d", i, in.Txid, in.Vout))
 		}
-		txPreResult, err := client.GetRawTransactionVerbose(hashPre) //BTCD API
-		if err != nil {
-			return nil, fmt.Errorf("GetRawTransactionVerbose txPre %d failed : %s", i, err.Error())
+		prevOut := txPreResult.Vout[in.Vout]
+		if i == 0 {
+			if len(prevOut.ScriptPubKey.Addresses) == 0 {
+				return nil, newError(ErrKindNode, "txPre 0 has no address")
+			}
+			fromAddr = prevOut.ScriptPubKey.Addresses[0]
 		}
-		inputAmount += txPreResult.Vout[txResult.Vin[i].Vout].Value
+		inputAmount += chain.amountOf(prevOut.Value)
 	}
+	output.Tx.FromAddress = fromAddr
 
 	//get to address and amount
-	change := float64(0)
-	amount := float64(0)
+	change := btcutil.Amount(0)
+	amount := btcutil.Amount(0)
+	amountOther := btcutil.Amount(0)
 	for _, out := range txResult.Vout {
 		if out.ScriptPubKey.Type == "nulldata" { //todo: more op_return ?
 			if strings.HasPrefix(out.ScriptPubKey.Asm, "OP_RETURN") {
@@ -553,28 +594,28 @@ func GetTransferTx(input *adaptor.GetTransferTxInput, rpcParams *RPCParams) (*ad
 			}
 			continue
 		}
+		if chain.ignored(out.ScriptPubKey.Hex) { //not fee
+			amountOther += chain.amountOf(out.Value)
+			continue
+		}
 		if len(out.ScriptPubKey.Addresses) == 0 {
 			continue
 		}
 		if fromAddr == out.ScriptPubKey.Addresses[0] {
-			change += out.Value
+			change += chain.amountOf(out.Value)
 			continue
 		}
 		if output.Tx.ToAddress != "" && output.Tx.ToAddress != out.ScriptPubKey.Addresses[0] {
-			return nil, fmt.Errorf("Not support send 2+ tx ")
+			return nil, newError(ErrKindInvalidParams, "Not support send 2+ tx ")
 		}
 		output.Tx.ToAddress = out.ScriptPubKey.Addresses[0]
-		amount += out.Value
+		amount += chain.amountOf(out.Value)
 	}
-	fee := inputAmount - change - amount
+	fee := inputAmount - change - amount - amountOther
 
 	//turn to big int
-	bigIntAmount := new(big.Int)
-	bigIntAmount.SetUint64(uint64(decimal.NewFromFloat(amount).Mul(decimal.New(1, 8)).IntPart()))
-	output.Tx.Amount = adaptor.NewAmountAsset(bigIntAmount, "BTC")
-	bigIntFee := new(big.Int)
-	bigIntFee.SetUint64(uint64(decimal.NewFromFloat(fee).Mul(decimal.New(1, 8)).IntPart()))
-	output.Tx.Fee = adaptor.NewAmountAsset(bigIntFee, "BTC")
+	output.Tx.Amount = adaptor.NewAmountAsset(bigAmount(amount), chain.Symbol)
+	output.Tx.Fee = adaptor.NewAmountAsset(bigAmount(fee), chain.Symbol)
 
 	output.Tx.TxID, _ = hex.DecodeString(txResult.Txid)
 	txRaw, _ := hex.DecodeString(txResult.Hex)
@@ -586,30 +627,48 @@ func GetTransferTx(input *adaptor.GetTransferTxInput, rpcParams *RPCParams) (*ad
 		output.Tx.IsSuccess = true
 		blockID, _ := hex.DecodeString(txResult.BlockHash)
 		output.Tx.BlockID = blockID
-		blkHash, err := chainhash.NewHashFromStr(txResult.BlockHash)
+		heights, err := resolver.getBlockHeights(ctx, []string{txResult.BlockHash})
 		if err == nil {
-			blkResult, err := client.GetBlockVerbose(blkHash) //BTCD API
-			if err == nil {
-				output.Tx.BlockHeight = uint(blkResult.Height)
-			}
+			output.Tx.BlockHeight = uint(heights[txResult.BlockHash])
+		}
+		indexes, err := resolver.getTxIndexes(ctx, []string{txResult.Txid}, []string{txResult.BlockHash})
+		if err != nil {
+			return nil, err
 		}
+		output.Tx.TxIndex = indexes[txResult.Txid]
 	} else {
 		output.Tx.IsInBlock = false
 		output.Tx.IsSuccess = false
 	}
-	if txResult.Confirmations >= MinConfirm {
-		output.Tx.IsStable = true
-	} else {
-		output.Tx.IsStable = false
+	bits, err := policy.blockBitsByClient(client, txResult.BlockHash)
+	if err != nil {
+		return nil, err
 	}
-	output.Tx.TxIndex = 0 //todo
+	output.Tx.IsStable = policy.IsFinal(int64(txResult.Confirmations), sumVout(txResult.Vout), bits)
 	output.Tx.Timestamp = uint64(txResult.Blocktime)
 
 	return &output, nil
 }
 
+//txIndexes return the positions of txids in a block
+func txIndexes(txids []string) map[string]uint {
+	indexes := make(map[string]uint, len(txids))
+	for i, txid := range txids {
+		indexes[txid] = uint(i)
+	}
+	return indexes
+}
+
 func httpGet(url string) (string, error, int) {
-	resp, err := http.Get(url)
+	return httpGetCtx(context.Background(), url)
+}
+
+func httpGetCtx(ctx context.Context, url string) (string, error, int) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return "", err, 0
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return "", err, 0
 	}
@@ -624,7 +683,16 @@ func httpGet(url string) (string, error, int) {
 }
 
 func httpPost(url string, params string) (string, error, int) {
-	resp, err := http.Post(url, "application/json", strings.NewReader(params))
+	return httpPostCtx(context.Background(), url, params)
+}
+
+func httpPostCtx(ctx context.Context, url string, params string) (string, error, int) {
+	req, err := http.NewRequest("POST", url, strings.NewReader(params))
+	if err != nil {
+		return "", err, 0
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return "", err, 0
 	}
@@ -676,9 +744,13 @@ type GetTransactionHttpResponse struct {
 }
 
 func GetTxBasicInfoHttp(input *adaptor.GetTxBasicInfoInput, netID int) (*adaptor.GetTxBasicInfoOutput, error) {
+	return GetTxBasicInfoHttpCtx(context.Background(), input, netID)
+}
+
+func GetTxBasicInfoHttpCtx(ctx context.Context, input *adaptor.GetTxBasicInfoInput, netID int) (*adaptor.GetTxBasicInfoOutput, error) {
 	txHash := hex.EncodeToString(input.TxID)
 	if "" == txHash {
-		return nil, fmt.Errorf("TxHash is empty")
+		return nil, newError(ErrKindInvalidParams, "TxHash is empty")
 	}
 
 	var request string
@@ -693,9 +765,12 @@ func GetTxBasicInfoHttp(input *adaptor.GetTxBasicInfoInput, netID int) (*adaptor
 		request = base + "get_raw_transaction/?api_key=f13a-e0e6-614f-bbf8&txid="
 	}
 	//
-	strRespose, err, _ := httpGet(request + txHash)
+	strRespose, err, _ := httpGetCtx(ctx, request+txHash)
 	if err != nil {
-		return nil, err
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, wrapError("httpGet failed", err)
 	}
 
 	var txResult GetTransactionHttpResponse
@@ -719,7 +794,7 @@ func GetTxBasicInfoHttp(input *adaptor.GetTxBasicInfoInput, netID int) (*adaptor
 			continue
 		}
 		if toAddr != "" && toAddr != out.Address {
-			return nil, fmt.Errorf("Not support send 2+ tx ")
+			return nil, newError(ErrKindInvalidParams, "Not support send 2+ tx ")
 		}
 		toAddr = out.Address
 		break
@@ -740,12 +815,8 @@ func GetTxBasicInfoHttp(input *adaptor.GetTxBasicInfoInput, netID int) (*adaptor
 		output.Tx.IsInBlock = false
 		output.Tx.IsSuccess = false
 	}
-	if txResult.Data.Confirmations >= MinConfirm {
-		output.Tx.IsStable = true
-	} else {
-		output.Tx.IsStable = false
-	}
-	output.Tx.TxIndex = 0 //todo
+	output.Tx.IsStable = int64(txResult.Data.Confirmations) >= defaultFinality(netID).Required(rawTxAmount(txRaw)) //no bits by http
+	output.Tx.TxIndex = 0 //not provided by block.io
 	output.Tx.Timestamp = uint64(txResult.Data.Time)
 
 	return &output, nil
@@ -49,9 +49,12 @@ func TestCreateTransferTokenTx(t *testing.T) {
 		fmt.Printf("Extra : %x\n", output.Extra)
 		rawTxHex := fmt.Sprintf("%x", output.Transaction)
 		fmt.Printf("rawTxHex : %s\n", rawTxHex)
-		_, err = decodeRawTransaction(rawTxHex, NETID_TEST)
+		decoded, err := DecodeTransaction(output.Transaction, GetNet(NETID_TEST), nil)
 		if err != nil {
 			fmt.Println(err.Error())
+		} else {
+			decodedJSON, _ := json.Marshal(decoded)
+			fmt.Println(string(decodedJSON))
 		}
 	}
 }
@@ -0,0 +1,221 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+const (
+	defaultTxCacheSize    = 10000
+	defaultBlockCacheSize = 10000
+	defaultIndexCacheSize = 10000 //blocks of the resolved positions of txs
+)
+
+//lruCache is a bounded least recently used cache, not concurrency-safe
+type lruCache struct {
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{capacity: capacity, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *lruCache) get(key string) (interface{}, bool) {
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		return elem.Value.(*lruEntry).value, true
+	}
+	return nil, false
+}
+
+//add add or update key, return the evicted entry if any
+func (c *lruCache) add(key string, value interface{}) *lruEntry {
+	if elem, ok := c.items[key]; ok {
+		c.ll.MoveToFront(elem)
+		elem.Value.(*lruEntry).value = value
+		return nil
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value})
+	if c.ll.Len() <= c.capacity {
+		return nil
+	}
+	oldest := c.ll.Back()
+	c.ll.Remove(oldest)
+	entry := oldest.Value.(*lruEntry)
+	delete(c.items, entry.key)
+	return entry
+}
+
+func (c *lruCache) remove(key string) {
+	if elem, ok := c.items[key]; ok {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+func (c *lruCache) len() int {
+	return c.ll.Len()
+}
+
+//blockEntry is a cached main chain block header
+type blockEntry struct {
+	height   int64
+	prevHash string
+}
+
+//txCache cache the decoded confirmed transactions and the heights of main chain blocks.
+//The entries of a block are removed when the block is orphaned by a reorg.
+type txCache struct {
+	mtx      sync.Mutex
+	txs      *lruCache                  //txid -> *btcjson.TxRawResult
+	blocks   *lruCache                  //block hash -> *blockEntry
+	blockTxs map[string]map[string]bool //block hash -> cached txids
+	indexes  *lruCache                  //block hash -> map[string]uint, the positions of the resolved txs in the block
+	tip      string                     //the best block hash seen
+}
+
+func newTxCache(txSize, blockSize int) *txCache {
+	if txSize <= 0 {
+		txSize = defaultTxCacheSize
+	}
+	if blockSize <= 0 {
+		blockSize = defaultBlockCacheSize
+	}
+	return &txCache{txs: newLRUCache(txSize), blocks: newLRUCache(blockSize), blockTxs: map[string]map[string]bool{},
+		indexes: newLRUCache(defaultIndexCacheSize)}
+}
+
+func (c *txCache) getTx(txid string) *btcjson.TxRawResult {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if value, ok := c.txs.get(txid); ok {
+		return value.(*btcjson.TxRawResult)
+	}
+	return nil
+}
+
+//addTx cache a confirmed tx, unconfirmed txs are not cached
+func (c *txCache) addTx(tx *btcjson.TxRawResult) {
+	if tx.BlockHash == "" {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if evicted := c.txs.add(tx.Txid, tx); evicted != nil {
+		c.unindexTx(evicted.value.(*btcjson.TxRawResult))
+	}
+	txids, ok := c.blockTxs[tx.BlockHash]
+	if !ok {
+		txids = map[string]bool{}
+		c.blockTxs[tx.BlockHash] = txids
+	}
+	txids[tx.Txid] = true
+}
+
+func (c *txCache) unindexTx(tx *btcjson.TxRawResult) {
+	if txids, ok := c.blockTxs[tx.BlockHash]; ok {
+		delete(txids, tx.Txid)
+		if len(txids) == 0 {
+			delete(c.blockTxs, tx.BlockHash)
+		}
+	}
+}
+
+func (c *txCache) getBlock(hash string) *blockEntry {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if value, ok := c.blocks.get(hash); ok {
+		return value.(*blockEntry)
+	}
+	return nil
+}
+
+//addBlock cache a main chain block
+func (c *txCache) addBlock(hash string, height int64, prevHash string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.blocks.add(hash, &blockEntry{height: height, prevHash: prevHash})
+}
+
+func (c *txCache) getTxIndex(hash, txid string) (uint, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if value, ok := c.indexes.get(hash); ok {
+		index, ok := value.(map[string]uint)[txid]
+		return index, ok
+	}
+	return 0, false
+}
+
+//addTxIndex cache the position of a tx in a block
+func (c *txCache) addTxIndex(hash, txid string, index uint) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if value, ok := c.indexes.get(hash); ok {
+		value.(map[string]uint)[txid] = index
+		return
+	}
+	c.indexes.add(hash, map[string]uint{txid: index})
+}
+
+//removeBlock remove the block and the txs in it, return the previous block hash if cached
+func (c *txCache) removeBlock(hash string) string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	prevHash := ""
+	if value, ok := c.blocks.get(hash); ok {
+		prevHash = value.(*blockEntry).prevHash
+	}
+	c.blocks.remove(hash)
+	c.indexes.remove(hash)
+	for txid := range c.blockTxs[hash] {
+		c.txs.remove(txid)
+	}
+	delete(c.blockTxs, hash)
+	return prevHash
+}
+
+//setTip set the best block hash, return the previous one
+func (c *txCache) setTip(tip string) string {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	old := c.tip
+	c.tip = tip
+	return old
+}
+
+//clear remove all entries
+func (c *txCache) clear() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.txs = newLRUCache(c.txs.capacity)
+	c.blocks = newLRUCache(c.blocks.capacity)
+	c.blockTxs = map[string]map[string]bool{}
+	c.indexes = newLRUCache(c.indexes.capacity)
+}
@@ -0,0 +1,48 @@
+package btcadaptor
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcjson"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", 1)
+	c.add("b", 2)
+	c.get("a")
+	evicted := c.add("c", 3)
+	if evicted == nil || evicted.key != "b" {
+		t.Fatalf("unexpected evicted entry - got: %v, "+"want: %v", evicted, "b")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Errorf("recently used entry is evicted")
+	}
+	if c.len() != 2 {
+		t.Errorf("unexpected len - got: %v, "+"want: %v", c.len(), 2)
+	}
+}
+
+func TestTxCacheRemoveBlock(t *testing.T) {
+	c := newTxCache(1, 0)
+	c.addTx(&btcjson.TxRawResult{Txid: "unconfirmed"})
+	if c.getTx("unconfirmed") != nil {
+		t.Errorf("unconfirmed tx is cached")
+	}
+	c.addBlock("b1", 1, "b0")
+	c.addBlock("b2", 2, "b1")
+	c.addTx(&btcjson.TxRawResult{Txid: "t1", BlockHash: "b1"})
+	c.addTx(&btcjson.TxRawResult{Txid: "t2", BlockHash: "b2"}) //evict t1
+	if c.getTx("t1") != nil || len(c.blockTxs["b1"]) != 0 {
+		t.Errorf("evicted tx is still indexed")
+	}
+	if prevHash := c.removeBlock("b2"); prevHash != "b1" {
+		t.Errorf("unexpected prev hash - got: %v, "+"want: %v", prevHash, "b1")
+	}
+	if c.getTx("t2") != nil || c.getBlock("b2") != nil {
+		t.Errorf("orphaned block is still cached")
+	}
+	if c.getBlock("b1") == nil {
+		t.Errorf("main chain block is removed")
+	}
+}
@@ -94,6 +94,12 @@ const (
 	// operation whose public key isn't serialized in a compressed format
 	// non-standard.
 	ScriptVerifyWitnessPubKeyType
+
+	// ScriptVerifyForkID defines whether signatures are checked as Bitcoin
+	// Cash: signatures with SigHashForkID sign the BIP0143 digest and are
+	// not removed from the subscript, and with the strict encoding flag,
+	// signatures without SigHashForkID are rejected.
+	ScriptVerifyForkID
 )
 
 const (
@@ -136,6 +142,31 @@ type Engine struct {
 	witnessVersion  int
 	witnessProgram  []byte
 	inputAmount     int64
+	sigChecks       []SigCheck // signature checks of the last step
+}
+
+// SigCheck describes a single signature verification performed by one of the
+// signature checking opcodes.  It is recorded so callers stepping through a
+// script can inspect why a signature check failed.
+type SigCheck struct {
+	// Signature is the raw signature including the trailing hash type
+	// byte.  It is empty when an empty signature was skipped.
+	Signature []byte
+
+	// PubKey is the serialized public key the signature was checked
+	// against.
+	PubKey []byte
+
+	// HashType is the signature hash type of the signature.
+	HashType SigHashType
+
+	// SigHash is the calculated signature hash.  It is nil when the
+	// signature or the public key could not be parsed and thus no hash was
+	// calculated.
+	SigHash []byte
+
+	// Valid is whether or not the signature is valid for the public key.
+	Valid bool
 }
 
 // hasFlag returns whether the script engine instance has the passed flag set.
@@ -358,6 +389,12 @@ func (vm *Engine) verifyWitnessProgram(witness [][]byte) error {
 	return nil
 }
 
+// PC returns the index of the script and the offset of the opcode within that
+// script that will be next to execute when Step() is called.
+func (vm *Engine) PC() (scriptIdx int, scriptOff int, err error) {
+	return vm.curPC()
+}
+
 // DisasmPC returns the string for the disassembly of the opcode that will be
 // next to execute when Step() is called.
 func (vm *Engine) DisasmPC() (string, error) {
@@ -447,6 +484,7 @@ func (vm *Engine) Step() (done bool, err error) {
 	}
 	opcode := &vm.scripts[vm.scriptIdx][vm.scriptOff]
 	vm.scriptOff++
+	vm.sigChecks = nil
 
 	// Execute the opcode while taking into account several things such as
 	// disabled opcodes, illegal opcodes, maximum allowed operations per
@@ -573,6 +611,14 @@ func (vm *Engine) checkHashTypeEncoding(hashType SigHashType) error {
 	}
 
 	sigHashType := hashType & ^SigHashAnyOneCanPay
+	if vm.hasFlag(ScriptVerifyForkID) {
+		if hashType&SigHashForkID == 0 {
+			str := fmt.Sprintf("hash type 0x%x does not use fork id",
+				hashType)
+			return scriptError(ErrInvalidSigHashType, str)
+		}
+		sigHashType &^= SigHashForkID
+	}
 	if sigHashType < SigHashAll || sigHashType > SigHashSingle {
 		str := fmt.Sprintf("invalid hash type 0x%x", hashType)
 		return scriptError(ErrInvalidSigHashType, str)
@@ -580,6 +626,13 @@ func (vm *Engine) checkHashTypeEncoding(hashType SigHashType) error {
 	return nil
 }
 
+// usesForkID returns whether the signature is checked against the Bitcoin Cash
+// digest of SigHashForkID.
+func (vm *Engine) usesForkID(sig []byte) bool {
+	return vm.hasFlag(ScriptVerifyForkID) && len(sig) > 0 &&
+		SigHashType(sig[len(sig)-1])&SigHashForkID != 0
+}
+
 // checkPubKeyEncoding returns whether or not the passed public key adheres to
 // the strict encoding requirements if enabled.
 func (vm *Engine) checkPubKeyEncoding(pubKey []byte) error {
@@ -849,6 +902,37 @@ func (vm *Engine) SetAltStack(data [][]byte) {
 	setStack(&vm.astack, data)
 }
 
+// GetCondStack returns the contents of the condition stack as an array where
+// the last item in the array is the innermost conditional.  Each item is one of
+// OpCondFalse, OpCondTrue or OpCondSkip.
+func (vm *Engine) GetCondStack() []int {
+	condStack := make([]int, len(vm.condStack))
+	copy(condStack, vm.condStack)
+	return condStack
+}
+
+// SigChecks returns the signature checks performed by the opcode executed by
+// the last call to Step, in the order they were performed.  It is empty when
+// the opcode is not a signature checking opcode.
+func (vm *Engine) SigChecks() []SigCheck {
+	return vm.sigChecks
+}
+
+// recordSigCheck records a signature check performed by the opcode being
+// executed.
+func (vm *Engine) recordSigCheck(sig, pubKey, hash []byte, valid bool) {
+	check := SigCheck{
+		Signature: sig,
+		PubKey:    pubKey,
+		SigHash:   hash,
+		Valid:     valid,
+	}
+	if len(sig) > 0 {
+		check.HashType = SigHashType(sig[len(sig)-1])
+	}
+	vm.sigChecks = append(vm.sigChecks, check)
+}
+
 // NewEngine returns a new script engine for the provided public key script,
 // transaction, and input index.  The flags modify the behavior of the script
 // engine according to the description provided by each flag.
@@ -5,8 +5,11 @@
 package txscript
 
 import (
+	"bytes"
+	"reflect"
 	"testing"
 
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/wire"
 )
@@ -145,6 +148,110 @@ func TestCheckErrorCondition(t *testing.T) {
 	}
 }
 
+// TestStepInspection ensures the program counter, condition stack and
+// signature checks exposed while stepping through a script are correct.
+func TestStepInspection(t *testing.T) {
+	t.Parallel()
+
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("failed to create private key: %v", err)
+	}
+	pubKey := privKey.PubKey().SerializeCompressed()
+
+	// Sign a hash other than the signature hash so the check fails.
+	sig, err := privKey.Sign(chainhash.DoubleHashB([]byte("wrong")))
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	fullSig := append(sig.Serialize(), byte(SigHashAll))
+
+	tx := &wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Index: 0},
+			Sequence:         4294967295,
+		}},
+		TxOut: []*wire.TxOut{{Value: 1000000000}},
+	}
+	sigScript, err := NewScriptBuilder().AddData(fullSig).AddOp(OP_TRUE).
+		Script()
+	if err != nil {
+		t.Fatalf("failed to build signature script: %v", err)
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+	pkScript, err := NewScriptBuilder().AddOp(OP_IF).AddData(pubKey).
+		AddOp(OP_CHECKSIG).AddOp(OP_ENDIF).Script()
+	if err != nil {
+		t.Fatalf("failed to build public key script: %v", err)
+	}
+
+	vm, err := NewEngine(pkScript, tx, 0, 0, nil, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create script: %v", err)
+	}
+
+	// Each entry is the program counter before a step and the condition
+	// stack after it.  The fifth step is the signature check.
+	tests := []struct {
+		scriptIdx, scriptOff int
+		condStack            []int
+	}{
+		{scriptIdx: 0, scriptOff: 0, condStack: []int{}},
+		{scriptIdx: 0, scriptOff: 1, condStack: []int{}},
+		{scriptIdx: 1, scriptOff: 0, condStack: []int{OpCondTrue}},
+		{scriptIdx: 1, scriptOff: 1, condStack: []int{OpCondTrue}},
+		{scriptIdx: 1, scriptOff: 2, condStack: []int{OpCondTrue}},
+		{scriptIdx: 1, scriptOff: 3, condStack: []int{}},
+	}
+	var sigChecks []SigCheck
+	for i, test := range tests {
+		scriptIdx, scriptOff, err := vm.PC()
+		if err != nil {
+			t.Fatalf("failed to get pc on step %d: %v", i, err)
+		}
+		if scriptIdx != test.scriptIdx || scriptOff != test.scriptOff {
+			t.Fatalf("unexpected pc on step %d - got %d:%d, want %d:%d",
+				i, scriptIdx, scriptOff, test.scriptIdx,
+				test.scriptOff)
+		}
+		if _, err := vm.Step(); err != nil {
+			t.Fatalf("failed to step %dth time: %v", i, err)
+		}
+		condStack := vm.GetCondStack()
+		if !reflect.DeepEqual(condStack, test.condStack) {
+			t.Errorf("unexpected condition stack after step %d - "+
+				"got %v, want %v", i, condStack, test.condStack)
+		}
+		if i == 4 {
+			sigChecks = vm.SigChecks()
+		} else if len(vm.SigChecks()) != 0 {
+			t.Errorf("unexpected signature checks on step %d - "+
+				"got %d", i, len(vm.SigChecks()))
+		}
+	}
+
+	// Ensure the failed signature check is described.
+	if len(sigChecks) != 1 {
+		t.Fatalf("unexpected signature checks - got %d, want 1",
+			len(sigChecks))
+	}
+	check := sigChecks[0]
+	if !bytes.Equal(check.Signature, fullSig) ||
+		!bytes.Equal(check.PubKey, pubKey) ||
+		check.HashType != SigHashAll || len(check.SigHash) != 32 ||
+		check.Valid {
+
+		t.Errorf("unexpected signature check - got %+v", check)
+	}
+	if _, _, err := vm.PC(); !IsErrorCode(err, ErrInvalidProgramCounter) {
+		t.Errorf("unexpected pc error after the scripts - got %v", err)
+	}
+	if err := vm.CheckErrorCondition(true); !IsErrorCode(err, ErrEvalFalse) {
+		t.Errorf("unexpected error on final check - got %v", err)
+	}
+}
+
 // TestInvalidFlagCombinations ensures the script engine returns the expected
 // error when disallowed flag combinations are specified.
 func TestInvalidFlagCombinations(t *testing.T) {
@@ -338,6 +338,15 @@ const (
 	// serialized in a compressed format.
 	ErrWitnessPubKeyType
 
+	// -------------------------------------------
+	// Failures related to assembling scripts.
+	// -------------------------------------------
+
+	// ErrMalformedAsm is returned when the assembly text passed to
+	// Assemble contains a token which is not an opcode, a small integer,
+	// hex data or a bound placeholder.
+	ErrMalformedAsm
+
 	// numErrorCodes is the maximum error code number used in tests.  This
 	// entry MUST be the last entry in the enum.
 	numErrorCodes
@@ -409,6 +418,7 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrMinimalIf:                          "ErrMinimalIf",
 	ErrWitnessPubKeyType:                  "ErrWitnessPubKeyType",
 	ErrDiscourageUpgradableWitnessProgram: "ErrDiscourageUpgradableWitnessProgram",
+	ErrMalformedAsm:                       "ErrMalformedAsm",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -2057,6 +2057,7 @@ func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
 	// least 1 byte is needed for the hash type below.  The full length is
 	// checked depending on the script flags and upon parsing the signature.
 	if len(fullSigBytes) < 1 {
+		vm.recordSigCheck(fullSigBytes, pkBytes, nil, false)
 		vm.dstack.PushBool(false)
 		return nil
 	}
@@ -2103,6 +2104,19 @@ func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
 		if err != nil {
 			return err
 		}
+	} else if vm.usesForkID(fullSigBytes) {
+		var sigHashes *TxSigHashes
+		if vm.hashCache != nil {
+			sigHashes = vm.hashCache
+		} else {
+			sigHashes = NewTxSigHashes(&vm.tx)
+		}
+
+		hash, err = calcForkIDSignatureHash(subScript, sigHashes, hashType,
+			&vm.tx, vm.txIdx, vm.inputAmount)
+		if err != nil {
+			return err
+		}
 	} else {
 		// Remove the signature since there is no way for a signature
 		// to sign itself.
@@ -2113,6 +2127,7 @@ func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
 
 	pubKey, err := btcec.ParsePubKey(pkBytes, btcec.S256())
 	if err != nil {
+		vm.recordSigCheck(fullSigBytes, pkBytes, nil, false)
 		vm.dstack.PushBool(false)
 		return nil
 	}
@@ -2126,6 +2141,7 @@ func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
 		signature, err = btcec.ParseSignature(sigBytes, btcec.S256())
 	}
 	if err != nil {
+		vm.recordSigCheck(fullSigBytes, pkBytes, nil, false)
 		vm.dstack.PushBool(false)
 		return nil
 	}
@@ -2143,6 +2159,7 @@ func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
 	} else {
 		valid = signature.Verify(hash, pubKey)
 	}
+	vm.recordSigCheck(fullSigBytes, pkBytes, hash, valid)
 
 	if !valid && vm.hasFlag(ScriptVerifyNullFail) && len(sigBytes) > 0 {
 		str := "signature not empty on failed checksig"
@@ -2276,10 +2293,16 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 	script := vm.subScript()
 
 	// Remove the signature in pre version 0 segwit scripts since there is
-	// no way for a signature to sign itself.
+	// no way for a signature to sign itself.  The Bitcoin Cash signatures
+	// with fork id are not removed.  Empty signatures are skipped since
+	// every push contains them, they never verify so it only changes the
+	// signature hash reported by the signature checks.
 	if !vm.isWitnessVersionActive(0) {
 		for _, sigInfo := range signatures {
-			script = removeOpcodeByData(script, sigInfo.signature)
+			if len(sigInfo.signature) > 0 &&
+				!vm.usesForkID(sigInfo.signature) {
+				script = removeOpcodeByData(script, sigInfo.signature)
+			}
 		}
 	}
 
@@ -2308,6 +2331,7 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 		rawSig := sigInfo.signature
 		if len(rawSig) == 0 {
 			// Skip to the next pubkey if signature is empty.
+			vm.recordSigCheck(rawSig, pubKey, nil, false)
 			continue
 		}
 
@@ -2338,12 +2362,14 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 			}
 			sigInfo.parsed = true
 			if err != nil {
+				vm.recordSigCheck(rawSig, pubKey, nil, false)
 				continue
 			}
 			sigInfo.parsedSignature = parsedSig
 		} else {
 			// Skip to the next pubkey if the signature is invalid.
 			if sigInfo.parsedSignature == nil {
+				vm.recordSigCheck(rawSig, pubKey, nil, false)
 				continue
 			}
 
@@ -2358,6 +2384,7 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 		// Parse the pubkey.
 		parsedPubKey, err := btcec.ParsePubKey(pubKey, btcec.S256())
 		if err != nil {
+			vm.recordSigCheck(rawSig, pubKey, nil, false)
 			continue
 		}
 
@@ -2376,6 +2403,19 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 			if err != nil {
 				return err
 			}
+		} else if vm.usesForkID(rawSig) {
+			var sigHashes *TxSigHashes
+			if vm.hashCache != nil {
+				sigHashes = vm.hashCache
+			} else {
+				sigHashes = NewTxSigHashes(&vm.tx)
+			}
+
+			hash, err = calcForkIDSignatureHash(script, sigHashes, hashType,
+				&vm.tx, vm.txIdx, vm.inputAmount)
+			if err != nil {
+				return err
+			}
 		} else {
 			hash = calcSignatureHash(script, hashType, &vm.tx, vm.txIdx)
 		}
@@ -2393,6 +2433,7 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 		} else {
 			valid = parsedSig.Verify(hash, parsedPubKey)
 		}
+		vm.recordSigCheck(rawSig, pubKey, hash, valid)
 
 		if valid {
 			// PubKey verified, move on to the next signature.
@@ -498,6 +498,109 @@ func TestScripts(t *testing.T) {
 	testScripts(t, tests, false)
 }
 
+// TestAssembleScriptTests ensures the scripts in script_tests.json which only
+// contain minimal data pushes round trip through DisasmString and Assemble.
+func TestAssembleScriptTests(t *testing.T) {
+	file, err := ioutil.ReadFile("data/script_tests.json")
+	if err != nil {
+		t.Fatalf("TestAssembleScriptTests: %v\n", err)
+	}
+
+	var tests [][]interface{}
+	err = json.Unmarshal(file, &tests)
+	if err != nil {
+		t.Fatalf("TestAssembleScriptTests couldn't Unmarshal: %v", err)
+	}
+
+	var roundTrips int
+	for i, test := range tests {
+		// Skip single line comments.
+		if len(test) == 1 {
+			continue
+		}
+
+		// The scripts follow the witness data when present.
+		witnessOffset := 0
+		if _, ok := test[0].([]interface{}); ok {
+			witnessOffset++
+		}
+		for _, field := range test[witnessOffset : witnessOffset+2] {
+			short, ok := field.(string)
+			if !ok {
+				continue
+			}
+			script, err := parseShortForm(short)
+			if err != nil {
+				t.Errorf("test #%d: can't parse script %q: %v", i,
+					short, err)
+				continue
+			}
+
+			// Scripts which fail to parse are disassembled with
+			// [error] and can't be assembled.
+			pops, err := parseScript(script)
+			if err != nil {
+				continue
+			}
+			minimal, ambiguous, tooBig := true, false, false
+			for _, pop := range pops {
+				if pop.opcode.value > OP_PUSHDATA4 {
+					continue
+				}
+				if pop.checkMinimalDataPush() != nil {
+					minimal = false
+				}
+				if _, ok := asmScriptNum(hex.EncodeToString(
+					pop.data)); ok {
+
+					ambiguous = true
+				}
+				if len(pop.data) > MaxScriptElementSize {
+					tooBig = true
+				}
+			}
+
+			// Data which is disassembled as hex digits only, such
+			// as the single byte 0x11, reads as a decimal number.
+			if ambiguous {
+				continue
+			}
+
+			asm, _ := DisasmString(script)
+			assembled, err := Assemble(asm, nil)
+			switch {
+			case !minimal:
+				if err == nil && bytes.Equal(assembled, script) {
+					t.Errorf("test #%d: non-minimal script %q "+
+						"round trips", i, short)
+				}
+			case tooBig:
+				if !IsErrorCode(err, ErrElementTooBig) {
+					t.Errorf("test #%d: unexpected error for "+
+						"script %q - got %v, want %v", i,
+						short, err, ErrElementTooBig)
+				}
+			case len(script) > MaxScriptSize:
+				if !IsErrorCode(err, ErrScriptTooBig) {
+					t.Errorf("test #%d: unexpected error for "+
+						"script %q - got %v, want %v", i,
+						short, err, ErrScriptTooBig)
+				}
+			case err != nil:
+				t.Errorf("test #%d: failed to assemble %q of "+
+					"script %q: %v", i, asm, short, err)
+			case !bytes.Equal(assembled, script):
+				t.Errorf("test #%d: script %q does not round "+
+					"trip - got %x, want %x", i, short,
+					assembled, script)
+			default:
+				roundTrips++
+			}
+		}
+	}
+	t.Logf("%d scripts round trip", roundTrips)
+}
+
 // testVecF64ToUint32 properly handles conversion of float64s read from the JSON
 // test data to unsigned 32-bit integers.  This is necessary because some of the
 // test data uses -1 as a shortcut to mean max uint32 and direct conversion of a
@@ -7,7 +7,11 @@ package txscript
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
@@ -30,6 +34,10 @@ const (
 	SigHashSingle       SigHashType = 0x3
 	SigHashAnyOneCanPay SigHashType = 0x80
 
+	// SigHashForkID is the Bitcoin Cash hash type bit which selects the
+	// BIP0143 digest for all inputs, with a fork id of zero.
+	SigHashForkID SigHashType = 0x40
+
 	// sigHashMask defines the number of bits of the hash type which is used
 	// to identify which outputs are signed.
 	sigHashMask = 0x1f
@@ -319,6 +327,169 @@ func DisasmString(buf []byte) (string, error) {
 	return disbuf.String(), err
 }
 
+// Assemble returns the script for the passed one-line assembly.  The dialect
+// is the one of DisasmString and of the asm field of Bitcoin Core, and the
+// assembly is a whitespace separated list of tokens where each token is one of
+// the following:
+//
+//   - an opcode name such as OP_DUP or OP_CHECKMULTISIG
+//   - a decimal script number such as -1, 16 or 500000, which is pushed with
+//     OP_1NEGATE, OP_0 or OP_1 through OP_16 from -1 to 16, and as the minimal
+//     encoding of the number otherwise
+//   - hex encoded data, optionally prefixed with 0x, which is pushed with the
+//     smallest possible data push opcode
+//   - a placeholder such as <pubkey> which pushes the data bound to its name
+//     in bindings
+//
+// A token is a decimal script number when it is the canonical decimal form
+// of a number which fits in four bytes, as Bitcoin Core writes data pushes of
+// up to four bytes.  Hex data which reads as such a number, for example the
+// single byte 0x17 or the two bytes 0x1234, must be written with the 0x
+// prefix, so this is the inverse of DisasmString only for the scripts whose
+// data pushes are minimal and do not read as decimal numbers.
+//
+// Data which must be pushed as a small integer in order to be minimal is
+// rejected when written in hex.  The data bound to a placeholder is pushed
+// minimally, so a bound single byte of 5 is pushed with OP_5.
+func Assemble(asm string, bindings map[string][]byte) ([]byte, error) {
+	var script []byte
+	for i, tok := range strings.Fields(asm) {
+		var data []byte
+		num, isNum := asmScriptNum(tok)
+		switch {
+		// Small integers as written by DisasmString and Bitcoin Core.
+		case isNum && num >= -1 && num <= 16:
+			script = append(script, smallIntOpcode(int(num)))
+			continue
+
+		// Data pushes of up to four bytes as written by Bitcoin Core.
+		case isNum:
+			data = scriptNum(num).Bytes()
+
+		case strings.HasPrefix(tok, "<") && strings.HasSuffix(tok, ">"):
+			bound, ok := bindings[tok[1:len(tok)-1]]
+			if !ok {
+				str := fmt.Sprintf("token %d: placeholder %s is not "+
+					"bound", i, tok)
+				return nil, scriptError(ErrMalformedAsm, str)
+			}
+			data = bound
+
+		case strings.HasPrefix(tok, "OP_"):
+			opcode, ok := OpcodeByName[tok]
+			if !ok {
+				str := fmt.Sprintf("token %d: unknown opcode %s", i,
+					tok)
+				return nil, scriptError(ErrMalformedAsm, str)
+			}
+			if opcode >= OP_DATA_1 && opcode <= OP_PUSHDATA4 {
+				str := fmt.Sprintf("token %d: the data pushed by %s "+
+					"must be written in hex", i, tok)
+				return nil, scriptError(ErrMalformedAsm, str)
+			}
+			script = append(script, opcode)
+			continue
+
+		default:
+			var err error
+			data, err = hex.DecodeString(strings.TrimPrefix(tok, "0x"))
+			if err != nil || len(data) == 0 {
+				str := fmt.Sprintf("token %d: %s is not an opcode, "+
+					"a number, hex data or a placeholder", i,
+					tok)
+				return nil, scriptError(ErrMalformedAsm, str)
+			}
+			if len(data) == 1 && isSmallIntData(data[0]) {
+				num := int(data[0])
+				if data[0] == 0x81 {
+					num = -1
+				}
+				str := fmt.Sprintf("token %d: data push of %s must "+
+					"be written as the small integer %d", i, tok,
+					num)
+				return nil, scriptError(ErrMinimalData, str)
+			}
+		}
+
+		if len(data) > MaxScriptElementSize {
+			str := fmt.Sprintf("token %d: element size %d is larger "+
+				"than max allowed size %d", i, len(data),
+				MaxScriptElementSize)
+			return nil, scriptError(ErrElementTooBig, str)
+		}
+		script = appendMinimalPush(script, data)
+	}
+
+	if len(script) > MaxScriptSize {
+		str := fmt.Sprintf("script size %d is larger than max allowed "+
+			"size %d", len(script), MaxScriptSize)
+		return nil, scriptError(ErrScriptTooBig, str)
+	}
+	return script, nil
+}
+
+// asmScriptNum returns the script number of a token which is the canonical
+// decimal form of a number which fits in four bytes, so hex data with leading
+// zeros or more than ten digits is not mistaken for a number.
+func asmScriptNum(tok string) (int64, bool) {
+	num, err := strconv.ParseInt(tok, 10, 64)
+	if err != nil || tok != strconv.FormatInt(num, 10) ||
+		num > math.MaxInt32 || num < -math.MaxInt32 {
+
+		return 0, false
+	}
+	return num, true
+}
+
+// smallIntOpcode returns the opcode which pushes the small integer num, which
+// must be from -1 to 16.
+func smallIntOpcode(num int) byte {
+	switch num {
+	case -1:
+		return OP_1NEGATE
+	case 0:
+		return OP_0
+	}
+	return byte(OP_1 - 1 + num)
+}
+
+// isSmallIntData returns whether the single byte of data must be pushed with
+// one of the small integer opcodes in order to be a minimal data push.
+func isSmallIntData(b byte) bool {
+	return b >= 1 && b <= 16 || b == 0x81
+}
+
+// appendMinimalPush appends the minimal data push of the passed data to the
+// script as required by ScriptVerifyMinimalData.  Unlike the ScriptBuilder, a
+// single zero byte is pushed as data since OP_0 pushes an empty item.
+func appendMinimalPush(script, data []byte) []byte {
+	dataLen := len(data)
+	switch {
+	case dataLen == 0:
+		return append(script, OP_0)
+	case dataLen == 1 && isSmallIntData(data[0]):
+		if data[0] == 0x81 {
+			return append(script, OP_1NEGATE)
+		}
+		return append(script, OP_1-1+data[0])
+	case dataLen < OP_PUSHDATA1:
+		script = append(script, byte(OP_DATA_1-1+dataLen))
+	case dataLen <= 0xff:
+		script = append(script, OP_PUSHDATA1, byte(dataLen))
+	case dataLen <= 0xffff:
+		var buf [2]byte
+		binary.LittleEndian.PutUint16(buf[:], uint16(dataLen))
+		script = append(script, OP_PUSHDATA2)
+		script = append(script, buf[:]...)
+	default:
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], uint32(dataLen))
+		script = append(script, OP_PUSHDATA4)
+		script = append(script, buf[:]...)
+	}
+	return append(script, data...)
+}
+
 // removeOpcode will remove any opcode matching ``opcode'' from the opcode
 // stream in pkscript
 func removeOpcode(pkscript []parsedOpcode, opcode byte) []parsedOpcode {
@@ -438,6 +609,26 @@ func calcHashOutputs(tx *wire.MsgTx) chainhash.Hash {
 func calcWitnessSignatureHash(subScript []parsedOpcode, sigHashes *TxSigHashes,
 	hashType SigHashType, tx *wire.MsgTx, idx int, amt int64) ([]byte, error) {
 
+	return calcBip143SignatureHash(subScript, sigHashes, hashType, tx, idx,
+		amt, false)
+}
+
+// calcForkIDSignatureHash computes the Bitcoin Cash sighash digest of a
+// transaction's input signed with SigHashForkID.  It is the BIP0143 digest
+// of the witness inputs, applied to the subscript of the legacy inputs.
+func calcForkIDSignatureHash(subScript []parsedOpcode, sigHashes *TxSigHashes,
+	hashType SigHashType, tx *wire.MsgTx, idx int, amt int64) ([]byte, error) {
+
+	return calcBip143SignatureHash(subScript, sigHashes, hashType, tx, idx,
+		amt, true)
+}
+
+// calcBip143SignatureHash computes the BIP0143 digest.  The script code of a
+// p2wkh subscript is the p2pkh script unless forkID is set.
+func calcBip143SignatureHash(subScript []parsedOpcode, sigHashes *TxSigHashes,
+	hashType SigHashType, tx *wire.MsgTx, idx int, amt int64,
+	forkID bool) ([]byte, error) {
+
 	// As a sanity check, ensure the passed input index for the transaction
 	// is valid.
 	if idx > len(tx.TxIn)-1 {
@@ -485,7 +676,7 @@ func calcWitnessSignatureHash(subScript []parsedOpcode, sigHashes *TxSigHashes,
 	binary.LittleEndian.PutUint32(bIndex[:], txIn.PreviousOutPoint.Index)
 	sigHash.Write(bIndex[:])
 
-	if isWitnessPubKeyHash(subScript) {
+	if isWitnessPubKeyHash(subScript) && !forkID {
 		// The script code for a p2wkh is a length prefix varint for
 		// the next 25 bytes, followed by a re-creation of the original
 		// p2pkh pk script.
@@ -499,7 +690,8 @@ func calcWitnessSignatureHash(subScript []parsedOpcode, sigHashes *TxSigHashes,
 	} else {
 		// For p2wsh outputs, and future outputs, the script code is
 		// the original script, with all code separators removed,
-		// serialized with a var int length prefix.
+		// serialized with a var int length prefix.  So is the script
+		// code of all inputs with SigHashForkID.
 		rawScript, _ := unparseScript(subScript)
 		wire.WriteVarBytes(&sigHash, 0, rawScript)
 	}
@@ -554,6 +746,20 @@ func CalcWitnessSigHash(script []byte, sigHashes *TxSigHashes, hType SigHashType
 		amt)
 }
 
+// CalcForkIDSigHash computes the Bitcoin Cash sighash digest for the
+// specified input of the target transaction, hType must have SigHashForkID.
+func CalcForkIDSigHash(script []byte, sigHashes *TxSigHashes, hType SigHashType,
+	tx *wire.MsgTx, idx int, amt int64) ([]byte, error) {
+
+	parsedScript, err := parseScript(script)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse output script: %v", err)
+	}
+
+	return calcForkIDSignatureHash(parsedScript, sigHashes, hType, tx, idx,
+		amt)
+}
+
 // shallowCopyTx creates a shallow copy of the transaction for use when
 // calculating the signature hash.  It is used over the Copy method on the
 // transaction itself since that is a deep copy and therefore does more work and
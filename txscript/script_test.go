@@ -7,6 +7,7 @@ package txscript
 import (
 	"bytes"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/btcsuite/btcd/wire"
@@ -3740,6 +3741,170 @@ func TestPushedData(t *testing.T) {
 	}
 }
 
+// TestAssemble ensures assembling one-line disassembly works as expected,
+// including placeholders and the rejection of non-minimal data pushes.
+func TestAssemble(t *testing.T) {
+	t.Parallel()
+
+	hash160 := bytes.Repeat([]byte{0x0f}, 20)
+	pubKey1 := append([]byte{0x02}, bytes.Repeat([]byte{0x01}, 32)...)
+	pubKey2 := append([]byte{0x03}, bytes.Repeat([]byte{0x02}, 32)...)
+	bindings := map[string][]byte{
+		"hash":    hash160,
+		"pubkey1": pubKey1,
+		"pubkey2": pubKey2,
+		"five":    {0x05},
+		"empty":   nil,
+		"neg":     {0x81},
+		"zero":    {0x00},
+		"big":     make([]byte, MaxScriptElementSize+1),
+	}
+
+	// The disassembly of data such as the single byte 0x11 is hex digits
+	// only, which read as a decimal number, so scripts which push it are not
+	// expected to round trip.
+	tests := []struct {
+		name        string
+		asm         string
+		want        []byte
+		err         ErrorCode
+		noRoundTrip bool
+	}{
+		{
+			name: "p2pkh with placeholder",
+			asm:  "OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG",
+			want: append(append([]byte{OP_DUP, OP_HASH160, OP_DATA_20},
+				hash160...), OP_EQUALVERIFY, OP_CHECKSIG),
+		},
+		{
+			name: "multisig with placeholders",
+			asm:  "1 <pubkey1> <pubkey2> 2 OP_CHECKMULTISIG",
+			want: append(append(append(append([]byte{OP_1, OP_DATA_33},
+				pubKey1...), OP_DATA_33), pubKey2...), OP_2,
+				OP_CHECKMULTISIG),
+		},
+		{
+			name: "small integers and single bytes",
+			asm:  "-1 0 16 00 0x11 11 OP_TRUE",
+			want: []byte{OP_1NEGATE, OP_0, OP_16, OP_DATA_1, 0x00,
+				OP_DATA_1, 0x11, OP_11, OP_1},
+			noRoundTrip: true,
+		},
+		{
+			name: "decimal script numbers",
+			asm: "500000 OP_CHECKLOCKTIMEVERIFY OP_DROP 17 -5 " +
+				"1600000000 -2147483647",
+			want: []byte{OP_DATA_3, 0x20, 0xa1, 0x07,
+				OP_CHECKLOCKTIMEVERIFY, OP_DROP, OP_DATA_1, 0x11,
+				OP_DATA_1, 0x85, OP_DATA_4, 0x00, 0x10, 0x5e, 0x5f,
+				OP_DATA_4, 0xff, 0xff, 0xff, 0xff},
+			noRoundTrip: true,
+		},
+		{
+			name: "hex digits",
+			asm:  "0x17 0x1234 0123 2147483648",
+			want: []byte{OP_DATA_1, 0x17, OP_DATA_2, 0x12, 0x34,
+				OP_DATA_2, 0x01, 0x23, OP_DATA_5, 0x21, 0x47, 0x48,
+				0x36, 0x48},
+			noRoundTrip: true,
+		},
+		{
+			name: "minimal placeholders",
+			asm:  "<five> <empty> <neg> <zero>",
+			want: []byte{OP_5, OP_0, OP_1NEGATE, OP_DATA_1, 0x00},
+		},
+		{
+			name: "pushdata1",
+			asm:  "  " + strings.Repeat("ab", 76) + "\n OP_DROP ",
+			want: append(append([]byte{OP_PUSHDATA1, 76},
+				bytes.Repeat([]byte{0xab}, 76)...), OP_DROP),
+		},
+		{
+			name: "empty",
+			asm:  "",
+			want: nil,
+		},
+		{
+			name: "non-minimal small integer",
+			asm:  "05 OP_DROP",
+			err:  ErrMinimalData,
+		},
+		{
+			name: "non-minimal negative one",
+			asm:  "0x81",
+			err:  ErrMinimalData,
+		},
+		{
+			name: "unknown opcode",
+			asm:  "OP_NOTANOPCODE",
+			err:  ErrMalformedAsm,
+		},
+		{
+			name: "push opcode",
+			asm:  "OP_DATA_1 05",
+			err:  ErrMalformedAsm,
+		},
+		{
+			name: "unbound placeholder",
+			asm:  "<pubkey3> OP_CHECKSIG",
+			err:  ErrMalformedAsm,
+		},
+		{
+			name: "odd length hex",
+			asm:  "abc",
+			err:  ErrMalformedAsm,
+		},
+		{
+			name: "empty hex",
+			asm:  "0x",
+			err:  ErrMalformedAsm,
+		},
+		{
+			name: "element too big",
+			asm:  "<big>",
+			err:  ErrElementTooBig,
+		},
+		{
+			name: "script too big",
+			asm: strings.Repeat(strings.Repeat("00", MaxScriptElementSize)+
+				" ", MaxScriptSize/MaxScriptElementSize+1),
+			err: ErrScriptTooBig,
+		},
+	}
+
+	for _, test := range tests {
+		script, err := Assemble(test.asm, bindings)
+		if test.err != 0 || err != nil {
+			if !IsErrorCode(err, test.err) {
+				t.Errorf("%s: unexpected error - got %v, want %v",
+					test.name, err, test.err)
+			}
+			continue
+		}
+		if !bytes.Equal(script, test.want) {
+			t.Errorf("%s: unexpected script - got %x, want %x",
+				test.name, script, test.want)
+			continue
+		}
+
+		// Ensure the disassembly of the script assembles to it.
+		disasm, err := DisasmString(script)
+		if err != nil {
+			t.Errorf("%s: failed to disassemble: %v", test.name, err)
+			continue
+		}
+		if test.noRoundTrip {
+			continue
+		}
+		reassembled, err := Assemble(disasm, nil)
+		if err != nil || !bytes.Equal(reassembled, script) {
+			t.Errorf("%s: unexpected round trip of %q - got %x %v, "+
+				"want %x", test.name, disasm, reassembled, err,
+				script)
+		}
+	}
+}
+
 // TestHasCanonicalPush ensures the canonicalPush function works as expected.
 func TestHasCanonicalPush(t *testing.T) {
 	t.Parallel()
@@ -69,12 +69,62 @@ func WitnessSignature(tx *wire.MsgTx, sigHashes *TxSigHashes, idx int, amt int64
 	return wire.TxWitness{sig, pkData}, nil
 }
 
+// sigHasher computes the signature hash of the subscript for the input idx of
+// tx, so the signing functions serve both the original digest and the Bitcoin
+// Cash digest.
+type sigHasher func(subScript []parsedOpcode, hashType SigHashType,
+	tx *wire.MsgTx, idx int) ([]byte, error)
+
+// legacySigHasher is the sigHasher of the original digest.
+func legacySigHasher(subScript []parsedOpcode, hashType SigHashType,
+	tx *wire.MsgTx, idx int) ([]byte, error) {
+
+	return calcSignatureHash(subScript, hashType, tx, idx), nil
+}
+
+// forkIDSigHasher returns the sigHasher of the Bitcoin Cash signatures of an
+// input of amt, hash types without SigHashForkID use the original digest.
+func forkIDSigHasher(amt int64) sigHasher {
+	return func(subScript []parsedOpcode, hashType SigHashType,
+		tx *wire.MsgTx, idx int) ([]byte, error) {
+
+		if hashType&SigHashForkID == 0 {
+			return calcSignatureHash(subScript, hashType, tx, idx), nil
+		}
+		return calcForkIDSignatureHash(subScript, NewTxSigHashes(tx),
+			hashType, tx, idx, amt)
+	}
+}
+
 // RawTxInSignature returns the serialized ECDSA signature for the input idx of
 // the given transaction, with hashType appended to it.
 func RawTxInSignature(tx *wire.MsgTx, idx int, subScript []byte,
 	hashType SigHashType, key *btcec.PrivateKey) ([]byte, error) {
 
-	hash, err := CalcSignatureHash(subScript, hashType, tx, idx)
+	return rawTxInSignature(tx, idx, subScript, hashType, key,
+		legacySigHasher)
+}
+
+// RawTxInForkIDSignature returns the serialized ECDSA Bitcoin Cash signature
+// for the input idx of amt of the given transaction, with hashType and
+// SigHashForkID appended to it.
+func RawTxInForkIDSignature(tx *wire.MsgTx, idx int, amt int64,
+	subScript []byte, hashType SigHashType,
+	key *btcec.PrivateKey) ([]byte, error) {
+
+	return rawTxInSignature(tx, idx, subScript, hashType|SigHashForkID, key,
+		forkIDSigHasher(amt))
+}
+
+func rawTxInSignature(tx *wire.MsgTx, idx int, subScript []byte,
+	hashType SigHashType, key *btcec.PrivateKey,
+	hasher sigHasher) ([]byte, error) {
+
+	parsedScript, err := parseScript(subScript)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse output script: %v", err)
+	}
+	hash, err := hasher(parsedScript, hashType, tx, idx)
 	if err != nil {
 		return nil, err
 	}
@@ -95,7 +145,16 @@ func RawTxInSignature(tx *wire.MsgTx, idx int, subScript []byte,
 // uncompressed format based on compress. This format must match the same format
 // used to generate the payment address, or the script validation will fail.
 func SignatureScript(tx *wire.MsgTx, idx int, subscript []byte, hashType SigHashType, privKey *btcec.PrivateKey, compress bool) ([]byte, error) {
-	sig, err := RawTxInSignature(tx, idx, subscript, hashType, privKey)
+	return signatureScript(tx, idx, subscript, hashType, privKey, compress,
+		legacySigHasher)
+}
+
+func signatureScript(tx *wire.MsgTx, idx int, subscript []byte,
+	hashType SigHashType, privKey *btcec.PrivateKey, compress bool,
+	hasher sigHasher) ([]byte, error) {
+
+	sig, err := rawTxInSignature(tx, idx, subscript, hashType, privKey,
+		hasher)
 	if err != nil {
 		return nil, err
 	}
@@ -111,8 +170,10 @@ func SignatureScript(tx *wire.MsgTx, idx int, subscript []byte, hashType SigHash
 	return NewScriptBuilder().AddData(sig).AddData(pkData).Script()
 }
 
-func p2pkSignatureScript(tx *wire.MsgTx, idx int, subScript []byte, hashType SigHashType, privKey *btcec.PrivateKey) ([]byte, error) {
-	sig, err := RawTxInSignature(tx, idx, subScript, hashType, privKey)
+func p2pkSignatureScript(tx *wire.MsgTx, idx int, subScript []byte, hashType SigHashType, privKey *btcec.PrivateKey,
+	hasher sigHasher) ([]byte, error) {
+	sig, err := rawTxInSignature(tx, idx, subScript, hashType, privKey,
+		hasher)
 	if err != nil {
 		return nil, err
 	}
@@ -125,7 +186,8 @@ func p2pkSignatureScript(tx *wire.MsgTx, idx int, subScript []byte, hashType Sig
 // the contract (i.e. nrequired signatures are provided).  Since it is arguably
 // legal to not be able to sign any of the outputs, no error is returned.
 func signMultiSig(tx *wire.MsgTx, idx int, subScript []byte, hashType SigHashType,
-	addresses []btcutil.Address, nRequired int, kdb KeyDB) ([]byte, bool) {
+	addresses []btcutil.Address, nRequired int, kdb KeyDB,
+	hasher sigHasher) ([]byte, bool) {
 	// We start with a single OP_FALSE to work around the (now standard)
 	// but in the reference implementation that causes a spurious pop at
 	// the end of OP_CHECKMULTISIG.
@@ -136,7 +198,8 @@ func signMultiSig(tx *wire.MsgTx, idx int, subScript []byte, hashType SigHashTyp
 		if err != nil {
 			continue
 		}
-		sig, err := RawTxInSignature(tx, idx, subScript, hashType, key)
+		sig, err := rawTxInSignature(tx, idx, subScript, hashType, key,
+			hasher)
 		if err != nil {
 			continue
 		}
@@ -154,8 +217,8 @@ func signMultiSig(tx *wire.MsgTx, idx int, subScript []byte, hashType SigHashTyp
 }
 
 func sign(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
-	subScript []byte, hashType SigHashType, kdb KeyDB, sdb ScriptDB) ([]byte,
-	ScriptClass, []btcutil.Address, int, error) {
+	subScript []byte, hashType SigHashType, kdb KeyDB, sdb ScriptDB,
+	hasher sigHasher) ([]byte, ScriptClass, []btcutil.Address, int, error) {
 
 	class, addresses, nrequired, err := ExtractPkScriptAddrs(subScript,
 		chainParams)
@@ -172,7 +235,7 @@ func sign(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 		}
 
 		script, err := p2pkSignatureScript(tx, idx, subScript, hashType,
-			key)
+			key, hasher)
 		if err != nil {
 			return nil, class, nil, 0, err
 		}
@@ -185,8 +248,8 @@ func sign(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 			return nil, class, nil, 0, err
 		}
 
-		script, err := SignatureScript(tx, idx, subScript, hashType,
-			key, compressed)
+		script, err := signatureScript(tx, idx, subScript, hashType,
+			key, compressed, hasher)
 		if err != nil {
 			return nil, class, nil, 0, err
 		}
@@ -201,7 +264,7 @@ func sign(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 		return script, class, addresses, nrequired, nil
 	case MultiSigTy:
 		script, _ := signMultiSig(tx, idx, subScript, hashType,
-			addresses, nrequired, kdb)
+			addresses, nrequired, kdb, hasher)
 		return script, class, addresses, nrequired, nil
 	case NullDataTy:
 		return nil, class, nil, 0,
@@ -220,7 +283,7 @@ func sign(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 // an error and results in undefined behaviour.
 func mergeScripts(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 	pkScript []byte, class ScriptClass, addresses []btcutil.Address,
-	nRequired int, sigScript, prevScript []byte) []byte {
+	nRequired int, sigScript, prevScript []byte, hasher sigHasher) []byte {
 
 	// TODO: the scripthash and multisig paths here are overly
 	// inefficient in that they will recompute already known data.
@@ -253,7 +316,7 @@ func mergeScripts(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 
 		// Merge
 		mergedScript := mergeScripts(chainParams, tx, idx, script,
-			class, addresses, nrequired, sigScript, prevScript)
+			class, addresses, nrequired, sigScript, prevScript, hasher)
 
 		// Reappend the script and return the result.
 		builder := NewScriptBuilder()
@@ -263,7 +326,7 @@ func mergeScripts(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 		return finalScript
 	case MultiSigTy:
 		return mergeMultiSig(tx, idx, addresses, nRequired, pkScript,
-			sigScript, prevScript)
+			sigScript, prevScript, hasher)
 
 	// It doesn't actually make sense to merge anything other than multiig
 	// and scripthash (because it could contain multisig). Everything else
@@ -286,7 +349,8 @@ func mergeScripts(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 // have come from other functions internally and thus are all consistent with
 // each other, behaviour is undefined if this contract is broken.
 func mergeMultiSig(tx *wire.MsgTx, idx int, addresses []btcutil.Address,
-	nRequired int, pkScript, sigScript, prevScript []byte) []byte {
+	nRequired int, pkScript, sigScript, prevScript []byte,
+	hasher sigHasher) []byte {
 
 	// This is an internal only function and we already parsed this script
 	// as ok for multisig (this is how we got here), so if this fails then
@@ -345,7 +409,10 @@ sigLoop:
 		// however, assume no sigs etc are in the script since that
 		// would make the transaction nonstandard and thus not
 		// MultiSigTy, so we just need to hash the full thing.
-		hash := calcSignatureHash(pkPops, hashType, tx, idx)
+		hash, err := hasher(pkPops, hashType, tx, idx)
+		if err != nil {
+			continue
+		}
 
 		for _, addr := range addresses {
 			// All multisig addresses should be pubkey addresses
@@ -434,8 +501,27 @@ func SignTxOutput(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 	pkScript []byte, hashType SigHashType, kdb KeyDB, sdb ScriptDB,
 	previousScript []byte) ([]byte, error) {
 
+	return signTxOutput(chainParams, tx, idx, pkScript, hashType, kdb, sdb,
+		previousScript, legacySigHasher)
+}
+
+// SignTxOutputForkID is SignTxOutput for Bitcoin Cash, the output of amt is
+// signed with hashType and SigHashForkID.
+func SignTxOutputForkID(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
+	amt int64, pkScript []byte, hashType SigHashType, kdb KeyDB, sdb ScriptDB,
+	previousScript []byte) ([]byte, error) {
+
+	return signTxOutput(chainParams, tx, idx, pkScript,
+		hashType|SigHashForkID, kdb, sdb, previousScript,
+		forkIDSigHasher(amt))
+}
+
+func signTxOutput(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
+	pkScript []byte, hashType SigHashType, kdb KeyDB, sdb ScriptDB,
+	previousScript []byte, hasher sigHasher) ([]byte, error) {
+
 	sigScript, class, addresses, nrequired, err := sign(chainParams, tx,
-		idx, pkScript, hashType, kdb, sdb)
+		idx, pkScript, hashType, kdb, sdb, hasher)
 	if err != nil {
 		return nil, err
 	}
@@ -443,7 +529,7 @@ func SignTxOutput(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 	if class == ScriptHashTy {
 		// TODO keep the sub addressed and pass down to merge.
 		realSigScript, _, _, _, err := sign(chainParams, tx, idx,
-			sigScript, hashType, kdb, sdb)
+			sigScript, hashType, kdb, sdb, hasher)
 		if err != nil {
 			return nil, err
 		}
@@ -459,13 +545,31 @@ func SignTxOutput(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 
 	// Merge scripts. with any previous data, if any.
 	mergedScript := mergeScripts(chainParams, tx, idx, pkScript, class,
-		addresses, nrequired, sigScript, previousScript)
+		addresses, nrequired, sigScript, previousScript, hasher)
 	return mergedScript, nil
 }
 
 func MergeMultiSigScript(tx *wire.MsgTx, idx int, addresses []btcutil.Address,
 	nRequired int, pkScript []byte, sigScripts [][]byte) ([]byte, int) {
 
+	return mergeMultiSigScript(tx, idx, addresses, nRequired, pkScript,
+		sigScripts, legacySigHasher)
+}
+
+// MergeMultiSigScriptForkID is MergeMultiSigScript of the Bitcoin Cash
+// signatures of an input of amt.
+func MergeMultiSigScriptForkID(tx *wire.MsgTx, idx int, amt int64,
+	addresses []btcutil.Address, nRequired int, pkScript []byte,
+	sigScripts [][]byte) ([]byte, int) {
+
+	return mergeMultiSigScript(tx, idx, addresses, nRequired, pkScript,
+		sigScripts, forkIDSigHasher(amt))
+}
+
+func mergeMultiSigScript(tx *wire.MsgTx, idx int, addresses []btcutil.Address,
+	nRequired int, pkScript []byte, sigScripts [][]byte,
+	hasher sigHasher) ([]byte, int) {
+
 	// This is an internal only function and we already parsed this script
 	// as ok for multisig (this is how we got here), so if this fails then
 	// all assumptions are broken and who knows which way is up?
@@ -520,7 +624,10 @@ sigLoop:
 		// however, assume no sigs etc are in the script since that
 		// would make the transaction nonstandard and thus not
 		// MultiSigTy, so we just need to hash the full thing.
-		hash := calcSignatureHash(pkPops, hashType, tx, idx)
+		hash, err := hasher(pkPops, hashType, tx, idx)
+		if err != nil {
+			continue
+		}
 
 		for _, addr := range addresses {
 			// All multisig addresses should be pubkey addresses
@@ -1714,3 +1714,123 @@ nexttest:
 		}
 	}
 }
+
+// TestSignTxOutputForkID ensures signatures of SignTxOutputForkID commit to
+// the input amount, carry SigHashForkID and are only valid with
+// ScriptVerifyForkID.
+func TestSignTxOutputForkID(t *testing.T) {
+	t.Parallel()
+
+	hashTypes := []SigHashType{
+		SigHashAll,
+		SigHashNone,
+		SigHashSingle,
+		SigHashAll | SigHashAnyOneCanPay,
+	}
+	inputAmounts := []int64{5, 10, 15}
+	tx := wire.NewMsgTx(1)
+	for i := range inputAmounts {
+		tx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Index: uint32(i)},
+			Sequence:         wire.MaxTxInSequenceNum,
+		})
+		tx.AddTxOut(&wire.TxOut{Value: int64(i + 1)})
+	}
+
+	key1, _ := btcec.NewPrivateKey(btcec.S256())
+	key2, _ := btcec.NewPrivateKey(btcec.S256())
+	pk1, _ := btcutil.NewAddressPubKey((*btcec.PublicKey)(&key1.PublicKey).
+		SerializeCompressed(), &chaincfg.TestNet3Params)
+	pk2, _ := btcutil.NewAddressPubKey((*btcec.PublicKey)(&key2.PublicKey).
+		SerializeCompressed(), &chaincfg.TestNet3Params)
+	pkhScript, _ := PayToAddrScript(pk1.AddressPubKeyHash())
+	redeem, _ := MultiSigScript([]*btcutil.AddressPubKey{pk1, pk2}, 2)
+	scriptAddr, _ := btcutil.NewAddressScriptHash(redeem,
+		&chaincfg.TestNet3Params)
+	p2shScript, _ := PayToAddrScript(scriptAddr)
+
+	verify := func(pkScript []byte, idx int, amt int64, flags ScriptFlags) error {
+		vm, err := NewEngine(pkScript, tx, idx, flags, nil, nil, amt)
+		if err != nil {
+			return err
+		}
+		return vm.Execute()
+	}
+
+	for _, hashType := range hashTypes {
+		for i := range tx.TxIn {
+			msg := fmt.Sprintf("%d:%d", hashType, i)
+			amt := inputAmounts[i]
+
+			// Pay to pubkey hash.
+			sigScript, err := SignTxOutputForkID(
+				&chaincfg.TestNet3Params, tx, i, amt, pkhScript,
+				hashType, mkGetKey(map[string]addressToKey{
+					pk1.EncodeAddress(): {key1, true},
+				}), mkGetScript(nil), nil)
+			if err != nil {
+				t.Errorf("failed to sign p2pkh %s: %v", msg, err)
+				continue
+			}
+			tx.TxIn[i].SignatureScript = sigScript
+			pushes, _ := PushedData(sigScript)
+			sig := pushes[0]
+			if SigHashType(sig[len(sig)-1]) != hashType|SigHashForkID {
+				t.Errorf("unexpected hash type of %s - got: %x",
+					msg, sig[len(sig)-1])
+			}
+			if err := verify(pkhScript, i, amt, BCHVerifyFlags); err != nil {
+				t.Errorf("invalid p2pkh signature of %s: %v", msg, err)
+			}
+			if err := verify(pkhScript, i, amt+1, BCHVerifyFlags); err == nil {
+				t.Errorf("p2pkh signature of %s is valid of "+
+					"another amount", msg)
+			}
+			if err := verify(pkhScript, i, amt, StandardVerifyFlags); err == nil {
+				t.Errorf("p2pkh signature of %s is valid without "+
+					"ScriptVerifyForkID", msg)
+			}
+
+			// Pay to script hash multisig, signed one by one.
+			getScript := mkGetScript(map[string][]byte{
+				scriptAddr.EncodeAddress(): redeem,
+			})
+			sigScript, err = SignTxOutputForkID(
+				&chaincfg.TestNet3Params, tx, i, amt, p2shScript,
+				hashType, mkGetKey(map[string]addressToKey{
+					pk1.EncodeAddress(): {key1, true},
+				}), getScript, nil)
+			if err != nil {
+				t.Errorf("failed to sign p2sh %s: %v", msg, err)
+				continue
+			}
+			sigScript, err = SignTxOutputForkID(
+				&chaincfg.TestNet3Params, tx, i, amt, p2shScript,
+				hashType, mkGetKey(map[string]addressToKey{
+					pk2.EncodeAddress(): {key2, true},
+				}), getScript, sigScript)
+			if err != nil {
+				t.Errorf("failed to sign p2sh %s: %v", msg, err)
+				continue
+			}
+			tx.TxIn[i].SignatureScript = sigScript
+			if err := verify(p2shScript, i, amt, BCHVerifyFlags); err != nil {
+				t.Errorf("invalid p2sh signature of %s: %v", msg, err)
+			}
+		}
+	}
+
+	// Signatures without SigHashForkID are rejected with
+	// ScriptVerifyForkID.
+	sigScript, err := SignTxOutput(&chaincfg.TestNet3Params, tx, 0,
+		pkhScript, SigHashAll, mkGetKey(map[string]addressToKey{
+			pk1.EncodeAddress(): {key1, true},
+		}), mkGetScript(nil), nil)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+	if err := verify(pkhScript, 0, inputAmounts[0], BCHVerifyFlags); err == nil {
+		t.Errorf("signature without SigHashForkID is valid")
+	}
+}
@@ -43,6 +43,23 @@ const (
 		ScriptVerifyDiscourageUpgradeableWitnessProgram |
 		ScriptVerifyMinimalIf |
 		ScriptVerifyWitnessPubKeyType
+
+	// BCHVerifyFlags are the script flags of the standard Bitcoin Cash
+	// transactions.  Signatures must use SigHashForkID, and there is no
+	// segwit.
+	BCHVerifyFlags = ScriptBip16 |
+		ScriptVerifyDERSignatures |
+		ScriptVerifyStrictEncoding |
+		ScriptVerifyMinimalData |
+		ScriptStrictMultiSig |
+		ScriptDiscourageUpgradableNops |
+		ScriptVerifyCleanStack |
+		ScriptVerifyNullFail |
+		ScriptVerifyCheckLockTimeVerify |
+		ScriptVerifyCheckSequenceVerify |
+		ScriptVerifyLowS |
+		ScriptVerifySigPushOnly |
+		ScriptVerifyForkID
 )
 
 // ScriptClass is an enumeration for the list of standard types of script.
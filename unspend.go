@@ -18,18 +18,17 @@
 package btcadaptor
 
 import (
+	"context"
 	"encoding/hex"
 	"fmt"
 	"io/ioutil"
-	"math/big"
 	"path/filepath"
 	"strings"
 
-	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/btcsuite/btcutil"
-	"github.com/shopspring/decimal"
 
 	"github.com/palletone/adaptor"
 )
@@ -40,7 +39,8 @@ import (
 var GHomeDir = btcutil.AppDataDir("btcd", false)
 var GCertPath = filepath.Join(GHomeDir, "rpc.cert")
 
-func GetClient(rpcParams *RPCParams) (*rpcclient.Client, error) {
+//newConnConfig build the connect config of rpcParams, read the cert from file
+func newConnConfig(rpcParams *RPCParams) (*rpcclient.ConnConfig, error) {
 	//read cert from file
 	var connCfg *rpcclient.ConnConfig
 	if rpcParams.CertPath == "" {
@@ -74,6 +74,14 @@ func GetClient(rpcParams *RPCParams) (*rpcclient.Client, error) {
 			//Certificates: certs, // btcwallet provide TLS by default
 		}
 	}
+	return connCfg, nil
+}
+
+func GetClient(rpcParams *RPCParams) (*rpcclient.Client, error) {
+	connCfg, err := newConnConfig(rpcParams)
+	if err != nil {
+		return nil, err
+	}
 
 	// Notice the notification parameter is nil since notifications are
 	// not supported in HTTP POST mode.
@@ -85,50 +93,62 @@ func GetClient(rpcParams *RPCParams) (*rpcclient.Client, error) {
 	return client, nil
 }
 
-func GetNet(netID int) *chaincfg.Params {
-	//chainnet
-	var realNet *chaincfg.Params
-	if netID == NETID_MAIN {
-		realNet = &chaincfg.MainNetParams
-	} else {
-		realNet = &chaincfg.TestNet3Params
-	}
-	return realNet
+//outPointOf return the outpoint of the txid returned by nodes
+func outPointOf(txid string, vout uint32) wire.OutPoint {
+	outPoint := wire.OutPoint{Index: vout}
+	chainhash.Decode(&outPoint.Hash, txid)
+	return outPoint
 }
 
-func getAllUnspend(client *rpcclient.Client, addr btcutil.Address) (map[string]float64, error) {
+//getAllUnspend return the utxos of addr which can be spent by policy
+func getAllUnspend(client *rpcclient.Client, addr btcutil.Address, chain *Chain,
+	policy *FinalityPolicy) (map[wire.OutPoint]btcutil.Amount, error) {
 	//get all raw transaction
 	count := 999999
 	msgTxs, err := client.SearchRawTransactionsVerbose(addr, 0, count, true, false, []string{}) //BTCD API
 	if err != nil {
-		return map[string]float64{}, fmt.Errorf("SearchRawTransactionsVerbose failed %s", err.Error())
+		return map[wire.OutPoint]btcutil.Amount{}, wrapError("SearchRawTransactionsVerbose failed", err)
 	}
 
-	addrStr := addr.String()
+	addrStr := addr.String() //the addresses returned by nodes may be CashAddr on BCH networks, compared by addressKey
 	//save utxo to map, check next one transanction is spend or not
-	outputIndex := map[string]float64{}
+	outputIndex := map[wire.OutPoint]btcutil.Amount{}
+	blockBits := map[string]uint32{}
 	//the result for return
 	for _, msgTx := range msgTxs {
-		if int(msgTx.Confirmations) < MinConfirm {
-			continue
-		}
-		//transaction inputs
+		//transaction inputs, the spent outputs are removed even if the spending tx is not final
+		isChange := false
 		for _, in := range msgTx.Vin {
 			//check is spend or not
-			idIndex := in.Txid + fmt.Sprintf("%02x", in.Vout)
+			idIndex := outPointOf(in.Txid, in.Vout)
 			_, exist := outputIndex[idIndex]
 			if exist { //spend
 				delete(outputIndex, idIndex)
 			}
+			if in.PrevOut != nil && len(in.PrevOut.Addresses) > 0 && addressKey(in.PrevOut.Addresses[0]) == addrStr {
+				isChange = true
+			}
 		}
 
+		bits, exist := blockBits[msgTx.BlockHash]
+		if !exist {
+			bits, err = policy.blockBitsByClient(client, msgTx.BlockHash)
+			if err != nil {
+				return map[wire.OutPoint]btcutil.Amount{}, err
+			}
+			blockBits[msgTx.BlockHash] = bits
+		}
 		//transaction outputs
 		for _, out := range msgTx.Vout {
 			if 0 == len(out.ScriptPubKey.Addresses) {
 				continue
 			}
-			if out.ScriptPubKey.Addresses[0] == addrStr {
-				outputIndex[msgTx.Txid+fmt.Sprintf("%02x", out.N)] = out.Value
+			amount := chain.amountOf(out.Value)
+			if !policy.CanSpend(int64(msgTx.Confirmations), int64(amount), bits, isChange) {
+				continue
+			}
+			if addressKey(out.ScriptPubKey.Addresses[0]) == addrStr {
+				outputIndex[outPointOf(msgTx.Txid, out.N)] = amount
 			}
 		}
 	}
@@ -136,42 +156,47 @@ func getAllUnspend(client *rpcclient.Client, addr btcutil.Address) (map[string]f
 	return outputIndex, nil
 }
 func GetBalance(input *adaptor.GetBalanceInput, rpcParams *RPCParams, netID int) (*adaptor.GetBalanceOutput, error) {
+	//get rpc client
+	client, err := GetClient(rpcParams)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Shutdown()
+
+	return getBalanceByClient(input, client, netID, defaultFinality(netID))
+}
+
+func getBalanceByClient(input *adaptor.GetBalanceInput, client *rpcclient.Client, netID int,
+	policy *FinalityPolicy) (*adaptor.GetBalanceOutput, error) {
 	if input.Address == "" {
-		return nil, fmt.Errorf("the Address is empty")
+		return nil, newError(ErrKindInvalidParams, "the Address is empty")
 	}
 
 	//chainnet
 	realNet := GetNet(netID)
 
 	//convert address from string
-	addr, err := btcutil.DecodeAddress(input.Address, realNet)
+	addr, err := DecodeAddress(input.Address, realNet)
 	if err != nil {
-		return nil, fmt.Errorf("DecodeAddress address failed %s", err.Error())
+		return nil, kindError(ErrKindBadAddress, "DecodeAddress address failed", err)
 	}
-	//get rpc client
-	client, err := GetClient(rpcParams)
-	if err != nil {
-		return nil, err
-	}
-	defer client.Shutdown()
 
-	outputIndexMap, err := getAllUnspend(client, addr)
+	chain := ChainOf(realNet)
+	outputIndexMap, err := getAllUnspend(client, addr, chain, policy)
 	if err != nil {
 		return nil, err
 	}
 
 	//compute total Amount for balance
 	var result adaptor.GetBalanceOutput
-	var allAmount float64
+	var allAmount btcutil.Amount
 	for _, value := range outputIndexMap {
 		allAmount += value
 	}
 
 	//
-	bigInt := new(big.Int)
-	bigInt.SetUint64(uint64(uint64(decimal.NewFromFloat(allAmount).Mul(decimal.New(1, 8)).IntPart())))
-	result.Balance.Amount = bigInt
-	result.Balance.Asset = "BTC"
+	result.Balance.Amount = bigAmount(allAmount)
+	result.Balance.Asset = chain.Symbol
 
 	return &result, nil
 }
@@ -221,28 +246,37 @@ func GetBalance(input *adaptor.GetBalanceInput, rpcParams *RPCParams, netID int)
 //}
 
 func GetTransactions(input *adaptor.GetAddrTxHistoryInput, rpcParams *RPCParams, netID int) (*adaptor.GetAddrTxHistoryOutput, error) {
-	//chainnet
-	realNet := GetNet(netID)
-
-	//convert address from string
-	addr, err := btcutil.DecodeAddress(input.FromAddress, realNet)
-	if err != nil {
-		return nil, fmt.Errorf("DecodeAddress FromAddress failed : %s", err.Error())
-	}
-
 	//get rpc client
 	client, err := GetClient(rpcParams)
 	if err != nil {
 		return nil, err
 	}
 	defer client.Shutdown()
+	resolver, err := newRPCResolver(rpcParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return getTransactionsByClient(context.Background(), input, client, resolver, netID, defaultFinality(netID))
+}
+
+func getTransactionsByClient(ctx context.Context, input *adaptor.GetAddrTxHistoryInput, client *rpcclient.Client,
+	resolver *txResolver, netID int, policy *FinalityPolicy) (*adaptor.GetAddrTxHistoryOutput, error) {
+	//chainnet
+	realNet := GetNet(netID)
+
+	//convert address from string
+	addr, err := DecodeAddress(input.FromAddress, realNet)
+	if err != nil {
+		return nil, kindError(ErrKindBadAddress, "DecodeAddress FromAddress failed", err)
+	}
 
 	//get all raw transaction
 	var strs []string
 	count := 999999
 	msgTxs, err := client.SearchRawTransactionsVerbose(addr, 0, count, true, false, strs) //BTCD API
 	if err != nil {
-		return nil, err
+		return nil, wrapError("SearchRawTransactionsVerbose failed", err)
 	}
 
 	isFilter := false
@@ -250,19 +284,53 @@ func GetTransactions(input *adaptor.GetAddrTxHistoryInput, rpcParams *RPCParams,
 		isFilter = true
 	}
 
+	//resolve the prevouts not returned by the node, the heights of blocks and the positions of txs
+	if err := resolver.checkReorg(ctx); err != nil {
+		return nil, err
+	}
+	var prevTxids, txids, blockHashes []string
+	for _, msgTx := range msgTxs {
+		for _, in := range msgTx.Vin {
+			if !in.IsCoinBase() && in.PrevOut == nil {
+				prevTxids = append(prevTxids, in.Txid)
+			}
+		}
+		if msgTx.BlockHash != "" {
+			txids = append(txids, msgTx.Txid)
+			blockHashes = append(blockHashes, msgTx.BlockHash)
+		}
+	}
+	prevTxs, err := resolver.getTxs(ctx, prevTxids)
+	if err != nil {
+		return nil, err
+	}
+	heights, err := resolver.getBlockHeights(ctx, blockHashes)
+	if err != nil {
+		return nil, err
+	}
+	indexes, err := resolver.getTxIndexes(ctx, txids, blockHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	blockBits := map[string]uint32{}
+
+	chain := ChainOf(realNet)
+	//CashAddr and legacy addresses of BCH are the same
+	fromKey, toKey := addressKey(input.FromAddress), addressKey(input.ToAddress)
+
 	//the result for return
-	msgIndex := map[string]float64{}
 	var output adaptor.GetAddrTxHistoryOutput
 	for _, msgTx := range msgTxs {
 		//one transaction result
 		var tx adaptor.SimpleTransferTokenTx
 
 		//
-		change := float64(0)
-		amount := float64(0)
-		amountOther := float64(0)
+		change := btcutil.Amount(0)
+		amount := btcutil.Amount(0)
+		amountOther := btcutil.Amount(0)
 		isTo := false
-		for i, out := range msgTx.Vout {
+		for _, out := range msgTx.Vout {
 			if out.ScriptPubKey.Type == "nulldata" { //todo: more op_return ?
 				if strings.HasPrefix(out.ScriptPubKey.Asm, "OP_RETURN") {
 					data, _ := hex.DecodeString(out.ScriptPubKey.Asm[len("OP_RETURN "):])
@@ -270,34 +338,37 @@ func GetTransactions(input *adaptor.GetAddrTxHistoryInput, rpcParams *RPCParams,
 				}
 				continue
 			}
+			if chain.ignored(out.ScriptPubKey.Hex) { //not fee
+				amountOther += chain.amountOf(out.Value)
+				continue
+			}
 			if len(out.ScriptPubKey.Addresses) == 0 {
 				continue
 			}
-			idIndex := msgTx.Txid + fmt.Sprintf("%02x", i)
-			msgIndex[idIndex] = out.Value //save in map
 
-			if input.FromAddress == out.ScriptPubKey.Addresses[0] {
-				change += out.Value
+			outAddr := addressKey(out.ScriptPubKey.Addresses[0])
+			if fromKey == outAddr {
+				change += chain.amountOf(out.Value)
 				continue
 			}
 			if "" != input.ToAddress {
-				if input.ToAddress == out.ScriptPubKey.Addresses[0] {
+				if toKey == outAddr {
 					isTo = true
 					tx.ToAddress = input.ToAddress
-					amount += out.Value
+					amount += chain.amountOf(out.Value)
 					continue
 				}
-				amountOther += out.Value //todo no to address?empty
+				amountOther += chain.amountOf(out.Value) //todo no to address?empty
 			} else {
 				if "" == tx.ToAddress { //first recv address
 					tx.ToAddress = out.ScriptPubKey.Addresses[0]
-					amount = out.Value
+					amount = chain.amountOf(out.Value)
 					continue
-				} else if tx.ToAddress == out.ScriptPubKey.Addresses[0] {
-					amount += out.Value
+				} else if addressKey(tx.ToAddress) == outAddr {
+					amount += chain.amountOf(out.Value)
 					continue
 				}
-				amountOther += out.Value //todo amountOther?
+				amountOther += chain.amountOf(out.Value) //todo amountOther?
 			}
 		}
 		if isFilter && !isTo {
@@ -305,39 +376,26 @@ func GetTransactions(input *adaptor.GetAddrTxHistoryInput, rpcParams *RPCParams,
 		}
 
 		//get input amount
-		inputAmount := float64(0)
-		for i := 0; i < len(msgTx.Vin); i++ {
-			idIndex := msgTx.Vin[i].Txid + fmt.Sprintf("%02x", i)
-			if val, exist := msgIndex[idIndex]; exist { //have saved in map
-				inputAmount += val
+		inputAmount := btcutil.Amount(0)
+		for i, in := range msgTx.Vin {
+			if in.IsCoinBase() {
 				continue
 			}
-			hashPre, err := chainhash.NewHashFromStr(msgTx.Vin[i].Txid)
-			if err != nil {
-				return nil, fmt.Errorf("hashPre failed : %s", err.Error())
-			}
-			txPreResult, err := client.GetRawTransactionVerbose(hashPre) //BTCD API
-			if err != nil {
-				return nil, fmt.Errorf("GetRawTransactionVerbose txPre %d failed : %s", i, err.Error())
+			if in.PrevOut != nil {
+				inputAmount += chain.amountOf(in.PrevOut.Value)
+				continue
 			}
-			for j, out := range txPreResult.Vout {
-				if input.FromAddress != out.ScriptPubKey.Addresses[0] {
-					continue
-				}
-				idIndex := txPreResult.Txid + fmt.Sprintf("%02x", j)
-				msgIndex[idIndex] = out.Value //save in map
+			txPreResult := prevTxs[in.Txid]
+			if int(in.Vout) >= len(txPreResult.Vout) {
+				return nil, newError(ErrKindNode, fmt.Sprintf("txPre %d %s has no output %d", i, in.Txid, in.Vout))
 			}
-			inputAmount += txPreResult.Vout[msgTx.Vin[i].Vout].Value
+			inputAmount += chain.amountOf(txPreResult.Vout[in.Vout].Value)
 		}
 		fee := inputAmount - change - amount - amountOther
 
 		//turn to big int
-		bigIntAmount := new(big.Int)
-		bigIntAmount.SetUint64(uint64(uint64(decimal.NewFromFloat(amount).Mul(decimal.New(1, 8)).IntPart())))
-		tx.Amount = adaptor.NewAmountAsset(bigIntAmount, "BTC")
-		bigIntFee := new(big.Int)
-		bigIntFee.SetUint64(uint64(uint64(decimal.NewFromFloat(fee).Mul(decimal.New(1, 8)).IntPart())))
-		tx.Fee = adaptor.NewAmountAsset(bigIntFee, "BTC")
+		tx.Amount = adaptor.NewAmountAsset(bigAmount(amount), chain.Symbol)
+		tx.Fee = adaptor.NewAmountAsset(bigAmount(fee), chain.Symbol)
 
 		tx.TxID, _ = hex.DecodeString(msgTx.Txid)
 		txRaw, _ := hex.DecodeString(msgTx.Hex)
@@ -349,23 +407,21 @@ func GetTransactions(input *adaptor.GetAddrTxHistoryInput, rpcParams *RPCParams,
 			tx.IsSuccess = true
 			blockID, _ := hex.DecodeString(msgTx.BlockHash)
 			tx.BlockID = blockID
-			blkHash, err := chainhash.NewHashFromStr(msgTx.BlockHash)
-			if err == nil {
-				blkResult, err := client.GetBlockVerbose(blkHash) //BTCD API
-				if err == nil {
-					tx.BlockHeight = uint(blkResult.Height) //GetBlockVerbose
-				}
-			}
+			tx.BlockHeight = uint(heights[msgTx.BlockHash])
 		} else {
 			tx.IsInBlock = false
 			tx.IsSuccess = false
 		}
-		if msgTx.Confirmations >= MinConfirm {
-			tx.IsStable = true
-		} else {
-			tx.IsStable = false
+		bits, exist := blockBits[msgTx.BlockHash]
+		if !exist {
+			bits, err = policy.blockBitsByClient(client, msgTx.BlockHash)
+			if err != nil {
+				return nil, err
+			}
+			blockBits[msgTx.BlockHash] = bits
 		}
-		tx.TxIndex = 0 //todo
+		tx.IsStable = policy.IsFinal(int64(msgTx.Confirmations), sumVout(msgTx.Vout), bits)
+		tx.TxIndex = indexes[msgTx.Txid]
 		tx.Timestamp = uint64(msgTx.Blocktime)
 
 		//add to result for return
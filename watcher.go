@@ -0,0 +1,588 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+const (
+	defaultWatcherEventBuffer = 100
+	watcherNoteBuffer         = 1000
+)
+
+var ErrWatcherClosed = errors.New("deposit watcher is closed")
+
+//DepositEventType is the type of DepositEvent
+type DepositEventType int
+
+const (
+	DepositEventUnconfirmed  DepositEventType = iota //the deposit is accepted to the mempool
+	DepositEventConfirmation                         //the deposit is mined, or its block gets one more confirmation
+	DepositEventStable                               //the deposit reaches the finality policy
+	DepositEventReversed                             //the block of the deposit is disconnected by a reorg
+	DepositEventConflicted                           //another tx spending an input of the unconfirmed deposit is seen
+	DepositEventReplaced                             //a conflicting tx is mined, the deposit will never be mined
+)
+
+func (t DepositEventType) String() string {
+	switch t {
+	case DepositEventUnconfirmed:
+		return "unconfirmed"
+	case DepositEventConfirmation:
+		return "confirmation"
+	case DepositEventStable:
+		return "stable"
+	case DepositEventReversed:
+		return "reversed"
+	case DepositEventConflicted:
+		return "conflicted"
+	case DepositEventReplaced:
+		return "replaced"
+	}
+	return fmt.Sprintf("DepositEventType(%d)", int(t))
+}
+
+//DepositEvent is a change of an output paying to a watched address,
+//BlockHash, Height and Confirmations are zero if the deposit is not mined
+type DepositEvent struct {
+	Type          DepositEventType
+	Address       string
+	PkScript      []byte
+	OutPoint      wire.OutPoint
+	Value         int64 //satoshi
+	BlockHash     chainhash.Hash
+	Height        int32
+	Confirmations int64
+	RBF           bool             //the tx signals BIP125 replaceability by itself, not by unconfirmed parents
+	Conflicts     []chainhash.Hash //the txs seen spending an input of the deposit tx, the miner of Replaced is the last
+}
+
+func (e DepositEvent) String() string {
+	return fmt.Sprintf("%s %s %s %d %d", e.Type, e.Address, e.OutPoint, e.Value, e.Confirmations)
+}
+
+//WatcherConfig is the config of Watcher
+type WatcherConfig struct {
+	Params      *chaincfg.Params //network of the node
+	Finality    FinalityPolicy   //when a deposit is stable, zero value means the default of Params
+	EventBuffer int              //buffer of each subscription channel
+}
+
+type watchedDeposit struct {
+	event  DepositEvent //the last emitted
+	stable bool
+	inputs []wire.OutPoint
+}
+
+type watchedBlock struct {
+	height int32
+	bits   uint32
+	prev   chainhash.Hash
+}
+
+type depositSubscription struct {
+	events chan DepositEvent
+	done   chan struct{}
+}
+
+//Watcher receives the deposits of watched addresses by websocket notifications of btcd
+//(notifyreceived and notifyblocks), and emits DepositEvents to subscribers: unconfirmed,
+//each new confirmation until stable, stable, and reversed by a reorg. The inputs of deposit txs
+//are watched too (notifyspent), a tx spending them is a conflict of the deposit, and the deposit is
+//replaced once a conflict is mined. A stable deposit is still watched for maxReorgDepth blocks.
+//Notifications missed while reconnecting are not replayed.
+type Watcher struct {
+	rpcParams RPCParams
+	cfg       WatcherConfig
+
+	notes chan func() //notifications in order, handled by loop
+	quit  chan struct{}
+	wg    sync.WaitGroup
+
+	mtx      sync.RWMutex
+	client   *rpcclient.Client
+	scripts  map[string]string //pkScript -> address
+	subs     map[*depositSubscription]bool
+	closed   bool
+	tip      int32
+	blocks   map[chainhash.Hash]watchedBlock //recent connected blocks, for the bits of deposits
+	deposits map[wire.OutPoint]*watchedDeposit
+	spends   map[wire.OutPoint]chainhash.Hash //inputs of deposit txs -> the deposit tx
+}
+
+//NewWatcher create a watcher of the websocket of a btcd node, call Start to connect
+func NewWatcher(rpcParams RPCParams, cfg WatcherConfig) *Watcher {
+	if cfg.Params == nil {
+		cfg.Params = &chaincfg.MainNetParams
+	}
+	if cfg.EventBuffer <= 0 {
+		cfg.EventBuffer = defaultWatcherEventBuffer
+	}
+	cfg.Finality = cfg.Finality.withDefault(cfg.Params)
+	return &Watcher{rpcParams: rpcParams, cfg: cfg, notes: make(chan func(), watcherNoteBuffer), quit: make(chan struct{}),
+		scripts: map[string]string{}, subs: map[*depositSubscription]bool{},
+		blocks: map[chainhash.Hash]watchedBlock{}, deposits: map[wire.OutPoint]*watchedDeposit{},
+		spends: map[wire.OutPoint]chainhash.Hash{}}
+}
+
+//Start connect the websocket and register the notifications, the client reconnects and registers again if broken
+func (w *Watcher) Start() error {
+	connCfg, err := newConnConfig(&w.rpcParams)
+	if err != nil {
+		return err
+	}
+	connCfg.HTTPPostMode = false //notifications need websocket
+	handlers := &rpcclient.NotificationHandlers{
+		OnFilteredBlockConnected: func(height int32, header *wire.BlockHeader, txs []*btcutil.Tx) {
+			w.post(func() { w.blockConnected(height, header) })
+		},
+		OnFilteredBlockDisconnected: func(height int32, header *wire.BlockHeader) {
+			w.post(func() { w.blockDisconnected(height, header.BlockHash()) })
+		},
+		OnRecvTx: func(tx *btcutil.Tx, details *btcjson.BlockDetails) {
+			w.post(func() { w.recvTx(tx.MsgTx(), details) })
+		},
+		OnRedeemingTx: func(tx *btcutil.Tx, details *btcjson.BlockDetails) {
+			w.post(func() { w.recvTx(tx.MsgTx(), details) })
+		},
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closed {
+		return ErrWatcherClosed
+	}
+	if w.client != nil {
+		return nil
+	}
+	client, err := rpcclient.New(connCfg, handlers)
+	if err != nil {
+		return wrapError("rpcclient.New failed", err)
+	}
+	height, err := client.GetBlockCount()
+	if err != nil {
+		client.Shutdown()
+		return wrapError("GetBlockCount failed", err)
+	}
+	if err := client.NotifyBlocks(); err != nil {
+		client.Shutdown()
+		return wrapError("NotifyBlocks failed", err)
+	}
+	if addrs := w.addresses(); len(addrs) > 0 {
+		if err := client.NotifyReceived(addrs); err != nil {
+			client.Shutdown()
+			return wrapError("NotifyReceived failed", err)
+		}
+	}
+	w.client = client
+	w.tip = int32(height)
+	w.wg.Add(1)
+	go w.loop()
+	return nil
+}
+
+//Close disconnect the websocket and stop emitting events
+func (w *Watcher) Close() {
+	w.mtx.Lock()
+	if w.closed {
+		w.mtx.Unlock()
+		return
+	}
+	w.closed = true
+	client := w.client
+	w.mtx.Unlock()
+	close(w.quit)
+	if client != nil {
+		client.Shutdown()
+		client.WaitForShutdown()
+	}
+	w.wg.Wait()
+}
+
+//WatchAddress watch the deposits of addrs, registered to the node at once if started
+func (w *Watcher) WatchAddress(addrs ...string) error {
+	var scripts [][]byte
+	for _, addrStr := range addrs {
+		addr, err := btcutil.DecodeAddress(addrStr, w.cfg.Params)
+		if err != nil {
+			return kindError(ErrKindBadAddress, "DecodeAddress failed", err)
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return kindError(ErrKindBadAddress, "PayToAddrScript failed", err)
+		}
+		scripts = append(scripts, pkScript)
+	}
+	return w.watch(scripts)
+}
+
+//WatchScript watch the deposits of pkScripts, each script must pay to one address
+func (w *Watcher) WatchScript(pkScripts ...[]byte) error {
+	return w.watch(pkScripts)
+}
+
+func (w *Watcher) watch(pkScripts [][]byte) error {
+	var addrs []btcutil.Address
+	w.mtx.Lock()
+	for _, pkScript := range pkScripts {
+		_, scriptAddrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, w.cfg.Params)
+		if err != nil || len(scriptAddrs) != 1 {
+			w.mtx.Unlock()
+			return newError(ErrKindInvalidParams, fmt.Sprintf("script %x does not pay to one address", pkScript))
+		}
+		if _, exist := w.scripts[string(pkScript)]; exist {
+			continue
+		}
+		w.scripts[string(pkScript)] = scriptAddrs[0].EncodeAddress()
+		addrs = append(addrs, scriptAddrs[0])
+	}
+	client := w.client
+	w.mtx.Unlock()
+
+	if client == nil || len(addrs) == 0 {
+		return nil
+	}
+	if err := client.NotifyReceived(addrs); err != nil {
+		return wrapError("NotifyReceived failed", err)
+	}
+	return nil
+}
+
+//addresses return the watched addresses, the caller must hold mtx
+func (w *Watcher) addresses() []btcutil.Address {
+	var addrs []btcutil.Address
+	for _, addrStr := range w.scripts {
+		addr, err := btcutil.DecodeAddress(addrStr, w.cfg.Params)
+		if err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+//Subscribe return a channel of DepositEvents and a func to cancel it.
+//The watcher blocks while the channel is full, so events are never lost.
+func (w *Watcher) Subscribe() (<-chan DepositEvent, func()) {
+	sub := &depositSubscription{events: make(chan DepositEvent, w.cfg.EventBuffer), done: make(chan struct{})}
+	w.mtx.Lock()
+	w.subs[sub] = true
+	w.mtx.Unlock()
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			w.mtx.Lock()
+			delete(w.subs, sub)
+			w.mtx.Unlock()
+			close(sub.done)
+		})
+	}
+}
+
+//watching return whether any address is watched
+func (w *Watcher) watching() bool {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return len(w.scripts) > 0
+}
+
+//Tip return the height of the best block notified
+func (w *Watcher) Tip() int32 {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	return w.tip
+}
+
+//post queue a notification, the handlers of rpcclient must not block on rpc calls
+func (w *Watcher) post(note func()) {
+	select {
+	case w.notes <- note:
+	case <-w.quit:
+	}
+}
+
+func (w *Watcher) loop() {
+	defer w.wg.Done()
+	for {
+		select {
+		case note := <-w.notes:
+			note()
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+func (w *Watcher) emit(events []DepositEvent) {
+	w.mtx.RLock()
+	subs := make([]*depositSubscription, 0, len(w.subs))
+	for sub := range w.subs {
+		subs = append(subs, sub)
+	}
+	w.mtx.RUnlock()
+	for _, sub := range subs {
+		for _, event := range events {
+			select {
+			case sub.events <- event:
+			case <-sub.done:
+			case <-w.quit:
+				return
+			}
+		}
+	}
+}
+
+//SignalsRBF return whether tx signals BIP125 replaceability explicitly, by an input sequence below 0xfffffffe
+func SignalsRBF(tx *wire.MsgTx) bool {
+	for _, txIn := range tx.TxIn {
+		if txIn.Sequence < wire.MaxTxInSequenceNum-1 {
+			return true
+		}
+	}
+	return false
+}
+
+//recvTx handle a tx paying to watched addresses or spending the inputs of deposits,
+//details is nil if it is in the mempool
+func (w *Watcher) recvTx(tx *wire.MsgTx, details *btcjson.BlockDetails) {
+	var blockHash chainhash.Hash
+	if details != nil {
+		hash, err := chainhash.NewHashFromStr(details.Hash)
+		if err != nil {
+			return
+		}
+		blockHash = *hash
+	}
+	txHash := tx.TxHash()
+
+	var events []DepositEvent
+	var newSpends []*wire.OutPoint
+	w.mtx.Lock()
+	events = append(events, w.conflict(tx, txHash, details != nil)...)
+	for i, out := range tx.TxOut {
+		addr, watched := w.scripts[string(out.PkScript)]
+		if !watched {
+			continue
+		}
+		outPoint := wire.OutPoint{Hash: txHash, Index: uint32(i)}
+		deposit, exist := w.deposits[outPoint]
+		if !exist {
+			deposit = &watchedDeposit{event: DepositEvent{Address: addr, PkScript: out.PkScript, OutPoint: outPoint,
+				Value: out.Value, RBF: SignalsRBF(tx)}}
+			for _, txIn := range tx.TxIn {
+				if txIn.PreviousOutPoint.Hash == (chainhash.Hash{}) {
+					continue //coinbase
+				}
+				deposit.inputs = append(deposit.inputs, txIn.PreviousOutPoint)
+				if _, exist := w.spends[txIn.PreviousOutPoint]; !exist {
+					w.spends[txIn.PreviousOutPoint] = txHash
+					prevOut := txIn.PreviousOutPoint
+					newSpends = append(newSpends, &prevOut)
+				}
+			}
+			w.deposits[outPoint] = deposit
+		}
+		if details == nil {
+			if !exist {
+				deposit.event.Type = DepositEventUnconfirmed
+				events = append(events, deposit.event)
+			}
+			continue
+		}
+		if deposit.event.BlockHash == blockHash {
+			continue
+		}
+		deposit.event.BlockHash = blockHash
+		deposit.event.Height = details.Height
+		if details.Height > w.tip { //the block is notified after its txs
+			w.tip = details.Height
+		}
+		events = append(events, w.confirm(deposit)...)
+	}
+	client := w.client
+	w.mtx.Unlock()
+	if client != nil && len(newSpends) > 0 {
+		//not waited, the loop must not block on rpc calls, and the client registers again after reconnecting
+		client.NotifySpentAsync(newSpends)
+	}
+	w.emit(events)
+}
+
+//conflict return the events of the deposits conflicted by tx, they are replaced if tx is mined.
+//The caller must hold mtx.
+func (w *Watcher) conflict(tx *wire.MsgTx, txHash chainhash.Hash, mined bool) []DepositEvent {
+	var events []DepositEvent
+	for _, txIn := range tx.TxIn {
+		depositTx, exist := w.spends[txIn.PreviousOutPoint]
+		if !exist || depositTx == txHash {
+			continue
+		}
+		for outPoint, deposit := range w.deposits {
+			if outPoint.Hash != depositTx || deposit.event.Height != 0 {
+				continue
+			}
+			if !mined && hashIn(txHash, deposit.event.Conflicts) {
+				continue
+			}
+			if !hashIn(txHash, deposit.event.Conflicts) {
+				deposit.event.Conflicts = append(append([]chainhash.Hash{}, deposit.event.Conflicts...), txHash)
+			}
+			deposit.event.Type = DepositEventConflicted
+			if mined {
+				deposit.event.Type = DepositEventReplaced
+				w.forget(outPoint)
+			}
+			events = append(events, deposit.event)
+		}
+	}
+	return events
+}
+
+func hashIn(hash chainhash.Hash, hashes []chainhash.Hash) bool {
+	for _, h := range hashes {
+		if h == hash {
+			return true
+		}
+	}
+	return false
+}
+
+//forget stop watching a deposit, and the inputs of its tx if it is the last deposit of the tx.
+//The caller must hold mtx.
+func (w *Watcher) forget(outPoint wire.OutPoint) {
+	deposit := w.deposits[outPoint]
+	delete(w.deposits, outPoint)
+	if deposit == nil {
+		return
+	}
+	for other := range w.deposits {
+		if other.Hash == outPoint.Hash {
+			return
+		}
+	}
+	for _, input := range deposit.inputs {
+		if w.spends[input] == outPoint.Hash {
+			delete(w.spends, input)
+		}
+	}
+}
+
+//confirm return the events of a mined deposit at the tip, the caller must hold mtx
+func (w *Watcher) confirm(deposit *watchedDeposit) []DepositEvent {
+	confirmations := int64(w.tip - deposit.event.Height + 1)
+	if deposit.stable || confirmations == deposit.event.Confirmations {
+		return nil
+	}
+	deposit.event.Type = DepositEventConfirmation
+	deposit.event.Confirmations = confirmations
+	events := []DepositEvent{deposit.event}
+	bits := w.blocks[deposit.event.BlockHash].bits //0 if not notified yet
+	if w.cfg.Finality.IsFinal(confirmations, deposit.event.Value, bits) {
+		deposit.stable = true
+		deposit.event.Type = DepositEventStable
+		events = append(events, deposit.event)
+	}
+	return events
+}
+
+func (w *Watcher) blockConnected(height int32, header *wire.BlockHeader) {
+	var events []DepositEvent
+	w.mtx.Lock()
+	w.tip = height
+	w.blocks[header.BlockHash()] = watchedBlock{height: height, bits: header.Bits, prev: header.PrevBlock}
+	for hash, block := range w.blocks {
+		if height-block.height >= maxReorgDepth {
+			delete(w.blocks, hash)
+		}
+	}
+	for outPoint, deposit := range w.deposits {
+		if deposit.event.Height == 0 {
+			continue
+		}
+		if deposit.stable && height-deposit.event.Height >= maxReorgDepth {
+			w.forget(outPoint)
+			continue
+		}
+		events = append(events, w.confirm(deposit)...)
+	}
+	w.mtx.Unlock()
+	w.emit(events)
+}
+
+func (w *Watcher) blockDisconnected(height int32, hash chainhash.Hash) {
+	var events []DepositEvent
+	w.mtx.Lock()
+	w.tip = height - 1
+	delete(w.blocks, hash)
+	for _, deposit := range w.deposits {
+		if deposit.event.Height == 0 || deposit.event.BlockHash != hash {
+			continue
+		}
+		deposit.event.Type = DepositEventReversed
+		events = append(events, deposit.event)
+		//back to the mempool, recvtx is notified again if it is mined
+		deposit.stable = false
+		deposit.event.BlockHash = chainhash.Hash{}
+		deposit.event.Height = 0
+		deposit.event.Confirmations = 0
+	}
+	w.mtx.Unlock()
+	w.emit(events)
+}
+
+//connectBlock handle a block of the main chain, it is blockConnected and recvTx of every tx,
+//used by the feeds which notify whole blocks
+func (w *Watcher) connectBlock(block *wire.MsgBlock, height int32) {
+	w.blockConnected(height, &block.Header)
+	details := &btcjson.BlockDetails{Height: height, Hash: block.BlockHash().String()}
+	for i, tx := range block.Transactions {
+		details.Index = i
+		w.recvTx(tx, details)
+	}
+}
+
+//block return the connected block of hash
+func (w *Watcher) block(hash chainhash.Hash) (watchedBlock, bool) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	block, exist := w.blocks[hash]
+	return block, exist
+}
+
+//tipBlock return the highest connected block, ok is false if no block is connected
+func (w *Watcher) tipBlock() (hash chainhash.Hash, height int32, ok bool) {
+	w.mtx.RLock()
+	defer w.mtx.RUnlock()
+	for blockHash, block := range w.blocks {
+		if !ok || block.height > height {
+			hash, height, ok = blockHash, block.height, true
+		}
+	}
+	return hash, height, ok
+}
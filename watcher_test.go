@@ -0,0 +1,205 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+func TestWatcherDeposit(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	registered := make(chan []string, 1)
+	node.handle("getblockcount", func(params []json.RawMessage) (interface{}, error) {
+		return 100, nil
+	})
+	node.handle("notifyblocks", func(params []json.RawMessage) (interface{}, error) {
+		return nil, nil
+	})
+	node.handle("notifyreceived", func(params []json.RawMessage) (interface{}, error) {
+		var addrs []string
+		json.Unmarshal(params[0], &addrs)
+		registered <- addrs
+		return nil, nil
+	})
+
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addrB := "mgtT62nq65DsPPAzPp6KhsWoHjNQUR9Bu5"
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	watcher := abtc.NewWatcher(WatcherConfig{Finality: FinalityPolicy{Confirmations: 2}})
+	defer watcher.Close()
+	events, cancel := watcher.Subscribe()
+	defer cancel()
+	if err := watcher.WatchAddress(addrA); err != nil {
+		t.Fatal(err)
+	}
+	if err := watcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := <-registered; len(addrs) != 1 || addrs[0] != addrA {
+		t.Fatalf("unexpected registered addresses - got: %v", addrs)
+	}
+	if err := watcher.WatchScript([]byte{txscript.OP_TRUE}); ErrorKindOf(err) != ErrKindInvalidParams {
+		t.Errorf("unexpected error of script without address - got: %v", err)
+	}
+
+	pkScript := func(addrStr string) []byte {
+		addr, _ := btcutil.DecodeAddress(addrStr, &chaincfg.TestNet3Params)
+		script, _ := txscript.PayToAddrScript(addr)
+		return script
+	}
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	tx.AddTxOut(wire.NewTxOut(1000, pkScript(addrB)))
+	tx.AddTxOut(wire.NewTxOut(2000, pkScript(addrA)))
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	txHex := hex.EncodeToString(buf.Bytes())
+	headerHex := func(header *wire.BlockHeader) string {
+		var buf bytes.Buffer
+		header.Serialize(&buf)
+		return hex.EncodeToString(buf.Bytes())
+	}
+	header101 := &wire.BlockHeader{Nonce: 101, Timestamp: time.Unix(1500000000, 0)}
+	header102 := &wire.BlockHeader{Nonce: 102, PrevBlock: header101.BlockHash(), Timestamp: time.Unix(1500000600, 0)}
+
+	node.notify("recvtx", txHex)
+	node.notify("filteredblockconnected", 101, headerHex(header101), []string{})
+	node.notify("recvtx", txHex, btcjson.BlockDetails{Height: 101, Hash: header101.BlockHash().String(), Index: 1})
+	node.notify("filteredblockconnected", 102, headerHex(header102), []string{})
+	node.notify("filteredblockdisconnected", 102, headerHex(header102))
+	node.notify("filteredblockdisconnected", 101, headerHex(header101))
+
+	want := []struct {
+		eventType     DepositEventType
+		height        int32
+		confirmations int64
+	}{
+		{DepositEventUnconfirmed, 0, 0},
+		{DepositEventConfirmation, 101, 1},
+		{DepositEventConfirmation, 101, 2},
+		{DepositEventStable, 101, 2},
+		{DepositEventReversed, 101, 2},
+	}
+	for i, w := range want {
+		select {
+		case event := <-events:
+			if event.Type != w.eventType || event.Height != w.height || event.Confirmations != w.confirmations ||
+				event.Address != addrA || event.Value != 2000 || event.OutPoint != (wire.OutPoint{Hash: tx.TxHash(), Index: 1}) {
+				t.Errorf("unexpected event %d - got: %v, "+"want: %v %v %v", i, event, w.eventType, w.height, w.confirmations)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d timed out", i)
+		}
+	}
+	if tip := watcher.Tip(); tip != 100 {
+		t.Errorf("unexpected tip - got: %v, "+"want: %v", tip, 100)
+	}
+}
+
+func TestWatcherConflict(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	spent := make(chan []btcjson.OutPoint, 1)
+	node.handle("getblockcount", func(params []json.RawMessage) (interface{}, error) {
+		return 100, nil
+	})
+	for _, method := range []string{"notifyblocks", "notifyreceived"} {
+		node.handle(method, func(params []json.RawMessage) (interface{}, error) {
+			return nil, nil
+		})
+	}
+	node.handle("notifyspent", func(params []json.RawMessage) (interface{}, error) {
+		var outPoints []btcjson.OutPoint
+		json.Unmarshal(params[0], &outPoints)
+		spent <- outPoints
+		return nil, nil
+	})
+
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addr, _ := btcutil.DecodeAddress(addrA, &chaincfg.TestNet3Params)
+	pkScript, _ := txscript.PayToAddrScript(addr)
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	watcher := abtc.NewWatcher(WatcherConfig{})
+	defer watcher.Close()
+	events, cancel := watcher.Subscribe()
+	defer cancel()
+	watcher.WatchAddress(addrA)
+	if err := watcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	input := wire.OutPoint{Hash: chainhash.Hash{1}, Index: 2}
+	deposit := wire.NewMsgTx(1)
+	deposit.AddTxIn(&wire.TxIn{PreviousOutPoint: input, Sequence: wire.MaxTxInSequenceNum - 2})
+	deposit.AddTxOut(wire.NewTxOut(2000, pkScript))
+	conflicts := make([]*wire.MsgTx, 2)
+	for i := range conflicts {
+		conflicts[i] = wire.NewMsgTx(1)
+		conflicts[i].AddTxIn(&wire.TxIn{PreviousOutPoint: input, Sequence: wire.MaxTxInSequenceNum})
+		conflicts[i].AddTxOut(wire.NewTxOut(int64(1000+i), []byte{txscript.OP_TRUE}))
+	}
+	txHex := func(tx *wire.MsgTx) string {
+		var buf bytes.Buffer
+		tx.Serialize(&buf)
+		return hex.EncodeToString(buf.Bytes())
+	}
+	if !SignalsRBF(deposit) || SignalsRBF(conflicts[0]) {
+		t.Errorf("unexpected RBF signalling")
+	}
+
+	node.notify("recvtx", txHex(deposit))
+	select {
+	case outPoints := <-spent:
+		if len(outPoints) != 1 || outPoints[0].Hash != input.Hash.String() || outPoints[0].Index != input.Index {
+			t.Errorf("unexpected registered outpoints - got: %v", outPoints)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("notifyspent timed out")
+	}
+	node.notify("redeemingtx", txHex(deposit))
+	node.notify("redeemingtx", txHex(conflicts[0]))
+	node.notify("redeemingtx", txHex(conflicts[0]))
+	node.notify("redeemingtx", txHex(conflicts[1]),
+		btcjson.BlockDetails{Height: 101, Hash: chainhash.Hash{9}.String(), Index: 1})
+
+	want := []struct {
+		eventType DepositEventType
+		conflicts []*wire.MsgTx
+	}{
+		{DepositEventUnconfirmed, nil},
+		{DepositEventConflicted, conflicts[:1]},
+		{DepositEventReplaced, conflicts},
+	}
+	for i, w := range want {
+		select {
+		case event := <-events:
+			ok := event.Type == w.eventType && event.RBF && event.OutPoint == (wire.OutPoint{Hash: deposit.TxHash()}) &&
+				len(event.Conflicts) == len(w.conflicts)
+			for j := 0; ok && j < len(w.conflicts); j++ {
+				ok = event.Conflicts[j] == w.conflicts[j].TxHash()
+			}
+			if !ok {
+				t.Errorf("unexpected event %d - got: %v %v, "+"want: %v", i, event, event.Conflicts, w.eventType)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %d timed out", i)
+		}
+	}
+	//the replaced deposit and its inputs are not watched any more
+	watcher.mtx.RLock()
+	if len(watcher.deposits) != 0 || len(watcher.spends) != 0 {
+		t.Errorf("unexpected watched deposits and spends - got: %v %v, "+"want: 0 0", len(watcher.deposits), len(watcher.spends))
+	}
+	watcher.mtx.RUnlock()
+}
@@ -0,0 +1,679 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	bolt "go.etcd.io/bbolt"
+)
+
+//types of webhook events
+const (
+	WebhookDepositSeen      = "deposit.seen"      //the deposit is in the mempool
+	WebhookDepositConfirmed = "deposit.confirmed" //the deposit is confirmed N blocks
+	WebhookDepositStable    = "deposit.stable"    //the deposit reaches the finality policy
+	WebhookDepositReorged   = "deposit.reorged"   //the block of the deposit is reorged out
+	WebhookDepositConflict  = "deposit.conflict"  //a tx spending an input of the deposit tx is seen
+	WebhookDepositReplaced  = "deposit.replaced"  //a conflicting tx is mined
+	WebhookPayoutBroadcast  = "payout.broadcast"  //the payout is sent to the network
+	WebhookPayoutConfirmed  = "payout.confirmed"  //the payout is confirmed N blocks
+	WebhookPayoutReplaced   = "payout.replaced"   //the payout is replaced by a conflicting tx
+	WebhookPayoutReorged    = "payout.reorged"    //the block of the payout is reorged out
+)
+
+//headers of webhook requests
+const (
+	WebhookHeaderSignature   = "X-Webhook-Signature" //sha256=hex(hmac-sha256(secret, timestamp + "." + body))
+	WebhookHeaderTimestamp   = "X-Webhook-Timestamp" //unix seconds of the attempt
+	WebhookHeaderEventType   = "X-Webhook-Event"
+	WebhookHeaderIdempotency = "Idempotency-Key" //the event ID, the same on every retry
+)
+
+const (
+	defaultWebhookMaxAttempts  = 10
+	defaultWebhookMinBackoff   = time.Second
+	defaultWebhookMaxBackoff   = time.Hour
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookPollInterval = time.Second
+	defaultWebhookKeepKeys     = 7 * 24 * time.Hour
+	webhookPruneInterval       = time.Hour //interval of Run to delete the expired event IDs
+)
+
+//buckets of the webhook db
+var (
+	bucketWebhookQueue = []byte("queue") //seq -> webhookRecord json, in publish order
+	bucketWebhookKeys  = []byte("keys")  //event ID -> state|unix nano, for idempotency
+	bucketWebhookDead  = []byte("dead")  //event ID -> webhookRecord json
+)
+
+//states of event IDs
+const (
+	webhookQueued    = 'q'
+	webhookDelivered = 'd'
+	webhookDead      = 'x'
+)
+
+//WebhookConfig is the config of Dispatcher
+type WebhookConfig struct {
+	URL         string        //endpoint receiving POST of events
+	Secret      []byte        //key of the HMAC signature
+	DBPath      string        //file of the bbolt db of the queue
+	MaxAttempts int           //attempts before an event is dead-lettered
+	MinBackoff  time.Duration //delay of the first retry, doubled for each retry
+	MaxBackoff  time.Duration
+	Timeout     time.Duration //timeout of one request
+	//interval to check the queue, and how long the IDs of delivered events are kept to drop duplicates
+	PollInterval time.Duration
+	KeepKeys     time.Duration
+	Client       *http.Client //nil means a client of Timeout
+}
+
+//WebhookEvent is the JSON body of a webhook, ID is the idempotency key
+type WebhookEvent struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Created int64           `json:"created"` //unix seconds
+	Data    json.RawMessage `json:"data"`
+}
+
+//WebhookRecord is an event in the queue or the dead-letter store
+type WebhookRecord struct {
+	Event       WebhookEvent `json:"event"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt int64        `json:"nextAttempt"` //unix nano
+	LastError   string       `json:"lastError,omitempty"`
+}
+
+//DepositWebhookData is the data of deposit events
+type DepositWebhookData struct {
+	Address       string   `json:"address"`
+	TxID          string   `json:"txid"`
+	Vout          uint32   `json:"vout"`
+	Value         int64    `json:"value"` //satoshi
+	BlockHash     string   `json:"blockHash,omitempty"`
+	Height        int32    `json:"height,omitempty"`
+	Confirmations int64    `json:"confirmations"`
+	RBF           bool     `json:"rbf"`
+	Conflicts     []string `json:"conflicts,omitempty"`
+}
+
+//PayoutWebhookData is the data of payout events
+type PayoutWebhookData struct {
+	TxID          string `json:"txid"`
+	BlockHash     string `json:"blockHash,omitempty"`
+	Height        int32  `json:"height,omitempty"`
+	Confirmations int64  `json:"confirmations"`
+	ReplacedBy    string `json:"replacedBy,omitempty"`
+	Conflict      string `json:"conflict,omitempty"` //the input spent by the replacing tx, txid:vout
+}
+
+//webhookID derive the idempotency key of an event from the fields identify it,
+//so the same event emitted again after a restart or a resync is dropped
+func webhookID(eventType string, fields ...interface{}) string {
+	h := sha256.New()
+	fmt.Fprint(h, eventType)
+	for _, field := range fields {
+		fmt.Fprintf(h, "|%v", field)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func newWebhookEvent(id, eventType string, data interface{}) (*WebhookEvent, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookEvent{ID: id, Type: eventType, Created: time.Now().Unix(), Data: raw}, nil
+}
+
+//DepositWebhook convert a DepositEvent of Watcher to a webhook event
+func DepositWebhook(e DepositEvent) (*WebhookEvent, error) {
+	var eventType string
+	switch e.Type {
+	case DepositEventUnconfirmed:
+		eventType = WebhookDepositSeen
+	case DepositEventConfirmation:
+		eventType = WebhookDepositConfirmed
+	case DepositEventStable:
+		eventType = WebhookDepositStable
+	case DepositEventReversed:
+		eventType = WebhookDepositReorged
+	case DepositEventConflicted:
+		eventType = WebhookDepositConflict
+	case DepositEventReplaced:
+		eventType = WebhookDepositReplaced
+	default:
+		return nil, newError(ErrKindInvalidParams, "unknown deposit event "+e.Type.String())
+	}
+	data := DepositWebhookData{Address: e.Address, TxID: e.OutPoint.Hash.String(), Vout: e.OutPoint.Index, Value: e.Value,
+		Height: e.Height, Confirmations: e.Confirmations, RBF: e.RBF}
+	if e.Height != 0 {
+		data.BlockHash = e.BlockHash.String()
+	}
+	for _, conflict := range e.Conflicts {
+		data.Conflicts = append(data.Conflicts, conflict.String())
+	}
+	return newWebhookEvent(webhookID(eventType, e.OutPoint, data.BlockHash, e.Confirmations, data.Conflicts), eventType, &data)
+}
+
+//PayoutWebhook create a payout event, blockHash is nil if not mined, replacedBy is only for WebhookPayoutReplaced
+func PayoutWebhook(eventType string, txID chainhash.Hash, blockHash *chainhash.Hash, height int32, confirmations int64,
+	replacedBy *chainhash.Hash) (*WebhookEvent, error) {
+	data := PayoutWebhookData{TxID: txID.String(), Confirmations: confirmations}
+	if blockHash != nil {
+		data.BlockHash = blockHash.String()
+		data.Height = height
+	}
+	if replacedBy != nil {
+		data.ReplacedBy = replacedBy.String()
+	}
+	return payoutWebhook(eventType, &data)
+}
+
+func payoutWebhook(eventType string, data *PayoutWebhookData) (*WebhookEvent, error) {
+	id := webhookID(eventType, data.TxID, data.BlockHash, data.Confirmations, data.ReplacedBy, data.Conflict)
+	return newWebhookEvent(id, eventType, data)
+}
+
+//PayoutWebhooks convert a BroadcastEvent of Broadcaster to webhook events: payout.reorged if the block
+//of the tx is reorged out, then payout.broadcast, payout.confirmed or payout.replaced by its status.
+//A pending tx not accepted by any node has no event.
+func PayoutWebhooks(e BroadcastEvent) ([]*WebhookEvent, error) {
+	var events []*WebhookEvent
+	add := func(eventType string, data *PayoutWebhookData) error {
+		event, err := payoutWebhook(eventType, data)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+		return nil
+	}
+	record := &e.Record
+	if e.PrevBlock != "" && e.PrevBlock != record.BlockHash {
+		if err := add(WebhookPayoutReorged, &PayoutWebhookData{TxID: record.TxID, BlockHash: e.PrevBlock}); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	switch record.Status {
+	case BroadcastPending:
+		if len(record.Accepted) > 0 {
+			err = add(WebhookPayoutBroadcast, &PayoutWebhookData{TxID: record.TxID})
+		}
+	case BroadcastMined, BroadcastConfirmed:
+		err = add(WebhookPayoutConfirmed, &PayoutWebhookData{TxID: record.TxID, BlockHash: record.BlockHash,
+			Confirmations: record.Confirmations})
+	case BroadcastConflicted:
+		err = add(WebhookPayoutReplaced, &PayoutWebhookData{TxID: record.TxID, Conflict: record.Conflict})
+	default:
+		err = newError(ErrKindInvalidParams, "unknown broadcast status "+record.Status.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+//SignWebhook return the signature header of body sent at timestamp
+func SignWebhook(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+//VerifyWebhook check the signature of a received webhook, for receivers written in Go and tests
+func VerifyWebhook(secret []byte, signature, timestamp string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(SignWebhook(secret, ts, body)))
+}
+
+//webhookError is a failed delivery, permanent if retrying will not succeed
+type webhookError struct {
+	msg       string
+	permanent bool
+}
+
+func (e *webhookError) Error() string {
+	return e.msg
+}
+
+//Dispatcher delivers webhook events to URL in publish order with persistent retry.
+//Events are kept in a bbolt db until delivered, failed ones are retried with exponential backoff,
+//and moved to the dead-letter store after MaxAttempts or a permanent 4xx response.
+//The events after a failed one wait for it, so they are never reordered.
+//Events of the same ID are only delivered once within KeepKeys.
+type Dispatcher struct {
+	cfg  WebhookConfig
+	db   *bolt.DB
+	wake chan struct{}
+}
+
+//NewDispatcher open or create the queue db
+func NewDispatcher(cfg WebhookConfig) (*Dispatcher, error) {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = defaultWebhookMinBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultWebhookTimeout
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultWebhookPollInterval
+	}
+	if cfg.KeepKeys <= 0 {
+		cfg.KeepKeys = defaultWebhookKeepKeys
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: cfg.Timeout}
+	}
+	db, err := bolt.Open(cfg.DBPath, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open webhook db failed : %s", err.Error())
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketWebhookQueue, bucketWebhookKeys, bucketWebhookDead} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init webhook db failed : %s", err.Error())
+	}
+	return &Dispatcher{cfg: cfg, db: db, wake: make(chan struct{}, 1)}, nil
+}
+
+//Close close the db
+func (d *Dispatcher) Close() error {
+	return d.db.Close()
+}
+
+func webhookKeyValue(state byte, t time.Time) []byte {
+	value := make([]byte, 9)
+	value[0] = state
+	binary.BigEndian.PutUint64(value[1:], uint64(t.UnixNano()))
+	return value
+}
+
+//Publish queue an event, return false if an event of the same ID is queued, delivered or dead-lettered
+func (d *Dispatcher) Publish(event *WebhookEvent) (bool, error) {
+	if event.ID == "" || event.Type == "" {
+		return false, newError(ErrKindInvalidParams, "the ID and Type of webhook event are required")
+	}
+	queued := false
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(bucketWebhookKeys)
+		if keys.Get([]byte(event.ID)) != nil {
+			return nil
+		}
+		queue := tx.Bucket(bucketWebhookQueue)
+		seq, err := queue.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(&WebhookRecord{Event: *event})
+		if err != nil {
+			return err
+		}
+		if err := queue.Put(webhookSeqKey(seq), data); err != nil {
+			return err
+		}
+		queued = true
+		return keys.Put([]byte(event.ID), webhookKeyValue(webhookQueued, time.Now()))
+	})
+	if err != nil {
+		return false, fmt.Errorf("queue webhook failed : %s", err.Error())
+	}
+	if queued {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return queued, nil
+}
+
+func webhookSeqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+//PublishDeposits publish the events of a Watcher subscription until ctx is done or events is closed
+func (d *Dispatcher) PublishDeposits(ctx context.Context, events <-chan DepositEvent) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			event, err := DepositWebhook(e)
+			if err != nil {
+				return err
+			}
+			if _, err := d.Publish(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+//PublishPayouts publish the events of a Broadcaster subscription until ctx is done or events is closed
+func (d *Dispatcher) PublishPayouts(ctx context.Context, events <-chan BroadcastEvent) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			webhooks, err := PayoutWebhooks(e)
+			if err != nil {
+				return err
+			}
+			for _, event := range webhooks {
+				if _, err := d.Publish(event); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+//Run deliver the queued events until ctx is done, and PruneKeys every hour
+func (d *Dispatcher) Run(ctx context.Context) error {
+	var lastPrune time.Time
+	for {
+		if time.Since(lastPrune) >= webhookPruneInterval {
+			d.PruneKeys()
+			lastPrune = time.Now()
+		}
+		next, err := d.DeliverDue(ctx)
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		wait := d.cfg.PollInterval
+		if !next.IsZero() {
+			if untilNext := time.Until(next); untilNext < wait {
+				wait = untilNext
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+//DeliverDue deliver the events due now in publish order, stop at the first one waiting for retry,
+//return the time of the next retry, zero if none
+func (d *Dispatcher) DeliverDue(ctx context.Context) (time.Time, error) {
+	var next time.Time
+	for {
+		seq, record, err := d.nextDue(&next)
+		if err != nil || record == nil {
+			return next, err
+		}
+		deliverErr := d.deliver(ctx, &record.Event)
+		if ctx.Err() != nil {
+			return next, ctx.Err()
+		}
+		if err := d.finish(seq, record, deliverErr, &next); err != nil {
+			return next, err
+		}
+	}
+}
+
+//nextDue return the first queued event if it is due now, else keep its retry time in next
+func (d *Dispatcher) nextDue(next *time.Time) ([]byte, *WebhookRecord, error) {
+	var seq []byte
+	var due *WebhookRecord
+	err := d.db.View(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(bucketWebhookQueue).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		var record WebhookRecord
+		if err := json.Unmarshal(v, &record); err != nil {
+			return err
+		}
+		if record.NextAttempt <= time.Now().UnixNano() {
+			seq, due = copyBytes(k), &record
+			return nil
+		}
+		*next = time.Unix(0, record.NextAttempt)
+		return nil
+	})
+	return seq, due, err
+}
+
+//finish update the record of seq by the result of its delivery
+func (d *Dispatcher) finish(seq []byte, record *WebhookRecord, deliverErr error, next *time.Time) error {
+	return d.db.Update(func(tx *bolt.Tx) error {
+		queue := tx.Bucket(bucketWebhookQueue)
+		keys := tx.Bucket(bucketWebhookKeys)
+		id := []byte(record.Event.ID)
+		now := time.Now()
+		if deliverErr == nil {
+			if err := queue.Delete(seq); err != nil {
+				return err
+			}
+			return keys.Put(id, webhookKeyValue(webhookDelivered, now))
+		}
+
+		record.Attempts++
+		record.LastError = deliverErr.Error()
+		permanent := false
+		if e, ok := deliverErr.(*webhookError); ok {
+			permanent = e.permanent
+		}
+		if permanent || record.Attempts >= d.cfg.MaxAttempts {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(bucketWebhookDead).Put(id, data); err != nil {
+				return err
+			}
+			if err := keys.Put(id, webhookKeyValue(webhookDead, now)); err != nil {
+				return err
+			}
+			return queue.Delete(seq)
+		}
+		retryAt := now.Add(d.backoff(record.Attempts))
+		record.NextAttempt = retryAt.UnixNano()
+		if next.IsZero() || retryAt.Before(*next) {
+			*next = retryAt
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return queue.Put(seq, data)
+	})
+}
+
+//PruneKeys delete the IDs of events delivered before KeepKeys, it is called by Run
+func (d *Dispatcher) PruneKeys() error {
+	expire := time.Now().Add(-d.cfg.KeepKeys).UnixNano()
+	var expired [][]byte
+	d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhookKeys).ForEach(func(k, v []byte) error {
+			if len(v) == 9 && v[0] == webhookDelivered && int64(binary.BigEndian.Uint64(v[1:])) < expire {
+				expired = append(expired, copyBytes(k))
+			}
+			return nil
+		})
+	})
+	if len(expired) == 0 {
+		return nil
+	}
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		keys := tx.Bucket(bucketWebhookKeys)
+		for _, k := range expired {
+			//the ID may be queued again since the scan
+			if v := keys.Get(k); len(v) == 9 && v[0] == webhookDelivered && int64(binary.BigEndian.Uint64(v[1:])) < expire {
+				if err := keys.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("prune webhook keys failed : %s", err.Error())
+	}
+	return nil
+}
+
+//backoff return the delay after attempts failed
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.MinBackoff
+	for i := 1; i < attempts && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay
+}
+
+//deliver POST the event, 2xx is delivered, 4xx except 408 and 429 is permanent
+func (d *Dispatcher) deliver(ctx context.Context, event *WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return &webhookError{msg: err.Error(), permanent: true}
+	}
+	req, err := http.NewRequest("POST", d.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return &webhookError{msg: err.Error(), permanent: true}
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderSignature, SignWebhook(d.cfg.Secret, timestamp, body))
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookHeaderEventType, event.Type)
+	req.Header.Set(WebhookHeaderIdempotency, event.ID)
+	resp, err := d.cfg.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return &webhookError{msg: err.Error()}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	permanent := resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+	return &webhookError{msg: "webhook response " + resp.Status, permanent: permanent}
+}
+
+//Pending return the number of queued events
+func (d *Dispatcher) Pending() int {
+	count := 0
+	d.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(bucketWebhookQueue).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+//DeadLetters return the events in the dead-letter store
+func (d *Dispatcher) DeadLetters() ([]WebhookRecord, error) {
+	var records []WebhookRecord
+	err := d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhookDead).ForEach(func(k, v []byte) error {
+			var record WebhookRecord
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+//Redrive move a dead-lettered event back to the queue with its attempts reset
+func (d *Dispatcher) Redrive(id string) error {
+	err := d.db.Update(func(tx *bolt.Tx) error {
+		dead := tx.Bucket(bucketWebhookDead)
+		data := dead.Get([]byte(id))
+		if data == nil {
+			return newError(ErrKindNotFound, "dead letter "+id+" is not found")
+		}
+		var record WebhookRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		record.Attempts, record.NextAttempt, record.LastError = 0, 0, ""
+		queue := tx.Bucket(bucketWebhookQueue)
+		seq, err := queue.NextSequence()
+		if err != nil {
+			return err
+		}
+		if data, err = json.Marshal(&record); err != nil {
+			return err
+		}
+		if err := queue.Put(webhookSeqKey(seq), data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketWebhookKeys).Put([]byte(id), webhookKeyValue(webhookQueued, time.Now())); err != nil {
+			return err
+		}
+		return dead.Delete([]byte(id))
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
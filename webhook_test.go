@@ -0,0 +1,256 @@
+package btcadaptor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	bolt "go.etcd.io/bbolt"
+)
+
+//testReceiver is a webhook endpoint answering the queued status codes, then 200
+type testReceiver struct {
+	mtx      sync.Mutex
+	statuses []int
+	received []WebhookEvent
+	badSig   int
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if !VerifyWebhook([]byte("secret"), req.Header.Get(WebhookHeaderSignature), req.Header.Get(WebhookHeaderTimestamp), body) {
+		r.badSig++
+	}
+	var event WebhookEvent
+	json.Unmarshal(body, &event)
+	if req.Header.Get(WebhookHeaderIdempotency) != event.ID || req.Header.Get(WebhookHeaderEventType) != event.Type {
+		r.badSig++
+	}
+	r.received = append(r.received, event)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *testReceiver) events() []WebhookEvent {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]WebhookEvent{}, r.received...)
+}
+
+func TestWebhookDispatcher(t *testing.T) {
+	receiver := &testReceiver{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := WebhookConfig{URL: server.URL, Secret: []byte("secret"), DBPath: filepath.Join(dir, "webhook.db"),
+		MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	d, err := NewDispatcher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deposit := DepositEvent{Type: DepositEventStable, Address: "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt",
+		OutPoint: wire.OutPoint{Hash: chainhash.Hash{1}, Index: 1}, Value: 2000, BlockHash: chainhash.Hash{2},
+		Height: 101, Confirmations: 6}
+	event, err := DepositWebhook(deposit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != WebhookDepositStable {
+		t.Errorf("unexpected event type - got: %v, "+"want: %v", event.Type, WebhookDepositStable)
+	}
+	for i, want := range []bool{true, false} {
+		again, _ := DepositWebhook(deposit)
+		if queued, err := d.Publish(again); err != nil || queued != want {
+			t.Errorf("unexpected queued %d - got: %v %v, "+"want: %v", i, queued, err, want)
+		}
+	}
+	payout, _ := PayoutWebhook(WebhookPayoutReplaced, chainhash.Hash{3}, nil, 0, 0, &chainhash.Hash{4})
+	d.Publish(payout)
+	//the queue is kept across reopen
+	d.Close()
+	if d, err = NewDispatcher(cfg); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if pending := d.Pending(); pending != 2 {
+		t.Errorf("unexpected pending - got: %v, "+"want: %v", pending, 2)
+	}
+
+	//the first event fails twice, then both are delivered in order
+	ctx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	for d.Pending() > 0 && ctx.Err() == nil {
+		d.DeliverDue(ctx)
+		time.Sleep(time.Millisecond)
+	}
+	received := receiver.events()
+	if len(received) != 4 || received[2].ID != event.ID || received[3].ID != payout.ID {
+		t.Fatalf("unexpected received - got: %v", received)
+	}
+	var data DepositWebhookData
+	json.Unmarshal(received[2].Data, &data)
+	if data.TxID != deposit.OutPoint.Hash.String() || data.Vout != 1 || data.Value != 2000 || data.Height != 101 ||
+		data.Confirmations != 6 {
+		t.Errorf("unexpected deposit data - got: %+v", data)
+	}
+	if receiver.badSig != 0 {
+		t.Errorf("unexpected bad signatures - got: %v, "+"want: %v", receiver.badSig, 0)
+	}
+	if queued, _ := d.Publish(event); queued {
+		t.Errorf("delivered event is queued again")
+	}
+
+	//400 is permanent, the event is dead-lettered at once and can be redriven
+	receiver.mtx.Lock()
+	receiver.statuses = []int{http.StatusBadRequest}
+	receiver.mtx.Unlock()
+	confirmed, _ := PayoutWebhook(WebhookPayoutConfirmed, chainhash.Hash{3}, &chainhash.Hash{5}, 102, 1, nil)
+	d.Publish(confirmed)
+	d.DeliverDue(ctx)
+	dead, err := d.DeadLetters()
+	if err != nil || len(dead) != 1 || dead[0].Event.ID != confirmed.ID || dead[0].Attempts != 1 {
+		t.Fatalf("unexpected dead letters - got: %v %v", dead, err)
+	}
+	if queued, _ := d.Publish(confirmed); queued {
+		t.Errorf("dead-lettered event is queued again")
+	}
+	if err := d.Redrive(confirmed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Redrive(confirmed.ID); ErrorKindOf(err) != ErrKindNotFound {
+		t.Errorf("unexpected error of redrive again - got: %v", err)
+	}
+	d.DeliverDue(ctx)
+	if received := receiver.events(); len(received) != 6 || received[5].ID != confirmed.ID || d.Pending() != 0 {
+		t.Errorf("unexpected received after redrive - got: %v", received)
+	}
+
+	//retries are exhausted after MaxAttempts
+	receiver.mtx.Lock()
+	receiver.statuses = []int{500, 500, 500}
+	receiver.mtx.Unlock()
+	broadcast, _ := PayoutWebhook(WebhookPayoutBroadcast, chainhash.Hash{6}, nil, 0, 0, nil)
+	d.Publish(broadcast)
+	for d.Pending() > 0 && ctx.Err() == nil {
+		d.DeliverDue(ctx)
+		time.Sleep(time.Millisecond)
+	}
+	if dead, _ := d.DeadLetters(); len(dead) != 1 || dead[0].Event.ID != broadcast.ID || dead[0].Attempts != 3 {
+		t.Errorf("unexpected dead letters - got: %v", dead)
+	}
+}
+
+func TestWebhookPayouts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	d, err := NewDispatcher(WebhookConfig{URL: "http://127.0.0.1:1", DBPath: filepath.Join(dir, "webhook.db"),
+		KeepKeys: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	txid := chainhash.Hash{7}.String()
+	blockA, blockB := chainhash.Hash{8}.String(), chainhash.Hash{9}.String()
+	tests := []struct {
+		event BroadcastEvent
+		types []string
+	}{
+		{BroadcastEvent{Record: BroadcastRecord{TxID: txid, Status: BroadcastPending}}, nil}, //not accepted
+		{BroadcastEvent{Record: BroadcastRecord{TxID: txid, Status: BroadcastPending, Accepted: []string{"a"}}},
+			[]string{WebhookPayoutBroadcast}},
+		{BroadcastEvent{Record: BroadcastRecord{TxID: txid, Status: BroadcastMined, BlockHash: blockA, Confirmations: 1}},
+			[]string{WebhookPayoutConfirmed}},
+		{BroadcastEvent{Record: BroadcastRecord{TxID: txid, Status: BroadcastMined, BlockHash: blockB, Confirmations: 1},
+			PrevBlock: blockA}, []string{WebhookPayoutReorged, WebhookPayoutConfirmed}},
+		{BroadcastEvent{Record: BroadcastRecord{TxID: txid, Status: BroadcastConflicted, Conflict: txid + ":0"},
+			PrevBlock: blockB}, []string{WebhookPayoutReorged, WebhookPayoutReplaced}},
+	}
+	events := make(chan BroadcastEvent, len(tests))
+	var want []string
+	for i, test := range tests {
+		webhooks, err := PayoutWebhooks(test.event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var types []string
+		for _, webhook := range webhooks {
+			types = append(types, webhook.Type)
+		}
+		if fmt.Sprint(types) != fmt.Sprint(test.types) {
+			t.Errorf("unexpected webhooks %d - got: %v, "+"want: %v", i, types, test.types)
+		}
+		events <- test.event
+		want = append(want, test.types...)
+	}
+	close(events)
+	if err := d.PublishPayouts(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	var reorged, replaced PayoutWebhookData
+	d.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketWebhookQueue).ForEach(func(k, v []byte) error {
+			var record WebhookRecord
+			json.Unmarshal(v, &record)
+			got = append(got, record.Event.Type)
+			switch record.Event.Type {
+			case WebhookPayoutReorged:
+				json.Unmarshal(record.Event.Data, &reorged)
+			case WebhookPayoutReplaced:
+				json.Unmarshal(record.Event.Data, &replaced)
+			}
+			return nil
+		})
+	})
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("unexpected published - got: %v, "+"want: %v", got, want)
+	}
+	if reorged.BlockHash != blockB || replaced.Conflict != txid+":0" {
+		t.Errorf("unexpected payout data - got: %+v %+v", reorged, replaced)
+	}
+
+	//the IDs of delivered events are pruned after KeepKeys, the others are kept
+	var delivered *WebhookEvent
+	d.db.Update(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(bucketWebhookQueue).Cursor().First()
+		var record WebhookRecord
+		json.Unmarshal(v, &record)
+		delivered = &record.Event
+		tx.Bucket(bucketWebhookQueue).Delete(k)
+		return tx.Bucket(bucketWebhookKeys).Put([]byte(delivered.ID), webhookKeyValue(webhookDelivered, time.Now()))
+	})
+	time.Sleep(5 * time.Millisecond)
+	if err := d.PruneKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if queued, err := d.Publish(delivered); err != nil || !queued {
+		t.Errorf("unexpected queued of pruned event - got: %v %v, "+"want: %v", queued, err, true)
+	}
+	if queued, _ := d.Publish(delivered); queued {
+		t.Errorf("queued event is queued again")
+	}
+}
@@ -0,0 +1,666 @@
+/*
+   This file is part of go-palletone.
+   go-palletone is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+   go-palletone is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+   You should have received a copy of the GNU General Public License
+   along with go-palletone.  If not, see <http://www.gnu.org/licenses/>.
+*/
+/*
+ * @author PalletOne core developers <dev@pallet.one>
+ * @date 2018
+ */
+package btcadaptor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/rpcclient"
+	"github.com/btcsuite/btcd/wire"
+)
+
+//topics published by bitcoind -zmqpub<topic>=<address>
+const (
+	ZMQTopicRawTx    = "rawtx"
+	ZMQTopicRawBlock = "rawblock"
+	ZMQTopicSequence = "sequence"
+)
+
+const (
+	defaultZMQDialTimeout       = 10 * time.Second
+	defaultZMQReconnectInterval = 5 * time.Second
+	zmqMessageBuffer            = 1000
+	mempoolResyncChunk          = 1000 //txs fetched by one call on mempool resync
+)
+
+//ZMTP 3.0 frame flags
+const (
+	zmqFlagMore    = 0x01
+	zmqFlagLong    = 0x02
+	zmqFlagCommand = 0x04
+)
+
+//zmqGreetingSize is the size of the ZMTP 3.0 greeting, signature, version, mechanism, as-server and filler
+const zmqGreetingSize = 64
+
+//zmqGreeting is the greeting of ZMTP 3.0 with the NULL mechanism
+func zmqGreeting(asServer bool) []byte {
+	greeting := make([]byte, zmqGreetingSize)
+	greeting[0] = 0xff
+	greeting[9] = 0x7f
+	greeting[10] = 3 //version 3.0, the subscriptions are messages
+	copy(greeting[12:32], "NULL")
+	if asServer {
+		greeting[32] = 1
+	}
+	return greeting
+}
+
+//zmqConn is a ZMTP 3.0 connection with the NULL mechanism
+type zmqConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+//handshakeZMQ exchange the greeting and the READY command, socketType is ours, the peer must be one of peerTypes
+func handshakeZMQ(conn net.Conn, asServer bool, socketType string, peerTypes ...string) (*zmqConn, error) {
+	zc := &zmqConn{conn: conn, r: bufio.NewReader(conn)}
+	if _, err := conn.Write(zmqGreeting(asServer)); err != nil {
+		return nil, err
+	}
+	greeting := make([]byte, zmqGreetingSize)
+	if _, err := io.ReadFull(zc.r, greeting); err != nil {
+		return nil, err
+	}
+	if greeting[0] != 0xff || greeting[9] != 0x7f || greeting[10] < 3 {
+		return nil, errors.New("not a ZMTP 3 peer")
+	}
+	if mechanism := string(bytes.TrimRight(greeting[12:32], "\x00")); mechanism != "NULL" {
+		return nil, fmt.Errorf("unsupported ZMTP mechanism %s", mechanism)
+	}
+
+	ready := []byte{5}
+	ready = append(ready, "READY"...)
+	ready = appendZMQProperty(ready, "Socket-Type", socketType)
+	if err := zc.writeFrame(zmqFlagCommand, ready); err != nil {
+		return nil, err
+	}
+	flags, body, err := zc.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if flags&zmqFlagCommand == 0 || len(body) < 6 || string(body[1:6]) != "READY" {
+		return nil, errors.New("ZMTP READY command is expected")
+	}
+	peerType := parseZMQProperties(body[6:])["socket-type"]
+	for _, t := range peerTypes {
+		if peerType == t {
+			return zc, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected ZMTP socket type %s", peerType)
+}
+
+func appendZMQProperty(buf []byte, name, value string) []byte {
+	buf = append(buf, byte(len(name)))
+	buf = append(buf, name...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(value)))
+	buf = append(buf, size[:]...)
+	return append(buf, value...)
+}
+
+//parseZMQProperties parse the metadata of a command, names are case-insensitive
+func parseZMQProperties(data []byte) map[string]string {
+	props := map[string]string{}
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+4 {
+			break
+		}
+		name := strings.ToLower(string(data[1 : 1+nameLen]))
+		data = data[1+nameLen:]
+		valueLen := int(binary.BigEndian.Uint32(data))
+		if len(data) < 4+valueLen {
+			break
+		}
+		props[name] = string(data[4 : 4+valueLen])
+		data = data[4+valueLen:]
+	}
+	return props
+}
+
+func (zc *zmqConn) writeFrame(flags byte, body []byte) error {
+	var header []byte
+	if len(body) > 255 {
+		header = make([]byte, 9)
+		header[0] = flags | zmqFlagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	} else {
+		header = []byte{flags, byte(len(body))}
+	}
+	_, err := zc.conn.Write(append(header, body...))
+	return err
+}
+
+func (zc *zmqConn) readFrame() (byte, []byte, error) {
+	flags, err := zc.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var size uint64
+	if flags&zmqFlagLong != 0 {
+		var buf [8]byte
+		if _, err := io.ReadFull(zc.r, buf[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(buf[:])
+	} else {
+		b, err := zc.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		size = uint64(b)
+	}
+	if size > wire.MaxMessagePayload {
+		return 0, nil, fmt.Errorf("ZMTP frame of %d bytes is too large", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(zc.r, body); err != nil {
+		return 0, nil, err
+	}
+	return flags, body, nil
+}
+
+//readMessage read the frames of the next message, commands are skipped
+func (zc *zmqConn) readMessage() ([][]byte, error) {
+	var frames [][]byte
+	for {
+		flags, body, err := zc.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if flags&zmqFlagCommand != 0 {
+			continue
+		}
+		frames = append(frames, body)
+		if flags&zmqFlagMore == 0 {
+			return frames, nil
+		}
+	}
+}
+
+//writeMessage write the frames as one message
+func (zc *zmqConn) writeMessage(frames ...[]byte) error {
+	for i, frame := range frames {
+		var flags byte
+		if i < len(frames)-1 {
+			flags = zmqFlagMore
+		}
+		if err := zc.writeFrame(flags, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//dialZMQ connect a SUB socket to a publisher and subscribe topics
+func dialZMQ(addr string, timeout time.Duration, topics []string) (*zmqConn, error) {
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(addr, "tcp://"), timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	zc, err := handshakeZMQ(conn, false, "SUB", "PUB", "XPUB")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, topic := range topics {
+		if err := zc.writeFrame(0, append([]byte{1}, topic...)); err != nil { //1 is subscribe
+			conn.Close()
+			return nil, err
+		}
+	}
+	conn.SetDeadline(time.Time{})
+	return zc, nil
+}
+
+//ZMQConfig is the config of ZMQFeed, the addresses are -zmqpub<topic> of bitcoind like tcp://127.0.0.1:28332,
+//an empty address means the topic is not subscribed, topics of the same address share one connection
+type ZMQConfig struct {
+	RawTx             string
+	RawBlock          string
+	Sequence          string
+	DialTimeout       time.Duration
+	ReconnectInterval time.Duration
+}
+
+//zmqMessage is a message of a topic, seq is the sequence number of the topic of the publisher.
+//A message with empty topic means the connection is (re)established.
+type zmqMessage struct {
+	addr  string
+	topic string
+	body  []byte
+	seq   uint32
+}
+
+//ZMQFeed subscribes the rawtx, rawblock and sequence feeds of bitcoind, and drives a deposit
+//Watcher and a TipTracker with low latency. Each topic carries a sequence number, a gap in it
+//or a reconnection means messages are lost, then the tracker and the watcher are resynced by RPC.
+type ZMQFeed struct {
+	cfg   ZMQConfig
+	nodes *NodeSet
+
+	watcher *Watcher
+	tracker *TipTracker
+
+	mtx     sync.Mutex
+	seqs    map[string]uint32 //addr|topic -> last sequence number
+	gaps    int
+	lastErr error
+}
+
+//NewZMQFeed create a feed, blocks are fetched by nodes on resync
+func NewZMQFeed(cfg ZMQConfig, nodes *NodeSet) *ZMQFeed {
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultZMQDialTimeout
+	}
+	if cfg.ReconnectInterval <= 0 {
+		cfg.ReconnectInterval = defaultZMQReconnectInterval
+	}
+	return &ZMQFeed{cfg: cfg, nodes: nodes, seqs: map[string]uint32{}}
+}
+
+//DriveWatcher feed the deposits and blocks to w, w should not be started by websocket
+func (f *ZMQFeed) DriveWatcher(w *Watcher) {
+	f.watcher = w
+}
+
+//DriveTracker sync tt on every block notification
+func (f *ZMQFeed) DriveTracker(tt *TipTracker) {
+	f.tracker = tt
+}
+
+//Gaps return the number of sequence gaps detected
+func (f *ZMQFeed) Gaps() int {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.gaps
+}
+
+//LastError return the error of the last message handled, nil if it succeeded
+func (f *ZMQFeed) LastError() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	return f.lastErr
+}
+
+//endpoints return the topics of each address
+func (f *ZMQFeed) endpoints() map[string][]string {
+	endpoints := map[string][]string{}
+	for topic, addr := range map[string]string{ZMQTopicRawTx: f.cfg.RawTx, ZMQTopicRawBlock: f.cfg.RawBlock,
+		ZMQTopicSequence: f.cfg.Sequence} {
+		if addr != "" {
+			endpoints[addr] = append(endpoints[addr], topic)
+		}
+	}
+	for _, topics := range endpoints {
+		sort.Strings(topics)
+	}
+	return endpoints
+}
+
+//Run subscribe the feeds and handle the messages until ctx is done, connections are retried every ReconnectInterval
+func (f *ZMQFeed) Run(ctx context.Context) error {
+	endpoints := f.endpoints()
+	if len(endpoints) == 0 {
+		return newError(ErrKindInvalidParams, "no ZMQ address is configured")
+	}
+	msgs := make(chan zmqMessage, zmqMessageBuffer)
+	var wg sync.WaitGroup
+	for addr, topics := range endpoints {
+		wg.Add(1)
+		go func(addr string, topics []string) {
+			defer wg.Done()
+			f.subscribe(ctx, addr, topics, msgs)
+		}(addr, topics)
+	}
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-msgs:
+			err := f.handle(ctx, msg)
+			f.mtx.Lock()
+			f.lastErr = err
+			f.mtx.Unlock()
+		}
+	}
+}
+
+//subscribe read the messages of addr into msgs, reconnect if the connection is broken
+func (f *ZMQFeed) subscribe(ctx context.Context, addr string, topics []string, msgs chan<- zmqMessage) {
+	for {
+		zc, err := dialZMQ(addr, f.cfg.DialTimeout, topics)
+		if err == nil {
+			closed := make(chan struct{})
+			go func() {
+				select {
+				case <-ctx.Done():
+					zc.conn.Close()
+				case <-closed:
+				}
+			}()
+			err = f.read(ctx, zc, addr, msgs)
+			zc.conn.Close()
+			close(closed)
+		}
+		f.mtx.Lock()
+		f.lastErr = fmt.Errorf("ZMQ %s failed : %s", addr, err.Error())
+		f.mtx.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.cfg.ReconnectInterval):
+		}
+	}
+}
+
+func (f *ZMQFeed) read(ctx context.Context, zc *zmqConn, addr string, msgs chan<- zmqMessage) error {
+	send := func(msg zmqMessage) error {
+		select {
+		case msgs <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := send(zmqMessage{addr: addr}); err != nil {
+		return err
+	}
+	for {
+		frames, err := zc.readMessage()
+		if err != nil {
+			return err
+		}
+		if len(frames) != 3 || len(frames[2]) != 4 {
+			continue //not a message of bitcoind
+		}
+		msg := zmqMessage{addr: addr, topic: string(frames[0]), body: frames[1], seq: binary.LittleEndian.Uint32(frames[2])}
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+}
+
+//checkSeq return false if msg is not the next of its topic, the first message after connecting is in order
+func (f *ZMQFeed) checkSeq(msg zmqMessage) bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	key := msg.addr + "|" + msg.topic
+	last, exist := f.seqs[key]
+	f.seqs[key] = msg.seq
+	if exist && msg.seq != last+1 {
+		f.gaps++
+		return false
+	}
+	return true
+}
+
+func (f *ZMQFeed) handle(ctx context.Context, msg zmqMessage) error {
+	if msg.topic == "" { //connected, the messages before are lost
+		f.mtx.Lock()
+		for key := range f.seqs {
+			if strings.HasPrefix(key, msg.addr+"|") {
+				delete(f.seqs, key)
+			}
+		}
+		f.mtx.Unlock()
+		return f.resync(ctx, true)
+	}
+	if !f.checkSeq(msg) {
+		return f.resync(ctx, msg.topic == ZMQTopicRawTx)
+	}
+
+	switch msg.topic {
+	case ZMQTopicRawTx:
+		var tx wire.MsgTx
+		if err := tx.Deserialize(bytes.NewReader(msg.body)); err != nil {
+			return fmt.Errorf("Deserialize rawtx failed : %s", err.Error())
+		}
+		if f.watcher != nil {
+			f.watcher.recvTx(&tx, nil)
+		}
+		return nil
+	case ZMQTopicRawBlock:
+		var block wire.MsgBlock
+		if err := block.Deserialize(bytes.NewReader(msg.body)); err != nil {
+			return fmt.Errorf("Deserialize rawblock failed : %s", err.Error())
+		}
+		if err := f.syncTracker(ctx); err != nil {
+			return err
+		}
+		return f.connectBlock(ctx, &block)
+	case ZMQTopicSequence:
+		//hash in the byte order of rpc, then C connected, D disconnected, A added to or R removed from the mempool
+		if len(msg.body) < chainhash.HashSize+1 {
+			return fmt.Errorf("invalid sequence message %x", msg.body)
+		}
+		hash, err := chainhash.NewHashFromStr(hex.EncodeToString(msg.body[:chainhash.HashSize]))
+		if err != nil {
+			return err
+		}
+		switch msg.body[chainhash.HashSize] {
+		case 'C':
+			if err := f.syncTracker(ctx); err != nil {
+				return err
+			}
+			if f.watcher == nil {
+				return nil
+			}
+			if _, exist := f.watcher.block(*hash); exist { //by rawblock
+				return nil
+			}
+			block, err := f.getBlock(ctx, hash)
+			if err != nil {
+				return err
+			}
+			return f.connectBlock(ctx, block)
+		case 'D':
+			if err := f.syncTracker(ctx); err != nil {
+				return err
+			}
+			return f.disconnectBlock(ctx, hash)
+		}
+	}
+	return nil
+}
+
+func (f *ZMQFeed) syncTracker(ctx context.Context) error {
+	if f.tracker == nil {
+		return nil
+	}
+	return f.tracker.SyncCtx(ctx)
+}
+
+//connectBlock connect block to the watcher, resync if it is not on the tip of the watcher
+func (f *ZMQFeed) connectBlock(ctx context.Context, block *wire.MsgBlock) error {
+	if f.watcher == nil {
+		return nil
+	}
+	if _, exist := f.watcher.block(block.BlockHash()); exist {
+		return nil
+	}
+	tipHash, tipHeight, ok := f.watcher.tipBlock()
+	if !ok || block.Header.PrevBlock != tipHash {
+		return f.resync(ctx, false)
+	}
+	f.watcher.connectBlock(block, tipHeight+1)
+	return nil
+}
+
+//disconnectBlock disconnect the tip of the watcher, resync if hash is not the tip
+func (f *ZMQFeed) disconnectBlock(ctx context.Context, hash *chainhash.Hash) error {
+	if f.watcher == nil {
+		return nil
+	}
+	tipHash, tipHeight, ok := f.watcher.tipBlock()
+	if !ok {
+		return nil
+	}
+	if tipHash != *hash {
+		if _, exist := f.watcher.block(*hash); !exist {
+			return nil //below the blocks of the watcher
+		}
+		return f.resync(ctx, false)
+	}
+	f.watcher.blockDisconnected(tipHeight, tipHash)
+	return nil
+}
+
+func (f *ZMQFeed) getBlock(ctx context.Context, hash *chainhash.Hash) (*wire.MsgBlock, error) {
+	result, err := f.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		block, err := client.GetBlock(hash)
+		if err != nil {
+			return nil, wrapError("GetBlock failed", err)
+		}
+		return block, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*wire.MsgBlock), nil
+}
+
+//resync the tracker and the watcher by RPC: blocks of the watcher not on the main chain are disconnected,
+//and the missed blocks are connected. If mempool is true, the txs in the mempool are fed to the watcher.
+func (f *ZMQFeed) resync(ctx context.Context, mempool bool) error {
+	if err := f.syncTracker(ctx); err != nil {
+		return err
+	}
+	if f.watcher == nil {
+		return nil
+	}
+	result, err := f.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		hash, err := client.GetBestBlockHash()
+		if err != nil {
+			return nil, wrapError("GetBestBlockHash failed", err)
+		}
+		return hash, nil
+	})
+	if err != nil {
+		return err
+	}
+	hash := result.(*chainhash.Hash)
+
+	//fetch back to a connected block, only the best block if none is connected
+	_, _, connected := f.watcher.tipBlock()
+	var blocks []*wire.MsgBlock
+	forkHeight := int32(-1)
+	for len(blocks) < maxReorgDepth {
+		if block, exist := f.watcher.block(*hash); exist {
+			forkHeight = block.height
+			break
+		}
+		block, err := f.getBlock(ctx, hash)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+		if !connected {
+			break
+		}
+		hash = &block.Header.PrevBlock
+	}
+	if forkHeight < 0 && len(blocks) > 0 { //the fork is deeper than the blocks of the watcher
+		oldest := blocks[len(blocks)-1].BlockHash()
+		result, err := f.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+			header, err := client.GetBlockHeaderVerbose(&oldest)
+			if err != nil {
+				return nil, wrapError("GetBlockHeaderVerbose failed", err)
+			}
+			return header, nil
+		})
+		if err != nil {
+			return err
+		}
+		forkHeight = result.(*btcjson.GetBlockHeaderVerboseResult).Height - 1
+	}
+
+	for {
+		tipHash, tipHeight, ok := f.watcher.tipBlock()
+		if !ok || tipHeight <= forkHeight {
+			break
+		}
+		f.watcher.blockDisconnected(tipHeight, tipHash)
+	}
+	for i := len(blocks) - 1; i >= 0; i-- {
+		f.watcher.connectBlock(blocks[i], forkHeight+int32(len(blocks)-i))
+	}
+
+	if mempool && f.watcher.watching() {
+		return f.resyncMempool(ctx)
+	}
+	return nil
+}
+
+//resyncMempool feed the txs in the mempool to the watcher, the deposits notified before are not notified again.
+//The txs are fetched by batches, a chunk of mempoolResyncChunk txs per call, the txs removed from the mempool meanwhile are skipped.
+func (f *ZMQFeed) resyncMempool(ctx context.Context) error {
+	result, err := f.nodes.CallCtx(ctx, func(client *rpcclient.Client) (interface{}, error) {
+		hashes, err := client.GetRawMempool()
+		if err != nil {
+			return nil, wrapError("GetRawMempool failed", err)
+		}
+		return hashes, nil
+	})
+	if err != nil {
+		return err
+	}
+	hashes := result.([]*chainhash.Hash)
+	for start := 0; start < len(hashes); start += mempoolResyncChunk {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + mempoolResyncChunk
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		result, err := f.nodes.callNodeCtx(ctx, func(node *rpcNode, client *rpcclient.Client) (interface{}, error) {
+			resolver, err := node.getResolver()
+			if err != nil {
+				return nil, err
+			}
+			return resolver.getRawTxs(ctx, hashes[start:end])
+		})
+		if err != nil {
+			return err
+		}
+		for _, tx := range result.([]*wire.MsgTx) {
+			f.watcher.recvTx(tx, nil)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package btcadaptor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcjson"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+
+	"github.com/palletone/btc-adaptor/txscript"
+)
+
+//testPublisher is a ZMQ PUB socket standing in for bitcoind
+type testPublisher struct {
+	ln net.Listener
+
+	mtx  sync.Mutex
+	subs map[*zmqConn][]string //topics subscribed by each connection
+}
+
+func newTestPublisher(t *testing.T) *testPublisher {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := &testPublisher{ln: ln, subs: map[*zmqConn][]string{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go pub.serve(conn)
+		}
+	}()
+	return pub
+}
+
+func (p *testPublisher) serve(conn net.Conn) {
+	defer conn.Close()
+	zc, err := handshakeZMQ(conn, true, "PUB", "SUB")
+	if err != nil {
+		return
+	}
+	p.mtx.Lock()
+	p.subs[zc] = nil
+	p.mtx.Unlock()
+	defer func() {
+		p.mtx.Lock()
+		delete(p.subs, zc)
+		p.mtx.Unlock()
+	}()
+	for {
+		frames, err := zc.readMessage()
+		if err != nil {
+			return
+		}
+		if len(frames[0]) > 0 && frames[0][0] == 1 {
+			p.mtx.Lock()
+			p.subs[zc] = append(p.subs[zc], string(frames[0][1:]))
+			p.mtx.Unlock()
+		}
+	}
+}
+
+func (p *testPublisher) addr() string {
+	return "tcp://" + p.ln.Addr().String()
+}
+
+//waitSubscribed wait until a connection subscribes n topics
+func (p *testPublisher) waitSubscribed(t *testing.T, n int) {
+	for i := 0; i < 500; i++ {
+		p.mtx.Lock()
+		for _, topics := range p.subs {
+			if len(topics) >= n {
+				p.mtx.Unlock()
+				return
+			}
+		}
+		p.mtx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("no subscriber of %d topics", n)
+}
+
+//send publish a message of bitcoind, topic, body and the sequence number
+func (p *testPublisher) send(topic string, body []byte, seq uint32) {
+	var seqBytes [4]byte
+	binary.LittleEndian.PutUint32(seqBytes[:], seq)
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for zc, topics := range p.subs {
+		for _, t := range topics {
+			if bytes.HasPrefix([]byte(topic), []byte(t)) {
+				zc.writeMessage([]byte(topic), body, seqBytes[:])
+				break
+			}
+		}
+	}
+}
+
+func (p *testPublisher) Close() {
+	p.ln.Close()
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for zc := range p.subs {
+		zc.conn.Close()
+	}
+}
+
+func TestZMQFeed(t *testing.T) {
+	node, rpcParams := newTestNode(t)
+	defer node.Close()
+	chain := &testRPCChain{}
+	chain.serve(node)
+	node.handle("getrawmempool", func(params []json.RawMessage) (interface{}, error) {
+		return []string{}, nil
+	})
+	for height := 0; height <= 3; height++ {
+		chain.add(height, 0)
+	}
+	pub := newTestPublisher(t)
+	defer pub.Close()
+
+	addrA := "mxprH5bkXtn9tTTAxdQGPXrvruCUvsBNKt"
+	addr, _ := btcutil.DecodeAddress(addrA, &chaincfg.TestNet3Params)
+	pkScript, _ := txscript.PayToAddrScript(addr)
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{1}}})
+	tx.AddTxOut(wire.NewTxOut(2000, pkScript))
+
+	abtc := NewAdaptorBTC(NETID_TEST, rpcParams)
+	defer abtc.Close()
+	watcher := abtc.NewWatcher(WatcherConfig{Finality: FinalityPolicy{Confirmations: 3}})
+	defer watcher.Close()
+	if err := watcher.WatchAddress(addrA); err != nil {
+		t.Fatal(err)
+	}
+	events, cancel := watcher.Subscribe()
+	defer cancel()
+	tracker := abtc.TrackTip(TipTrackerConfig{Depth: 10})
+	feed := abtc.NewZMQFeed(ZMQConfig{RawTx: pub.addr(), RawBlock: pub.addr(), Sequence: pub.addr()})
+	feed.DriveWatcher(watcher)
+	feed.DriveTracker(tracker)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go feed.Run(ctx)
+	pub.waitSubscribed(t, 3)
+
+	serialize := func(msg interface{ Serialize(w io.Writer) error }) []byte {
+		var buf bytes.Buffer
+		msg.Serialize(&buf)
+		return buf.Bytes()
+	}
+	sequence := func(block *wire.MsgBlock, label byte) []byte {
+		hash, _ := hex.DecodeString(block.BlockHash().String())
+		return append(hash, label)
+	}
+	expect := func(eventType DepositEventType, height int32, confirmations int64) {
+		select {
+		case event := <-events:
+			if event.Type != eventType || event.Height != height || event.Confirmations != confirmations ||
+				event.OutPoint != (wire.OutPoint{Hash: tx.TxHash()}) {
+				t.Errorf("unexpected event - got: %v, "+"want: %v %v %v", event, eventType, height, confirmations)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %v timed out, last error: %v", eventType, feed.LastError())
+		}
+	}
+
+	pub.send(ZMQTopicRawTx, serialize(tx), 0)
+	expect(DepositEventUnconfirmed, 0, 0)
+	chain.add(4, 0, tx)
+	pub.send(ZMQTopicRawBlock, serialize(chain.blocks[4]), 0)
+	expect(DepositEventConfirmation, 4, 1)
+
+	//block 5 is lost, the gap is resynced by rpc
+	chain.add(5, 0)
+	chain.add(6, 0)
+	pub.send(ZMQTopicRawBlock, serialize(chain.blocks[6]), 2)
+	expect(DepositEventConfirmation, 4, 2)
+	expect(DepositEventConfirmation, 4, 3)
+	expect(DepositEventStable, 4, 3)
+	if gaps := feed.Gaps(); gaps != 1 {
+		t.Errorf("unexpected gaps - got: %v, "+"want: %v", gaps, 1)
+	}
+
+	//blocks from 4 are replaced by a longer branch without the deposit
+	oldBlocks := append([]*wire.MsgBlock{}, chain.blocks[4:]...)
+	for height := 4; height <= 7; height++ {
+		chain.add(height, 1)
+	}
+	for i, seq := len(oldBlocks)-1, uint32(0); i >= 0; i, seq = i-1, seq+1 {
+		pub.send(ZMQTopicSequence, sequence(oldBlocks[i], 'D'), seq)
+	}
+	expect(DepositEventReversed, 4, 3)
+	if hash, height, _ := tracker.Tip(); height != 7 || hash != chain.blocks[7].BlockHash() {
+		t.Errorf("unexpected tracker tip - got: %v %v, "+"want: %v", height, hash, 7)
+	}
+	pub.send(ZMQTopicSequence, sequence(chain.blocks[7], 'C'), 3)
+	for i := 0; i < 500; i++ {
+		if hash, height, _ := watcher.tipBlock(); height == 7 && hash == chain.blocks[7].BlockHash() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hash, height, _ := watcher.tipBlock(); height != 7 || hash != chain.blocks[7].BlockHash() {
+		t.Errorf("unexpected watcher tip - got: %v %v, "+"want: %v", height, hash, 7)
+	}
+	if gaps := feed.Gaps(); gaps != 1 {
+		t.Errorf("unexpected gaps - got: %v, "+"want: %v", gaps, 1)
+	}
+
+	//a rawtx gap resyncs the mempool by batches, the tx removed from the mempool meanwhile is skipped
+	mempoolTx := wire.NewMsgTx(1)
+	mempoolTx.AddTxIn(&wire.TxIn{PreviousOutPoint: wire.OutPoint{Hash: chainhash.Hash{2}}})
+	mempoolTx.AddTxOut(wire.NewTxOut(3000, pkScript))
+	removed := chainhash.Hash{3}
+	node.handle("getrawmempool", func(params []json.RawMessage) (interface{}, error) {
+		return []string{removed.String(), mempoolTx.TxHash().String()}, nil
+	})
+	node.handle("getrawtransaction", func(params []json.RawMessage) (interface{}, error) {
+		var txid string
+		json.Unmarshal(params[0], &txid)
+		if txid != mempoolTx.TxHash().String() {
+			return nil, btcjson.NewRPCError(btcjson.ErrRPCNoTxInfo, "No such mempool or blockchain transaction")
+		}
+		return hex.EncodeToString(serialize(mempoolTx)), nil
+	})
+	pub.send(ZMQTopicRawTx, serialize(wire.NewMsgTx(1)), 2)
+	select {
+	case event := <-events:
+		if event.Type != DepositEventUnconfirmed || event.OutPoint != (wire.OutPoint{Hash: mempoolTx.TxHash()}) {
+			t.Errorf("unexpected event - got: %v, "+"want: %v %v", event, DepositEventUnconfirmed, mempoolTx.TxHash())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("mempool resync timed out, last error: %v", feed.LastError())
+	}
+	if gaps := feed.Gaps(); gaps != 2 {
+		t.Errorf("unexpected gaps - got: %v, "+"want: %v", gaps, 2)
+	}
+}